@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"abt-dashboard/internal/transform"
+)
+
+// configLayerFlags are the flags that layer settings over the -config file
+type configLayerFlags struct {
+	env      string
+	settings pathList
+}
+
+func (f *configLayerFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&f.env, "env", os.Getenv("ABT_ENV"),
+		"environment whose config file is layered over -config, e.g. production reads data_transformation.production.yaml (default $ABT_ENV)")
+	flags.Var(&f.settings, "set", "override a config setting as path=value, e.g. performance.batch_size=5000; repeatable")
+}
+
+// load loads the config file with the environment file, ABT_* environment
+// variables and -set flags layered over it
+func (f *configLayerFlags) load(configPath string) (transform.TransformConfig, *transform.EffectiveConfig, error) {
+	return transform.NewConfigLoader(configPath).LoadLayeredConfig(transform.ConfigLayers{
+		Environment: f.env,
+		Variables:   os.Environ(),
+		Overrides:   f.settings,
+	})
+}
+
+// checkEnvironment fails when -env names an environment without a config
+// file. Loading would fail too, but the server would then start on the
+// built-in defaults rather than stop.
+func (f *configLayerFlags) checkEnvironment(configPath string) error {
+	if f.env == "" {
+		return nil
+	}
+	if _, err := os.Stat(transform.NewConfigLoader(configPath).EnvironmentConfigPath(f.env)); err != nil {
+		return fmt.Errorf("environment %s: %w", f.env, err)
+	}
+	return nil
+}
+
+// logConfigLayers logs the layers a config was loaded from, and the ABT_*
+// variables that were ignored
+func logConfigLayers(effective *transform.EffectiveConfig) {
+	layers := make([]string, 0, len(effective.Layers))
+	for _, layer := range effective.Layers {
+		if layer.Source == "" {
+			layers = append(layers, layer.Layer)
+			continue
+		}
+		layers = append(layers, layer.Layer+" "+layer.Source)
+	}
+	log.Printf("Config layers: %s", strings.Join(layers, ", "))
+	if len(effective.IgnoredVariables) > 0 {
+		log.Printf("Ignoring environment variables that name no config section: %s",
+			strings.Join(effective.IgnoredVariables, ", "))
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// pathList is a repeatable string flag, e.g. -data a.csv -data 'b/*.csv'
+type pathList []string
+
+func (p *pathList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *pathList) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// expandDataPaths resolves -data arguments into a list of files, in argument
+// order and sorted within each pattern or directory. An argument may be a
+// file, a glob pattern or a directory, which is searched recursively; hidden
+// files and directories are ignored unless named, or matched by a pattern
+// whose last element starts with a dot, as in a shell. Each file is returned
+// once even if several arguments match it.
+func expandDataPaths(args []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+
+	add := func(path string) {
+		path = filepath.Clean(path)
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	for _, arg := range args {
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			matches, err = filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", arg, err)
+			}
+			if !strings.HasPrefix(filepath.Base(arg), ".") {
+				visible := matches[:0]
+				for _, match := range matches {
+					if !strings.HasPrefix(filepath.Base(match), ".") {
+						visible = append(visible, match)
+					}
+				}
+				matches = visible
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("pattern %q matched no files", arg)
+			}
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(match)
+				continue
+			}
+
+			var dirFiles []string
+			err = filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if path != match && strings.HasPrefix(d.Name(), ".") {
+					if d.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if !d.IsDir() {
+					dirFiles = append(dirFiles, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			if len(dirFiles) == 0 {
+				return nil, fmt.Errorf("directory %q contains no files", match)
+			}
+			sort.Strings(dirFiles)
+			for _, f := range dirFiles {
+				add(f)
+			}
+		}
+	}
+
+	return files, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandDataPaths(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"a.csv", "b.json", ".hidden.csv",
+		"nested/c.csv", "nested/deeper/d.csv", "nested/.git/config",
+		"empty/.keep",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("transaction_id\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		args    []string // relative to root, joined without cleaning
+		want    []string // relative to root
+		wantErr string
+	}{
+		{name: "file", args: []string{"a.csv"}, want: []string{"a.csv"}},
+		{name: "glob over files and directories", args: []string{"nested/*"},
+			want: []string{"nested/c.csv", "nested/deeper/d.csv"}},
+		{name: "glob without matches", args: []string{"*.xml"}, wantErr: "matched no files"},
+		{name: "glob skips hidden files", args: []string{"*.csv"}, want: []string{"a.csv"}},
+		{name: "dot glob matches hidden files", args: []string{".*.csv"}, want: []string{".hidden.csv"}},
+		{name: "named hidden file", args: []string{".hidden.csv"}, want: []string{".hidden.csv"}},
+		{name: "nested directory", args: []string{"nested"}, want: []string{"nested/c.csv", "nested/deeper/d.csv"}},
+		{name: "same file by glob and path", args: []string{"*.csv", "./a.csv", "a.csv"}, want: []string{"a.csv"}},
+		{name: "file then its directory", args: []string{"nested/deeper/d.csv", "nested"},
+			want: []string{"nested/deeper/d.csv", "nested/c.csv"}},
+		{name: "only hidden files", args: []string{"empty"}, wantErr: "contains no files"},
+		{name: "missing file", args: []string{"missing.csv"}, wantErr: "no such file"},
+		{name: "invalid pattern", args: []string{"[.csv"}, wantErr: "invalid pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := make([]string, len(tt.args))
+			for i, arg := range tt.args {
+				args[i] = root + string(filepath.Separator) + filepath.FromSlash(arg)
+			}
+			got, err := expandDataPaths(args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := make([]string, len(tt.want))
+			for i, name := range tt.want {
+				want[i] = filepath.Join(root, filepath.FromSlash(name))
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"abt-dashboard/internal/ingest"
+	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/transform"
+)
+
+// loadInventory reads the stock snapshots of the inventory files through
+// the flexible pipeline, so that product names are normalized like those of
+// the transactions. A file that cannot be read is logged and skipped; for
+// the same as-of day, levels from later files replace those of earlier ones.
+func loadInventory(handler *transform.FlexibleDataHandler, files []string) []models.Inventory {
+	var inventory []models.Inventory
+	for _, file := range files {
+		levels, result, err := handler.ProcessInventoryFile(file)
+		if err != nil {
+			log.Printf("failed to load inventory %s (continuing anyway): %v", file, err)
+			continue
+		}
+		log.Printf("Loaded %d stock levels in %d warehouse(s) %v as of %v from %s (%d records, %d skipped, %d dated by the file)",
+			result.StockLevels, len(result.Warehouses), result.Warehouses, result.Snapshots, file,
+			result.Records, result.SkippedRecords, result.Undated)
+		for _, msg := range result.Errors {
+			log.Printf("  - %s", msg)
+		}
+		inventory = append(inventory, levels...)
+	}
+	return inventory
+}
+
+// loadInventoryCSV reads the inventory files with the traditional CSV
+// parser, which keeps product names as written. Rows without an as-of date
+// are taken as of the file's modification time.
+func loadInventoryCSV(files []string) []models.Inventory {
+	var inventory []models.Inventory
+	for _, file := range files {
+		levels, err := parseInventoryFile(file)
+		if err != nil {
+			log.Printf("failed to parse inventory %s (continuing anyway): %v", file, err)
+			continue
+		}
+		log.Printf("Loaded %d stock levels from %s", len(levels), file)
+		inventory = append(inventory, levels...)
+	}
+	return inventory
+}
+
+func parseInventoryFile(file string) ([]models.Inventory, error) {
+	reader, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("inventory file missing: %w", err)
+	}
+	defer reader.Close()
+
+	info, err := reader.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("inventory file unreadable: %w", err)
+	}
+	levels, err := ingest.ParseInventoryCSV(reader)
+	if err != nil {
+		return nil, err
+	}
+	for i := range levels {
+		if levels[i].AsOf.IsZero() {
+			levels[i].AsOf = info.ModTime()
+		}
+	}
+	return levels, nil
+}
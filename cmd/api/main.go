@@ -1,142 +1,363 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
+	"sort"
+	"time"
 
+	"abt-dashboard/internal/config"
 	"abt-dashboard/internal/handlers"
 	"abt-dashboard/internal/ingest"
+	"abt-dashboard/internal/jobs"
 	"abt-dashboard/internal/metrics"
 	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/quarantine"
 	"abt-dashboard/internal/server"
+	"abt-dashboard/internal/spool"
 	"abt-dashboard/internal/transform"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "profile" {
+		runProfile(os.Args[2:])
+		return
+	}
+
 	var (
-		dataPath      string
-		inventoryPath string
-		staticDir     string
-		addr          string
-		configPath    string
-		useFlexible   bool
+		dataPaths      pathList
+		inventoryPaths pathList
+		staticDir      string
+		addr           string
+		configPath     string
+		useFlexible    bool
+		useStreaming   bool
+		ingestToken    string
+		ingestMax      int64
+		lineageMax     int
+		configLayers   configLayerFlags
+		dashboardPath  string
+		watchInterval  time.Duration
+		reprocess      bool
 	)
 
 	// Command-line flags for file names
-	flag.StringVar(&dataPath, "data", "dataset.csv", "path to transactions data file")
-	flag.StringVar(&inventoryPath, "inventory", "dataset.csv", "path to inventory CSV")
+	flag.Var(&dataPaths, "data", "transactions data file, glob or directory; repeatable (default dataset.csv)")
+	flag.Var(&inventoryPaths, "inventory", "inventory data file, glob or directory in any data format; repeatable (default dataset.csv)")
 	flag.StringVar(&staticDir, "static", "web", "path to static files directory")
 	flag.StringVar(&addr, "addr", ":8080", "server listen address")
 	flag.StringVar(&configPath, "config", "config/data_transformation.yaml", "path to transformation config")
+	configLayers.register(flag.CommandLine)
+	flag.StringVar(&dashboardPath, "dashboard", "config/dashboard.json", "path to dashboard config")
+	flag.DurationVar(&watchInterval, "watch", config.DefaultWatchInterval, "how often to check the config files for changes to reload (0 disables hot reload)")
+	flag.BoolVar(&reprocess, "reload-reprocess", false, "reprocess the data files and the files ingested since startup when the transformation config is reloaded (flexible mode only)")
 	flag.BoolVar(&useFlexible, "flexible", true, "use flexible data handling system")
+	flag.BoolVar(&useStreaming, "stream", false, "stream data into the aggregator in bounded-memory chunks (flexible mode only)")
+	flag.StringVar(&ingestToken, "ingest-token", os.Getenv("INGEST_TOKEN"), "bearer token enabling POST /api/ingest (default $INGEST_TOKEN; empty disables it)")
+	flag.Int64Var(&ingestMax, "ingest-max-bytes", handlers.DefaultIngestMaxBytes, "maximum size of one upload to /api/ingest")
+	flag.IntVar(&lineageMax, "lineage-max", metrics.DefaultLineageLimit, "transactions whose lineage is kept for /api/drilldown (0 disables it)")
 	flag.Parse()
 
+	if err := configLayers.checkEnvironment(configPath); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if len(dataPaths) == 0 {
+		dataPaths = pathList{"dataset.csv"}
+	}
+	dataFiles, err := expandDataPaths(dataPaths)
+	if err != nil {
+		log.Fatalf("Failed to resolve data files: %v", err)
+	}
+	log.Printf("Loading %d data file(s)", len(dataFiles))
+
+	if len(inventoryPaths) == 0 {
+		inventoryPaths = pathList{"dataset.csv"}
+	}
+	inventoryFiles, err := expandDataPaths(inventoryPaths)
+	if err != nil {
+		log.Printf("inventory files missing: %v", err)
+	}
+	var inventory []models.Inventory
+
 	var transactions []models.Transaction
+	var agg *metrics.Aggregator
+	var ingestHandler *transform.FlexibleDataHandler
+	var effective *transform.EffectiveConfig
 
 	if useFlexible {
 		// Use flexible data handling system
 		log.Printf("Using flexible data handling system with config: %s", configPath)
 
 		// Load transformation configuration
-		config, err := transform.LoadTransformationConfigFromPath(configPath)
+		config, configEffective, err := configLayers.load(configPath)
 		if err != nil {
 			log.Printf("Failed to load config, using defaults: %v", err)
 			config = transform.LoadDefaultTransformationConfig()
+		} else {
+			effective = configEffective
+			logConfigLayers(effective)
+		}
+		agg = metrics.NewAggregatorInLocation(config.Timezone.ReportingLocation())
+		agg.SetLineageLimit(lineageMax)
+		log.Printf("Reporting timezone: %s", config.Timezone.ReportingLocation())
+		if config.Currency.ReportingCurrency != "" {
+			log.Printf("Reporting currency: %s (FX rates for %v)",
+				config.Currency.ReportingCurrency, config.Currency.Rates.Currencies())
 		}
 
-		// Create flexible data handler
+		// Create flexible data handler. Uploads to /api/ingest go through
+		// the same handler, so duplicates of loaded IDs are reported.
 		dataHandler := transform.NewFlexibleDataHandler(config)
+		dataHandler.SetRawLineage(agg)
+		ingestHandler = dataHandler
 
-		// Process data file with automatic format detection and transformation
-		var result *transform.TransformationResult
-		transactions, result, err = dataHandler.ProcessDataFile(dataPath)
-		if err != nil {
-			log.Fatalf("Failed to process data file: %v", err)
-		}
-
-		// Log processing results
-		log.Printf("Data processing completed:")
-		log.Printf("  - Original records: %d", result.OriginalRecords)
-		log.Printf("  - Transformed records: %d", result.TransformedRecords)
-		log.Printf("  - Skipped records: %d", result.SkippedRecords)
-		log.Printf("  - Processing time: %v", result.ProcessingTime)
-		log.Printf("  - Data quality score: %.2f%%", result.DataQuality.Completeness*100)
-		log.Printf("  - Transformations applied: %v", result.Transformations)
-
-		// Log warnings and errors if any
-		if len(result.Warnings) > 0 {
-			log.Printf("Warnings encountered:")
-			for _, warning := range result.Warnings {
-				log.Printf("  - %s", warning)
+		// Process each data file with automatic format detection and
+		// transformation. The handler is shared so that UniquenessValidator
+		// also reports IDs duplicated across files.
+		var totals transform.TransformationResult
+		for _, dataPath := range dataFiles {
+			var result *transform.TransformationResult
+			if useStreaming {
+				// Feed each chunk straight into the aggregator so the full
+				// dataset is never held in memory
+				result, err = dataHandler.ProcessDataFileStreaming(dataPath, func(chunk []models.Transaction) error {
+					agg.AddTransactions(chunk)
+					return nil
+				})
+			} else {
+				var fileTransactions []models.Transaction
+				fileTransactions, result, err = dataHandler.ProcessDataFile(dataPath)
+				transactions = append(transactions, fileTransactions...)
+			}
+			if err != nil {
+				log.Fatalf("Failed to process data file %s: %v", dataPath, err)
 			}
+
+			logTransformationResult(dataPath, result)
+
+			totals.OriginalRecords += result.OriginalRecords
+			totals.TransformedRecords += result.TransformedRecords
+			totals.SkippedRecords += result.SkippedRecords
+			totals.ProcessingTime += result.ProcessingTime
 		}
 
-		if len(result.Errors) > 0 {
-			log.Printf("Errors encountered:")
-			for _, error := range result.Errors {
-				log.Printf("  - %s", error)
-			}
+		if len(dataFiles) > 1 {
+			log.Printf("All %d files processed: %d original, %d transformed, %d skipped records in %v",
+				len(dataFiles), totals.OriginalRecords, totals.TransformedRecords,
+				totals.SkippedRecords, totals.ProcessingTime)
 		}
 
-		// Generate and log data quality report
-		qualityReport := dataHandler.GetDataQualityReport(transactions)
-		if len(qualityReport.Issues) > 0 {
-			log.Printf("Data quality issues detected:")
-			for _, issue := range qualityReport.Issues {
-				log.Printf("  - %s: %s (%d records)", issue.Type, issue.Description, issue.Count)
-			}
+		if q := dataHandler.Quarantine(); q != nil {
+			logQuarantine(q)
 		}
 
-		if len(qualityReport.Recommendations) > 0 {
-			log.Printf("Data quality recommendations:")
-			for _, rec := range qualityReport.Recommendations {
-				log.Printf("  - %s", rec)
-			}
+		// The per-record quality report needs the full dataset, so it is
+		// only available when not streaming
+		if !useStreaming {
+			logDataQualityReport(dataHandler.GetDataQualityReport(transactions))
 		}
+
+		inventory = loadInventory(dataHandler, inventoryFiles)
 	} else {
 		// Use traditional data handling
 		log.Printf("Using traditional data handling system")
 
-		// Open dataset CSV
-		transReader, err := os.Open(dataPath)
-		if err != nil {
-			log.Fatalf("failed to open transactions: %v", err)
+		// Only the quarantine and reporting timezone settings of the
+		// transformation config apply
+		var q *quarantine.Writer
+		agg = metrics.NewAggregator()
+		if config, configEffective, err := configLayers.load(configPath); err == nil {
+			effective = configEffective
+			logConfigLayers(effective)
+			agg = metrics.NewAggregatorInLocation(config.Timezone.ReportingLocation())
+			if config.Quarantine.Path != "" {
+				q, err = quarantine.New(config.Quarantine.Path, config.Quarantine.Format)
+				if err != nil {
+					log.Printf("Quarantine disabled: %v", err)
+				}
+			}
 		}
-		defer transReader.Close()
+		agg.SetLineageLimit(lineageMax)
 
-		// Parse CSVs using traditional method
-		transactions, err = ingest.ParseTransactionsCSV(transReader)
-		if err != nil {
-			log.Fatalf("failed to parse transactions: %v", err)
+		for _, dataPath := range dataFiles {
+			// Open dataset CSV
+			transReader, err := os.Open(dataPath)
+			if err != nil {
+				log.Fatalf("failed to open transactions: %v", err)
+			}
+
+			// Parse CSVs using traditional method. Only the transactions
+			// the aggregator traces keep their raw row.
+			rawLimit := max(lineageMax-len(transactions), 0)
+			fileTransactions, err := ingest.ParseTransactionsCSVWithQuarantine(transReader, dataPath, q, rawLimit)
+			transReader.Close()
+			if err != nil {
+				log.Fatalf("failed to parse transactions in %s: %v", dataPath, err)
+			}
+			log.Printf("Loaded %d transactions from %s", len(fileTransactions), dataPath)
+			transactions = append(transactions, fileTransactions...)
 		}
-	}
 
-	// Handle inventory data (common for both approaches)
-	invMap := map[string]models.Inventory{}
-	var invReader *os.File
-	invReader, err := os.Open(inventoryPath)
-	if err != nil {
-		log.Printf("inventory file missing: %v", err)
-	}
-	if invReader != nil {
-		inv, err := ingest.ParseInventoryCSV(invReader)
-		if err != nil {
-			log.Printf("failed to parse inventory (continuing anyway): %v", err)
-		} else {
-			invMap = inv
+		if q != nil {
+			logQuarantine(q)
 		}
+
+		inventory = loadInventoryCSV(inventoryFiles)
 	}
 
 	// Aggregate
-	agg := metrics.NewAggregator()
-	agg.Ingest(transactions, invMap)
+	agg.Ingest(transactions, inventory)
 
 	// Start HTTP server
-	api := &handlers.API{Agg: agg}
+	configs := &handlers.Configs{}
+	configs.Transform.Store(effective)
+	configs.Dashboard.Store(loadDashboard(dashboardPath))
+	api := &handlers.API{Agg: agg, Configs: configs}
+	var ingested *spool.Spool
+	if ingestToken != "" {
+		if ingestHandler == nil {
+			// Traditional mode: uploads still use the flexible pipeline
+			config, _, err := configLayers.load(configPath)
+			if err != nil {
+				config = transform.LoadDefaultTransformationConfig()
+			}
+			ingestHandler = transform.NewFlexibleDataHandler(config)
+			ingestHandler.SetRawLineage(agg)
+		}
+		api.Ingester = handlers.NewIngester(ingestHandler, ingestToken, ingestMax)
+		api.Ingester.Jobs = jobs.NewQueue(ingestHandler, agg)
+		log.Printf("Live ingestion enabled at POST /api/ingest and POST /api/jobs")
+
+		if reprocess && useFlexible && watchInterval > 0 {
+			// Keep the ingested files, so that reprocessing does not drop them
+			ingested, err = spool.New("")
+			if err != nil {
+				log.Fatalf("Failed to enable -reload-reprocess: %v", err)
+			}
+			api.Ingester.Spool = ingested
+			api.Ingester.Jobs.Spool = ingested
+			log.Printf("Keeping copies of ingested files in %s for -reload-reprocess", ingested.Dir())
+		}
+	}
+
+	// Profiles read the data files with the flexible converter in either
+	// mode. The profiler has a handler of its own: a handler processes one
+	// source at a time, and uploads must not wait for a profiling pass.
+	profileConfig, _, err := configLayers.load(configPath)
+	if err != nil {
+		profileConfig = transform.LoadDefaultTransformationConfig()
+	}
+	profileConfig.Quarantine.Path = ""
+	profileHandler := transform.NewFlexibleDataHandler(profileConfig)
+	api.Profiler = handlers.NewProfiler(profileHandler, dataFiles)
+	// Profile in the background so the first request rarely has to wait
+	api.Profiler.Start()
+
+	// Reload edited config files without a restart
+	if watchInterval > 0 {
+		reload := &reloader{
+			configPath:    configPath,
+			dashboardPath: dashboardPath,
+			layers:        &configLayers,
+			configs:       configs,
+			agg:           agg,
+			profiler:      profileHandler,
+			profiles:      api.Profiler,
+		}
+		if ingestHandler != nil {
+			reload.handlers = append(reload.handlers, ingestHandler)
+		}
+		if reprocess {
+			if useFlexible {
+				reload.reprocess = reprocessFiles(agg, dataFiles, inventoryFiles, ingested)
+			} else {
+				log.Printf("-reload-reprocess only applies in flexible mode")
+			}
+		}
+		go reload.watch(context.Background(), watchInterval)
+	}
 	srv := server.New(api, staticDir)
 	log.Printf("Server listening on %s", addr)
 	if err := srv.Listen(addr); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// logTransformationResult logs the summary, warnings and errors of processing one source
+func logTransformationResult(source string, result *transform.TransformationResult) {
+	log.Printf("Data processing completed for %s:", source)
+	log.Printf("  - Original records: %d", result.OriginalRecords)
+	log.Printf("  - Transformed records: %d", result.TransformedRecords)
+	log.Printf("  - Skipped records: %d", result.SkippedRecords)
+	log.Printf("  - Processing time: %v", result.ProcessingTime)
+	log.Printf("  - Data quality score: %.2f%%", result.DataQuality.Completeness*100)
+	log.Printf("  - Transformations applied: %v", result.Transformations)
+	if result.QuarantinedEntries > 0 {
+		log.Printf("  - Quarantine entries: %d", result.QuarantinedEntries)
+	}
+	if result.RoundedValues > 0 {
+		log.Printf("  - Rounded values: %d", result.RoundedValues)
+	}
+	rules := make([]string, 0, len(result.RuleViolations))
+	for rule := range result.RuleViolations {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		log.Printf("  - Rule %s broken by %d records", rule, result.RuleViolations[rule])
+	}
+
+	for _, member := range result.Members {
+		log.Printf("  - %s (%s): %d records, %d transformed, %d skipped",
+			member.Name, member.Format, member.OriginalRecords, member.TransformedRecords, member.SkippedRecords)
+	}
+
+	// Log warnings and errors if any
+	if len(result.Warnings) > 0 {
+		log.Printf("Warnings encountered:")
+		for _, warning := range result.Warnings {
+			log.Printf("  - %s", warning)
+		}
+	}
+
+	if len(result.Errors) > 0 {
+		log.Printf("Errors encountered:")
+		for _, error := range result.Errors {
+			log.Printf("  - %s", error)
+		}
+	}
+}
+
+// logDataQualityReport logs the issues and recommendations of a quality report
+func logDataQualityReport(qualityReport transform.DataQualityReport) {
+	if len(qualityReport.Issues) > 0 {
+		log.Printf("Data quality issues detected:")
+		for _, issue := range qualityReport.Issues {
+			log.Printf("  - %s: %s (%d records)", issue.Type, issue.Description, issue.Count)
+		}
+	}
+
+	if len(qualityReport.Recommendations) > 0 {
+		log.Printf("Data quality recommendations:")
+		for _, rec := range qualityReport.Recommendations {
+			log.Printf("  - %s", rec)
+		}
+	}
+}
+
+// logQuarantine reports how many quarantine entries were written and where
+func logQuarantine(q *quarantine.Writer) {
+	counts := q.Counts()
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	if total == 0 {
+		return
+	}
+	log.Printf("Wrote %d quarantine entries to %s (%d rejected, %d defaulted, %d flagged, %d rounded)", total, q.Path(),
+		counts[quarantine.ActionRejected], counts[quarantine.ActionDefaulted], counts[quarantine.ActionFlagged],
+		counts[quarantine.ActionRounded])
+}
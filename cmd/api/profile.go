@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"abt-dashboard/internal/transform"
+)
+
+// profileTopShown is the number of top values listed per column in the
+// table; -json lists all of them
+const profileTopShown = 3
+
+// runProfile implements the profile subcommand, which profiles the columns of
+// data files without starting the server:
+//
+//	api profile [-config path] [-env name] [-set path=value]... [-json] data-file-or-glob...
+func runProfile(args []string) {
+	flags := flag.NewFlagSet("profile", flag.ExitOnError)
+	configPath := flags.String("config", "config/data_transformation.yaml", "path to transformation config")
+	asJSON := flags.Bool("json", false, "print the full profiles as JSON")
+	var configLayers configLayerFlags
+	configLayers.register(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s profile [flags] data-file-or-glob...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	files, err := expandDataPaths(flags.Args())
+	if err != nil {
+		log.Fatalf("Failed to resolve data files: %v", err)
+	}
+
+	if err := configLayers.checkEnvironment(*configPath); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	config, _, err := configLayers.load(*configPath)
+	if err != nil {
+		log.Printf("Failed to load config, using defaults: %v", err)
+		config = transform.LoadDefaultTransformationConfig()
+	}
+	// Profiling only reads the files
+	config.Quarantine.Path = ""
+	handler := transform.NewFlexibleDataHandler(config)
+
+	profiles := make([]*transform.DataProfile, 0, len(files))
+	for _, file := range files {
+		profile, err := handler.ProfileDataFile(file)
+		if err != nil {
+			log.Fatalf("Failed to profile %s: %v", file, err)
+		}
+		profiles = append(profiles, profile)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(profiles); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	for i, profile := range profiles {
+		if i > 0 {
+			fmt.Println()
+		}
+		printProfile(profile)
+	}
+}
+
+// printProfile prints one line per column of a profile
+func printProfile(profile *transform.DataProfile) {
+	fmt.Printf("%s: %d records, %d would be skipped, %d columns\n\n",
+		profile.Source, profile.Records, profile.SkippedRecords, len(profile.Columns))
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "COLUMN\tTYPE\tNULLS\tDISTINCT\tMIN\tMAX\tTOP VALUES\t")
+	for _, c := range profile.Columns {
+		distinct := fmt.Sprint(c.Distinct)
+		if c.DistinctCapped {
+			distinct += "+"
+		}
+		var top []string
+		for i, v := range c.TopValues {
+			if i == profileTopShown {
+				break
+			}
+			top = append(top, fmt.Sprintf("%s (%d)", shorten(v.Value), v.Count))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.1f%%\t%s\t%s\t%s\t%s\t\n", c.Name, c.Type, c.NullRate*100, distinct,
+			shorten(c.Min), shorten(c.Max), strings.Join(top, ", "))
+	}
+	tw.Flush()
+}
+
+// shorten truncates long values for the table
+func shorten(value string) string {
+	const width = 24
+	if runes := []rune(value); len(runes) > width {
+		return string(runes[:width-1]) + "…"
+	}
+	return value
+}
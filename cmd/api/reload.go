@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"abt-dashboard/internal/config"
+	"abt-dashboard/internal/handlers"
+	"abt-dashboard/internal/metrics"
+	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/spool"
+	"abt-dashboard/internal/transform"
+)
+
+// reloader applies edits of the config files to the running server. An edit
+// that does not load or validate is logged and the current config is kept.
+type reloader struct {
+	configPath    string
+	dashboardPath string
+	layers        *configLayerFlags
+	configs       *handlers.Configs
+	agg           *metrics.Aggregator
+
+	// handlers process uploads and are reconfigured with a new
+	// transformation config; profiler only profiles the data files. The
+	// profiles are computed again with the new config.
+	handlers []*transform.FlexibleDataHandler
+	profiler *transform.FlexibleDataHandler
+	profiles *handlers.Profiler
+
+	// reprocess rebuilds the aggregates with a new transformation config
+	// when set
+	reprocess func(transform.TransformConfig) error
+}
+
+// watch polls the config files until ctx is done, reloading the ones that
+// change. The environment file is watched even if it does not exist yet.
+func (r *reloader) watch(ctx context.Context, interval time.Duration) {
+	paths := []string{r.configPath}
+	if r.layers.env != "" {
+		paths = append(paths, transform.NewConfigLoader(r.configPath).EnvironmentConfigPath(r.layers.env))
+	}
+	paths = append(paths, r.dashboardPath)
+	log.Printf("Watching %s for changes", strings.Join(paths, ", "))
+
+	config.WatchFiles(ctx, interval, paths, func(changed []string) {
+		transformChanged, dashboardChanged := false, false
+		for _, path := range changed {
+			if path == r.dashboardPath {
+				dashboardChanged = true
+			} else {
+				transformChanged = true
+			}
+		}
+		if transformChanged {
+			r.reloadTransform()
+		}
+		if dashboardChanged {
+			r.reloadDashboard()
+		}
+	})
+}
+
+// reloadTransform loads and validates the transformation config and swaps it
+// into the data handlers and /api/config/effective
+func (r *reloader) reloadTransform() {
+	loader := transform.NewConfigLoader(r.configPath)
+	transformConfig, effective, err := r.layers.load(r.configPath)
+	if err == nil {
+		err = loader.ValidateConfig(transformConfig)
+	}
+	if err != nil {
+		log.Printf("Config reload failed, keeping the current transformation config: %v", err)
+		return
+	}
+
+	for _, handler := range r.handlers {
+		handler.Reconfigure(transformConfig)
+	}
+	if r.profiler != nil {
+		profileConfig := transformConfig
+		profileConfig.Quarantine.Path = ""
+		r.profiler.Reconfigure(profileConfig)
+	}
+	if r.profiles != nil {
+		r.profiles.Reset()
+	}
+	r.configs.Transform.Store(effective)
+	log.Printf("Reloaded transformation config")
+	logConfigLayers(effective)
+
+	if r.reprocess == nil {
+		if loc := transformConfig.Timezone.ReportingLocation(); loc.String() != r.agg.Location().String() {
+			log.Printf("The reporting timezone %s applies to loaded data after a restart or with -reload-reprocess", loc)
+		}
+		return
+	}
+	start := time.Now()
+	if err := r.reprocess(transformConfig); err != nil {
+		log.Printf("Reprocessing failed, keeping the current data: %v", err)
+		return
+	}
+	log.Printf("Reprocessed the data and ingested files with the new config in %v", time.Since(start))
+}
+
+// reloadDashboard loads and validates the dashboard config and swaps it into
+// /api/config/dashboard
+func (r *reloader) reloadDashboard() {
+	dashboard, err := config.LoadConfig(r.dashboardPath)
+	if err != nil {
+		log.Printf("Config reload failed, keeping the current dashboard config: %v", err)
+		return
+	}
+	r.configs.Dashboard.Store(dashboard)
+	log.Printf("Reloaded dashboard config from %s", r.dashboardPath)
+}
+
+// loadDashboard loads the dashboard config, falling back to the defaults
+func loadDashboard(path string) *config.DashboardConfig {
+	dashboard, err := config.LoadConfig(path)
+	if err != nil {
+		log.Printf("Failed to load dashboard config, using defaults: %v", err)
+		return config.DefaultConfig()
+	}
+	return dashboard
+}
+
+// reprocessFiles returns a reprocess function for reloader that runs the data
+// files and then the files ingested since startup through a new
+// transformation config into fresh aggregates, and swaps them in. The
+// ingested files come from the spool, which keeps further uploads from being
+// merged until the swap. The inventory files are read again, as the new
+// config may map their columns or product names differently.
+func reprocessFiles(agg *metrics.Aggregator, dataFiles, inventoryFiles []string, ingested *spool.Spool) func(transform.TransformConfig) error {
+	return func(transformConfig transform.TransformConfig) error {
+		// A handler of its own, so that validators start over and rows are
+		// not quarantined twice
+		transformConfig.Quarantine.Path = ""
+		handler := transform.NewFlexibleDataHandler(transformConfig)
+
+		return ingested.Rebuild(func(sources []spool.Source) error {
+			fresh := metrics.NewAggregatorInLocation(transformConfig.Timezone.ReportingLocation())
+			fresh.SetLineageLimit(agg.LineageLimit())
+			handler.SetRawLineage(fresh)
+			sink := func(chunk []models.Transaction) error {
+				fresh.AddTransactions(chunk)
+				return nil
+			}
+			for _, dataPath := range dataFiles {
+				if _, err := handler.ProcessDataFileStreaming(dataPath, sink); err != nil {
+					return fmt.Errorf("failed to process data file %s: %w", dataPath, err)
+				}
+			}
+			for _, source := range sources {
+				if err := replaySource(handler, source, sink); err != nil {
+					return err
+				}
+			}
+			fresh.MergeInventory(loadInventory(handler, inventoryFiles))
+
+			agg.Replace(fresh)
+			return nil
+		})
+	}
+}
+
+// replaySource processes an ingested file again as an upload, so records
+// whose transaction ID is already loaded are skipped as they were at first
+func replaySource(handler *transform.FlexibleDataHandler, source spool.Source, sink func([]models.Transaction) error) error {
+	file, err := os.Open(source.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open ingested file %s: %w", source.Name, err)
+	}
+	defer file.Close()
+
+	_, err = handler.ProcessUpload(context.Background(), transform.Upload{Name: source.Name, Reader: file, Sink: sink})
+	if err != nil {
+		return fmt.Errorf("failed to process ingested file %s: %w", source.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"abt-dashboard/internal/handlers"
+	"abt-dashboard/internal/metrics"
+	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/spool"
+	"abt-dashboard/internal/transform"
+)
+
+func TestReprocessFiles_KeepsIngested(t *testing.T) {
+	const header = "transaction_id,transaction_date,country,region,product_name,price,quantity\n"
+	dataPath := filepath.Join(t.TempDir(), "data.csv")
+	data := header + "tx-1,2024-04-10T12:00:00Z,USA,East,Widget,10.00,1\ntx-2,2024-04-11T12:00:00Z,USA,East,Widget,10.00,2\n"
+	if err := os.WriteFile(dataPath, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := transform.LoadDefaultTransformationConfig()
+	handler := transform.NewFlexibleDataHandler(config)
+	agg := metrics.NewAggregator()
+	if _, err := handler.ProcessDataFileStreaming(dataPath, func(chunk []models.Transaction) error {
+		agg.AddTransactions(chunk)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ingested, err := spool.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &handlers.API{Agg: agg, Ingester: handlers.NewIngester(handler, "secret", 0)}
+	api.Ingester.Spool = ingested
+	ingest := func(body string) int {
+		req := httptest.NewRequest("POST", "/api/ingest?format=csv", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		api.Ingest(rr, req)
+		return rr.Code
+	}
+	// tx-2 is already loaded and skipped; the second upload fails and is
+	// not kept
+	if code := ingest(header + "tx-2,2024-04-11T12:00:00Z,USA,East,Widget,10.00,2\nup-1,2024-04-30T23:30:00Z,USA,East,Widget,10.00,4\n"); code != http.StatusOK {
+		t.Fatalf("ingest: got status %d", code)
+	}
+	if code := ingest(header + "up-2,2024-04-12T12:00:00Z,USA,East,Widget,10.00,\"8\n"); code == http.StatusOK {
+		t.Fatalf("malformed ingest: got status %d", code)
+	}
+
+	// Reporting in UTC+2 moves up-1 into May
+	config.Timezone.Reporting = "+02:00"
+	if err := reprocessFiles(agg, []string{dataPath}, nil, ingested)(config); err != nil {
+		t.Fatal(err)
+	}
+
+	months := agg.SalesByMonth()
+	if len(months) != 2 || months[0].UnitsSold != 3 || months[1].YearMonth != "2024-05" || months[1].UnitsSold != 4 {
+		t.Errorf("months after reprocessing: got %+v", months)
+	}
+	if top := agg.TopProducts(1, false); len(top) != 1 || top[0].TxCount != 3 {
+		t.Errorf("top products after reprocessing: got %+v", top)
+	}
+}
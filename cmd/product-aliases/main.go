@@ -0,0 +1,167 @@
+// Command product-aliases suggests product names to merge. It runs data
+// files through the transformation pipeline, groups product names that look
+// alike, reports how much revenue each merge would move and can write the
+// suggestions into an alias file for review:
+//
+//	product-aliases -config config/data_transformation.yaml -out config/product_aliases.yaml dataset.csv
+//
+// Point transformation.product_aliases_file at the reviewed file to apply it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"abt-dashboard/internal/metrics"
+	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/money"
+	"abt-dashboard/internal/transform"
+)
+
+func main() {
+	var (
+		configPath string
+		threshold  float64
+		outPath    string
+	)
+	flag.StringVar(&configPath, "config", "config/data_transformation.yaml", "path to transformation config")
+	flag.Float64Var(&threshold, "threshold", transform.DefaultSimilarityThreshold, "minimum similarity (0-1) of names to merge")
+	flag.StringVar(&outPath, "out", "", "write the configured aliases plus the suggestions to this alias file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] data-file-or-glob...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 || threshold <= 0 || threshold > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	config, err := transform.LoadTransformationConfigFromPath(configPath)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	// Only report; do not write quarantine entries
+	config.Quarantine.Path = ""
+
+	products, err := loadProducts(config, flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	units := 2
+	if config.Currency.ReportingCurrency != "" {
+		units = money.MinorUnits(config.Currency.ReportingCurrency)
+	}
+	formatCents := func(cents int64) string { return money.Format(cents, units) }
+
+	merges := transform.SuggestProductMerges(products, threshold)
+	printReport(products, merges, threshold, formatCents)
+
+	if outPath != "" {
+		var aliases transform.ProductAliasFile
+		if config.ProductAliasesFile != "" {
+			if aliases, err = transform.LoadProductAliases(config.ProductAliasesFile); err != nil {
+				log.Fatal(err)
+			}
+		}
+		out, err := os.Create(outPath)
+		if err != nil {
+			log.Fatalf("Failed to create alias file: %v", err)
+		}
+		err = transform.WriteProductAliases(out, aliases.AddMerges(merges), merges, formatCents)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Fatalf("Failed to write alias file: %v", err)
+		}
+		log.Printf("Wrote %s; review it and set transformation.product_aliases_file to apply it", outPath)
+	}
+}
+
+// loadProducts streams the data files through the pipeline and returns the
+// revenue and transaction count of every product name
+func loadProducts(config transform.TransformConfig, args []string) ([]transform.ProductStats, error) {
+	var files []string
+	for _, arg := range args {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", arg, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", arg)
+		}
+		files = append(files, matches...)
+	}
+
+	handler := transform.NewFlexibleDataHandler(config)
+	agg := metrics.NewAggregatorInLocation(config.Timezone.ReportingLocation())
+	agg.SetLineageLimit(0) // only the product totals are needed
+	for _, file := range files {
+		_, err := handler.ProcessDataFileStreaming(file, func(chunk []models.Transaction) error {
+			agg.AddTransactions(chunk)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to process %s: %w", file, err)
+		}
+	}
+
+	revenue := make(map[string]int64)
+	for _, row := range agg.CountryRevenueTable() {
+		revenue[row.ProductName] += row.TotalRevenue
+	}
+	var products []transform.ProductStats
+	for _, p := range agg.TopProducts(0, false) {
+		products = append(products, transform.ProductStats{
+			Name:         p.ProductName,
+			RevenueCents: revenue[p.ProductName],
+			Transactions: p.TxCount,
+		})
+	}
+	return products, nil
+}
+
+// printReport lists the suggested merges with the revenue each would move
+func printReport(products []transform.ProductStats, merges []transform.ProductMerge, threshold float64, formatCents func(int64) string) {
+	var total, affected int64
+	for _, p := range products {
+		total += p.RevenueCents
+	}
+	for _, m := range merges {
+		affected += m.AffectedRevenueCents
+	}
+
+	fmt.Printf("%d product names, %d suggested merges at similarity %.2f\n", len(products), len(merges), threshold)
+	fmt.Printf("Revenue affected: %s of %s (%s)\n\n", formatCents(affected), formatCents(total), percent(affected, total))
+	if len(merges) == 0 {
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSIMILARITY\tTRANSACTIONS\tREVENUE\tSHARE\t")
+	for _, m := range merges {
+		fmt.Fprintf(tw, "%s\t\t%d\t%s\t%s\t\n", strings.TrimSpace(m.Canonical.Name),
+			m.Canonical.Transactions, formatCents(m.Canonical.RevenueCents), percent(m.Canonical.RevenueCents, total))
+		for _, a := range m.Aliases {
+			fmt.Fprintf(tw, "  <- %s\t%.2f\t%d\t%s\t%s\t\n", a.Name, a.Similarity,
+				a.Transactions, formatCents(a.RevenueCents), percent(a.RevenueCents, total))
+		}
+		fmt.Fprintf(tw, "  affected\t\t\t%s\t%s\t\n", formatCents(m.AffectedRevenueCents), percent(m.AffectedRevenueCents, total))
+	}
+	tw.Flush()
+}
+
+func percent(part, total int64) string {
+	if total == 0 {
+		return "0.00%"
+	}
+	return fmt.Sprintf("%.2f%%", float64(part)*100/float64(total))
+}
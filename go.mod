@@ -2,4 +2,7 @@ module abt-dashboard
 
 go 1.24.2
 
-require gopkg.in/yaml.v2 v2.4.0
+require (
+	github.com/klauspost/compress v1.18.0
+	gopkg.in/yaml.v2 v2.4.0
+)
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"strings"
 	"sync"
 )
 
@@ -150,10 +151,65 @@ func LoadConfig(filename string) (*DashboardConfig, error) {
 	if err := json.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %v", err)
 	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", filename, err)
+	}
 
 	return &config, nil
 }
 
+// componentSizes are the sizes a component can take
+var componentSizes = map[string]bool{"small": true, "medium": true, "large": true, "full-width": true}
+
+// Validate checks the configuration against the dashboard schema: a title,
+// and components with unique IDs, a type, a known size and an API data
+// source. Sections the dashboard does not read are not checked.
+func (c *DashboardConfig) Validate() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if strings.TrimSpace(c.Title) == "" {
+		return fmt.Errorf("title must not be empty")
+	}
+
+	ids := make(map[string]bool, len(c.Components))
+	for i, component := range c.Components {
+		if component.ID == "" {
+			return fmt.Errorf("components[%d]: id must not be empty", i)
+		}
+		if ids[component.ID] {
+			return fmt.Errorf("components[%d]: duplicate id %q", i, component.ID)
+		}
+		ids[component.ID] = true
+
+		if component.Type == "" {
+			return fmt.Errorf("component %s: type must not be empty", component.ID)
+		}
+		if component.Size != "" && !componentSizes[component.Size] {
+			return fmt.Errorf("component %s: size must be small, medium, large or full-width, got %q",
+				component.ID, component.Size)
+		}
+		if component.DataSource != "" && !strings.HasPrefix(component.DataSource, "/") {
+			return fmt.Errorf("component %s: data_source must be a path such as /api/products/top, got %q",
+				component.ID, component.DataSource)
+		}
+		if component.Position < 0 {
+			return fmt.Errorf("component %s: position must not be negative, got %d", component.ID, component.Position)
+		}
+		if component.RefreshRate < 0 {
+			return fmt.Errorf("component %s: refresh_rate must not be negative, got %d", component.ID, component.RefreshRate)
+		}
+	}
+
+	if c.API.Timeout < 0 || c.API.CacheTime < 0 {
+		return fmt.Errorf("api.timeout and api.cache_time must not be negative")
+	}
+	if c.Performance.LoadTimeout < 0 {
+		return fmt.Errorf("performance.load_timeout must not be negative, got %d", c.Performance.LoadTimeout)
+	}
+	return nil
+}
+
 // SaveConfig saves configuration to a JSON file
 func (c *DashboardConfig) SaveConfig(filename string) error {
 	c.mu.RLock()
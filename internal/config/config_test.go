@@ -0,0 +1,81 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboardConfigValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("default config: %v", err)
+	}
+
+	for name, edit := range map[string]func(c *DashboardConfig){
+		"no title":           func(c *DashboardConfig) { c.Title = " " },
+		"duplicate id":       func(c *DashboardConfig) { c.Components[1].ID = c.Components[0].ID },
+		"empty id":           func(c *DashboardConfig) { c.Components[0].ID = "" },
+		"no type":            func(c *DashboardConfig) { c.Components[0].Type = "" },
+		"unknown size":       func(c *DashboardConfig) { c.Components[0].Size = "huge" },
+		"data source URL":    func(c *DashboardConfig) { c.Components[0].DataSource = "api/products/top" },
+		"negative refresh":   func(c *DashboardConfig) { c.Components[0].RefreshRate = -1 },
+		"negative timeout":   func(c *DashboardConfig) { c.API.Timeout = -1 },
+		"negative load time": func(c *DashboardConfig) { c.Performance.LoadTimeout = -5 },
+	} {
+		c := DefaultConfig()
+		edit(c)
+		if err := c.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "dashboard.json")
+	os.WriteFile(path, []byte(`{"title": "Sales", "components": [{"id": "a", "type": "table", "size": "tiny"}]}`), 0o644)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "size") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestWatchFiles(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "dashboard.json")
+	created := filepath.Join(dir, "transform.production.yaml")
+	os.WriteFile(existing, []byte("{}"), 0o644)
+
+	changes := make(chan []string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go WatchFiles(ctx, 10*time.Millisecond, []string{existing, created}, func(changed []string) {
+		changes <- changed
+	})
+
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case changed := <-changes:
+			if len(changed) != 1 || changed[0] != want {
+				t.Fatalf("got changes %v, want %s", changed, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no change reported for %s", want)
+		}
+	}
+
+	// Let the watcher take its first look
+	time.Sleep(50 * time.Millisecond)
+	os.WriteFile(existing, []byte(`{"title": "Sales"}`), 0o644)
+	expect(existing)
+	os.WriteFile(created, []byte("performance:\n  batch_size: 10\n"), 0o644)
+	expect(created)
+	os.Remove(created)
+	expect(created)
+
+	select {
+	case changed := <-changes:
+		t.Errorf("unexpected change %v", changed)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
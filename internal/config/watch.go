@@ -0,0 +1,67 @@
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// DefaultWatchInterval is how often WatchFiles polls unless told otherwise
+const DefaultWatchInterval = 2 * time.Second
+
+// fileState is what WatchFiles compares between polls
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime int64 // nanoseconds since the epoch
+}
+
+func statFile(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{exists: true, size: info.Size(), modTime: info.ModTime().UnixNano()}
+}
+
+// WatchFiles polls files every interval until ctx is done and calls onChange
+// with the paths that changed. A file has changed when its size or
+// modification time differs or it was created or removed. A change is only
+// reported once the file has looked the same for a whole interval, so that
+// an editor saving in several writes is seen once, after the last one.
+func WatchFiles(ctx context.Context, interval time.Duration, paths []string, onChange func(changed []string)) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	reported := make(map[string]fileState, len(paths))
+	last := make(map[string]fileState, len(paths))
+	for _, path := range paths {
+		reported[path] = statFile(path)
+		last[path] = reported[path]
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var changed []string
+		for _, path := range paths {
+			state := statFile(path)
+			settled := state == last[path]
+			last[path] = state
+			if settled && state != reported[path] {
+				reported[path] = state
+				changed = append(changed, path)
+			}
+		}
+		if len(changed) > 0 {
+			onChange(changed)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"abt-dashboard/internal/config"
+	"abt-dashboard/internal/transform"
+)
+
+// Configs holds the configurations the server runs with. Hot reloads store
+// new ones, which requests see atomically: either the old or the new one.
+type Configs struct {
+	Transform atomic.Pointer[transform.EffectiveConfig]
+	Dashboard atomic.Pointer[config.DashboardConfig]
+}
+
+// GET /api/config/effective
+//
+// Lists every setting of the transformation config the server runs with, its
+// final value and the layer that set it: the built-in defaults, the config
+// file, the environment file, an ABT_* environment variable or a -set flag.
+func (api *API) EffectiveConfig(w http.ResponseWriter, r *http.Request) {
+	var effective *transform.EffectiveConfig
+	if api.Configs != nil {
+		effective = api.Configs.Transform.Load()
+	}
+	if effective == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeUncached(w, http.StatusOK, effective)
+}
+
+// GET /api/config/dashboard
+//
+// Returns the dashboard configuration: title, components, API and
+// performance settings.
+func (api *API) DashboardConfig(w http.ResponseWriter, r *http.Request) {
+	var dashboard *config.DashboardConfig
+	if api.Configs != nil {
+		dashboard = api.Configs.Dashboard.Load()
+	}
+	if dashboard == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeUncached(w, http.StatusOK, dashboard)
+}
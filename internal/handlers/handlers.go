@@ -2,8 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"abt-dashboard/internal/metrics"
 )
@@ -11,15 +14,46 @@ import (
 // API wraps our aggregator so it can serve JSON endpoints.
 type API struct {
 	Agg *metrics.Aggregator
+
+	// Ingester enables POST /api/ingest, and /api/jobs if it has a queue, when set
+	Ingester *Ingester
+
+	// Profiler enables GET /api/data/profile when set
+	Profiler *Profiler
+
+	// Configs enables GET /api/config/effective and /api/config/dashboard
+	// when set
+	Configs *Configs
 }
 
-func (api *API) writeJSON(w http.ResponseWriter, v interface{}) {
+// writeJSON writes dashboard data. Browsers must revalidate it on every use,
+// as its ETag changes whenever data is ingested; a request whose If-None-Match
+// holds the current ETag gets 304 Not Modified without a body.
+func (api *API) writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	etag := fmt.Sprintf("\"dashboard-data-%d\"", api.Agg.Version())
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.Header().Set("Cache-Control", "public, max-age=300") // 5 minutes browser caching for better performance
-	w.Header().Set("ETag", "\"dashboard-data\"")           // Simple ETag for cache validation
 	json.NewEncoder(w).Encode(v)
 }
 
+// etagMatches reports whether an If-None-Match header lists etag. The
+// comparison is weak, so a W/ prefix added by a compressing proxy still
+// matches.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
 // GET /api/revenue/countries?limit=100&offset=0
 func (api *API) CountryRevenue(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
@@ -37,7 +71,7 @@ func (api *API) CountryRevenue(w http.ResponseWriter, r *http.Request) {
 	// Apply pagination
 	start := offset
 	if start >= len(all) {
-		api.writeJSON(w, []interface{}{}) // Empty result
+		api.writeJSON(w, r, []interface{}{}) // Empty result
 		return
 	}
 
@@ -47,10 +81,14 @@ func (api *API) CountryRevenue(w http.ResponseWriter, r *http.Request) {
 	}
 
 	result := all[start:end]
-	api.writeJSON(w, result)
+	api.writeJSON(w, r, result)
 }
 
-// GET /api/products/top?limit=20&by=units
+// GET /api/products/top?limit=20&by=units&stock=warehouse&warehouse=north
+//
+// stock_qty is rolled up over all warehouses, or only the one named by
+// warehouse. stock=warehouse or stock=location also breaks it out per
+// warehouse or per warehouse location.
 func (api *API) TopProducts(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	limit, _ := strconv.Atoi(q.Get("limit"))
@@ -58,12 +96,70 @@ func (api *API) TopProducts(w http.ResponseWriter, r *http.Request) {
 		limit = 20
 	}
 	byUnits := q.Get("by") == "units"
-	api.writeJSON(w, api.Agg.TopProducts(limit, byUnits))
+	view := metrics.StockView{Warehouse: q.Get("warehouse"), By: q.Get("stock")}
+	switch view.By {
+	case "", metrics.StockByWarehouse, metrics.StockByLocation:
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("stock must be %q or %q", metrics.StockByWarehouse, metrics.StockByLocation))
+		return
+	}
+	if view == (metrics.StockView{}) {
+		api.writeJSON(w, r, api.Agg.TopProducts(limit, byUnits))
+		return
+	}
+	api.writeJSON(w, r, api.Agg.TopProductsStock(limit, byUnits, view))
+}
+
+// GET /api/products/{product}/stock?by=location&warehouse=north
+//
+// Lists the stock of one product per warehouse, or per warehouse location
+// with by=location.
+func (api *API) ProductStock(w http.ResponseWriter, r *http.Request) {
+	product := r.PathValue("product")
+	q := r.URL.Query()
+	view := metrics.StockView{Warehouse: q.Get("warehouse"), By: q.Get("by")}
+	switch view.By {
+	case "", metrics.StockByWarehouse, metrics.StockByLocation:
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("by must be %q or %q", metrics.StockByWarehouse, metrics.StockByLocation))
+		return
+	}
+	stock, ok := api.Agg.ProductStock(product, view)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no stock known for product %q", product))
+		return
+	}
+	api.writeJSON(w, r, stock)
+}
+
+// GET /api/products/{product}/stock/history?warehouse=north&from=2024-03-01&to=2024-03-31
+//
+// Lists the stock snapshots of one product alongside its daily units sold,
+// flagging restocks and depletions unless warehouse is set, as sales are not
+// known per warehouse. from and to are YYYY-MM-DD days in the reporting
+// timezone.
+func (api *API) StockHistory(w http.ResponseWriter, r *http.Request) {
+	product := r.PathValue("product")
+	q := r.URL.Query()
+	for _, param := range []string{"from", "to"} {
+		if value := q.Get(param); value != "" {
+			if _, err := time.Parse("2006-01-02", value); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("%s must be a date as YYYY-MM-DD", param))
+				return
+			}
+		}
+	}
+	history, ok := api.Agg.StockHistory(product, q.Get("warehouse"), q.Get("from"), q.Get("to"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no stock or sales known for product %q", product))
+		return
+	}
+	api.writeJSON(w, r, history)
 }
 
 // GET /api/sales/by-month
 func (api *API) SalesByMonth(w http.ResponseWriter, r *http.Request) {
-	api.writeJSON(w, api.Agg.SalesByMonth())
+	api.writeJSON(w, r, api.Agg.SalesByMonth())
 }
 
 // GET /api/regions/top?limit=30
@@ -73,5 +169,78 @@ func (api *API) TopRegions(w http.ResponseWriter, r *http.Request) {
 	if limit <= 0 {
 		limit = 30
 	}
-	api.writeJSON(w, api.Agg.TopRegions(limit))
+	api.writeJSON(w, r, api.Agg.TopRegions(limit))
+}
+
+// GET /api/revenue/continents
+func (api *API) ContinentRevenue(w http.ResponseWriter, r *http.Request) {
+	api.writeJSON(w, r, api.Agg.ContinentRevenue())
+}
+
+// GET /api/revenue/continents/{continent}
+func (api *API) ContinentCountries(w http.ResponseWriter, r *http.Request) {
+	continent := r.PathValue("continent")
+	rows, ok := api.Agg.ContinentCountries(continent)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown continent %q", continent))
+		return
+	}
+	api.writeJSON(w, r, rows)
+}
+
+// GET /api/dimensions
+func (api *API) Dimensions(w http.ResponseWriter, r *http.Request) {
+	api.writeJSON(w, r, api.Agg.Dimensions())
+}
+
+// GET /api/breakdown?by=price_band&limit=50
+func (api *API) Breakdown(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 {
+		limit = 50
+	}
+	rows, ok := api.Agg.GroupBy(q.Get("by"), limit)
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown dimension %q", q.Get("by")))
+		return
+	}
+	api.writeJSON(w, r, rows)
+}
+
+// GET /api/drilldown?country=Sri%20Lanka&product=Widget%20A&limit=100&offset=0
+//
+// Lists the transactions counted in an aggregate cell with their lineage:
+// source, line, ingest batch, the transformations that changed them and the
+// raw record. Cells are selected by any of country, region, product,
+// continent, month (YYYY-MM) and an attribute given as by=name&value=value.
+func (api *API) DrillDown(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	cell := metrics.Cell{
+		Country:   q.Get("country"),
+		Region:    q.Get("region"),
+		Product:   q.Get("product"),
+		Continent: q.Get("continent"),
+		Month:     q.Get("month"),
+		Attribute: q.Get("by"),
+		Value:     q.Get("value"),
+	}
+	if cell == (metrics.Cell{}) {
+		writeError(w, http.StatusBadRequest, "select a cell by country, region, product, continent, month or by and value")
+		return
+	}
+	if (cell.Attribute == "") != (cell.Value == "") {
+		writeError(w, http.StatusBadRequest, "by and value must be given together")
+		return
+	}
+
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	api.writeJSON(w, r, api.Agg.DrillDown(cell, offset, limit))
 }
@@ -1,10 +1,22 @@
 package handlers
 
 import (
+	"abt-dashboard/internal/config"
+	"abt-dashboard/internal/jobs"
+	"abt-dashboard/internal/metrics"
 	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/transform"
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -54,7 +66,7 @@ type TestAPI struct {
 
 func (api *TestAPI) writeJSON(w http.ResponseWriter, v interface{}) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("ETag", "\"dashboard-data\"")
 	json.NewEncoder(w).Encode(v)
 }
@@ -431,7 +443,7 @@ func TestAPI_writeJSON(t *testing.T) {
 		t.Errorf("expected content type %s, got %s", expectedContentType, ct)
 	}
 
-	if cc := rr.Header().Get("Cache-Control"); cc != "public, max-age=300" {
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-cache" {
 		t.Errorf("expected cache control header, got %s", cc)
 	}
 
@@ -493,3 +505,691 @@ func BenchmarkAPI_writeJSON(b *testing.B) {
 		api.writeJSON(rr, testData)
 	}
 }
+
+func TestAPI_Breakdown(t *testing.T) {
+	agg := metrics.NewAggregator()
+	api := &API{Agg: agg}
+	agg.AddTransactions([]models.Transaction{
+		{ID: "1", ProductName: "A", UnitPriceCents: 500, Quantity: 2, Attributes: map[string]string{"price_band": "<10", "weekend": "true"}},
+		{ID: "2", ProductName: "B", UnitPriceCents: 2000, Quantity: 1, Attributes: map[string]string{"price_band": "10-50"}},
+		{ID: "3", ProductName: "A", UnitPriceCents: 500, Quantity: 1, Attributes: map[string]string{"price_band": "<10"}},
+	})
+
+	rr := httptest.NewRecorder()
+	api.Dimensions(rr, httptest.NewRequest("GET", "/api/dimensions", nil))
+	var dims []string
+	if err := json.Unmarshal(rr.Body.Bytes(), &dims); err != nil {
+		t.Fatalf("could not parse response: %v", err)
+	}
+	if strings.Join(dims, ",") != "price_band,weekend" {
+		t.Errorf("dimensions: got %v", dims)
+	}
+
+	rr = httptest.NewRecorder()
+	api.Breakdown(rr, httptest.NewRequest("GET", "/api/breakdown?by=price_band", nil))
+	var rows []models.DimensionAgg
+	if err := json.Unmarshal(rr.Body.Bytes(), &rows); err != nil {
+		t.Fatalf("could not parse response: %v", err)
+	}
+	want := []models.DimensionAgg{
+		{Value: "10-50", TotalRevenue: 2000, UnitsSold: 1, NumberOfTx: 1},
+		{Value: "<10", TotalRevenue: 1500, UnitsSold: 3, NumberOfTx: 2},
+	}
+	if len(rows) != len(want) || rows[0] != want[0] || rows[1] != want[1] {
+		t.Errorf("breakdown: got %+v want %+v", rows, want)
+	}
+
+	rr = httptest.NewRecorder()
+	api.Breakdown(rr, httptest.NewRequest("GET", "/api/breakdown?by=nosuch", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown dimension: got status %d", rr.Code)
+	}
+}
+
+func TestAPI_ConditionalRequests(t *testing.T) {
+	agg := metrics.NewAggregator()
+	api := &API{Agg: agg}
+	agg.AddTransactions([]models.Transaction{
+		{ID: "1", ProductName: "A", UnitPriceCents: 500, Quantity: 2, TxTime: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+	})
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/sales/by-month", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		api.SalesByMonth(rr, req)
+		return rr
+	}
+
+	rr := get("")
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || etag == "" || rr.Body.Len() == 0 {
+		t.Fatalf("first request: got status %d, ETag %q", rr.Code, etag)
+	}
+	// Browsers must check the ETag before reusing a response
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("Cache-Control: got %q want no-cache", cc)
+	}
+
+	for _, header := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		rr = get(header)
+		if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: got status %d with %d bytes", header, rr.Code, rr.Body.Len())
+		}
+		if rr.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %s: 304 should repeat the ETag, got %q", header, rr.Header().Get("ETag"))
+		}
+	}
+	if rr = get(`"other"`); rr.Code != http.StatusOK {
+		t.Errorf("other ETag: got status %d", rr.Code)
+	}
+
+	// Ingesting data changes the ETag, so the cached copy is replaced
+	agg.AddTransactions([]models.Transaction{
+		{ID: "2", ProductName: "A", UnitPriceCents: 500, Quantity: 1, TxTime: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)},
+	})
+	rr = get(etag)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+		t.Fatalf("after ingest: got status %d, ETag %q", rr.Code, rr.Header().Get("ETag"))
+	}
+	var months []models.MonthAgg
+	if err := json.Unmarshal(rr.Body.Bytes(), &months); err != nil {
+		t.Fatalf("could not parse response: %v", err)
+	}
+	if len(months) != 1 || months[0].UnitsSold != 3 {
+		t.Errorf("after ingest: got %+v", months)
+	}
+}
+
+func TestAPI_ContinentRevenue(t *testing.T) {
+	agg := metrics.NewAggregator()
+	api := &API{Agg: agg}
+	agg.AddTransactions([]models.Transaction{
+		{ID: "1", Country: "Sri Lanka", CountryCode: "LK", Continent: "Asia", Region: "Western", UnitPriceCents: 1000, Quantity: 2},
+		{ID: "2", Country: "India", CountryCode: "IN", Continent: "Asia", Region: "Western", UnitPriceCents: 500, Quantity: 1},
+		{ID: "3", Country: "France", CountryCode: "FR", Continent: "Europe", Region: "Paris", UnitPriceCents: 3000, Quantity: 1},
+		{ID: "4", Country: "Atlantis", Region: "Deep", UnitPriceCents: 100, Quantity: 1},
+	})
+
+	rr := httptest.NewRecorder()
+	api.ContinentRevenue(rr, httptest.NewRequest("GET", "/api/revenue/continents", nil))
+	var continents []models.ContinentAgg
+	if err := json.Unmarshal(rr.Body.Bytes(), &continents); err != nil {
+		t.Fatalf("could not parse response: %v", err)
+	}
+	want := []models.ContinentAgg{
+		{Continent: "Europe", TotalRevenue: 3000, ItemsSold: 1, NumberOfTx: 1, Countries: 1},
+		{Continent: "Asia", TotalRevenue: 2500, ItemsSold: 3, NumberOfTx: 2, Countries: 2},
+		{Continent: metrics.UnknownContinent, TotalRevenue: 100, ItemsSold: 1, NumberOfTx: 1, Countries: 1},
+	}
+	if len(continents) != len(want) || continents[0] != want[0] || continents[1] != want[1] || continents[2] != want[2] {
+		t.Errorf("continents: got %+v want %+v", continents, want)
+	}
+
+	// Matched through the route so that the path value is set
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/revenue/continents/{continent}", api.ContinentCountries)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/api/revenue/continents/asia", nil))
+	var countries []models.CountryAgg
+	if err := json.Unmarshal(rr.Body.Bytes(), &countries); err != nil {
+		t.Fatalf("could not parse response: %v", err)
+	}
+	if len(countries) != 2 || countries[0].Country != "Sri Lanka" || countries[0].CountryCode != "LK" || countries[1].Country != "India" {
+		t.Errorf("countries of asia: got %+v", countries)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/api/revenue/continents/Lemuria", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown continent: got status %d", rr.Code)
+	}
+
+	// Regions of the same name in different countries are kept apart
+	regions := agg.TopRegions(0)
+	if len(regions) != 4 || regions[1].Region != "Western" || regions[1].Country != "Sri Lanka" ||
+		regions[2].Region != "Western" || regions[2].Country != "India" {
+		t.Errorf("regions: got %+v", regions)
+	}
+}
+
+// day returns midday on the given day of March 2024, in UTC
+func day(n int) time.Time {
+	return time.Date(2024, 3, n, 12, 0, 0, 0, time.UTC)
+}
+
+func TestAPI_ProductStock(t *testing.T) {
+	agg := metrics.NewAggregator()
+	api := &API{Agg: agg}
+	agg.Ingest([]models.Transaction{
+		{ID: "1", ProductName: "Widget A", UnitPriceCents: 500, Quantity: 2},
+		{ID: "2", ProductName: "Gadget B", UnitPriceCents: 500, Quantity: 1},
+	}, []models.Inventory{
+		{ProductName: "Widget A", Warehouse: "north", Location: "A-1", StockQty: 10, AsOf: day(1)},
+		{ProductName: "Widget A", Warehouse: "north", Location: "A-2", StockQty: 5, AsOf: day(1)},
+		{ProductName: "Widget A", Warehouse: "south", StockQty: 20, AsOf: day(1)},
+		{ProductName: "Gadget B", Warehouse: "south", StockQty: 3, AsOf: day(1)},
+	})
+	// A newer snapshot replaces the stock of a warehouse location; an older
+	// one loaded later does not
+	agg.MergeInventory([]models.Inventory{
+		{ProductName: "Widget A", Warehouse: "north", Location: "A-2", StockQty: 1, AsOf: day(3)},
+		{ProductName: "Widget A", Warehouse: "south", StockQty: 99, AsOf: day(0)},
+	})
+
+	get := func(h http.HandlerFunc, pattern, url string) *httptest.ResponseRecorder {
+		mux := http.NewServeMux()
+		mux.HandleFunc(pattern, h)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		return rr
+	}
+	products := func(url string) []models.ProductAgg {
+		rr := get(api.TopProducts, "GET /api/products/top", url)
+		var out []models.ProductAgg
+		if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+			t.Fatalf("%s: could not parse response: %v", url, err)
+		}
+		return out
+	}
+
+	if got := products("/api/products/top?by=units"); len(got) != 2 || got[0].StockQty != 31 || got[0].Stock != nil || got[1].StockQty != 3 {
+		t.Errorf("rolled up: got %+v", got)
+	}
+	got := products("/api/products/top?by=units&stock=warehouse")
+	want := []models.StockLevel{{Warehouse: "north", StockQty: 11, AsOf: "2024-03-03"}, {Warehouse: "south", StockQty: 20, AsOf: "2024-03-01"}}
+	if len(got[0].Stock) != 2 || got[0].Stock[0] != want[0] || got[0].Stock[1] != want[1] {
+		t.Errorf("by warehouse: got %+v", got[0].Stock)
+	}
+	got = products("/api/products/top?by=units&stock=location&warehouse=NORTH")
+	if got[0].StockQty != 11 || len(got[0].Stock) != 2 || got[0].Stock[1].Location != "A-2" || got[1].StockQty != 0 {
+		t.Errorf("by location in north: got %+v", got)
+	}
+
+	rr := get(api.ProductStock, "GET /api/products/{product}/stock", "/api/products/widget%20a/stock?by=location")
+	var stock models.ProductStock
+	if err := json.Unmarshal(rr.Body.Bytes(), &stock); err != nil {
+		t.Fatalf("could not parse response: %v", err)
+	}
+	if stock.ProductName != "Widget A" || stock.StockQty != 31 || len(stock.Stock) != 3 || stock.Stock[2].Warehouse != "south" {
+		t.Errorf("product stock: got %+v", stock)
+	}
+
+	for url, status := range map[string]int{
+		"/api/products/Nothing/stock":           http.StatusNotFound,
+		"/api/products/Gadget%20B/stock?by=bin": http.StatusBadRequest,
+	} {
+		if rr := get(api.ProductStock, "GET /api/products/{product}/stock", url); rr.Code != status {
+			t.Errorf("%s: got status %d want %d", url, rr.Code, status)
+		}
+	}
+	if rr := get(api.TopProducts, "GET /api/products/top", "/api/products/top?stock=bin"); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown breakout: got status %d", rr.Code)
+	}
+}
+
+func TestAPI_StockHistory(t *testing.T) {
+	agg := metrics.NewAggregator()
+	api := &API{Agg: agg}
+	agg.Ingest([]models.Transaction{
+		{ID: "1", ProductName: "Widget A", Quantity: 4, TxTime: day(2)},
+		{ID: "2", ProductName: "Widget A", Quantity: 2, TxTime: day(3)},
+		{ID: "3", ProductName: "Widget A", Quantity: 1, TxTime: day(3)},
+		{ID: "4", ProductName: "Widget A", Quantity: 5, TxTime: day(5)},
+		{ID: "5", ProductName: "Gadget B", Quantity: 1, TxTime: day(5)},
+	}, []models.Inventory{
+		{ProductName: "Widget A", Warehouse: "north", StockQty: 10, AsOf: day(1)},
+		{ProductName: "Widget A", Warehouse: "south", StockQty: 5, AsOf: day(1)},
+		{ProductName: "Widget A", Warehouse: "north", StockQty: 3, AsOf: day(3)},
+		{ProductName: "Widget A", Warehouse: "north", StockQty: 20, AsOf: day(5)},
+		{ProductName: "Widget A", Warehouse: "south", StockQty: 5, AsOf: day(5)},
+	})
+
+	get := func(url string) *httptest.ResponseRecorder {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /api/products/{product}/stock/history", api.StockHistory)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		return rr
+	}
+	history := func(url string) models.StockHistory {
+		rr := get(url)
+		var out models.StockHistory
+		if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+			t.Fatalf("%s: could not parse response: %v", url, err)
+		}
+		return out
+	}
+	describe := func(days []models.StockDay) string {
+		var parts []string
+		for _, d := range days {
+			stock := "-"
+			if d.StockQty != nil {
+				stock = fmt.Sprint(*d.StockQty)
+			}
+			parts = append(parts, fmt.Sprintf("%s sold=%d stock=%s change=%d restocked=%d %s",
+				d.Date, d.UnitsSold, stock, d.Change, d.Restocked, d.Event))
+		}
+		return strings.Join(parts, "\n")
+	}
+
+	// Sales of 7 units take 15 down to 8 (south holds 5). With 5 more sold,
+	// 3 would be left, so 25 on the last snapshot means 22 were received.
+	got := history("/api/products/widget%20a/stock/history")
+	want := `2024-03-01 sold=0 stock=15 change=0 restocked=0 
+2024-03-02 sold=4 stock=- change=0 restocked=0 
+2024-03-03 sold=3 stock=8 change=-7 restocked=0 depletion
+2024-03-05 sold=5 stock=25 change=17 restocked=22 restock`
+	if got.ProductName != "Widget A" || describe(got.Days) != want {
+		t.Errorf("got %s:\n%s\nwant:\n%s", got.ProductName, describe(got.Days), want)
+	}
+
+	// A warehouse and date range; the first snapshot in range is still
+	// compared with the one before it
+	got = history("/api/products/Widget%20A/stock/history?warehouse=NORTH&from=2024-03-03&to=2024-03-04")
+	want = `2024-03-03 sold=3 stock=3 change=-7 restocked=0 `
+	if describe(got.Days) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", describe(got.Days), want)
+	}
+
+	// The sales of all warehouses do not explain the stock of one: south
+	// held 5 throughout, which is no restock of the 12 units sold
+	got = history("/api/products/Widget%20A/stock/history?warehouse=south")
+	want = `2024-03-01 sold=0 stock=5 change=0 restocked=0 
+2024-03-02 sold=4 stock=- change=0 restocked=0 
+2024-03-03 sold=3 stock=- change=0 restocked=0 
+2024-03-05 sold=5 stock=5 change=0 restocked=0 `
+	if got.Warehouse != "south" || describe(got.Days) != want {
+		t.Errorf("got %s:\n%s\nwant:\n%s", got.Warehouse, describe(got.Days), want)
+	}
+
+	// Products with sales but no stock have a history of sales
+	got = history("/api/products/Gadget%20B/stock/history")
+	if len(got.Days) != 1 || got.Days[0].UnitsSold != 1 || got.Days[0].StockQty != nil {
+		t.Errorf("sales only: got %s", describe(got.Days))
+	}
+
+	for url, status := range map[string]int{
+		"/api/products/Nothing/stock/history":                   http.StatusNotFound,
+		"/api/products/Widget%20A/stock/history?from=yesterday": http.StatusBadRequest,
+		"/api/products/Widget%20A/stock/history?to=2024-3-1":    http.StatusBadRequest,
+	} {
+		if rr := get(url); rr.Code != status {
+			t.Errorf("%s: got status %d want %d", url, rr.Code, status)
+		}
+	}
+}
+
+func TestAPI_Ingest(t *testing.T) {
+	agg := metrics.NewAggregator()
+	api := &API{
+		Agg: agg,
+		Ingester: NewIngester(
+			transform.NewFlexibleDataHandler(transform.LoadDefaultTransformationConfig()), "secret", 0),
+	}
+
+	const upload = `transaction_id,transaction_date,country,region,product_name,price,quantity
+in-1,2024-03-01,USA,East,Widget,10.00,2
+in-2,2024-03-02,USA,East,Widget,10.00,1
+in-3,bad-date,USA,East,Widget,10.00,1
+`
+	post := func(url, contentType, token string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", url, body)
+		req.Header.Set("Content-Type", contentType)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		api.Ingest(rr, req)
+		return rr
+	}
+
+	if rr := post("/api/ingest?format=csv", "text/csv", "wrong", strings.NewReader(upload)); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: got status %d", rr.Code)
+	}
+	if len(agg.SalesByMonth()) != 0 {
+		t.Fatal("rejected upload must not change the aggregates")
+	}
+
+	version := agg.Version()
+	rr := post("/api/ingest?format=csv", "text/csv", "secret", strings.NewReader(upload))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+	}
+	var result transform.TransformationResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("could not parse response: %v", err)
+	}
+	if result.OriginalRecords != 3 || result.TransformedRecords != 2 || result.SkippedRecords != 1 {
+		t.Errorf("result: got %d original, %d transformed, %d skipped",
+			result.OriginalRecords, result.TransformedRecords, result.SkippedRecords)
+	}
+	if agg.Version() == version {
+		t.Error("version should change after ingesting")
+	}
+
+	// Multipart upload, format detected from the file name
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, _ := mw.CreateFormFile("file", "day2.csv")
+	io.WriteString(fw, strings.Replace(upload, "in-", "mp-", -1))
+	mw.Close()
+	if rr := post("/api/ingest", mw.FormDataContentType(), "secret", &form); rr.Code != http.StatusOK {
+		t.Fatalf("multipart: got status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	months := agg.SalesByMonth()
+	if len(months) != 1 || months[0].YearMonth != "2024-03" || months[0].TxCount != 4 || months[0].UnitsSold != 6 {
+		t.Errorf("months: got %+v", months)
+	}
+
+	// Uploading a file again loads none of it
+	rr = post("/api/ingest?format=csv", "text/csv", "secret", strings.NewReader(upload))
+	result = transform.TransformationResult{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("repeat: got status %d: %s", rr.Code, rr.Body.String())
+	}
+	if result.TransformedRecords != 0 || result.AlreadyLoaded != 2 || result.SkippedRecords != 3 || len(result.Warnings) != 0 {
+		t.Errorf("repeat: got %+v", result)
+	}
+	if months := agg.SalesByMonth(); months[0].TxCount != 4 || months[0].UnitsSold != 6 {
+		t.Errorf("repeat must not change the aggregates, got %+v", months)
+	}
+
+	// An upload that fails part way through forgets the IDs it has seen, so
+	// that a retry loads every record without reporting duplicates
+	var big strings.Builder
+	big.WriteString("transaction_id,transaction_date,country,region,product_name,price,quantity\n")
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&big, "big-%d,2024-04-01,USA,East,Widget,10.00,1\n", i)
+	}
+	api.Ingester.MaxBytes = int64(big.Len() / 2)
+	if rr := post("/api/ingest?format=csv", "text/csv", "secret", strings.NewReader(big.String())); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized upload: got status %d", rr.Code)
+	}
+	api.Ingester.MaxBytes = DefaultIngestMaxBytes
+	rr = post("/api/ingest?format=csv", "text/csv", "secret", strings.NewReader(big.String()))
+	result = transform.TransformationResult{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("retry: got status %d: %s", rr.Code, rr.Body.String())
+	}
+	if result.TransformedRecords != 500 || result.AlreadyLoaded != 0 || len(result.Warnings) != 0 {
+		t.Errorf("retry: got %d transformed, %d already loaded, warnings %v",
+			result.TransformedRecords, result.AlreadyLoaded, result.Warnings)
+	}
+	if months := agg.SalesByMonth(); len(months) != 2 || months[1].TxCount != 500 {
+		t.Errorf("retry: got %+v", months)
+	}
+
+	api.Ingester.MaxBytes = 10
+	if rr := post("/api/ingest?format=csv", "text/csv", "secret", strings.NewReader(upload)); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized upload: got status %d", rr.Code)
+	}
+}
+
+func TestAPI_Jobs(t *testing.T) {
+	agg := metrics.NewAggregator()
+	handler := transform.NewFlexibleDataHandler(transform.LoadDefaultTransformationConfig())
+	api := &API{Agg: agg, Ingester: NewIngester(handler, "secret", 0)}
+	api.Ingester.Jobs = jobs.NewQueue(handler, agg)
+
+	do := func(h http.HandlerFunc, method, url, id string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, body)
+		req.Header.Set("Content-Type", "text/csv")
+		req.Header.Set("Authorization", "Bearer secret")
+		if id != "" {
+			req.SetPathValue("id", id)
+		}
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder) jobs.Status {
+		var status jobs.Status
+		if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+			t.Fatalf("could not parse response: %v", err)
+		}
+		return status
+	}
+
+	const upload = `transaction_id,transaction_date,country,region,product_name,price,quantity
+job-1,2024-04-01,USA,East,Widget,10.00,2
+job-2,bad-date,USA,East,Widget,10.00,1
+`
+	rr := do(api.SubmitJob, "POST", "/api/jobs?format=csv", "", strings.NewReader(upload))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("submit: got status %d: %s", rr.Code, rr.Body.String())
+	}
+	submitted := decode(rr)
+	if submitted.ID == "" || rr.Header().Get("Location") != "/api/jobs/"+submitted.ID {
+		t.Fatalf("submit: got %+v, Location %q", submitted, rr.Header().Get("Location"))
+	}
+
+	var status jobs.Status
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		rr := do(api.GetJob, "GET", "/api/jobs/"+submitted.ID, submitted.ID, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("get: got status %d: %s", rr.Code, rr.Body.String())
+		}
+		if status = decode(rr); status.State.Finished() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job did not finish: %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if status.State != jobs.StateSucceeded || status.Result == nil {
+		t.Fatalf("job: got %+v", status)
+	}
+	if status.RowsProcessed != 2 || status.RowsSkipped != 1 || status.Result.TransformedRecords != 1 {
+		t.Errorf("job: got %d processed, %d skipped, result %+v",
+			status.RowsProcessed, status.RowsSkipped, status.Result)
+	}
+	months := agg.SalesByMonth()
+	if len(months) != 1 || months[0].YearMonth != "2024-04" || months[0].UnitsSold != 2 {
+		t.Errorf("months: got %+v", months)
+	}
+
+	if rr := do(api.CancelJob, "DELETE", "/api/jobs/"+status.ID, status.ID, nil); rr.Code != http.StatusConflict {
+		t.Errorf("cancel finished job: got status %d", rr.Code)
+	}
+	if rr := do(api.GetJob, "GET", "/api/jobs/unknown", "unknown", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown job: got status %d", rr.Code)
+	}
+
+	rr = do(api.ListJobs, "GET", "/api/jobs", "", nil)
+	var list []jobs.Status
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || len(list) != 1 || list[0].ID != status.ID {
+		t.Errorf("list: got %s", rr.Body.String())
+	}
+
+	req := httptest.NewRequest("GET", "/api/jobs", nil)
+	rr = httptest.NewRecorder()
+	api.ListJobs(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("missing token: got status %d", rr.Code)
+	}
+}
+
+func TestAPI_DataProfile(t *testing.T) {
+	api := &API{Agg: metrics.NewAggregator()}
+	rr := httptest.NewRecorder()
+	api.DataProfile(rr, httptest.NewRequest("GET", "/api/data/profile", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("without profiler: got status %d", rr.Code)
+	}
+
+	path := filepath.Join(t.TempDir(), "feed.csv")
+	os.WriteFile(path, []byte("transaction_id,transaction_date,price\n1,2024-01-01,5\n2,2024-02-01,\n"), 0o644)
+	handler := transform.NewFlexibleDataHandler(transform.LoadDefaultTransformationConfig())
+	api.Profiler = NewProfiler(handler, []string{path})
+
+	rr = httptest.NewRecorder()
+	api.DataProfile(rr, httptest.NewRequest("GET", "/api/data/profile", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+	}
+	var profiles []transform.DataProfile
+	if err := json.Unmarshal(rr.Body.Bytes(), &profiles); err != nil {
+		t.Fatalf("could not parse response: %v", err)
+	}
+	if len(profiles) != 1 || profiles[0].Source != path || profiles[0].Records != 2 || len(profiles[0].Columns) != 3 {
+		t.Fatalf("got %+v", profiles)
+	}
+	if price := profiles[0].Columns[2]; price.Name != "price" || price.Type != transform.ColumnInteger || price.NullRate != 0.5 {
+		t.Errorf("price: got %+v", price)
+	}
+	if date := profiles[0].Columns[1]; date.Type != transform.ColumnDate || date.DateRange == nil {
+		t.Errorf("transaction_date: got %+v", date)
+	}
+
+	// Reset profiles the files again
+	os.WriteFile(path, []byte("transaction_id,quantity\n1,2\n"), 0o644)
+	api.Profiler.Reset()
+	if got, err := api.Profiler.Profiles(context.Background()); err != nil || len(got) != 1 || len(got[0].Columns) != 2 {
+		t.Errorf("after reset: got %v, %v", got, err)
+	}
+
+	// A failure is reported, and the next call tries again
+	missing := filepath.Join(t.TempDir(), "later.csv")
+	api.Profiler = NewProfiler(handler, []string{missing})
+	rr = httptest.NewRecorder()
+	api.DataProfile(rr, httptest.NewRequest("GET", "/api/data/profile", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("missing file: got status %d", rr.Code)
+	}
+	os.WriteFile(missing, []byte("transaction_id\n1\n"), 0o644)
+	if got, err := api.Profiler.Profiles(context.Background()); err != nil || len(got) != 1 || got[0].Records != 1 {
+		t.Errorf("after the file appeared: got %v, %v", got, err)
+	}
+}
+
+func TestAPI_DrillDown(t *testing.T) {
+	agg := metrics.NewAggregator()
+	api := &API{Agg: agg}
+	day := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	lineage := func(line int) models.Lineage {
+		return models.Lineage{Source: "sales.csv", Line: line, BatchID: "b1", Raw: fmt.Sprintf("row %d", line)}
+	}
+	agg.AddTransactions([]models.Transaction{
+		{ID: "1", Country: "Sri Lanka", ProductName: "A", UnitPriceCents: 500, Quantity: 2, TxTime: day, Lineage: lineage(1),
+			Attributes: map[string]string{"price_band": "<10"}},
+		{ID: "2", Country: "India", ProductName: "A", UnitPriceCents: 900, Quantity: 1, TxTime: day, Lineage: lineage(2)},
+		{ID: "3", Country: "Sri Lanka", ProductName: "A", UnitPriceCents: 500, Quantity: 1, TxTime: day, Lineage: lineage(3),
+			Attributes: map[string]string{"price_band": "<10"}},
+		{ID: "4", Country: "Sri Lanka", ProductName: "B", UnitPriceCents: 100, Quantity: 1, TxTime: day.AddDate(0, 1, 0), Lineage: lineage(4)},
+	})
+
+	get := func(url string) (*httptest.ResponseRecorder, models.DrillDown) {
+		rr := httptest.NewRecorder()
+		api.DrillDown(rr, httptest.NewRequest("GET", url, nil))
+		var drill models.DrillDown
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &drill); err != nil {
+				t.Fatalf("could not parse response: %v", err)
+			}
+		}
+		return rr, drill
+	}
+
+	_, drill := get("/api/drilldown?country=sri%20lanka&product=A")
+	if !drill.Complete || drill.Matched != 2 || len(drill.Records) != 2 ||
+		drill.Records[0].ID != "1" || drill.Records[0].RevenueCents != 1000 || drill.Records[1].Lineage.Raw != "row 3" {
+		t.Errorf("country and product: got %+v", drill)
+	}
+
+	_, drill = get("/api/drilldown?month=2024-03&limit=1&offset=1")
+	if drill.Matched != 3 || len(drill.Records) != 1 || drill.Records[0].ID != "2" {
+		t.Errorf("month page: got %+v", drill)
+	}
+
+	_, drill = get("/api/drilldown?by=price_band&value=%3C10")
+	if drill.Matched != 2 {
+		t.Errorf("attribute: got %+v", drill)
+	}
+
+	// Transactions without lineage are aggregated but not traced
+	agg.AddTransactions([]models.Transaction{{ID: "5", Country: "India", ProductName: "A", TxTime: day}})
+	if _, drill = get("/api/drilldown?country=India"); drill.Complete || drill.Matched != 1 {
+		t.Errorf("untraced: got %+v", drill)
+	}
+
+	for _, url := range []string{"/api/drilldown", "/api/drilldown?by=price_band"} {
+		if rr, _ := get(url); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d", url, rr.Code)
+		}
+	}
+}
+
+func TestAPI_EffectiveConfig(t *testing.T) {
+	api := &API{Agg: metrics.NewAggregator()}
+	rr := httptest.NewRecorder()
+	api.EffectiveConfig(rr, httptest.NewRequest("GET", "/api/config/effective", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("without config: got status %d", rr.Code)
+	}
+
+	configPath := filepath.Join(t.TempDir(), "transform.yaml")
+	os.WriteFile(configPath, []byte("performance:\n  batch_size: 500\n"), 0o644)
+	_, effective, err := transform.NewConfigLoader(configPath).LoadLayeredConfig(transform.ConfigLayers{
+		Variables: []string{"ABT_TRANSFORMATION__CURRENCY__REPORTING_CURRENCY=EUR"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	api.Configs = &Configs{}
+	api.Configs.Transform.Store(effective)
+
+	rr = httptest.NewRecorder()
+	api.EffectiveConfig(rr, httptest.NewRequest("GET", "/api/config/effective", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("got status %d: %s", rr.Code, rr.Body.String())
+	}
+	var got transform.EffectiveConfig
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("could not parse response: %v", err)
+	}
+	settings := make(map[string]transform.ConfigSetting)
+	for _, setting := range got.Settings {
+		settings[setting.Key] = setting
+	}
+	if s := settings["performance.batch_size"]; s.Layer != transform.LayerBase || s.Source != configPath || s.Value != 500.0 {
+		t.Errorf("batch size: got %+v", s)
+	}
+	if s := settings["transformation.currency.reporting_currency"]; s.Layer != transform.LayerVariable || s.Value != "EUR" {
+		t.Errorf("reporting currency: got %+v", s)
+	}
+	if s := settings["transformation.enable_validation"]; s.Layer != transform.LayerDefault || s.Value != false {
+		t.Errorf("enable_validation: got %+v", s)
+	}
+}
+
+func TestAPI_DashboardConfig(t *testing.T) {
+	api := &API{Agg: metrics.NewAggregator(), Configs: &Configs{}}
+	rr := httptest.NewRecorder()
+	api.DashboardConfig(rr, httptest.NewRequest("GET", "/api/config/dashboard", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("without dashboard config: got status %d", rr.Code)
+	}
+
+	// A reload swaps in the new config for later requests
+	for _, title := range []string{"Before", "After"} {
+		dashboard := config.DefaultConfig()
+		dashboard.Title = title
+		api.Configs.Dashboard.Store(dashboard)
+
+		rr = httptest.NewRecorder()
+		api.DashboardConfig(rr, httptest.NewRequest("GET", "/api/config/dashboard", nil))
+		var got config.DashboardConfig
+		if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+			t.Fatalf("could not parse response: %v", err)
+		}
+		if got.Title != title || len(got.Components) != 4 {
+			t.Errorf("got title %q and %d components", got.Title, len(got.Components))
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"abt-dashboard/internal/jobs"
+	"abt-dashboard/internal/metrics"
+	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/spool"
+	"abt-dashboard/internal/transform"
+)
+
+// DefaultIngestMaxBytes limits the size of one upload when no limit is set
+const DefaultIngestMaxBytes = 100 << 20
+
+// ingestTimeout bounds reading and processing one upload. It replaces the
+// server's short read and write timeouts for this endpoint only.
+const ingestTimeout = 10 * time.Minute
+
+// Ingester merges uploaded files into the running aggregator. The data
+// handler processes uploads one at a time, so its validators see them in
+// order.
+type Ingester struct {
+	Handler  *transform.FlexibleDataHandler
+	Token    string // required as "Authorization: Bearer <token>"
+	MaxBytes int64  // defaults to DefaultIngestMaxBytes
+
+	// Jobs enables the asynchronous /api/jobs endpoints when set
+	Jobs *jobs.Queue
+
+	// Spool keeps the merged uploads for reprocessing when set
+	Spool *spool.Spool
+}
+
+// NewIngester creates an ingester that authenticates uploads with token
+func NewIngester(handler *transform.FlexibleDataHandler, token string, maxBytes int64) *Ingester {
+	if maxBytes <= 0 {
+		maxBytes = DefaultIngestMaxBytes
+	}
+	return &Ingester{Handler: handler, Token: token, MaxBytes: maxBytes}
+}
+
+// authorized reports whether the request carries the ingest token
+func (ing *Ingester) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && ing.Token != "" &&
+		subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(ing.Token)) == 1
+}
+
+// POST /api/ingest?format=csv
+//
+// The body is the file itself, or a multipart form with the file in a "file"
+// field. The format is taken from ?format=, else the file name (?name= or the
+// form file name), else detected from the content; gzip, zstd and zip uploads
+// are unpacked. Records are merged into the dashboard only if the whole file
+// is processed, and the response is the TransformationResult. Records whose
+// transaction ID is already loaded are skipped, so retrying an upload does
+// not count it twice.
+func (api *API) Ingest(w http.ResponseWriter, r *http.Request) {
+	ing := api.ingester(w, r)
+	if ing == nil {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Now().Add(ingestTimeout))
+	rc.SetWriteDeadline(time.Now().Add(ingestTimeout))
+
+	r.Body = http.MaxBytesReader(w, r.Body, ing.MaxBytes)
+	name, body, err := uploadedFile(r)
+	if err != nil {
+		writeError(w, uploadErrorStatus(err), err.Error())
+		return
+	}
+	body, spooled, err := ing.Spool.Tee(body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer ing.Spool.Discard(spooled)
+
+	// Fold the upload into a private aggregator and merge it in one step, so
+	// dashboard readers never wait for parsing and never see half a file
+	delta := metrics.NewAggregatorInLocation(api.Agg.Location())
+	delta.SetLineageLimit(api.Agg.LineageLimit())
+	result, err := ing.Handler.ProcessUpload(r.Context(), transform.Upload{
+		Name:   name,
+		Reader: body,
+		Sink: func(chunk []models.Transaction) error {
+			delta.AddTransactions(chunk)
+			return nil
+		},
+		Commit: func(*transform.TransformationResult) error {
+			return ing.Spool.Commit(name, spooled, func() { api.Agg.Merge(delta) })
+		},
+	})
+	if err != nil {
+		writeError(w, uploadErrorStatus(err), fmt.Sprintf("failed to process %s: %v", name, err))
+		return
+	}
+
+	log.Printf("Ingested %s: %d original, %d transformed, %d skipped records (%d already loaded)",
+		name, result.OriginalRecords, result.TransformedRecords, result.SkippedRecords, result.AlreadyLoaded)
+
+	writeUncached(w, http.StatusOK, result)
+}
+
+// ingester returns the Ingester if the request may use it, otherwise it
+// writes the error response and returns nil
+func (api *API) ingester(w http.ResponseWriter, r *http.Request) *Ingester {
+	ing := api.Ingester
+	if ing == nil {
+		http.NotFound(w, r)
+		return nil
+	}
+	if !ing.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ingest"`)
+		writeError(w, http.StatusUnauthorized, "missing or invalid ingest token")
+		return nil
+	}
+	return ing
+}
+
+// uploadedFile returns the name and content of the uploaded file. The name
+// carries the format as its extension when one was given.
+func uploadedFile(r *http.Request) (string, io.Reader, error) {
+	name := r.URL.Query().Get("name")
+	body := io.Reader(r.Body)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return "", nil, err
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return "", nil, errors.New(`multipart upload has no "file" field`)
+			}
+			if err != nil {
+				return "", nil, err
+			}
+			if part.FormName() == "file" {
+				if name == "" {
+					name = part.FileName()
+				}
+				body = part
+				break
+			}
+		}
+	}
+
+	if name == "" {
+		name = "upload"
+	}
+	if format := strings.ToLower(r.URL.Query().Get("format")); format != "" {
+		if !isDataFormat(format) {
+			return "", nil, fmt.Errorf("unsupported format %q", format)
+		}
+		name = strings.TrimSuffix(name, "."+format) + "." + format
+	}
+	return name, body, nil
+}
+
+func isDataFormat(format string) bool {
+	switch transform.DataFormat(format) {
+	case transform.FormatCSV, transform.FormatTSV, transform.FormatJSON, transform.FormatNDJSON,
+		transform.FormatYAML, transform.FormatXML, transform.FormatXLSX:
+		return true
+	}
+	return false
+}
+
+// uploadErrorStatus maps an upload error to a status code
+func uploadErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeUncached(w, status, map[string]string{"error": message})
+}
+
+// writeUncached writes a JSON response that must not be cached, unlike the
+// dashboard data served by writeJSON
+func writeUncached(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"abt-dashboard/internal/jobs"
+)
+
+// jobs returns the job queue if the request may use it, otherwise it writes
+// the error response and returns nil
+func (api *API) jobs(w http.ResponseWriter, r *http.Request) *jobs.Queue {
+	ing := api.ingester(w, r)
+	if ing == nil {
+		return nil
+	}
+	if ing.Jobs == nil {
+		http.NotFound(w, r)
+		return nil
+	}
+	return ing.Jobs
+}
+
+// POST /api/jobs?format=csv
+//
+// Accepts an upload like POST /api/ingest, stores it and processes it in the
+// background. Responds 202 with the job's status, including its ID.
+func (api *API) SubmitJob(w http.ResponseWriter, r *http.Request) {
+	queue := api.jobs(w, r)
+	if queue == nil {
+		return
+	}
+
+	// Storing the upload can take a while; processing happens afterwards
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Now().Add(ingestTimeout))
+	rc.SetWriteDeadline(time.Now().Add(ingestTimeout))
+
+	r.Body = http.MaxBytesReader(w, r.Body, api.Ingester.MaxBytes)
+	name, body, err := uploadedFile(r)
+	if err != nil {
+		writeError(w, uploadErrorStatus(err), err.Error())
+		return
+	}
+
+	status, err := queue.SubmitReader(name, body, "")
+	switch {
+	case errors.Is(err, jobs.ErrQueueFull):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	case err != nil:
+		writeError(w, uploadErrorStatus(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/api/jobs/"+status.ID)
+	writeUncached(w, http.StatusAccepted, status)
+}
+
+// GET /api/jobs
+func (api *API) ListJobs(w http.ResponseWriter, r *http.Request) {
+	if queue := api.jobs(w, r); queue != nil {
+		writeUncached(w, http.StatusOK, queue.List())
+	}
+}
+
+// GET /api/jobs/{id}
+//
+// Reports the job's state, rows processed, rows per second, warnings and
+// errors so far and, once it has succeeded, its TransformationResult.
+func (api *API) GetJob(w http.ResponseWriter, r *http.Request) {
+	queue := api.jobs(w, r)
+	if queue == nil {
+		return
+	}
+
+	status, err := queue.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeUncached(w, http.StatusOK, status)
+}
+
+// DELETE /api/jobs/{id}
+//
+// Cancels a queued or running job. Records of a cancelled job are not merged.
+func (api *API) CancelJob(w http.ResponseWriter, r *http.Request) {
+	queue := api.jobs(w, r)
+	if queue == nil {
+		return
+	}
+
+	status, err := queue.Cancel(r.PathValue("id"))
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, jobs.ErrFinished):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeUncached(w, http.StatusOK, status)
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"abt-dashboard/internal/transform"
+)
+
+// ErrProfiling is returned by Profiler.Profiles when the files are still
+// being profiled
+var ErrProfiling = errors.New("the data files are still being profiled")
+
+// profileWait bounds how long a request waits for a profiling pass
+const profileWait = 2 * time.Second
+
+// Profiler profiles the data files the dashboard was loaded from. Files are
+// profiled in the background and the result is kept until Reset; a pass that
+// fails is not kept, so the next call profiles the files again. Handler
+// should not be the one uploads go through, as profiling a file holds the
+// handler like processing one does.
+type Profiler struct {
+	Handler *transform.FlexibleDataHandler
+	Files   []string
+
+	mu      sync.Mutex
+	current *profilePass // nil before the first pass and after a failure
+}
+
+// profilePass is one profiling of all files. profiles and err are set before
+// done is closed.
+type profilePass struct {
+	done     chan struct{}
+	profiles []*transform.DataProfile
+	err      error
+}
+
+// NewProfiler creates a profiler for the given data files
+func NewProfiler(handler *transform.FlexibleDataHandler, files []string) *Profiler {
+	return &Profiler{Handler: handler, Files: files}
+}
+
+// Start profiles the files in the background unless they are profiled
+// already or being profiled
+func (p *Profiler) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.current == nil {
+		p.start()
+	}
+}
+
+// Reset drops the profiles and profiles the files again in the background,
+// for instance after the handler has been reconfigured
+func (p *Profiler) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.start()
+}
+
+// Profiles returns the profile of every file. It starts a pass if none is
+// running or kept, and returns ErrProfiling if ctx is done before the pass.
+func (p *Profiler) Profiles(ctx context.Context) ([]*transform.DataProfile, error) {
+	p.mu.Lock()
+	if p.current == nil {
+		p.start()
+	}
+	pass := p.current
+	p.mu.Unlock()
+
+	select {
+	case <-pass.done:
+		return pass.profiles, pass.err
+	case <-ctx.Done():
+		return nil, ErrProfiling
+	}
+}
+
+// start begins a new pass that replaces the current one. p.mu is held.
+func (p *Profiler) start() {
+	pass := &profilePass{done: make(chan struct{})}
+	p.current = pass
+	go func() {
+		pass.profiles, pass.err = p.profile()
+		close(pass.done)
+		if pass.err != nil {
+			p.mu.Lock()
+			if p.current == pass {
+				p.current = nil
+			}
+			p.mu.Unlock()
+		}
+	}()
+}
+
+// profile reads every file
+func (p *Profiler) profile() ([]*transform.DataProfile, error) {
+	profiles := make([]*transform.DataProfile, 0, len(p.Files))
+	for _, file := range p.Files {
+		profile, err := p.Handler.ProfileDataFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to profile %s: %w", file, err)
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// GET /api/data/profile
+//
+// Lists a DataProfile for each loaded data file: every source column with its
+// inferred type, null rate, distinct count, min/max, top values, value-length
+// histogram and date range. Responds 503 with Retry-After while the files are
+// still being profiled.
+func (api *API) DataProfile(w http.ResponseWriter, r *http.Request) {
+	if api.Profiler == nil {
+		http.NotFound(w, r)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), profileWait)
+	defer cancel()
+	profiles, err := api.Profiler.Profiles(ctx)
+	if errors.Is(err, ErrProfiling) {
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// Not tied to the aggregates' ETag, as Reset changes the profiles
+	writeUncached(w, http.StatusOK, profiles)
+}
@@ -4,12 +4,15 @@ import (
 	"bufio"
 	"encoding/csv"
 	"errors"
+	"fmt"
 	"io"
 	"strconv"
 	"strings"
 	"time"
 
 	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/money"
+	"abt-dashboard/internal/quarantine"
 )
 
 // ParseTransactionsCSV reads transactions.csv into a slice of Transaction.
@@ -21,6 +24,19 @@ import (
 // tx_time supports RFC3339 (e.g. 2024-03-15T12:34:56Z)
 // or YYYY-MM-DD (e.g. 2024-03-15).
 func ParseTransactionsCSV(r io.Reader) ([]models.Transaction, error) {
+	return ParseTransactionsCSVWithQuarantine(r, "", nil, 0)
+}
+
+// ParseTransactionsCSVWithQuarantine is ParseTransactionsCSV that reports
+// every row it drops or patches to q, labelled with source. Rows with an
+// unparseable date are dropped; an unparseable price or quantity is read as 0
+// and prices with fractions of a cent are rounded half up.
+// A nil q discards the reports.
+//
+// Transactions carry their source and line in Lineage. Only the first
+// rawLimit also carry their CSV row in Lineage.Raw, as only the transactions
+// an aggregator traces need it; pass 0 when lineage is not drilled into.
+func ParseTransactionsCSVWithQuarantine(r io.Reader, source string, q *quarantine.Writer, rawLimit int) ([]models.Transaction, error) {
 	cr := csv.NewReader(bufio.NewReader(r))
 	cr.TrimLeadingSpace = true
 
@@ -52,11 +68,33 @@ func ParseTransactionsCSV(r io.Reader) ([]models.Transaction, error) {
 		if err != nil {
 			return nil, err
 		}
+		// The file line the row starts on: the header and line breaks in
+		// quoted fields count
+		line, _ := cr.FieldPos(0)
+
+		report := func(action, column, reason string) {
+			q.Record(quarantine.Entry{
+				Source: source,
+				Line:   line,
+				Action: action,
+				Stage:  "ingest",
+				Column: column,
+				Reason: reason,
+				Raw:    quarantine.RawCSV(rec, ','),
+			})
+		}
 
-		// Parse price as float and convert to cents
-		priceFloat, _ := strconv.ParseFloat(rec[idx["price"]], 64)
-		up := int64(priceFloat * 100) // Convert dollars to cents
-		qty, _ := strconv.ParseInt(rec[idx["quantity"]], 10, 64)
+		// Parse price as an exact decimal and convert dollars to cents
+		up, exact, err := money.Parse(rec[idx["price"]], 2, money.DefaultRounding)
+		if err != nil {
+			report(quarantine.ActionDefaulted, "price", fmt.Sprintf("invalid price %q read as 0", rec[idx["price"]]))
+		} else if !exact {
+			report(quarantine.ActionRounded, "price", fmt.Sprintf("%s rounded to %s", rec[idx["price"]], money.Format(up, 2)))
+		}
+		qty, err := strconv.ParseInt(rec[idx["quantity"]], 10, 64)
+		if err != nil {
+			report(quarantine.ActionDefaulted, "quantity", fmt.Sprintf("invalid quantity %q read as 0", rec[idx["quantity"]]))
+		}
 
 		// Parse date (try RFC3339 then YYYY-MM-DD)
 		tstr := rec[idx["transaction_date"]]
@@ -65,10 +103,15 @@ func ParseTransactionsCSV(r io.Reader) ([]models.Transaction, error) {
 		if err != nil {
 			tt, err = time.Parse("2006-01-02", tstr)
 			if err != nil {
+				report(quarantine.ActionRejected, "transaction_date", fmt.Sprintf("invalid date %q", tstr))
 				continue // skip bad rows
 			}
 		}
 
+		lineage := models.Lineage{Source: source, Line: line}
+		if len(out) < rawLimit {
+			lineage.Raw = quarantine.RawCSV(rec, ',')
+		}
 		out = append(out, models.Transaction{
 			ID:             rec[idx["transaction_id"]],
 			Country:        rec[idx["country"]],
@@ -77,17 +120,25 @@ func ParseTransactionsCSV(r io.Reader) ([]models.Transaction, error) {
 			UnitPriceCents: up,
 			Quantity:       qty,
 			TxTime:         tt,
+			Lineage:        lineage,
 		})
 	}
 	return out, nil
 }
 
-// ParseInventoryCSV reads inventory.csv into a map of ProductName → Inventory.
+// ParseInventoryCSV reads inventory.csv into one stock level per product and
+// as-of date, held in models.DefaultWarehouse. When a product is listed more
+// than once for a date the last row wins. The flexible pipeline reads other
+// formats, column names and warehouses; see
+// transform.FlexibleDataHandler.ProcessInventoryFile.
 //
 // Expected CSV headers:
 //
-//	product,stock_qty
-func ParseInventoryCSV(r io.Reader) (map[string]models.Inventory, error) {
+//	product_name,stock_quantity[,as_of]
+//
+// as_of supports RFC3339 or YYYY-MM-DD; without it, or when it does not
+// parse, AsOf is left zero.
+func ParseInventoryCSV(r io.Reader) ([]models.Inventory, error) {
 	cr := csv.NewReader(bufio.NewReader(r))
 	cr.TrimLeadingSpace = true
 
@@ -108,7 +159,8 @@ func ParseInventoryCSV(r io.Reader) (map[string]models.Inventory, error) {
 		}
 	}
 
-	res := make(map[string]models.Inventory, 128)
+	res := make([]models.Inventory, 0, 128)
+	seen := make(map[string]int, 128)
 	for {
 		rec, err := cr.Read()
 		if err == io.EOF {
@@ -120,7 +172,22 @@ func ParseInventoryCSV(r io.Reader) (map[string]models.Inventory, error) {
 
 		qty, _ := strconv.ParseInt(rec[idx["stock_quantity"]], 10, 64)
 		name := rec[idx["product_name"]]
-		res[name] = models.Inventory{ProductName: name, StockQty: qty}
+		row := models.Inventory{ProductName: name, Warehouse: models.DefaultWarehouse, StockQty: qty}
+		if i, ok := idx["as_of"]; ok && i < len(rec) {
+			if tt, err := time.Parse(time.RFC3339, rec[i]); err == nil {
+				row.AsOf = tt
+			} else if tt, err := time.Parse("2006-01-02", rec[i]); err == nil {
+				row.AsOf = tt
+			}
+		}
+
+		key := name + "\x00" + row.AsOf.Format(time.RFC3339)
+		if i, ok := seen[key]; ok {
+			res[i] = row
+			continue
+		}
+		seen[key] = len(res)
+		res = append(res, row)
 	}
 	return res, nil
 }
@@ -0,0 +1,138 @@
+package ingest
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"abt-dashboard/internal/quarantine"
+)
+
+const header = "transaction_id,transaction_date,country,region,product_name,price,quantity\n"
+
+func TestParseTransactionsCSVWithQuarantine(t *testing.T) {
+	tests := []struct {
+		name   string
+		rows   string
+		kept   int
+		line   int
+		action string
+		column string
+		reason string
+	}{
+		{
+			name:   "bad date",
+			rows:   "tx-1,2024-01-15,US,N,widget,1.00,1\ntx-2,15/01/2024,US,N,widget,1.00,1\n",
+			kept:   1,
+			line:   3,
+			action: quarantine.ActionRejected,
+			column: "transaction_date",
+			reason: `invalid date "15/01/2024"`,
+		},
+		{
+			name:   "bad price",
+			rows:   "tx-1,2024-01-15,US,N,widget,$1.00,1\n",
+			kept:   1,
+			line:   2,
+			action: quarantine.ActionDefaulted,
+			column: "price",
+			reason: `invalid price "$1.00" read as 0`,
+		},
+		{
+			name:   "defaulted quantity",
+			rows:   "tx-1,2024-01-15,US,N,widget,1.00,\n",
+			kept:   1,
+			line:   2,
+			action: quarantine.ActionDefaulted,
+			column: "quantity",
+			reason: `invalid quantity "" read as 0`,
+		},
+		{
+			name:   "rounded price",
+			rows:   "tx-1,2024-01-15,US,N,widget,1.005,1\n",
+			kept:   1,
+			line:   2,
+			action: quarantine.ActionRounded,
+			column: "price",
+			reason: "1.005 rounded to 1.01",
+		},
+		{
+			// The quoted line break puts tx-2 on the fourth line of the file
+			name:   "after a multi-line field",
+			rows:   "tx-1,2024-01-15,US,N,\"widget\nlarge\",1.00,1\ntx-2,2024-01-15,US,N,widget,one,1\n",
+			kept:   2,
+			line:   4,
+			action: quarantine.ActionDefaulted,
+			column: "price",
+			reason: `invalid price "one" read as 0`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "quarantine.ndjson")
+			q, err := quarantine.New(path, "")
+			if err != nil {
+				t.Fatalf("quarantine.New: %v", err)
+			}
+
+			txs, err := ParseTransactionsCSVWithQuarantine(strings.NewReader(header+tt.rows), "sales.csv", q, 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := q.Close(); err != nil {
+				t.Fatalf("closing quarantine: %v", err)
+			}
+			if len(txs) != tt.kept {
+				t.Errorf("kept %d transactions, want %d", len(txs), tt.kept)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("quarantine file not written: %v", err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if len(lines) != 1 {
+				t.Fatalf("got %d quarantine entries, want 1: %s", len(lines), data)
+			}
+			var entry quarantine.Entry
+			if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+				t.Fatalf("invalid quarantine line %q: %v", lines[0], err)
+			}
+			if entry.Source != "sales.csv" || entry.Stage != "ingest" || entry.Line != tt.line ||
+				entry.Action != tt.action || entry.Column != tt.column || entry.Reason != tt.reason {
+				t.Errorf("entry: got %+v, want line %d, %s %s %q", entry, tt.line, tt.action, tt.column, tt.reason)
+			}
+		})
+	}
+}
+
+func TestParseTransactionsCSVLineage(t *testing.T) {
+	rows := "tx-1,2024-01-15,US,N,\"widget\nlarge\",1.00,1\n" +
+		"tx-2,2024-01-16,US,N,widget,2.00,1\n" +
+		"tx-3,2024-01-17,US,N,widget,3.00,1\n"
+
+	txs, err := ParseTransactionsCSVWithQuarantine(strings.NewReader(header+rows), "sales.csv", nil, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(txs) != 3 {
+		t.Fatalf("got %d transactions, want 3", len(txs))
+	}
+
+	wantLines := []int{2, 4, 5}
+	for i, tx := range txs {
+		if tx.Lineage.Source != "sales.csv" || tx.Lineage.Line != wantLines[i] {
+			t.Errorf("%s lineage: got %+v, want line %d", tx.ID, tx.Lineage, wantLines[i])
+		}
+	}
+	// Only the first rawLimit transactions keep their row
+	if want := "tx-1,2024-01-15,US,N,\"widget\nlarge\",1.00,1"; txs[0].Lineage.Raw != want {
+		t.Errorf("tx-1 raw: got %q want %q", txs[0].Lineage.Raw, want)
+	}
+	if txs[1].Lineage.Raw == "" || txs[2].Lineage.Raw != "" {
+		t.Errorf("raw rows: got %q, %q; want only the first two kept", txs[1].Lineage.Raw, txs[2].Lineage.Raw)
+	}
+}
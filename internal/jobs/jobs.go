@@ -0,0 +1,371 @@
+// Package jobs runs data files through a FlexibleDataHandler in the
+// background. Each submitted file becomes a job that can be polled for
+// progress and cancelled; the records of a job are merged into the dashboard
+// aggregator only once the whole file has been processed.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"abt-dashboard/internal/metrics"
+	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/spool"
+	"abt-dashboard/internal/transform"
+)
+
+// State is the lifecycle stage of a job
+type State string
+
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled"
+)
+
+// Finished reports whether the job has stopped for good
+func (s State) Finished() bool {
+	return s == StateSucceeded || s == StateFailed || s == StateCancelled
+}
+
+// ErrNotFound is returned for unknown job IDs
+var ErrNotFound = errors.New("job not found")
+
+// ErrFinished is returned when cancelling a job that has already stopped
+var ErrFinished = errors.New("job already finished")
+
+// ErrQueueFull is returned by SubmitFile when too many jobs are waiting
+var ErrQueueFull = errors.New("job queue is full")
+
+const (
+	// queueSize is the number of jobs that may wait to run
+	queueSize = 64
+
+	// keepFinished is the number of finished jobs kept for status queries
+	keepFinished = 100
+)
+
+// Status is a point-in-time view of a job
+type Status struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	State         State      `json:"state"`
+	SubmittedAt   time.Time  `json:"submitted_at"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+	RowsProcessed int        `json:"rows_processed"`
+	RowsSkipped   int        `json:"rows_skipped"`
+	RowsPerSecond float64    `json:"rows_per_second"`
+	Warnings      []string   `json:"warnings"`
+	Errors        []string   `json:"errors"`
+	Error         string     `json:"error,omitempty"`
+
+	// Result is the final TransformationResult of a succeeded job
+	Result *transform.TransformationResult `json:"result,omitempty"`
+}
+
+// job is the mutable state behind a Status
+type job struct {
+	mu       sync.Mutex
+	status   Status
+	progress transform.TransformationResult
+	path     string
+	temp     bool // remove path when the job finishes
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// Queue runs jobs one at a time, in submission order
+type Queue struct {
+	handler *transform.FlexibleDataHandler
+	agg     *metrics.Aggregator
+	open    func(path string) (io.ReadCloser, error) // opens a job's file
+
+	// Spool keeps the files of succeeded jobs for reprocessing when set
+	Spool *spool.Spool
+
+	mu    sync.Mutex
+	jobs  map[string]*job
+	order []string // job IDs in submission order
+	queue chan *job
+}
+
+// NewQueue creates a queue whose jobs are processed by handler and merged
+// into agg, and starts its worker
+func NewQueue(handler *transform.FlexibleDataHandler, agg *metrics.Aggregator) *Queue {
+	q := &Queue{
+		handler: handler,
+		agg:     agg,
+		open:    func(path string) (io.ReadCloser, error) { return os.Open(path) },
+		jobs:    make(map[string]*job),
+		queue:   make(chan *job, queueSize),
+	}
+	go q.work()
+	return q
+}
+
+// SubmitFile queues the file at path. name labels the job and selects the
+// format by its extension. When temp is true the file is removed once the
+// job has finished.
+func (q *Queue) SubmitFile(name, path string, temp bool) (Status, error) {
+	id, err := newID()
+	if err != nil {
+		return Status{}, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		status: Status{
+			ID:          id,
+			Name:        name,
+			State:       StateQueued,
+			SubmittedAt: time.Now(),
+			Warnings:    []string{},
+			Errors:      []string{},
+		},
+		path:   path,
+		temp:   temp,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.queue <- j:
+	default:
+		cancel()
+		return Status{}, ErrQueueFull
+	}
+	q.jobs[id] = j
+	q.order = append(q.order, id)
+	q.prune()
+	return j.snapshot(), nil
+}
+
+// SubmitReader copies reader to a temporary file in dir (the system default
+// when empty) and queues it
+func (q *Queue) SubmitReader(name string, reader io.Reader, dir string) (Status, error) {
+	file, err := os.CreateTemp(dir, "ingest-job-*")
+	if err != nil {
+		return Status{}, fmt.Errorf("failed to store upload: %w", err)
+	}
+	_, copyErr := io.Copy(file, reader)
+	closeErr := file.Close()
+	if copyErr != nil || closeErr != nil {
+		os.Remove(file.Name())
+		return Status{}, fmt.Errorf("failed to store upload: %w", errors.Join(copyErr, closeErr))
+	}
+
+	status, err := q.SubmitFile(name, file.Name(), true)
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return status, err
+}
+
+// Get returns the status of a job
+func (q *Queue) Get(id string) (Status, error) {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	q.mu.Unlock()
+	if !ok {
+		return Status{}, ErrNotFound
+	}
+	return j.snapshot(), nil
+}
+
+// List returns the status of all known jobs, most recent first. Warnings,
+// errors and results are left out to keep the listing small.
+func (q *Queue) List() []Status {
+	q.mu.Lock()
+	jobs := make([]*job, 0, len(q.order))
+	for _, id := range q.order {
+		jobs = append(jobs, q.jobs[id])
+	}
+	q.mu.Unlock()
+
+	out := make([]Status, 0, len(jobs))
+	for i := len(jobs) - 1; i >= 0; i-- {
+		status := jobs[i].snapshot()
+		status.Warnings, status.Errors, status.Result = nil, nil, nil
+		out = append(out, status)
+	}
+	return out
+}
+
+// Cancel stops a queued or running job. A running job stops at its next
+// record and none of its records are merged; the handler forgets their
+// transaction IDs, so the file can be submitted again.
+func (q *Queue) Cancel(id string) (Status, error) {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	q.mu.Unlock()
+	if !ok {
+		return Status{}, ErrNotFound
+	}
+
+	j.mu.Lock()
+	if j.status.State.Finished() {
+		j.mu.Unlock()
+		return j.snapshot(), ErrFinished
+	}
+	if j.status.State == StateQueued {
+		// The worker skips it when it comes up
+		j.finish(StateCancelled, nil, "")
+	}
+	j.mu.Unlock()
+
+	j.cancel()
+	return j.snapshot(), nil
+}
+
+// prune forgets the oldest finished jobs beyond keepFinished. q.mu is held.
+func (q *Queue) prune() {
+	finished := 0
+	for i := len(q.order) - 1; i >= 0; i-- {
+		j := q.jobs[q.order[i]]
+		j.mu.Lock()
+		done := j.status.State.Finished()
+		j.mu.Unlock()
+		if !done {
+			continue
+		}
+		if finished++; finished > keepFinished {
+			delete(q.jobs, q.order[i])
+			q.order = append(q.order[:i], q.order[i+1:]...)
+		}
+	}
+}
+
+func (q *Queue) work() {
+	for j := range q.queue {
+		q.run(j)
+	}
+}
+
+// run processes one job
+func (q *Queue) run(j *job) {
+	if j.temp {
+		defer os.Remove(j.path)
+	}
+
+	j.mu.Lock()
+	if j.status.State != StateQueued {
+		j.mu.Unlock()
+		return
+	}
+	started := time.Now()
+	j.status.State = StateRunning
+	j.status.StartedAt = &started
+	j.mu.Unlock()
+
+	result, err := q.process(j)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch {
+	case errors.Is(err, context.Canceled):
+		j.finish(StateCancelled, nil, "")
+		log.Printf("Job %s (%s) cancelled", j.status.ID, j.status.Name)
+	case err != nil:
+		j.finish(StateFailed, nil, err.Error())
+		log.Printf("Job %s (%s) failed: %v", j.status.ID, j.status.Name, err)
+	default:
+		j.finish(StateSucceeded, result, "")
+		log.Printf("Job %s (%s) finished: %d original, %d transformed, %d skipped records",
+			j.status.ID, j.status.Name, result.OriginalRecords, result.TransformedRecords, result.SkippedRecords)
+	}
+}
+
+// process streams the job's file into a private aggregator and merges it
+// into the dashboard when the file has been read completely, unless the job
+// has been cancelled by then. Records whose transaction ID is already loaded
+// are skipped, as for POST /api/ingest.
+func (q *Queue) process(j *job) (*transform.TransformationResult, error) {
+	file, err := q.open(j.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", j.status.Name, err)
+	}
+	defer file.Close()
+	reader, spooled, err := q.Spool.Tee(file)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Spool.Discard(spooled)
+
+	delta := metrics.NewAggregatorInLocation(q.agg.Location())
+	delta.SetLineageLimit(q.agg.LineageLimit())
+	return q.handler.ProcessUpload(j.ctx, transform.Upload{
+		Name:   j.status.Name,
+		Reader: reader,
+		Sink: func(chunk []models.Transaction) error {
+			delta.AddTransactions(chunk)
+			return nil
+		},
+		Progress: func(progress transform.TransformationResult) {
+			j.mu.Lock()
+			j.progress = progress
+			j.mu.Unlock()
+		},
+		Commit: func(*transform.TransformationResult) error {
+			if err := j.ctx.Err(); err != nil {
+				return err
+			}
+			return q.Spool.Commit(j.status.Name, spooled, func() { q.agg.Merge(delta) })
+		},
+	})
+}
+
+// finish records the final state of a job. j.mu is held.
+func (j *job) finish(state State, result *transform.TransformationResult, errMsg string) {
+	finished := time.Now()
+	j.status.State = state
+	j.status.FinishedAt = &finished
+	j.status.Error = errMsg
+	if result != nil {
+		j.progress = *result
+		j.status.Result = result
+	}
+}
+
+// snapshot builds the job's current Status
+func (j *job) snapshot() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := j.status
+	status.RowsProcessed = j.progress.OriginalRecords
+	status.RowsSkipped = j.progress.SkippedRecords
+	status.Warnings = append([]string{}, j.progress.Warnings...)
+	status.Errors = append([]string{}, j.progress.Errors...)
+
+	if status.StartedAt != nil {
+		end := time.Now()
+		if status.FinishedAt != nil {
+			end = *status.FinishedAt
+		}
+		if elapsed := end.Sub(*status.StartedAt).Seconds(); elapsed > 0 {
+			status.RowsPerSecond = float64(status.RowsProcessed) / elapsed
+		}
+	}
+	return status
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,263 @@
+package jobs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"abt-dashboard/internal/metrics"
+	"abt-dashboard/internal/transform"
+)
+
+const jobHeader = "transaction_id,transaction_date,country,region,product_name,price,quantity\n"
+
+// gatedReader returns head, then blocks until gate is closed before
+// returning tail. blocked is closed once head has been read.
+type gatedReader struct {
+	head, tail io.Reader
+	gate       chan struct{}
+	blocked    chan struct{}
+	once       sync.Once
+}
+
+func newGatedReader(head, tail string) *gatedReader {
+	return &gatedReader{
+		head:    strings.NewReader(head),
+		tail:    strings.NewReader(tail),
+		gate:    make(chan struct{}),
+		blocked: make(chan struct{}),
+	}
+}
+
+func (g *gatedReader) Read(p []byte) (int, error) {
+	if n, err := g.head.Read(p); err != io.EOF {
+		return n, err
+	}
+	g.once.Do(func() { close(g.blocked) })
+	<-g.gate
+	return g.tail.Read(p)
+}
+
+func (g *gatedReader) Close() error { return nil }
+
+// newTestQueue returns a queue that opens gated for the path "gated" and
+// reads other paths from disk
+func newTestQueue(t *testing.T, gated *gatedReader) (*Queue, *metrics.Aggregator) {
+	t.Helper()
+	agg := metrics.NewAggregator()
+	q := NewQueue(transform.NewFlexibleDataHandler(transform.LoadDefaultTransformationConfig()), agg)
+	q.open = func(path string) (io.ReadCloser, error) {
+		if path == "gated" {
+			return gated, nil
+		}
+		return os.Open(path)
+	}
+	if gated != nil {
+		t.Cleanup(func() {
+			select {
+			case <-gated.gate:
+			default:
+				close(gated.gate)
+			}
+		})
+	}
+	return q, agg
+}
+
+func writeJobFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "upload.csv")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func waitFinished(t *testing.T, q *Queue, id string) Status {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		status, err := q.Get(id)
+		if err != nil {
+			t.Fatalf("get %s: %v", id, err)
+		}
+		if status.State.Finished() {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job did not finish: %+v", status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func unitsSold(agg *metrics.Aggregator) int64 {
+	var units int64
+	for _, month := range agg.SalesByMonth() {
+		units += month.UnitsSold
+	}
+	return units
+}
+
+func TestQueue_CancelQueued(t *testing.T) {
+	gated := newGatedReader(jobHeader+"job-1,2024-04-01,USA,East,Widget,10.00,2\n", "")
+	q, agg := newTestQueue(t, gated)
+
+	first, err := q.SubmitFile("first.csv", "gated", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-gated.blocked
+	second, err := q.SubmitFile("second.csv",
+		writeJobFile(t, jobHeader+"job-2,2024-04-01,USA,East,Widget,10.00,5\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := q.Cancel(second.ID)
+	if err != nil || status.State != StateCancelled {
+		t.Fatalf("cancel: got %+v, %v", status, err)
+	}
+	if _, err := q.Cancel(second.ID); !errors.Is(err, ErrFinished) {
+		t.Errorf("cancel twice: got %v, want ErrFinished", err)
+	}
+
+	close(gated.gate)
+	if status := waitFinished(t, q, first.ID); status.State != StateSucceeded {
+		t.Fatalf("first: got %+v", status)
+	}
+	if status := waitFinished(t, q, second.ID); status.State != StateCancelled || status.StartedAt != nil {
+		t.Errorf("second: got %+v, want cancelled before starting", status)
+	}
+	if units := unitsSold(agg); units != 2 {
+		t.Errorf("units sold: got %d, want 2", units)
+	}
+}
+
+func TestQueue_CancelRunning(t *testing.T) {
+	const (
+		head = jobHeader + "job-1,2024-04-01,USA,East,Widget,10.00,2\njob-2,2024-04-02,USA,East,Widget,10.00,3\n"
+		tail = "job-3,2024-04-03,USA,East,Widget,10.00,4\n"
+	)
+	gated := newGatedReader(head, tail)
+	q, agg := newTestQueue(t, gated)
+
+	submitted, err := q.SubmitFile("upload.csv", "gated", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-gated.blocked
+
+	status, err := q.Cancel(submitted.ID)
+	if err != nil || status.State != StateRunning {
+		t.Fatalf("cancel: got %+v, %v", status, err)
+	}
+	close(gated.gate)
+	if status := waitFinished(t, q, submitted.ID); status.State != StateCancelled || status.Result != nil {
+		t.Fatalf("job: got %+v", status)
+	}
+	if units := unitsSold(agg); units != 0 {
+		t.Errorf("units sold after cancel: got %d, want 0", units)
+	}
+
+	// The cancelled job's IDs are forgotten, so the same rows load in full
+	resubmitted, err := q.SubmitFile("upload.csv", writeJobFile(t, head+tail), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status = waitFinished(t, q, resubmitted.ID)
+	if status.State != StateSucceeded || status.Result == nil {
+		t.Fatalf("resubmitted: got %+v", status)
+	}
+	if status.Result.TransformedRecords != 3 || status.Result.AlreadyLoaded != 0 || len(status.Errors) != 0 {
+		t.Errorf("resubmitted: got result %+v", status.Result)
+	}
+	if units := unitsSold(agg); units != 9 {
+		t.Errorf("units sold: got %d, want 9", units)
+	}
+}
+
+func TestQueue_Failure(t *testing.T) {
+	q, agg := newTestQueue(t, nil)
+
+	missing, err := q.SubmitFile("missing.csv", filepath.Join(t.TempDir(), "missing.csv"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := waitFinished(t, q, missing.ID)
+	if status.State != StateFailed || !strings.Contains(status.Error, "failed to open missing.csv") {
+		t.Errorf("missing file: got %+v", status)
+	}
+
+	malformed, err := q.SubmitFile("upload.json",
+		writeJobFile(t, `[{"transaction_id": "job-1", "quantity": 2}, {"transaction_id": `), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitFinished(t, q, malformed.ID); status.State != StateFailed || status.Error == "" {
+		t.Errorf("malformed file: got %+v", status)
+	}
+	if units := unitsSold(agg); units != 0 {
+		t.Errorf("units sold: got %d, want 0", units)
+	}
+}
+
+func TestQueue_Full(t *testing.T) {
+	gated := newGatedReader(jobHeader, "")
+	q, _ := newTestQueue(t, gated)
+
+	if _, err := q.SubmitFile("running.csv", "gated", false); err != nil {
+		t.Fatal(err)
+	}
+	<-gated.blocked
+
+	path := writeJobFile(t, jobHeader)
+	for i := 0; i < queueSize; i++ {
+		if _, err := q.SubmitFile("queued.csv", path, false); err != nil {
+			t.Fatalf("job %d: %v", i, err)
+		}
+	}
+	if _, err := q.SubmitFile("overflow.csv", path, false); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("overflow: got %v, want ErrQueueFull", err)
+	}
+	if got := len(q.List()); got != queueSize+1 {
+		t.Errorf("list: got %d jobs, want %d", got, queueSize+1)
+	}
+}
+
+func TestQueue_Prune(t *testing.T) {
+	gated := newGatedReader(jobHeader, "")
+	q, _ := newTestQueue(t, gated)
+
+	missing := filepath.Join(t.TempDir(), "missing.csv")
+	var ids []string
+	for i := 0; i < keepFinished+5; i++ {
+		status, err := q.SubmitFile("missing.csv", missing, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitFinished(t, q, status.ID)
+		ids = append(ids, status.ID)
+	}
+
+	// Submitting prunes; the new job is still running, so it is not counted
+	running, err := q.SubmitFile("running.csv", "gated", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := q.List()
+	if len(list) != keepFinished+1 || list[0].ID != running.ID {
+		t.Fatalf("list: got %d jobs, first %+v", len(list), list[0])
+	}
+	for i, id := range ids {
+		_, err := q.Get(id)
+		if pruned := i < 5; pruned != errors.Is(err, ErrNotFound) {
+			t.Errorf("job %d: got %v, pruned %v", i, err, pruned)
+		}
+	}
+}
@@ -9,28 +9,74 @@ import (
     "abt-dashboard/internal/models"
 )
 
+// maxDimensionValues caps the distinct values tracked per attribute; further
+// values are counted under OtherValue so that an attribute unique to every
+// transaction cannot grow the aggregator without bound.
+const maxDimensionValues = 1000
+
+// OtherValue groups the values of an attribute beyond maxDimensionValues.
+const OtherValue = "(other)"
+
+// UnknownContinent groups the countries whose continent is not known, e.g.
+// because no geography reference file is configured.
+const UnknownContinent = "(unknown)"
+
 // Aggregator holds in-memory aggregations for analytics.
 type Aggregator struct {
     countryProduct map[string]map[string]*models.CountryProductAgg // country → product → agg
     productAgg     map[string]*models.ProductAgg                   // product → agg
     monthAgg       map[string]*models.MonthAgg                     // YYYY-MM → agg
-    regionAgg      map[string]*models.RegionAgg                    // region → agg
+    regionAgg      map[string]map[string]*models.RegionAgg         // country → region → agg
+    countryAgg     map[string]*models.CountryAgg                   // country → agg
+    dimensions     map[string]map[string]*models.DimensionAgg      // attribute → value → agg
+
+    loc        *time.Location                      // reporting timezone months and days are bucketed in
+    inventory  map[string]map[stockSite]*siteStock // product → warehouse location → stock snapshots
+    dailyUnits map[string]map[string]int64         // product → YYYY-MM-DD → units sold
+    version    uint64                              // incremented whenever the aggregates change
+
+    lineage      []lineageEntry // traced transactions in the order added, for drill-down
+    lineageLimit int            // entries kept in lineage; 0 disables tracing
+    untraced     int64          // transactions counted without a lineage entry
 
     mu sync.RWMutex
 }
 
-// NewAggregator creates a new, empty aggregator.
+// NewAggregator creates a new, empty aggregator that buckets months in UTC.
 func NewAggregator() *Aggregator {
+    return NewAggregatorInLocation(time.UTC)
+}
+
+// NewAggregatorInLocation creates a new, empty aggregator that buckets
+// months in the given reporting timezone, so a sale at 23:30 on 31 January
+// in UTC counts towards February when reporting in Asia/Tokyo.
+func NewAggregatorInLocation(loc *time.Location) *Aggregator {
+    if loc == nil {
+        loc = time.UTC
+    }
     return &Aggregator{
         countryProduct: make(map[string]map[string]*models.CountryProductAgg),
         productAgg:     make(map[string]*models.ProductAgg),
         monthAgg:       make(map[string]*models.MonthAgg),
-        regionAgg:      make(map[string]*models.RegionAgg),
+        regionAgg:      make(map[string]map[string]*models.RegionAgg),
+        countryAgg:     make(map[string]*models.CountryAgg),
+        dimensions:     make(map[string]map[string]*models.DimensionAgg),
+        inventory:      make(map[string]map[stockSite]*siteStock),
+        dailyUnits:     make(map[string]map[string]int64),
+        loc:            loc,
+        lineageLimit:   DefaultLineageLimit,
     }
 }
 
 // Ingest loads transactions and inventory into the aggregator.
-func (a *Aggregator) Ingest(trans []models.Transaction, inv map[string]models.Inventory) {
+func (a *Aggregator) Ingest(trans []models.Transaction, inv []models.Inventory) {
+    a.AddTransactions(trans)
+    a.MergeInventory(inv)
+}
+
+// AddTransactions folds a batch of transactions into the running aggregates.
+// It can be called repeatedly, e.g. once per chunk of a streamed file.
+func (a *Aggregator) AddTransactions(trans []models.Transaction) {
     a.mu.Lock()
     defer a.mu.Unlock()
 
@@ -53,14 +99,17 @@ func (a *Aggregator) Ingest(trans []models.Transaction, inv map[string]models.In
         // Product-level aggregation
         pa := a.productAgg[t.ProductName]
         if pa == nil {
-            pa = &models.ProductAgg{ProductName: t.ProductName}
+            pa = &models.ProductAgg{ProductName: t.ProductName, StockQty: a.stockTotal(t.ProductName)}
             a.productAgg[t.ProductName] = pa
         }
         pa.TxCount++
         pa.UnitsSold += t.Quantity
 
+        // Daily units per product, aligned with stock snapshots
+        a.addDailyUnits(t.ProductName, t.TxTime.In(a.loc).Format("2006-01-02"), t.Quantity)
+
         // Month aggregation
-        ym := t.TxTime.Format("2006-01")
+        ym := t.TxTime.In(a.loc).Format("2006-01")
         ma := a.monthAgg[ym]
         if ma == nil {
             ma = &models.MonthAgg{YearMonth: ym}
@@ -70,23 +119,209 @@ func (a *Aggregator) Ingest(trans []models.Transaction, inv map[string]models.In
         ma.TxCount++
         ma.RevenueCents += t.UnitPriceCents * t.Quantity
 
-        // Region aggregation
-        ra := a.regionAgg[t.Region]
-        if ra == nil {
-            ra = &models.RegionAgg{Region: t.Region}
-            a.regionAgg[t.Region] = ra
-        }
+        // Region aggregation, per country
+        ra := a.regionAggOf(t.Country, t.Region)
         ra.TotalRevenue += t.UnitPriceCents * t.Quantity
         ra.ItemsSold += t.Quantity
         ra.NumberOfTx++
+
+        // Country aggregation, rolled up by continent
+        ca := a.countryAggOf(t.Country, t.CountryCode, t.Continent)
+        ca.TotalRevenue += t.UnitPriceCents * t.Quantity
+        ca.ItemsSold += t.Quantity
+        ca.NumberOfTx++
+
+        // Attribute aggregation
+        for name, value := range t.Attributes {
+            da := a.dimensionAgg(name, value)
+            da.TotalRevenue += t.UnitPriceCents * t.Quantity
+            da.UnitsSold += t.Quantity
+            da.NumberOfTx++
+        }
+
+        a.trace(&t, ym)
+    }
+    if len(trans) > 0 {
+        a.version++
+    }
+}
+
+// Merge adds the aggregates of other, which must not be in use, in one short
+// critical section. Folding a large batch into a private aggregator first and
+// merging it keeps readers blocked only for the merge, whose cost depends on
+// the number of distinct countries, products, months and regions rather than
+// on the number of transactions.
+func (a *Aggregator) Merge(other *Aggregator) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+
+    for country, products := range other.countryProduct {
+        if _, ok := a.countryProduct[country]; !ok {
+            a.countryProduct[country] = make(map[string]*models.CountryProductAgg)
+        }
+        for product, o := range products {
+            cp := a.countryProduct[country][product]
+            if cp == nil {
+                cp = &models.CountryProductAgg{Country: country, ProductName: product}
+                a.countryProduct[country][product] = cp
+            }
+            cp.TotalRevenue += o.TotalRevenue
+            cp.NumberOfTx += o.NumberOfTx
+        }
     }
 
-    // Merge inventory stocks into product aggregates
-    for name, p := range a.productAgg {
-        if invRow, ok := inv[name]; ok {
-            p.StockQty = invRow.StockQty
+    for product, o := range other.productAgg {
+        pa := a.productAgg[product]
+        if pa == nil {
+            pa = &models.ProductAgg{ProductName: product, StockQty: a.stockTotal(product)}
+            a.productAgg[product] = pa
+        }
+        pa.TxCount += o.TxCount
+        pa.UnitsSold += o.UnitsSold
+    }
+
+    for product, days := range other.dailyUnits {
+        for day, units := range days {
+            a.addDailyUnits(product, day, units)
+        }
+    }
+
+    for ym, o := range other.monthAgg {
+        ma := a.monthAgg[ym]
+        if ma == nil {
+            ma = &models.MonthAgg{YearMonth: ym}
+            a.monthAgg[ym] = ma
+        }
+        ma.UnitsSold += o.UnitsSold
+        ma.TxCount += o.TxCount
+        ma.RevenueCents += o.RevenueCents
+    }
+
+    for country, regions := range other.regionAgg {
+        for region, o := range regions {
+            ra := a.regionAggOf(country, region)
+            ra.TotalRevenue += o.TotalRevenue
+            ra.ItemsSold += o.ItemsSold
+            ra.NumberOfTx += o.NumberOfTx
+        }
+    }
+
+    for country, o := range other.countryAgg {
+        ca := a.countryAggOf(country, o.CountryCode, o.Continent)
+        ca.TotalRevenue += o.TotalRevenue
+        ca.ItemsSold += o.ItemsSold
+        ca.NumberOfTx += o.NumberOfTx
+    }
+
+    for name, values := range other.dimensions {
+        for value, o := range values {
+            da := a.dimensionAgg(name, value)
+            da.TotalRevenue += o.TotalRevenue
+            da.UnitsSold += o.UnitsSold
+            da.NumberOfTx += o.NumberOfTx
+        }
+    }
+
+    a.mergeLineage(other)
+    a.version++
+}
+
+// Replace swaps in the aggregates, reporting timezone, inventory and lineage
+// of other in one step, such as after reprocessing the data with a new
+// configuration. Readers see either the old or the new aggregates. other
+// must not be used afterwards.
+func (a *Aggregator) Replace(other *Aggregator) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+
+    a.countryProduct = other.countryProduct
+    a.productAgg = other.productAgg
+    a.monthAgg = other.monthAgg
+    a.regionAgg = other.regionAgg
+    a.countryAgg = other.countryAgg
+    a.dimensions = other.dimensions
+    a.loc = other.loc
+    a.inventory = other.inventory
+    a.dailyUnits = other.dailyUnits
+    a.lineage = other.lineage
+    a.lineageLimit = other.lineageLimit
+    a.untraced = other.untraced
+    // The version only moves forward, so cached responses are revalidated
+    a.version++
+}
+
+// regionAggOf returns the aggregate of a region of a country, creating it.
+// a.mu is held.
+func (a *Aggregator) regionAggOf(country, region string) *models.RegionAgg {
+    regions := a.regionAgg[country]
+    if regions == nil {
+        regions = make(map[string]*models.RegionAgg)
+        a.regionAgg[country] = regions
+    }
+    ra := regions[region]
+    if ra == nil {
+        ra = &models.RegionAgg{Country: country, Region: region}
+        regions[region] = ra
+    }
+    return ra
+}
+
+// countryAggOf returns the aggregate of a country, creating it. A country
+// first seen without a continent takes the first one given later. a.mu is
+// held.
+func (a *Aggregator) countryAggOf(country, code, continent string) *models.CountryAgg {
+    if continent == "" {
+        continent = UnknownContinent
+    }
+    ca := a.countryAgg[country]
+    if ca == nil {
+        ca = &models.CountryAgg{Country: country, Continent: continent}
+        a.countryAgg[country] = ca
+    }
+    if ca.CountryCode == "" {
+        ca.CountryCode = code
+    }
+    if ca.Continent == UnknownContinent {
+        ca.Continent = continent
+    }
+    return ca
+}
+
+// dimensionAgg returns the aggregate of one attribute value, creating it or
+// falling back to OtherValue. a.mu is held.
+func (a *Aggregator) dimensionAgg(name, value string) *models.DimensionAgg {
+    values := a.dimensions[name]
+    if values == nil {
+        values = make(map[string]*models.DimensionAgg)
+        a.dimensions[name] = values
+    }
+    da := values[value]
+    if da == nil {
+        if len(values) >= maxDimensionValues {
+            value = OtherValue
+            if da = values[value]; da != nil {
+                return da
+            }
         }
+        da = &models.DimensionAgg{Value: value}
+        values[value] = da
     }
+    return da
+}
+
+// Location returns the reporting timezone months and days are bucketed in.
+func (a *Aggregator) Location() *time.Location {
+    a.mu.RLock()
+    defer a.mu.RUnlock()
+    return a.loc
+}
+
+// Version returns a counter that changes whenever the aggregates change, for
+// use in cache validators.
+func (a *Aggregator) Version() uint64 {
+    a.mu.RLock()
+    defer a.mu.RUnlock()
+    return a.version
 }
 
 // CountryRevenueTable returns all country-product aggregates sorted by revenue desc.
@@ -119,7 +354,11 @@ func (a *Aggregator) CountryRevenueTable() []models.CountryProductAgg {
 func (a *Aggregator) TopProducts(limit int, byUnits bool) []models.ProductAgg {
     a.mu.RLock()
     defer a.mu.RUnlock()
+    return a.topProducts(limit, byUnits)
+}
 
+// topProducts implements TopProducts. a.mu is held.
+func (a *Aggregator) topProducts(limit int, byUnits bool) []models.ProductAgg {
     out := make([]models.ProductAgg, 0, len(a.productAgg))
     for _, v := range a.productAgg {
         out = append(out, *v)
@@ -162,18 +401,23 @@ func (a *Aggregator) SalesByMonth() []models.MonthAgg {
     return out
 }
 
-// TopRegions returns regions sorted by revenue desc.
+// TopRegions returns the regions of each country sorted by revenue desc.
 func (a *Aggregator) TopRegions(limit int) []models.RegionAgg {
     a.mu.RLock()
     defer a.mu.RUnlock()
 
     out := make([]models.RegionAgg, 0, len(a.regionAgg))
-    for _, v := range a.regionAgg {
-        out = append(out, *v)
+    for _, regions := range a.regionAgg {
+        for _, v := range regions {
+            out = append(out, *v)
+        }
     }
 
     sort.Slice(out, func(i, j int) bool {
         if out[i].TotalRevenue == out[j].TotalRevenue {
+            if out[i].Region == out[j].Region {
+                return out[i].Country < out[j].Country
+            }
             return out[i].Region < out[j].Region
         }
         return out[i].TotalRevenue > out[j].TotalRevenue
@@ -184,3 +428,104 @@ func (a *Aggregator) TopRegions(limit int) []models.RegionAgg {
     }
     return out
 }
+
+// ContinentRevenue returns the continents sorted by revenue desc. Countries
+// without a known continent are grouped under UnknownContinent.
+func (a *Aggregator) ContinentRevenue() []models.ContinentAgg {
+    a.mu.RLock()
+    defer a.mu.RUnlock()
+
+    continents := make(map[string]*models.ContinentAgg)
+    for _, ca := range a.countryAgg {
+        c := continents[ca.Continent]
+        if c == nil {
+            c = &models.ContinentAgg{Continent: ca.Continent}
+            continents[ca.Continent] = c
+        }
+        c.TotalRevenue += ca.TotalRevenue
+        c.ItemsSold += ca.ItemsSold
+        c.NumberOfTx += ca.NumberOfTx
+        c.Countries++
+    }
+
+    out := make([]models.ContinentAgg, 0, len(continents))
+    for _, c := range continents {
+        out = append(out, *c)
+    }
+
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].TotalRevenue == out[j].TotalRevenue {
+            return out[i].Continent < out[j].Continent
+        }
+        return out[i].TotalRevenue > out[j].TotalRevenue
+    })
+    return out
+}
+
+// ContinentCountries returns the countries of a continent sorted by revenue
+// desc, and false if no country is in the continent. Continents match
+// case-insensitively.
+func (a *Aggregator) ContinentCountries(continent string) ([]models.CountryAgg, bool) {
+    a.mu.RLock()
+    defer a.mu.RUnlock()
+
+    out := make([]models.CountryAgg, 0)
+    for _, ca := range a.countryAgg {
+        if strings.EqualFold(ca.Continent, continent) {
+            out = append(out, *ca)
+        }
+    }
+    if len(out) == 0 {
+        return nil, false
+    }
+
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].TotalRevenue == out[j].TotalRevenue {
+            return out[i].Country < out[j].Country
+        }
+        return out[i].TotalRevenue > out[j].TotalRevenue
+    })
+    return out, true
+}
+
+// Dimensions returns the names of the transaction attributes seen so far,
+// sorted.
+func (a *Aggregator) Dimensions() []string {
+    a.mu.RLock()
+    defer a.mu.RUnlock()
+
+    out := make([]string, 0, len(a.dimensions))
+    for name := range a.dimensions {
+        out = append(out, name)
+    }
+    sort.Strings(out)
+    return out
+}
+
+// GroupBy returns the aggregates of one transaction attribute sorted by
+// revenue desc, and false if no transaction has the attribute.
+func (a *Aggregator) GroupBy(name string, limit int) ([]models.DimensionAgg, bool) {
+    a.mu.RLock()
+    defer a.mu.RUnlock()
+
+    values, ok := a.dimensions[name]
+    if !ok {
+        return nil, false
+    }
+    out := make([]models.DimensionAgg, 0, len(values))
+    for _, v := range values {
+        out = append(out, *v)
+    }
+
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].TotalRevenue == out[j].TotalRevenue {
+            return out[i].Value < out[j].Value
+        }
+        return out[i].TotalRevenue > out[j].TotalRevenue
+    })
+
+    if limit > 0 && len(out) > limit {
+        out = out[:limit]
+    }
+    return out, true
+}
@@ -0,0 +1,304 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"abt-dashboard/internal/models"
+)
+
+// Stock breakouts for StockView.By
+const (
+	StockByWarehouse = "warehouse" // one level per warehouse, locations rolled up
+	StockByLocation  = "location"  // one level per location of each warehouse
+)
+
+// stockSite is a location of a warehouse that holds stock
+type stockSite struct {
+	warehouse string
+	location  string
+}
+
+// siteStock is the stock of a product at one site: the snapshots taken, by
+// day, and the latest of them
+type siteStock struct {
+	qty  int64            // stock at the latest snapshot
+	asOf string           // day of the latest snapshot, YYYY-MM-DD
+	days map[string]int64 // snapshot day → stock
+}
+
+// StockView selects the stock reported with a product. Warehouse limits it
+// to one warehouse, matched case-insensitively; empty counts all of them.
+// By breaks the total out per StockByWarehouse or StockByLocation; empty
+// reports the total only.
+type StockView struct {
+	Warehouse string
+	By        string
+}
+
+// MergeInventory records a snapshot of the stock of each product at each
+// warehouse location listed, on the day of its AsOf in the reporting
+// timezone, or today when AsOf is zero. A snapshot replaces one taken the
+// same day. The stock of a location is that of its latest snapshot, so an
+// older snapshot loaded later only adds to the history. The stock of the
+// products seen so far is updated; products added later pick up their stock.
+func (a *Aggregator) MergeInventory(inv []models.Inventory) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	today := time.Now().In(a.loc).Format("2006-01-02")
+	changed := make(map[string]bool)
+	for _, row := range inv {
+		sites := a.inventory[row.ProductName]
+		if sites == nil {
+			sites = make(map[stockSite]*siteStock)
+			a.inventory[row.ProductName] = sites
+		}
+		site := stockSite{warehouse: row.Warehouse, location: row.Location}
+		stock := sites[site]
+		if stock == nil {
+			stock = &siteStock{days: make(map[string]int64)}
+			sites[site] = stock
+		}
+
+		day := today
+		if !row.AsOf.IsZero() {
+			day = row.AsOf.In(a.loc).Format("2006-01-02")
+		}
+		stock.days[day] = row.StockQty
+		if day >= stock.asOf {
+			stock.qty, stock.asOf = row.StockQty, day
+		}
+		changed[row.ProductName] = true
+	}
+
+	for name := range changed {
+		if p := a.productAgg[name]; p != nil {
+			p.StockQty = a.stockTotal(name)
+		}
+	}
+	a.version++
+}
+
+// stockTotal returns the stock of a product across all warehouses. a.mu is
+// held.
+func (a *Aggregator) stockTotal(product string) int64 {
+	var total int64
+	for _, stock := range a.inventory[product] {
+		total += stock.qty
+	}
+	return total
+}
+
+// stockLevels returns the stock of a product in the view: its total and,
+// if the view breaks it out, the levels sorted by warehouse and location.
+// a.mu is held.
+func (a *Aggregator) stockLevels(product string, view StockView) (int64, []models.StockLevel) {
+	var total int64
+	levels := make(map[stockSite]*models.StockLevel)
+	for site, stock := range a.inventory[product] {
+		if !view.includes(site) {
+			continue
+		}
+		total += stock.qty
+
+		key := site
+		switch view.By {
+		case StockByWarehouse:
+			key.location = ""
+		case StockByLocation:
+		default:
+			continue
+		}
+		level := levels[key]
+		if level == nil {
+			level = &models.StockLevel{Warehouse: key.warehouse, Location: key.location}
+			levels[key] = level
+		}
+		level.StockQty += stock.qty
+		if stock.asOf > level.AsOf {
+			level.AsOf = stock.asOf
+		}
+	}
+	if view.By == "" {
+		return total, nil
+	}
+
+	out := make([]models.StockLevel, 0, len(levels))
+	for _, level := range levels {
+		out = append(out, *level)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Warehouse == out[j].Warehouse {
+			return out[i].Location < out[j].Location
+		}
+		return out[i].Warehouse < out[j].Warehouse
+	})
+	return total, out
+}
+
+// includes reports whether the stock of site is counted in the view
+func (view StockView) includes(site stockSite) bool {
+	return view.Warehouse == "" || strings.EqualFold(view.Warehouse, site.warehouse)
+}
+
+// TopProductsStock is TopProducts with the stock of each product as
+// selected by the view.
+func (a *Aggregator) TopProductsStock(limit int, byUnits bool, view StockView) []models.ProductAgg {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	out := a.topProducts(limit, byUnits)
+	for i := range out {
+		out[i].StockQty, out[i].Stock = a.stockLevels(out[i].ProductName, view)
+	}
+	return out
+}
+
+// ProductStock returns the stock of one product, matched case-insensitively,
+// as selected by the view. A view without a breakout lists the stock by
+// warehouse. It reports false if no stock is known for the product.
+func (a *Aggregator) ProductStock(product string, view StockView) (models.ProductStock, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	name, ok := a.inventoryName(product)
+	if !ok {
+		return models.ProductStock{}, false
+	}
+	if view.By == "" {
+		view.By = StockByWarehouse
+	}
+	total, levels := a.stockLevels(name, view)
+	return models.ProductStock{ProductName: name, StockQty: total, Stock: levels}, true
+}
+
+// inventoryName returns the name a product's stock is kept under. a.mu is
+// held.
+func (a *Aggregator) inventoryName(product string) (string, bool) {
+	if _, ok := a.inventory[product]; ok {
+		return product, true
+	}
+	for name := range a.inventory {
+		if strings.EqualFold(name, product) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// addDailyUnits counts units of a product sold on a day. a.mu is held.
+func (a *Aggregator) addDailyUnits(product, day string, units int64) {
+	days := a.dailyUnits[product]
+	if days == nil {
+		days = make(map[string]int64)
+		a.dailyUnits[product] = days
+	}
+	days[day] += units
+}
+
+// StockHistory returns the stock of one product over time, matched
+// case-insensitively, with the units sold each day: one entry per day in the
+// reporting timezone on which the product sold or a snapshot of its stock
+// was taken, from and to included (YYYY-MM-DD, empty for no bound). Stock is
+// counted over all warehouses, or only the one named. A snapshot is taken to
+// follow the sales of its day; each is compared with the previous one,
+// before from if need be, to flag restocks and depletions. Sales are not
+// known per warehouse, so for one warehouse only the change in stock is
+// given. It reports false if neither stock nor sales are known for the
+// product.
+func (a *Aggregator) StockHistory(product, warehouse, from, to string) (models.StockHistory, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	name, ok := a.inventoryName(product)
+	if !ok {
+		if name, ok = a.salesName(product); !ok {
+			return models.StockHistory{}, false
+		}
+	}
+	view := StockView{Warehouse: warehouse}
+
+	// Every day with sales or a snapshot in the view, oldest first
+	sold := a.dailyUnits[name]
+	daySet := make(map[string]bool, len(sold))
+	for day := range sold {
+		daySet[day] = true
+	}
+	for site, stock := range a.inventory[name] {
+		if !view.includes(site) {
+			continue
+		}
+		for day := range stock.days {
+			daySet[day] = true
+		}
+	}
+	days := make([]string, 0, len(daySet))
+	for day := range daySet {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	history := models.StockHistory{ProductName: name, Warehouse: warehouse, Days: make([]models.StockDay, 0)}
+	levels := make(map[stockSite]int64) // latest snapshot of each site so far
+	var (
+		previous    int64
+		hasPrevious bool
+		soldSince   int64 // units sold since the previous snapshot
+	)
+	for _, day := range days {
+		entry := models.StockDay{Date: day, UnitsSold: sold[day]}
+		soldSince += entry.UnitsSold
+
+		snapshot := false
+		for site, stock := range a.inventory[name] {
+			if qty, ok := stock.days[day]; ok && view.includes(site) {
+				levels[site] = qty
+				snapshot = true
+			}
+		}
+		if snapshot {
+			var total int64
+			for _, qty := range levels {
+				total += qty
+			}
+			entry.StockQty = &total
+			if hasPrevious {
+				entry.Change = total - previous
+			}
+			// The sales may have been shipped from any warehouse, so they
+			// only explain the stock of all of them
+			if hasPrevious && warehouse == "" {
+				// What the previous snapshot leaves after the sales since;
+				// anything beyond it was received in between
+				if expected := previous - soldSince; total > expected {
+					entry.Restocked = total - expected
+					entry.Event = models.StockRestock
+				} else if entry.Change < 0 {
+					entry.Event = models.StockDepletion
+				}
+			}
+			previous, hasPrevious, soldSince = total, true, 0
+		}
+
+		if (from == "" || day >= from) && (to == "" || day <= to) {
+			history.Days = append(history.Days, entry)
+		}
+	}
+	return history, true
+}
+
+// salesName returns the name a product's sales are kept under, matched
+// case-insensitively. a.mu is held.
+func (a *Aggregator) salesName(product string) (string, bool) {
+	if _, ok := a.dailyUnits[product]; ok {
+		return product, true
+	}
+	for name := range a.dailyUnits {
+		if strings.EqualFold(name, product) {
+			return name, true
+		}
+	}
+	return "", false
+}
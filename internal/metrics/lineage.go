@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"strings"
+
+	"abt-dashboard/internal/models"
+)
+
+// DefaultLineageLimit is the number of transactions whose lineage an
+// aggregator keeps for drill-down unless SetLineageLimit is called. A traced
+// transaction with its raw record takes about 0.5 KB, so the default costs
+// about 50 MB.
+const DefaultLineageLimit = 100000
+
+// lineageEntry is a traced transaction with the keys of the aggregates it
+// counts towards
+type lineageEntry struct {
+	record     models.CellRecord
+	month      string
+	attributes map[string]string
+}
+
+// Cell selects the transactions counted in an aggregate, such as one row of
+// CountryRevenueTable or SalesByMonth. Empty fields match any transaction
+// and text matches case-insensitively.
+type Cell struct {
+	Country   string
+	Region    string
+	Product   string
+	Continent string // as in ContinentRevenue, including UnknownContinent
+	Month     string // YYYY-MM in the reporting timezone
+
+	// Attribute and Value select a value of a transaction attribute, as in
+	// GroupBy. Values grouped under OtherValue cannot be selected.
+	Attribute string
+	Value     string
+}
+
+// SetLineageLimit sets how many transactions the aggregator keeps the lineage
+// of; 0 disables tracing. Transactions added once the limit is reached, or
+// without a lineage, are still aggregated but cannot be drilled into.
+func (a *Aggregator) SetLineageLimit(limit int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if limit < 0 {
+		limit = 0
+	}
+	a.lineageLimit = limit
+	if len(a.lineage) > limit {
+		a.untraced += int64(len(a.lineage) - limit)
+		a.lineage = a.lineage[:limit:limit]
+	}
+}
+
+// LineageLimit returns how many transactions the aggregator keeps the
+// lineage of.
+func (a *Aggregator) LineageLimit() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.lineageLimit
+}
+
+// Tracing reports whether the lineage of the next transaction added would
+// be kept
+func (a *Aggregator) Tracing() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.lineage) < a.lineageLimit
+}
+
+// trace keeps the lineage of a transaction being added. a.mu is held.
+func (a *Aggregator) trace(t *models.Transaction, month string) {
+	if t.Lineage.Source == "" || len(a.lineage) >= a.lineageLimit {
+		a.untraced++
+		return
+	}
+	a.lineage = append(a.lineage, lineageEntry{
+		record: models.CellRecord{
+			ID:           t.ID,
+			Country:      t.Country,
+			Region:       t.Region,
+			ProductName:  t.ProductName,
+			RevenueCents: t.UnitPriceCents * t.Quantity,
+			Quantity:     t.Quantity,
+			TxTime:       t.TxTime,
+			Lineage:      t.Lineage,
+		},
+		month:      month,
+		attributes: t.Attributes,
+	})
+}
+
+// mergeLineage appends the lineage kept by other, up to the limit. a.mu is
+// held.
+func (a *Aggregator) mergeLineage(other *Aggregator) {
+	a.untraced += other.untraced
+	room := a.lineageLimit - len(a.lineage)
+	if room < 0 {
+		room = 0
+	}
+	if len(other.lineage) > room {
+		a.untraced += int64(len(other.lineage) - room)
+		a.lineage = append(a.lineage, other.lineage[:room]...)
+		return
+	}
+	a.lineage = append(a.lineage, other.lineage...)
+}
+
+// DrillDown lists the traced transactions counted in an aggregate cell, in
+// the order they were added. It skips offset matches and returns at most
+// limit records, or all of them if limit <= 0.
+func (a *Aggregator) DrillDown(cell Cell, offset, limit int) models.DrillDown {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	out := models.DrillDown{Complete: a.untraced == 0, Records: make([]models.CellRecord, 0)}
+	for i := range a.lineage {
+		entry := &a.lineage[i]
+		if !a.inCell(entry, cell) {
+			continue
+		}
+		if out.Matched >= offset && (limit <= 0 || len(out.Records) < limit) {
+			out.Records = append(out.Records, entry.record)
+		}
+		out.Matched++
+	}
+	return out
+}
+
+// inCell reports whether a traced transaction counts towards cell. a.mu is
+// held.
+func (a *Aggregator) inCell(entry *lineageEntry, cell Cell) bool {
+	matches := func(want, got string) bool {
+		return want == "" || strings.EqualFold(want, got)
+	}
+	r := &entry.record
+	if !matches(cell.Country, r.Country) || !matches(cell.Region, r.Region) ||
+		!matches(cell.Product, r.ProductName) || !matches(cell.Month, entry.month) {
+		return false
+	}
+	// The continent of a country is the one its aggregate settled on
+	if cell.Continent != "" {
+		ca := a.countryAgg[r.Country]
+		if ca == nil || !strings.EqualFold(cell.Continent, ca.Continent) {
+			return false
+		}
+	}
+	if cell.Attribute != "" {
+		value, ok := entry.attributes[cell.Attribute]
+		if !ok || !matches(cell.Value, value) {
+			return false
+		}
+	}
+	return true
+}
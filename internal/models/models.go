@@ -6,17 +6,45 @@ import "time"
 type Transaction struct {
 	ID             string    // unique transaction ID
 	Country        string    // e.g., "Sri Lanka"
+	CountryCode    string    // ISO 3166-1 alpha-2, e.g. "LK"; empty when the country is not recognized
+	Continent      string    // e.g., "Asia"; from the geography reference file
 	Region         string    // e.g., "Western"
 	ProductName    string    // e.g., "Widget A"
 	UnitPriceCents int64     // price per unit, stored in cents to avoid float issues
 	Quantity       int64     // number of units sold
 	TxTime         time.Time // transaction timestamp
+
+	Currency               string // ISO 4217 code of UnitPriceCents; the reporting currency once converted
+	OriginalCurrency       string // currency the price was given in at the source
+	OriginalUnitPriceCents int64  // source price per unit in OriginalCurrency, before conversion
+
+	Attributes map[string]string // derived fields by name, e.g. "price_band" -> "10-50"
+
+	Lineage Lineage // where the record came from and what changed it
+}
+
+// Lineage traces a transaction back to the record it was read from.
+type Lineage struct {
+	Source          string   `json:"source"`                    // file, archive member or upload name
+	Line            int      `json:"line"`                      // row, line or element index within Source
+	BatchID         string   `json:"batch_id,omitempty"`        // the ingestion run that loaded it
+	Transformations []string `json:"transformations,omitempty"` // pipeline stages that changed it, in order
+	Raw             string   `json:"raw,omitempty"`             // the record in its source syntax
 }
 
-// Inventory represents available stock for a product.
+// DefaultWarehouse holds the stock of inventory sources that do not name a
+// warehouse.
+const DefaultWarehouse = "default"
+
+// Inventory represents the stock of a product held at one location of a
+// warehouse, as counted at AsOf. Location is empty when the warehouse does
+// not track locations; AsOf is zero when the source does not say.
 type Inventory struct {
-	ProductName string
-	StockQty    int64
+	ProductName string    `json:"product_name"`
+	Warehouse   string    `json:"warehouse"`
+	Location    string    `json:"location,omitempty"`
+	StockQty    int64     `json:"stock_qty"`
+	AsOf        time.Time `json:"as_of"`
 }
 
 // Aggregated view: revenue by country/product
@@ -27,12 +55,61 @@ type CountryProductAgg struct {
 	NumberOfTx   int64  `json:"number_of_transactions"`
 }
 
-// Aggregated view: product popularity
+// Aggregated view: product popularity. StockQty is rolled up over the
+// warehouses asked for, all of them by default; Stock breaks it out when
+// asked for.
 type ProductAgg struct {
-	ProductName string `json:"product_name"`
-	TxCount     int64  `json:"tx_count"`
-	UnitsSold   int64  `json:"units_sold"`
-	StockQty    int64  `json:"stock_qty"`
+	ProductName string       `json:"product_name"`
+	TxCount     int64        `json:"tx_count"`
+	UnitsSold   int64        `json:"units_sold"`
+	StockQty    int64        `json:"stock_qty"`
+	Stock       []StockLevel `json:"stock,omitempty"`
+}
+
+// Aggregated view: stock of a product in one warehouse, or at one location
+// of it when broken out by location. AsOf is the day of the latest snapshot
+// counted, YYYY-MM-DD.
+type StockLevel struct {
+	Warehouse string `json:"warehouse"`
+	Location  string `json:"location,omitempty"`
+	StockQty  int64  `json:"stock_qty"`
+	AsOf      string `json:"as_of"`
+}
+
+// Aggregated view: stock of one product across warehouses
+type ProductStock struct {
+	ProductName string       `json:"product_name"`
+	StockQty    int64        `json:"stock_qty"`
+	Stock       []StockLevel `json:"stock"`
+}
+
+// Stock events of StockDay.Event
+const (
+	StockRestock   = "restock"   // more stock than the previous snapshot less the units sold since
+	StockDepletion = "depletion" // less stock than the previous snapshot, without a restock
+)
+
+// Aggregated view: stock of a product over time, one entry per day with
+// sales or a snapshot, oldest first
+type StockHistory struct {
+	ProductName string     `json:"product_name"`
+	Warehouse   string     `json:"warehouse,omitempty"`
+	Days        []StockDay `json:"days"`
+}
+
+// Aggregated view: one day of a product's stock history. StockQty is set on
+// snapshot days and counts every warehouse location at its latest snapshot
+// up to that day. Change is the difference from the previous snapshot, and
+// Restocked the units received since it: the stock beyond what the previous
+// snapshot less the units sold in between leaves. Restocked and Event are
+// left out for a single warehouse.
+type StockDay struct {
+	Date      string `json:"date"` // format: YYYY-MM-DD
+	UnitsSold int64  `json:"units_sold"`
+	StockQty  *int64 `json:"stock_qty,omitempty"`
+	Change    int64  `json:"change,omitempty"`
+	Restocked int64  `json:"restocked,omitempty"`
+	Event     string `json:"event,omitempty"`
 }
 
 // Aggregated view: monthly sales trends
@@ -43,14 +120,62 @@ type MonthAgg struct {
 	RevenueCents int64  `json:"revenue_cents"`
 }
 
-// Aggregated view: regional performance
+// Aggregated view: regional performance. Regions are per country, so a
+// "Western" region in two countries gives two aggregates.
 type RegionAgg struct {
+	Country      string `json:"country"`
 	Region       string `json:"region"`
 	TotalRevenue int64  `json:"total_revenue_cents"`
 	ItemsSold    int64  `json:"items_sold"`
 	NumberOfTx   int64  `json:"number_of_transactions"`
 }
 
+// Aggregated view: continent performance
+type ContinentAgg struct {
+	Continent    string `json:"continent"`
+	TotalRevenue int64  `json:"total_revenue_cents"`
+	ItemsSold    int64  `json:"items_sold"`
+	NumberOfTx   int64  `json:"number_of_transactions"`
+	Countries    int    `json:"countries"`
+}
+
+// Aggregated view: country performance within its continent
+type CountryAgg struct {
+	Country      string `json:"country"`
+	CountryCode  string `json:"country_code,omitempty"`
+	Continent    string `json:"continent"`
+	TotalRevenue int64  `json:"total_revenue_cents"`
+	ItemsSold    int64  `json:"items_sold"`
+	NumberOfTx   int64  `json:"number_of_transactions"`
+}
+
+// Aggregated view: revenue by the value of one transaction attribute
+type DimensionAgg struct {
+	Value        string `json:"value"`
+	TotalRevenue int64  `json:"total_revenue_cents"`
+	UnitsSold    int64  `json:"units_sold"`
+	NumberOfTx   int64  `json:"number_of_transactions"`
+}
+
+// Drill-down view: a transaction counted in an aggregate, with its lineage
+type CellRecord struct {
+	ID           string    `json:"transaction_id"`
+	Country      string    `json:"country"`
+	Region       string    `json:"region"`
+	ProductName  string    `json:"product_name"`
+	RevenueCents int64     `json:"revenue_cents"`
+	Quantity     int64     `json:"quantity"`
+	TxTime       time.Time `json:"transaction_date"`
+	Lineage      Lineage   `json:"lineage"`
+}
+
+// Drill-down view: the traced transactions counted in an aggregate
+type DrillDown struct {
+	Matched  int          `json:"matched"`  // traced transactions in the aggregate
+	Complete bool         `json:"complete"` // false if some transactions were not traced, so more may count
+	Records  []CellRecord `json:"records"`
+}
+
 // Insight represents a business insight generated from data analysis
 type Insight struct {
 	ID          string                 `json:"id"`
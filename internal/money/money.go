@@ -0,0 +1,153 @@
+// Package money parses and formats monetary amounts exactly. Amounts are
+// held as integer minor units (cents, fils, yen) and decimal input is never
+// routed through float64, so values like 19.99 cannot drift to 1998 cents.
+package money
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Rounding selects how amounts with more decimals than the currency allows
+// are brought to whole minor units
+type Rounding string
+
+const (
+	HalfUp   Rounding = "half_up"   // to nearest, halves away from zero
+	HalfEven Rounding = "half_even" // to nearest, halves to the even neighbour
+	Down     Rounding = "down"      // toward zero (truncate)
+	Up       Rounding = "up"        // away from zero
+	Floor    Rounding = "floor"     // toward negative infinity
+	Ceiling  Rounding = "ceiling"   // toward positive infinity
+)
+
+// DefaultRounding is used when no rounding mode is configured
+const DefaultRounding = HalfUp
+
+// Roundings lists the supported rounding modes
+var Roundings = []Rounding{HalfUp, HalfEven, Down, Up, Floor, Ceiling}
+
+// ParseRounding validates a configured rounding mode; empty selects
+// DefaultRounding
+func ParseRounding(name string) (Rounding, error) {
+	if name == "" {
+		return DefaultRounding, nil
+	}
+	for _, mode := range Roundings {
+		if Rounding(strings.ToLower(name)) == mode {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown rounding mode %q", name)
+}
+
+// minorUnits lists ISO 4217 currencies that do not use two decimal places
+var minorUnits = map[string]int{
+	// No minor unit
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0,
+	"KRW": 0, "PYG": 0, "RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0,
+	"XAF": 0, "XOF": 0, "XPF": 0,
+	// Three decimal places
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	// Four decimal places
+	"CLF": 4, "UYW": 4,
+}
+
+// MinorUnits returns the number of decimal places of an ISO 4217 currency.
+// Unknown codes use two.
+func MinorUnits(currency string) int {
+	if units, ok := minorUnits[strings.ToUpper(strings.TrimSpace(currency))]; ok {
+		return units
+	}
+	return 2
+}
+
+// Scale returns 10^units, the number of minor units in one major unit
+func Scale(units int) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(units)), nil))
+}
+
+// ParseDecimal parses a decimal number such as "-1234.5" or "1.5e3" exactly
+func ParseDecimal(s string) (*big.Rat, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.Contains(s, "/") {
+		return nil, fmt.Errorf("invalid decimal %q", s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid decimal %q", s)
+	}
+	return r, nil
+}
+
+// ToMinor converts an amount in major units to whole minor units, where scale
+// is the number of minor units per major unit. exact is false when rounding
+// discarded a non-zero remainder.
+func ToMinor(amount, scale *big.Rat, mode Rounding) (minor int64, exact bool, err error) {
+	scaled := new(big.Rat).Mul(amount, scale)
+	rounded := Round(scaled, mode)
+	if !rounded.IsInt64() {
+		return 0, false, fmt.Errorf("amount %s out of range", amount.FloatString(4))
+	}
+	return rounded.Int64(), scaled.IsInt(), nil
+}
+
+// Parse parses a decimal string into minor units of a currency with the given
+// number of decimal places
+func Parse(s string, units int, mode Rounding) (minor int64, exact bool, err error) {
+	amount, err := ParseDecimal(s)
+	if err != nil {
+		return 0, false, err
+	}
+	return ToMinor(amount, Scale(units), mode)
+}
+
+// Round rounds r to an integer
+func Round(r *big.Rat, mode Rounding) *big.Int {
+	num, den := r.Num(), r.Denom()
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int)) // truncated toward zero
+	if m.Sign() == 0 {
+		return q
+	}
+
+	negative := r.Sign() < 0
+	awayFromZero := false
+	switch mode {
+	case Down:
+	case Up:
+		awayFromZero = true
+	case Floor:
+		awayFromZero = negative
+	case Ceiling:
+		awayFromZero = !negative
+	default: // HalfUp, HalfEven
+		// Compare twice the remainder with the denominator
+		twice := new(big.Int).Abs(m)
+		twice.Lsh(twice, 1)
+		switch twice.Cmp(den) {
+		case 1:
+			awayFromZero = true
+		case 0:
+			awayFromZero = mode != HalfEven || q.Bit(0) == 1
+		}
+	}
+
+	if awayFromZero {
+		if negative {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+// Format renders minor units as a decimal string with the given number of
+// decimal places, e.g. Format(1999, 2) == "19.99" and Format(500, 0) == "500"
+func Format(minor int64, units int) string {
+	if units <= 0 {
+		return fmt.Sprintf("%d", minor)
+	}
+	return new(big.Rat).SetFrac(big.NewInt(minor), Scale(units).Num()).FloatString(units)
+}
@@ -0,0 +1,68 @@
+package money
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		units int
+		mode  Rounding
+		want  int64
+		exact bool
+	}{
+		{"19.99", 2, HalfUp, 1999, true},
+		{"0.29", 2, HalfUp, 29, true},
+		{"1234567.89", 2, HalfUp, 123456789, true},
+		{"19.995", 2, HalfUp, 2000, false},
+		{"19.985", 2, HalfEven, 1998, false},
+		{"19.995", 2, HalfEven, 2000, false},
+		{"19.999", 2, Down, 1999, false},
+		{"19.991", 2, Up, 2000, false},
+		{"-19.991", 2, Floor, -2000, false},
+		{"-19.999", 2, Ceiling, -1999, false},
+		{"-2.5", 0, HalfUp, -3, false},
+		{"1500", 0, HalfUp, 1500, true},    // JPY
+		{"12.345", 3, HalfUp, 12345, true}, // KWD
+		{"1.5e2", 2, HalfUp, 15000, true},
+	}
+
+	for _, tt := range tests {
+		got, exact, err := Parse(tt.input, tt.units, tt.mode)
+		if err != nil {
+			t.Errorf("Parse(%q): unexpected error: %v", tt.input, err)
+			continue
+		}
+		if got != tt.want || exact != tt.exact {
+			t.Errorf("Parse(%q, %d, %s) = %d, %v; want %d, %v",
+				tt.input, tt.units, tt.mode, got, exact, tt.want, tt.exact)
+		}
+	}
+
+	for _, bad := range []string{"", "abc", "1/3", "12.3.4"} {
+		if _, _, err := Parse(bad, 2, HalfUp); err == nil {
+			t.Errorf("Parse(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestMinorUnitsAndFormat(t *testing.T) {
+	if MinorUnits("jpy") != 0 || MinorUnits("KWD") != 3 || MinorUnits("USD") != 2 || MinorUnits("") != 2 {
+		t.Errorf("unexpected minor units")
+	}
+
+	tests := []struct {
+		minor int64
+		units int
+		want  string
+	}{
+		{1999, 2, "19.99"},
+		{-5, 2, "-0.05"},
+		{500, 0, "500"},
+		{12345, 3, "12.345"},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.minor, tt.units); got != tt.want {
+			t.Errorf("Format(%d, %d) = %q, want %q", tt.minor, tt.units, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package quarantine
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions recorded for a quarantined row
+const (
+	ActionRejected  = "rejected"  // the row was dropped
+	ActionDefaulted = "defaulted" // a value was missing or invalid and replaced by a default
+	ActionFlagged   = "flagged"   // the row was kept but failed a validator or transformation
+	ActionRounded   = "rounded"   // a value had more decimals than its currency allows and was rounded
+)
+
+// Supported output formats
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// Entry describes one quarantined row
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Line   int       `json:"line"`
+	Action string    `json:"action"`
+	Stage  string    `json:"stage"`  // parser, validator or transformation that raised the issue
+	Column string    `json:"column"` // empty when the issue is not tied to one column
+	Reason string    `json:"reason"`
+	Raw    string    `json:"raw"`
+}
+
+var csvHeader = []string{"time", "source", "line", "action", "stage", "column", "reason", "raw"}
+
+// Writer appends quarantine entries to a CSV or NDJSON file. The file is
+// opened on the first entry, so a run without rejected rows leaves no file.
+// A nil *Writer discards entries, which lets callers record unconditionally.
+type Writer struct {
+	path   string
+	format string
+
+	mu      sync.Mutex
+	file    *os.File
+	csv     *csv.Writer
+	failed  bool
+	entries map[string]int // action -> count
+}
+
+// New creates a writer for path. An empty format is derived from the file
+// extension and defaults to CSV.
+func New(path, format string) (*Writer, error) {
+	if format == "" {
+		format = FormatCSV
+		if strings.HasSuffix(strings.ToLower(path), ".ndjson") || strings.HasSuffix(strings.ToLower(path), ".jsonl") {
+			format = FormatNDJSON
+		}
+	}
+	if format != FormatCSV && format != FormatNDJSON {
+		return nil, fmt.Errorf("unsupported quarantine format: %s", format)
+	}
+
+	return &Writer{
+		path:    path,
+		format:  format,
+		entries: make(map[string]int),
+	}, nil
+}
+
+// RawCSV renders fields as one CSV line, for use as Entry.Raw
+func RawCSV(fields []string, delimiter rune) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Comma = delimiter
+	w.Write(fields)
+	w.Flush()
+	return strings.TrimRight(b.String(), "\r\n")
+}
+
+// Path returns the file entries are written to
+func (w *Writer) Path() string {
+	if w == nil {
+		return ""
+	}
+	return w.path
+}
+
+// Record appends an entry. Write failures are logged once and further
+// entries are dropped rather than failing ingestion.
+func (w *Writer) Record(e Entry) {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failed {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if err := w.write(e); err != nil {
+		log.Printf("Quarantine file %s disabled after write error: %v", w.path, err)
+		w.failed = true
+		return
+	}
+	w.entries[e.Action]++
+}
+
+// Counts returns the number of entries written per action
+func (w *Writer) Counts() map[string]int {
+	counts := make(map[string]int)
+	if w == nil {
+		return counts
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for action, n := range w.entries {
+		counts[action] = n
+	}
+	return counts
+}
+
+// Close closes the underlying file
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	w.csv = nil
+	return err
+}
+
+func (w *Writer) write(e Entry) error {
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+
+	if w.format == FormatNDJSON {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = w.file.Write(append(data, '\n'))
+		return err
+	}
+
+	err := w.csv.Write([]string{
+		e.Time.Format(time.RFC3339),
+		e.Source,
+		strconv.Itoa(e.Line),
+		e.Action,
+		e.Stage,
+		e.Column,
+		e.Reason,
+		e.Raw,
+	})
+	if err != nil {
+		return err
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// open opens the file for appending and writes the CSV header to new files
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+
+	if w.format == FormatCSV {
+		w.csv = csv.NewWriter(file)
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if info.Size() == 0 {
+			if err := w.csv.Write(csvHeader); err != nil {
+				return err
+			}
+			w.csv.Flush()
+			return w.csv.Error()
+		}
+	}
+	return nil
+}
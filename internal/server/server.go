@@ -53,8 +53,33 @@ func New(api *handlers.API, staticDir string) *Server {
 	// API routes with gzip compression
 	mux.Handle("GET /api/revenue/countries", gzipMiddleware(http.HandlerFunc(api.CountryRevenue)))
 	mux.Handle("GET /api/products/top", gzipMiddleware(http.HandlerFunc(api.TopProducts)))
+	mux.Handle("GET /api/products/{product}/stock", gzipMiddleware(http.HandlerFunc(api.ProductStock)))
+	mux.Handle("GET /api/products/{product}/stock/history", gzipMiddleware(http.HandlerFunc(api.StockHistory)))
 	mux.Handle("GET /api/sales/by-month", gzipMiddleware(http.HandlerFunc(api.SalesByMonth)))
 	mux.Handle("GET /api/regions/top", gzipMiddleware(http.HandlerFunc(api.TopRegions)))
+	mux.Handle("GET /api/revenue/continents", gzipMiddleware(http.HandlerFunc(api.ContinentRevenue)))
+	mux.Handle("GET /api/revenue/continents/{continent}", gzipMiddleware(http.HandlerFunc(api.ContinentCountries)))
+	mux.Handle("GET /api/dimensions", gzipMiddleware(http.HandlerFunc(api.Dimensions)))
+	mux.Handle("GET /api/breakdown", gzipMiddleware(http.HandlerFunc(api.Breakdown)))
+	mux.Handle("GET /api/drilldown", gzipMiddleware(http.HandlerFunc(api.DrillDown)))
+	if api.Profiler != nil {
+		mux.Handle("GET /api/data/profile", gzipMiddleware(http.HandlerFunc(api.DataProfile)))
+	}
+	if api.Configs != nil {
+		mux.Handle("GET /api/config/effective", gzipMiddleware(http.HandlerFunc(api.EffectiveConfig)))
+		mux.Handle("GET /api/config/dashboard", gzipMiddleware(http.HandlerFunc(api.DashboardConfig)))
+	}
+
+	// Live ingestion; not compressed, as it manages its own deadlines
+	if api.Ingester != nil {
+		mux.Handle("POST /api/ingest", http.HandlerFunc(api.Ingest))
+		if api.Ingester.Jobs != nil {
+			mux.Handle("POST /api/jobs", http.HandlerFunc(api.SubmitJob))
+			mux.Handle("GET /api/jobs", http.HandlerFunc(api.ListJobs))
+			mux.Handle("GET /api/jobs/{id}", http.HandlerFunc(api.GetJob))
+			mux.Handle("DELETE /api/jobs/{id}", http.HandlerFunc(api.CancelJob))
+		}
+	}
 
 	// Serve static frontend if needed
 	fs := http.FileServer(http.Dir(staticDir))
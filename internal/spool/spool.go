@@ -0,0 +1,119 @@
+// Package spool keeps a copy of every file ingested while the server runs,
+// so that the files can be processed again when the transformation config
+// changes. Merging an ingested file and rebuilding from the copies are
+// serialized, so a rebuild never drops a file merged while it ran.
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Source is an ingested file kept by the spool
+type Source struct {
+	Name string // upload name, which carries the format as its extension
+	Path string // copy of the content
+}
+
+// Spool stores the ingested files in a directory. A nil *Spool keeps
+// nothing: Tee passes readers through and Commit only merges.
+type Spool struct {
+	dir string
+
+	// mu is held while a file is merged and for a whole Rebuild
+	mu      sync.Mutex
+	sources []Source
+}
+
+// New creates a spool that stores its copies in dir, or in a new temporary
+// directory when dir is empty
+func New(dir string) (*Spool, error) {
+	if dir == "" {
+		temp, err := os.MkdirTemp("", "abt-ingest-spool-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create spool directory: %w", err)
+		}
+		dir = temp
+	} else if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	return &Spool{dir: dir}, nil
+}
+
+// Dir returns the directory holding the copies
+func (s *Spool) Dir() string {
+	if s == nil {
+		return ""
+	}
+	return s.dir
+}
+
+// Copy is a file being copied into the spool by the reader Tee returns
+type Copy struct {
+	file   *os.File
+	reader io.Reader
+}
+
+// Tee returns a reader that copies what is read from reader into the spool.
+// The copy is kept by Commit or removed by Discard.
+func (s *Spool) Tee(reader io.Reader) (io.Reader, *Copy, error) {
+	if s == nil {
+		return reader, nil, nil
+	}
+	file, err := os.CreateTemp(s.dir, "source-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to spool upload: %w", err)
+	}
+	c := &Copy{file: file, reader: io.TeeReader(reader, file)}
+	return c.reader, c, nil
+}
+
+// Commit finishes the copy of the file named name and runs merge, which
+// folds the file into the aggregates. The file is kept for later rebuilds.
+func (s *Spool) Commit(name string, c *Copy, merge func()) error {
+	if s == nil || c == nil {
+		merge()
+		return nil
+	}
+
+	// Read what the pipeline left unread, so that the copy is complete
+	_, copyErr := io.Copy(io.Discard, c.reader)
+	closeErr := c.file.Close()
+	path := c.file.Name()
+	c.file = nil
+	if err := errors.Join(copyErr, closeErr); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to spool %s: %w", name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sources = append(s.sources, Source{Name: name, Path: path})
+	merge()
+	return nil
+}
+
+// Discard removes a copy that was not committed. It does nothing after
+// Commit, so it can be deferred.
+func (s *Spool) Discard(c *Copy) {
+	if c == nil || c.file == nil {
+		return
+	}
+	c.file.Close()
+	os.Remove(c.file.Name())
+	c.file = nil
+}
+
+// Rebuild calls fn with the files committed so far. Commit waits until fn
+// returns, so no file is merged into aggregates that fn is about to replace.
+func (s *Spool) Rebuild(fn func(sources []Source) error) error {
+	if s == nil {
+		return fn(nil)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn(append([]Source(nil), s.sources...))
+}
@@ -0,0 +1,102 @@
+package spool
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSpool_CommitAndDiscard(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only part of the upload is read before Commit; the copy is complete
+	reader, kept, err := s.Tee(strings.NewReader("id\n1\n2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadFull(reader, make([]byte, 3))
+	merged := false
+	if err := s.Commit("kept.csv", kept, func() { merged = true }); err != nil || !merged {
+		t.Fatalf("commit: got %v, merged %v", err, merged)
+	}
+	s.Discard(kept) // no effect after Commit
+
+	_, dropped, err := s.Tee(strings.NewReader("id\n3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Discard(dropped)
+
+	var sources []Source
+	s.Rebuild(func(committed []Source) error {
+		sources = committed
+		return nil
+	})
+	if len(sources) != 1 || sources[0].Name != "kept.csv" {
+		t.Fatalf("sources: got %+v", sources)
+	}
+	if content, err := os.ReadFile(sources[0].Path); err != nil || string(content) != "id\n1\n2\n" {
+		t.Errorf("copy: got %q, %v", content, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("spool directory: got %d files, want 1", len(entries))
+	}
+}
+
+func TestSpool_CommitWaitsForRebuild(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var merged atomic.Bool
+	done := make(chan error)
+	s.Rebuild(func([]Source) error {
+		reader, c, err := s.Tee(strings.NewReader("id\n1\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			io.ReadAll(reader)
+			done <- s.Commit("late.csv", c, func() { merged.Store(true) })
+		}()
+		time.Sleep(20 * time.Millisecond)
+		if merged.Load() {
+			t.Error("merged while rebuilding")
+		}
+		return nil
+	})
+	if err := <-done; err != nil || !merged.Load() {
+		t.Fatalf("commit after rebuild: got %v, merged %v", err, merged.Load())
+	}
+
+	var sources []Source
+	s.Rebuild(func(committed []Source) error {
+		sources = committed
+		return nil
+	})
+	if len(sources) != 1 || sources[0].Name != "late.csv" {
+		t.Errorf("sources: got %+v", sources)
+	}
+}
+
+func TestSpool_Nil(t *testing.T) {
+	var s *Spool
+	reader := strings.NewReader("id\n")
+	got, c, err := s.Tee(reader)
+	if err != nil || got != reader || c != nil {
+		t.Fatalf("tee: got %v, %v, %v", got, c, err)
+	}
+	merged := false
+	if err := s.Commit("upload.csv", c, func() { merged = true }); err != nil || !merged {
+		t.Errorf("commit: got %v, merged %v", err, merged)
+	}
+	s.Discard(c)
+}
@@ -0,0 +1,224 @@
+package transform
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// standardFields are the Transaction fields that source columns map onto
+var standardFields = []string{
+	"transaction_id", "country", "region", "product_name",
+	"price", "quantity", "transaction_date", "currency", "timezone",
+}
+
+// requiredFields must be covered by the column mappings. The remaining
+// standard fields fall back to configured defaults when absent.
+var requiredFields = []string{"transaction_id", "product_name", "price", "transaction_date"}
+
+// ColumnMapping lists where a standard field is read from.
+//
+// Aliases are compared case-insensitively with CSV, TSV and XLSX headers and
+// with the keys of JSON, YAML and XML records, in order; the first one present
+// wins. An alias containing "." is a path into nested JSON or YAML objects,
+// e.g. "amount.value". Patterns are regular expressions tried after the
+// aliases against the header or key as written; use (?i) to ignore case.
+type ColumnMapping struct {
+	Aliases  []string `json:"aliases" yaml:"aliases"`
+	Patterns []string `json:"patterns,omitempty" yaml:"patterns,omitempty"`
+}
+
+// DefaultColumnMappings returns the built-in aliases used when the
+// configuration has no column_mappings section
+func DefaultColumnMappings() map[string]ColumnMapping {
+	return map[string]ColumnMapping{
+		"transaction_id":   {Aliases: []string{"transaction_id", "id", "trans_id", "txn_id", "transaction", "order_id"}},
+		"country":          {Aliases: []string{"country", "nation", "country_name", "country_code"}},
+		"region":           {Aliases: []string{"region", "state", "province", "area", "zone", "territory"}},
+		"product_name":     {Aliases: []string{"product_name", "product", "item", "item_name", "product_title"}},
+		"price":            {Aliases: []string{"price", "unit_price", "cost", "amount", "unit_cost", "price_per_unit"}},
+		"quantity":         {Aliases: []string{"quantity", "qty", "amount", "count", "units", "number"}},
+		"transaction_date": {Aliases: []string{"transaction_date", "date", "timestamp", "time", "tx_date", "order_date"}},
+		"currency":         {Aliases: []string{"currency", "currency_code", "ccy"}},
+		"timezone":         {Aliases: []string{"timezone", "time_zone", "tz"}},
+	}
+}
+
+// validateColumnMappings checks that every key is a standard field, that all
+// patterns compile and that every required field can be matched
+func validateColumnMappings(mappings map[string]ColumnMapping) error {
+	return validateFieldMappings("column_mappings", mappings, standardFields, requiredFields)
+}
+
+// validateFieldMappings checks mappings onto the given fields; section names
+// the mappings in errors
+func validateFieldMappings(section string, mappings map[string]ColumnMapping, fields, required []string) error {
+	for field, mapping := range mappings {
+		if !containsField(fields, field) {
+			return fmt.Errorf("%s: unknown field %q (expected one of %s)",
+				section, field, strings.Join(fields, ", "))
+		}
+		for _, pattern := range mapping.Patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("%s.%s: invalid pattern %q: %w", section, field, pattern, err)
+			}
+		}
+	}
+
+	for _, field := range required {
+		mapping := mappings[field]
+		covered := len(mapping.Patterns) > 0
+		for _, alias := range mapping.Aliases {
+			if strings.TrimSpace(alias) != "" {
+				covered = true
+			}
+		}
+		if !covered {
+			return fmt.Errorf("%s: required field %q has no aliases or patterns", section, field)
+		}
+	}
+
+	return nil
+}
+
+func containsField(fields []string, field string) bool {
+	for _, name := range fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldMatcher is a compiled ColumnMapping
+type fieldMatcher struct {
+	aliases  []string
+	patterns []*regexp.Regexp
+}
+
+// compileColumnMappings prepares mappings for matching. Configured XML field
+// paths take precedence over the aliases of their field. Invalid patterns are
+// ignored here; validateColumnMappings reports them at load time.
+func compileColumnMappings(mappings map[string]ColumnMapping, xmlPaths map[string]string) map[string]fieldMatcher {
+	if len(mappings) == 0 {
+		mappings = DefaultColumnMappings()
+	}
+
+	matchers := make(map[string]fieldMatcher, len(mappings))
+	for field, mapping := range mappings {
+		var m fieldMatcher
+		if path := strings.Trim(xmlPaths[field], "/"); path != "" {
+			m.aliases = append(m.aliases, path)
+		}
+		for _, alias := range mapping.Aliases {
+			if alias = strings.TrimSpace(alias); alias != "" {
+				m.aliases = append(m.aliases, alias)
+			}
+		}
+		for _, pattern := range mapping.Patterns {
+			if re, err := regexp.Compile(pattern); err == nil {
+				m.patterns = append(m.patterns, re)
+			}
+		}
+		matchers[field] = m
+	}
+	for field, path := range xmlPaths {
+		if _, ok := matchers[field]; !ok && strings.Trim(path, "/") != "" {
+			matchers[field] = fieldMatcher{aliases: []string{strings.Trim(path, "/")}}
+		}
+	}
+	return matchers
+}
+
+// matchColumn returns the index of the header column the field is read from,
+// or -1
+func (m fieldMatcher) matchColumn(header []string) int {
+	for _, alias := range m.aliases {
+		for i, col := range header {
+			if strings.EqualFold(strings.TrimSpace(col), alias) {
+				return i
+			}
+		}
+	}
+	for _, re := range m.patterns {
+		for i, col := range header {
+			if re.MatchString(strings.TrimSpace(col)) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// lookup returns the field's value in a decoded record
+func (m fieldMatcher) lookup(data map[string]interface{}) (interface{}, bool) {
+	// Exact keys first, as records usually use one spelling consistently
+	for _, alias := range m.aliases {
+		if val, ok := lookupPath(data, alias, false); ok {
+			return val, true
+		}
+	}
+	for _, alias := range m.aliases {
+		if val, ok := lookupPath(data, alias, true); ok {
+			return val, true
+		}
+	}
+
+	// Patterns match top-level keys; the first matching key in sorted
+	// order is used so that results do not depend on map iteration
+	for _, re := range m.patterns {
+		var matched []string
+		for key := range data {
+			if re.MatchString(key) {
+				matched = append(matched, key)
+			}
+		}
+		if len(matched) > 0 {
+			sort.Strings(matched)
+			return data[matched[0]], true
+		}
+	}
+	return nil, false
+}
+
+// lookupPath resolves a "."-separated path through nested objects. Keys are
+// first tried as a whole, so flat keys containing dots still match.
+func lookupPath(data map[string]interface{}, path string, foldCase bool) (interface{}, bool) {
+	if val, ok := lookupKey(data, path, foldCase); ok {
+		return val, true
+	}
+
+	segments := strings.Split(path, ".")
+	if len(segments) == 1 {
+		return nil, false
+	}
+
+	current := data
+	for i, segment := range segments {
+		val, ok := lookupKey(current, segment, foldCase)
+		if !ok {
+			return nil, false
+		}
+		if i == len(segments)-1 {
+			return val, true
+		}
+		if current, ok = toStringMap(val); !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+func lookupKey(data map[string]interface{}, key string, foldCase bool) (interface{}, bool) {
+	if !foldCase {
+		val, ok := data[key]
+		return val, ok
+	}
+	for k, val := range data {
+		if strings.EqualFold(k, key) {
+			return val, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,212 @@
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressionKind identifies a compressed container by its magic bytes
+type compressionKind string
+
+const (
+	compressionNone compressionKind = ""
+	compressionGzip compressionKind = "gzip"
+	compressionZstd compressionKind = "zstd"
+	compressionZip  compressionKind = "zip"
+
+	// sniffSize is how much of a source is inspected to detect compression
+	// and format
+	sniffSize = 1024
+
+	// lineSniffSize caps how far a source that starts with a JSON object is
+	// inspected for the end of its first line, which tells JSON Lines from a
+	// JSON document
+	lineSniffSize = 1 << 20
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+// detectCompression inspects the leading bytes of a source
+func detectCompression(header []byte) compressionKind {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return compressionGzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return compressionZstd
+	case bytes.HasPrefix(header, zipMagic):
+		return compressionZip
+	}
+	return compressionNone
+}
+
+// trimCompressionExt drops a compression suffix so that the inner file's
+// extension can still drive format detection, e.g. sales.csv.gz -> sales.csv
+func trimCompressionExt(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz", ".gzip", ".zst", ".zstd":
+		return strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name
+}
+
+// sourceConsumer reads the data files processSource finds in a source:
+// streamRun for ingestion, profileRun for profiling
+type sourceConsumer interface {
+	consume(reader io.Reader, format DataFormat) error
+	beginMember(name string, format DataFormat)
+	endMember() error
+}
+
+// processSource detects compression and format of a named source and streams
+// it through the run. Compressed sources are unwrapped recursively and every
+// file of a zip archive is processed as a member of the same dataset.
+func (fdh *FlexibleDataHandler) processSource(run sourceConsumer, name string, reader io.Reader, inArchive bool) error {
+	// Remember where a file starts so it can be handed on unbuffered,
+	// which zip archives and workbooks need for random access
+	file, isFile := reader.(*os.File)
+	var fileStart int64
+	if isFile {
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			isFile = false
+		}
+		fileStart = offset
+	}
+
+	buffered := bufio.NewReaderSize(reader, 4*sniffSize)
+	header, err := buffered.Peek(sniffSize)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if buffered, header, err = peekFirstLine(buffered, header); err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	source := io.Reader(buffered)
+	if isFile {
+		if _, err := file.Seek(fileStart, io.SeekStart); err == nil {
+			source = file
+		}
+	}
+
+	switch detectCompression(header) {
+	case compressionGzip:
+		gz, err := gzip.NewReader(source)
+		if err != nil {
+			return fmt.Errorf("failed to open gzip stream %s: %w", name, err)
+		}
+		defer gz.Close()
+		return fdh.processSource(run, trimCompressionExt(name), gz, inArchive)
+
+	case compressionZstd:
+		zr, err := zstd.NewReader(source, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return fmt.Errorf("failed to open zstd stream %s: %w", name, err)
+		}
+		defer zr.Close()
+		return fdh.processSource(run, trimCompressionExt(name), zr, inArchive)
+
+	case compressionZip:
+		if !isXLSXData(header) && strings.ToLower(filepath.Ext(name)) != ".xlsx" {
+			return fdh.processArchive(run, name, source)
+		}
+	}
+
+	format := fdh.detectFileFormat(name, header)
+	log.Printf("Processing %s with detected format: %s", name, format)
+
+	if !inArchive {
+		return run.consume(source, format)
+	}
+
+	run.beginMember(name, format)
+	if err := run.consume(source, format); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return run.endMember()
+}
+
+// peekFirstLine extends header, the start of buffered, until it holds the
+// first line and the start of the next when it starts with a JSON object, so
+// that JSON Lines with records longer than the sample are not taken for one
+// JSON document. At most lineSniffSize bytes are peeked, through a larger
+// buffer wrapping buffered.
+func peekFirstLine(buffered *bufio.Reader, header []byte) (*bufio.Reader, []byte, error) {
+	if len(header) < sniffSize || !bytes.HasPrefix(bytes.TrimSpace(header), []byte("{")) || hasSecondLine(header) {
+		return buffered, header, nil
+	}
+
+	buffered = bufio.NewReaderSize(buffered, lineSniffSize)
+	for n := len(header); n < lineSniffSize && !hasSecondLine(header); {
+		n = min(2*n, lineSniffSize)
+		var err error
+		header, err = buffered.Peek(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return buffered, header, nil
+}
+
+// hasSecondLine reports whether data holds a non-blank line after its first
+func hasSecondLine(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	i := bytes.IndexByte(data, '\n')
+	return i >= 0 && len(bytes.TrimSpace(data[i:])) > 0
+}
+
+// processArchive processes every data file of a zip archive as part of one
+// dataset. Directories and OS metadata entries are ignored, and so are files
+// whose extension, after any compression suffix, is not a data format, such
+// as a README.
+func (fdh *FlexibleDataHandler) processArchive(run sourceConsumer, name string, reader io.Reader) error {
+	archive, err := openZipArchive(reader)
+	if err != nil {
+		return fmt.Errorf("failed to open archive %s: %w", name, err)
+	}
+
+	processed := 0
+	for _, f := range archive.File {
+		base := path.Base(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") || strings.HasPrefix(base, ".") {
+			continue
+		}
+		if _, ok := formatFromExtension(trimCompressionExt(base)); !ok {
+			log.Printf("Skipping %s in %s: not a data file", f.Name, name)
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open %s in %s: %w", f.Name, name, err)
+		}
+		err = fdh.processSource(run, name+"/"+f.Name, rc, true)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		processed++
+	}
+
+	if processed == 0 {
+		return fmt.Errorf("archive %s contains no data files", name)
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+package transform
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Configuration layers, from lowest to highest precedence. A layer overrides
+// the settings it names and leaves the others to the layers below it.
+const (
+	LayerDefault     = "default"     // built-in defaults
+	LayerBase        = "base"        // the config file
+	LayerEnvironment = "environment" // the environment file
+	LayerVariable    = "variable"    // an ABT_* environment variable
+	LayerFlag        = "flag"        // a -set flag
+)
+
+// ConfigEnvPrefix starts the names of the environment variables that
+// override settings
+const ConfigEnvPrefix = "ABT_"
+
+// ConfigLayers selects what is layered over the config file
+type ConfigLayers struct {
+	// Environment selects the environment file, which sits next to the
+	// config file with the environment before the extension, such as
+	// data_transformation.production.yaml. The file must exist.
+	Environment string
+
+	// Variables are environment variables as "NAME=value", such as
+	// os.Environ returns. ABT_PERFORMANCE__BATCH_SIZE=5000 sets
+	// performance.batch_size: the name is lower-cased and its segments are
+	// separated by double underscores. Variables whose first segment is not
+	// a config section are ignored and listed in
+	// EffectiveConfig.IgnoredVariables, except ABT_ENV, which selects the
+	// environment.
+	Variables []string
+
+	// Overrides are "path=value" settings, such as
+	// "transformation.currency.reporting_currency=EUR"
+	Overrides []string
+}
+
+// EffectiveConfig lists every setting of a loaded configuration with the
+// layer that set it
+type EffectiveConfig struct {
+	Environment string          `json:"environment,omitempty"`
+	Layers      []ConfigSource  `json:"layers"` // applied, lowest first
+	Settings    []ConfigSetting `json:"settings"`
+
+	// IgnoredVariables are the ABT_* variables that name no config section
+	IgnoredVariables []string `json:"ignored_variables,omitempty"`
+}
+
+// ConfigSource is a layer and the file, environment variable or flag it
+// was read from
+type ConfigSource struct {
+	Layer  string `json:"layer"`
+	Source string `json:"source,omitempty"`
+}
+
+// ConfigSetting is the final value of a setting, by its dotted path. Lists
+// are one setting; maps and sections are listed key by key.
+type ConfigSetting struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+	ConfigSource
+}
+
+// configLayer is a parsed layer: a partial configuration document
+type configLayer struct {
+	source   ConfigSource
+	settings map[interface{}]interface{}
+}
+
+// LoadLayeredConfig loads the configuration from the config file with the
+// environment file, environment variables and overrides layered over it, in
+// that order. Without a config file the built-in defaults are the bottom
+// layer. Maps merge key by key, while lists and values replace the ones
+// below them. The merged configuration is validated like a config file.
+func (cl *ConfigLoader) LoadLayeredConfig(layers ConfigLayers) (TransformConfig, *EffectiveConfig, error) {
+	var applied []configLayer
+
+	if _, err := os.Stat(cl.configPath); os.IsNotExist(err) {
+		defaults, err := yaml.Marshal(newConfigFile(cl.getDefaultConfig()))
+		if err != nil {
+			return TransformConfig{}, nil, fmt.Errorf("failed to marshal default config: %w", err)
+		}
+		layer, err := parseConfigLayer(defaults, ConfigSource{Layer: LayerDefault})
+		if err != nil {
+			return TransformConfig{}, nil, err
+		}
+		applied = append(applied, layer)
+	} else {
+		layer, err := readConfigLayer(cl.configPath, LayerBase)
+		if err != nil {
+			return TransformConfig{}, nil, err
+		}
+		applied = append(applied, layer)
+	}
+
+	if layers.Environment != "" {
+		if strings.ContainsAny(layers.Environment, `/\`) {
+			return TransformConfig{}, nil, fmt.Errorf("invalid environment name %q", layers.Environment)
+		}
+		layer, err := readConfigLayer(cl.EnvironmentConfigPath(layers.Environment), LayerEnvironment)
+		if err != nil {
+			return TransformConfig{}, nil, fmt.Errorf("environment %s: %w", layers.Environment, err)
+		}
+		applied = append(applied, layer)
+	}
+
+	// Sort the variables so that the layers do not depend on the order of
+	// the environment
+	variables := append([]string(nil), layers.Variables...)
+	sort.Strings(variables)
+	var ignored []string
+	for _, variable := range variables {
+		name, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(name, ConfigEnvPrefix) {
+			continue
+		}
+		path := strings.Split(strings.ToLower(strings.TrimPrefix(name, ConfigEnvPrefix)), "__")
+		if _, err := settingType(path[:1]); err != nil {
+			if name != ConfigEnvPrefix+"ENV" {
+				ignored = append(ignored, name)
+			}
+			continue
+		}
+		layer, err := overrideLayer(path, value, ConfigSource{Layer: LayerVariable, Source: name})
+		if err != nil {
+			return TransformConfig{}, nil, fmt.Errorf("environment variable %s: %w", name, err)
+		}
+		applied = append(applied, layer)
+	}
+
+	for _, override := range layers.Overrides {
+		key, value, ok := strings.Cut(override, "=")
+		if !ok || key == "" {
+			return TransformConfig{}, nil, fmt.Errorf("invalid override %q: want path=value", override)
+		}
+		layer, err := overrideLayer(strings.Split(key, "."), value, ConfigSource{Layer: LayerFlag, Source: "-set " + override})
+		if err != nil {
+			return TransformConfig{}, nil, fmt.Errorf("override %s: %w", key, err)
+		}
+		applied = append(applied, layer)
+	}
+
+	merged := make(map[interface{}]interface{})
+	sources := make([]ConfigSource, len(applied))
+	names := make([]string, 0, len(applied))
+	for i, layer := range applied {
+		mergeSettings(merged, layer.settings)
+		sources[i] = layer.source
+		if layer.source.Source != "" {
+			names = append(names, layer.source.Source)
+		}
+	}
+	configData, err := yaml.Marshal(merged)
+	if err != nil {
+		return TransformConfig{}, nil, fmt.Errorf("failed to marshal merged config: %w", err)
+	}
+
+	// Errors name every layer, as any of them may have set the culprit
+	name := strings.Join(names, " + ")
+	if name == "" {
+		name = "defaults"
+	}
+	config, err := cl.parseConfig(configData, name)
+	if err != nil {
+		return TransformConfig{}, nil, err
+	}
+
+	effective := &EffectiveConfig{Environment: layers.Environment, Layers: sources, IgnoredVariables: ignored}
+	effective.Settings, err = describeSettings(config, merged, applied)
+	if err != nil {
+		return TransformConfig{}, nil, err
+	}
+	return config, effective, nil
+}
+
+// readConfigLayer reads a config file as a layer
+func readConfigLayer(path, layer string) (configLayer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return configLayer{}, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return parseConfigLayer(data, ConfigSource{Layer: layer, Source: path})
+}
+
+func parseConfigLayer(data []byte, source ConfigSource) (configLayer, error) {
+	settings := make(map[interface{}]interface{})
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return configLayer{}, fmt.Errorf("failed to parse config file %s: %w", source.Source, err)
+	}
+	return configLayer{source: source, settings: settings}, nil
+}
+
+// overrideLayer builds the layer of a single setting. The value is YAML,
+// except for text settings, which take it as is so that values such as "NO"
+// or "0800" stay text.
+func overrideLayer(path []string, value string, source ConfigSource) (configLayer, error) {
+	t, err := settingType(path)
+	if err != nil {
+		return configLayer{}, err
+	}
+
+	var setting interface{} = value
+	if t.Kind() != reflect.String {
+		if err := yaml.Unmarshal([]byte(value), reflect.New(t).Interface()); err != nil {
+			return configLayer{}, fmt.Errorf("invalid value %q: %w", value, err)
+		}
+		if err := yaml.Unmarshal([]byte(value), &setting); err != nil {
+			return configLayer{}, fmt.Errorf("invalid value %q: %w", value, err)
+		}
+	}
+
+	settings := map[interface{}]interface{}{path[len(path)-1]: setting}
+	for i := len(path) - 2; i >= 0; i-- {
+		settings = map[interface{}]interface{}{path[i]: settings}
+	}
+	return configLayer{source: source, settings: settings}, nil
+}
+
+// settingType returns the type of the setting at path in a config file. Paths
+// the loader does not read are an error rather than silently ignored.
+func settingType(path []string) (reflect.Type, error) {
+	t := reflect.TypeOf(configFile{})
+	for i, segment := range path {
+		switch t.Kind() {
+		case reflect.Struct:
+			field, ok := yamlField(t, segment)
+			if !ok {
+				return nil, fmt.Errorf("unknown setting %s", strings.Join(path[:i+1], "."))
+			}
+			t = field.Type
+		case reflect.Map:
+			if segment == "" {
+				return nil, fmt.Errorf("empty key in setting %s", strings.Join(path, "."))
+			}
+			t = t.Elem()
+		default:
+			return nil, fmt.Errorf("setting %s has no %q key", strings.Join(path[:i], "."), segment)
+		}
+	}
+	return t, nil
+}
+
+// yamlField finds the struct field a YAML key decodes into
+func yamlField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name != "" && name != "-" && name == key {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// mergeSettings merges src into dst: maps merge key by key and anything
+// else replaces what dst has. Maps from src are copied so that the layers
+// stay as they were read.
+func mergeSettings(dst, src map[interface{}]interface{}) {
+	for key, value := range src {
+		if srcMap, ok := value.(map[interface{}]interface{}); ok {
+			dstMap, ok := dst[key].(map[interface{}]interface{})
+			if !ok {
+				dstMap = make(map[interface{}]interface{})
+				dst[key] = dstMap
+			}
+			mergeSettings(dstMap, srcMap)
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+// lookupSetting finds the value a document gives the setting at path. A
+// value or list above path counts, as it replaces everything below it.
+func lookupSetting(settings map[interface{}]interface{}, path []string) (interface{}, bool) {
+	var node interface{} = settings
+	for _, segment := range path {
+		m, ok := node.(map[interface{}]interface{})
+		if !ok {
+			return node, true
+		}
+		found := false
+		for key, value := range m {
+			if fmt.Sprint(key) == segment {
+				node, found = value, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// describeSettings lists the settings of a loaded configuration. A setting
+// comes from the highest layer that names it, unless defaults applied after
+// merging changed its value.
+func describeSettings(config TransformConfig, merged map[interface{}]interface{}, layers []configLayer) ([]ConfigSetting, error) {
+	data, err := yaml.Marshal(newConfigFile(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	final := make(map[interface{}]interface{})
+	if err := yaml.Unmarshal(data, &final); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	var settings []ConfigSetting
+	var visit func(path []string, value interface{})
+	visit = func(path []string, value interface{}) {
+		if m, ok := value.(map[interface{}]interface{}); ok && len(m) > 0 {
+			keys := make([]string, 0, len(m))
+			values := make(map[string]interface{}, len(m))
+			for key, value := range m {
+				keys = append(keys, fmt.Sprint(key))
+				values[fmt.Sprint(key)] = value
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				visit(append(path[:len(path):len(path)], key), values[key])
+			}
+			return
+		}
+
+		setting := ConfigSetting{
+			Key:          strings.Join(path, "."),
+			Value:        jsonValue(value),
+			ConfigSource: ConfigSource{Layer: LayerDefault},
+		}
+		if set, ok := lookupSetting(merged, path); ok && sameSetting(set, value) {
+			for i := len(layers) - 1; i >= 0; i-- {
+				if _, ok := lookupSetting(layers[i].settings, path); ok {
+					setting.ConfigSource = layers[i].source
+					break
+				}
+			}
+		}
+		settings = append(settings, setting)
+	}
+	visit(nil, final)
+	return settings, nil
+}
+
+// sameSetting reports whether two decoded values are written the same way
+func sameSetting(a, b interface{}) bool {
+	aData, aErr := yaml.Marshal(a)
+	bData, bErr := yaml.Marshal(b)
+	return aErr == nil && bErr == nil && bytes.Equal(aData, bData)
+}
+
+// jsonValue converts a decoded YAML value into one encoding/json can
+// encode, which has no map[interface{}]interface{}
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonValue(value)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, value := range v {
+			list[i] = jsonValue(value)
+		}
+		return list
+	}
+	return value
+}
@@ -0,0 +1,141 @@
+package transform
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadLayeredConfig(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "transform.yaml")
+	os.WriteFile(configPath, []byte(`transformation:
+  enable_validation: true
+  enable_optimization: true
+  defaults:
+    country: Canada
+    price_multiplier: 100
+  custom_mappings:
+    deutschland: Germany
+  currency:
+    rounding: half_even
+performance:
+  batch_size: 500
+monitoring:
+  enabled: true
+`), 0o644)
+	// The environment file only names what it changes
+	os.WriteFile(filepath.Join(dir, "transform.staging.yaml"), []byte(`transformation:
+  defaults:
+    country: Mexico
+  custom_mappings:
+    allemagne: Germany
+performance:
+  batch_size: 1000
+`), 0o644)
+
+	config, effective, err := NewConfigLoader(configPath).LoadLayeredConfig(ConfigLayers{
+		Environment: "staging",
+		Variables: []string{
+			"ABT_PERFORMANCE__BATCH_SIZE=2000",
+			"ABT_TRANSFORMATION__DEFAULTS__REGION=NO",
+			"ABT_ENV=staging",
+			"ABT_LOG_LEVEL=debug",
+			"HOME=/root",
+		},
+		Overrides: []string{"performance.batch_size=3000"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(effective.IgnoredVariables) != 1 || effective.IgnoredVariables[0] != "ABT_LOG_LEVEL" {
+		t.Errorf("ignored variables: got %v", effective.IgnoredVariables)
+	}
+
+	if !config.EnableValidation || !config.EnableOptimization {
+		t.Errorf("the environment file turned off flags it does not name: %+v", config)
+	}
+	if config.DefaultCountry != "Mexico" || config.DefaultRegion != "NO" || config.BatchSize != 3000 ||
+		config.Currency.Rounding != "half_even" {
+		t.Errorf("got country %q, region %q, batch size %d, rounding %q",
+			config.DefaultCountry, config.DefaultRegion, config.BatchSize, config.Currency.Rounding)
+	}
+	if config.CustomMappings["deutschland"] != "Germany" || config.CustomMappings["allemagne"] != "Germany" {
+		t.Errorf("custom mappings were not merged: %v", config.CustomMappings)
+	}
+
+	if len(effective.Layers) != 5 || effective.Layers[1].Source != filepath.Join(dir, "transform.staging.yaml") {
+		t.Errorf("got layers %+v", effective.Layers)
+	}
+	settings := make(map[string]ConfigSetting)
+	for _, setting := range effective.Settings {
+		settings[setting.Key] = setting
+	}
+	for key, want := range map[string]ConfigSource{
+		"transformation.enable_validation":             {Layer: LayerBase, Source: configPath},
+		"transformation.custom_mappings.deutschland":   {Layer: LayerBase, Source: configPath},
+		"transformation.custom_mappings.allemagne":     {Layer: LayerEnvironment, Source: filepath.Join(dir, "transform.staging.yaml")},
+		"transformation.custom_mappings.usa":           {Layer: LayerDefault},
+		"transformation.defaults.country":              {Layer: LayerEnvironment, Source: filepath.Join(dir, "transform.staging.yaml")},
+		"transformation.defaults.region":               {Layer: LayerVariable, Source: "ABT_TRANSFORMATION__DEFAULTS__REGION"},
+		"transformation.date_formats":                  {Layer: LayerDefault},
+		"performance.batch_size":                       {Layer: LayerFlag, Source: "-set performance.batch_size=3000"},
+		"transformation.currency.rounding":             {Layer: LayerBase, Source: configPath},
+		"transformation.defaults.price_multiplier":     {Layer: LayerBase, Source: configPath},
+		"transformation.currency.reporting_currency":   {Layer: LayerDefault},
+		"error_handling.quarantine.format":             {Layer: LayerDefault},
+		"transformation.column_mappings.price.aliases": {Layer: LayerDefault},
+	} {
+		if got, ok := settings[key]; !ok || got.ConfigSource != want {
+			t.Errorf("%s: got %+v, want %+v", key, got, want)
+		}
+	}
+	if value := settings["performance.batch_size"].Value; value != 3000 {
+		t.Errorf("batch size: got %v (%T)", value, value)
+	}
+	if _, ok := settings["monitoring.enabled"]; ok {
+		t.Errorf("sections the loader does not read are listed")
+	}
+
+	// Without a config file the defaults are the bottom layer
+	config, effective, err = NewConfigLoader(filepath.Join(dir, "missing.yaml")).LoadLayeredConfig(ConfigLayers{
+		Overrides: []string{"transformation.enable_validation=false"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.EnableValidation || !config.EnableOptimization || len(config.NullValues) == 0 ||
+		effective.Layers[0].Layer != LayerDefault {
+		t.Errorf("got %+v with layers %+v", config, effective.Layers)
+	}
+}
+
+func TestLoadLayeredConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "transform.yaml")
+	os.WriteFile(configPath, []byte("performance:\n  batch_size: 500\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "transform.broken.yaml"), []byte("performance: [\n"), 0o644)
+
+	loader := NewConfigLoader(configPath)
+	for name, layers := range map[string]ConfigLayers{
+		"unknown setting":      {Overrides: []string{"transformation.no_such_setting=1"}},
+		"missing value":        {Overrides: []string{"performance.batch_size"}},
+		"wrong type":           {Variables: []string{"ABT_PERFORMANCE__BATCH_SIZE=lots"}},
+		"unknown variable key": {Variables: []string{"ABT_TRANSFORMATION__NO_SUCH_SETTING=1"}},
+		"invalid value":        {Overrides: []string{"transformation.currency.rounding=sideways"}},
+		"broken env file":      {Environment: "broken"},
+		"missing env file":     {Environment: "production"},
+		"env outside config":   {Environment: "../broken"},
+	} {
+		if _, _, err := loader.LoadLayeredConfig(layers); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// Errors name the layers
+	_, _, err := loader.LoadLayeredConfig(ConfigLayers{Overrides: []string{"transformation.timezone.reporting=Mars/Base"}})
+	if err == nil || !strings.Contains(err.Error(), "-set transformation.timezone.reporting=Mars/Base") {
+		t.Errorf("got %v", err)
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"path/filepath"
 	"strings"
 
+	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/quarantine"
+
 	"gopkg.in/yaml.v2"
 )
 
@@ -37,24 +40,79 @@ func (cl *ConfigLoader) LoadConfig() (TransformConfig, error) {
 		return TransformConfig{}, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	// Parse YAML configuration
-	var yamlConfig struct {
-		Transformation struct {
-			EnableValidation   bool     `yaml:"enable_validation"`
-			EnableOptimization bool     `yaml:"enable_optimization"`
-			DateFormats        []string `yaml:"date_formats"`
-			CurrencyFormats    []string `yaml:"currency_formats"`
-			NullValues         []string `yaml:"null_values"`
-			Defaults           struct {
-				Country         string  `yaml:"country"`
-				Region          string  `yaml:"region"`
-				PriceMultiplier float64 `yaml:"price_multiplier"`
-			} `yaml:"defaults"`
-			CustomMappings map[string]string `yaml:"custom_mappings"`
-			DataTypes      map[string]string `yaml:"data_types"`
-		} `yaml:"transformation"`
-	}
+	return cl.parseConfig(configData, cl.configPath)
+}
+
+// configFile is the layout of a configuration file. Sections the loader does
+// not use, such as monitoring, are ignored.
+type configFile struct {
+	Transformation struct {
+		EnableValidation   bool     `yaml:"enable_validation"`
+		EnableOptimization bool     `yaml:"enable_optimization"`
+		DateFormats        []string `yaml:"date_formats"`
+		CurrencyFormats    []string `yaml:"currency_formats"`
+		NullValues         []string `yaml:"null_values"`
+		Defaults           struct {
+			Country         string  `yaml:"country"`
+			Region          string  `yaml:"region"`
+			PriceMultiplier float64 `yaml:"price_multiplier"`
+		} `yaml:"defaults"`
+		CustomMappings map[string]string        `yaml:"custom_mappings"`
+		DataTypes      map[string]string        `yaml:"data_types"`
+		XML            XMLConfig                `yaml:"xml"`
+		XLSX           XLSXConfig               `yaml:"xlsx"`
+		ColumnMappings map[string]ColumnMapping `yaml:"column_mappings"`
+		Currency       CurrencyConfig           `yaml:"currency"`
+		Timezone       TimezoneConfig           `yaml:"timezone"`
+		DerivedFields  []DerivedField           `yaml:"derived_fields"`
+		ProductAliases string                   `yaml:"product_aliases_file"`
+		Geography      GeographyConfig          `yaml:"geography"`
+		Inventory      InventoryConfig          `yaml:"inventory"`
+	} `yaml:"transformation"`
+	ErrorHandling struct {
+		Quarantine QuarantineConfig `yaml:"quarantine"`
+	} `yaml:"error_handling"`
+	Performance struct {
+		BatchSize int `yaml:"batch_size"`
+	} `yaml:"performance"`
+	Pipeline []PipelineStage `yaml:"pipeline"`
+	Rules    []Rule          `yaml:"rules"`
+}
+
+// newConfigFile lays out a configuration as it is written to file
+func newConfigFile(config TransformConfig) configFile {
+	var yamlConfig configFile
+	yamlConfig.Transformation.EnableValidation = config.EnableValidation
+	yamlConfig.Transformation.EnableOptimization = config.EnableOptimization
+	yamlConfig.Transformation.DateFormats = config.DateFormats
+	yamlConfig.Transformation.CurrencyFormats = config.CurrencyFormats
+	yamlConfig.Transformation.NullValues = config.NullValues
+	yamlConfig.Transformation.Defaults.Country = config.DefaultCountry
+	yamlConfig.Transformation.Defaults.Region = config.DefaultRegion
+	yamlConfig.Transformation.Defaults.PriceMultiplier = config.PriceMultiplier
+	yamlConfig.Transformation.CustomMappings = config.CustomMappings
+	yamlConfig.Transformation.DataTypes = config.DataTypes
+	yamlConfig.Transformation.XML = config.XML
+	yamlConfig.Transformation.XLSX = config.XLSX
+	yamlConfig.ErrorHandling.Quarantine = config.Quarantine
+	yamlConfig.Transformation.ColumnMappings = config.ColumnMappings
+	yamlConfig.Transformation.Currency = config.Currency
+	yamlConfig.Transformation.Timezone = config.Timezone
+	yamlConfig.Transformation.DerivedFields = config.DerivedFields
+	yamlConfig.Transformation.ProductAliases = config.ProductAliasesFile
+	yamlConfig.Transformation.Geography = config.Geography
+	yamlConfig.Transformation.Inventory = config.Inventory
+	yamlConfig.Performance.BatchSize = config.BatchSize
+	yamlConfig.Pipeline = config.Pipeline
+	yamlConfig.Rules = config.Rules
+	return yamlConfig
+}
 
+// parseConfig builds a configuration from YAML: it validates it, loads the
+// reference files it names and applies defaults. The name identifies the
+// configuration in errors.
+func (cl *ConfigLoader) parseConfig(configData []byte, name string) (TransformConfig, error) {
+	var yamlConfig configFile
 	if err := yaml.Unmarshal(configData, &yamlConfig); err != nil {
 		return TransformConfig{}, fmt.Errorf("failed to parse config file: %w", err)
 	}
@@ -71,6 +129,81 @@ func (cl *ConfigLoader) LoadConfig() (TransformConfig, error) {
 		PriceMultiplier:    yamlConfig.Transformation.Defaults.PriceMultiplier,
 		CustomMappings:     yamlConfig.Transformation.CustomMappings,
 		DataTypes:          yamlConfig.Transformation.DataTypes,
+		BatchSize:          yamlConfig.Performance.BatchSize,
+		XML:                yamlConfig.Transformation.XML,
+		XLSX:               yamlConfig.Transformation.XLSX,
+		Quarantine:         yamlConfig.ErrorHandling.Quarantine,
+		ColumnMappings:     yamlConfig.Transformation.ColumnMappings,
+		Currency:           yamlConfig.Transformation.Currency,
+		Timezone:           yamlConfig.Transformation.Timezone,
+		Pipeline:           yamlConfig.Pipeline,
+		Rules:              yamlConfig.Rules,
+		DerivedFields:      yamlConfig.Transformation.DerivedFields,
+		ProductAliasesFile: yamlConfig.Transformation.ProductAliases,
+		Geography:          yamlConfig.Transformation.Geography,
+		Inventory:          yamlConfig.Transformation.Inventory,
+	}
+
+	// Column mappings decide whether any record can be read, so reject
+	// incomplete ones now rather than skipping every record later
+	if config.ColumnMappings != nil {
+		if err := validateColumnMappings(config.ColumnMappings); err != nil {
+			return TransformConfig{}, fmt.Errorf("invalid config file %s: %w", name, err)
+		}
+	}
+	if err := validateInventoryConfig(config.Inventory); err != nil {
+		return TransformConfig{}, fmt.Errorf("invalid config file %s: %w", name, err)
+	}
+
+	// An unknown rounding mode or timezone would otherwise silently fall
+	// back to half_up or UTC
+	if err := validateMoneyConfig(config.Currency); err != nil {
+		return TransformConfig{}, fmt.Errorf("invalid config file %s: %w", name, err)
+	}
+	if err := validateTimezoneConfig(config.Timezone); err != nil {
+		return TransformConfig{}, fmt.Errorf("invalid config file %s: %w", name, err)
+	}
+
+	// The engine would skip unknown stages, and rules and derived fields that
+	// do not compile
+	if err := validatePipeline(config.Pipeline); err != nil {
+		return TransformConfig{}, fmt.Errorf("invalid config file %s: %w", name, err)
+	}
+	if err := validateRules(config.Rules); err != nil {
+		return TransformConfig{}, fmt.Errorf("invalid config file %s: %w", name, err)
+	}
+	if err := validateDerivedFields(config.DerivedFields, config.Pipeline); err != nil {
+		return TransformConfig{}, fmt.Errorf("invalid config file %s: %w", name, err)
+	}
+
+	// Load the FX rate table so that conversion problems surface at startup
+	if config.Currency.FXRatesFile != "" {
+		rates, err := LoadFXRates(config.Currency.FXRatesFile)
+		if err != nil {
+			return TransformConfig{}, err
+		}
+		config.Currency.Rates = rates
+	}
+
+	// Load the geography hierarchy that continents and regions are checked
+	// against
+	if config.Geography.File != "" {
+		geography, err := LoadGeography(config.Geography.File)
+		if err != nil {
+			return TransformConfig{}, err
+		}
+		config.Geography.Hierarchy = geography
+	}
+
+	// Merge the reviewed product aliases into the custom mappings
+	if config.ProductAliasesFile != "" {
+		aliases, err := LoadProductAliases(config.ProductAliasesFile)
+		if err != nil {
+			return TransformConfig{}, err
+		}
+		if err := mergeProductAliases(&config, aliases); err != nil {
+			return TransformConfig{}, err
+		}
 	}
 
 	// Apply defaults for missing values
@@ -134,6 +267,12 @@ func (cl *ConfigLoader) getDefaultConfig() TransformConfig {
 			"quantity":         "integer",
 			"transaction_date": "datetime",
 		},
+		ColumnMappings: DefaultColumnMappings(),
+		BatchSize:      10000,
+		Inventory: InventoryConfig{
+			ColumnMappings:   DefaultInventoryColumnMappings(),
+			DefaultWarehouse: models.DefaultWarehouse,
+		},
 	}
 
 	return config
@@ -156,6 +295,11 @@ func (cl *ConfigLoader) applyDefaults(config TransformConfig) TransformConfig {
 		config.PriceMultiplier = 100.0
 	}
 
+	// Set default streaming batch size if not set
+	if config.BatchSize <= 0 {
+		config.BatchSize = cl.getDefaultConfig().BatchSize
+	}
+
 	// Initialize custom mappings if nil
 	if config.CustomMappings == nil {
 		config.CustomMappings = make(map[string]string)
@@ -174,45 +318,31 @@ func (cl *ConfigLoader) applyDefaults(config TransformConfig) TransformConfig {
 		config.DataTypes = cl.getDefaultConfig().DataTypes
 	}
 
-	return config
-}
-
-// LoadEnvironmentSpecificConfig loads configuration based on environment
-func (cl *ConfigLoader) LoadEnvironmentSpecificConfig(env string) (TransformConfig, error) {
-	// Base configuration
-	baseConfig, err := cl.LoadConfig()
-	if err != nil {
-		return TransformConfig{}, err
-	}
-
-	// Environment-specific override file
-	envConfigPath := cl.getEnvironmentConfigPath(env)
-
-	if _, err := os.Stat(envConfigPath); os.IsNotExist(err) {
-		// No environment-specific config, return base config
-		return baseConfig, nil
+	// Use the built-in column aliases if none are configured
+	if len(config.ColumnMappings) == 0 {
+		config.ColumnMappings = cl.getDefaultConfig().ColumnMappings
 	}
-
-	// Load environment-specific overrides
-	envConfigData, err := ioutil.ReadFile(envConfigPath)
-	if err != nil {
-		return baseConfig, nil // Fall back to base config
+	if len(config.Inventory.ColumnMappings) == 0 {
+		config.Inventory.ColumnMappings = DefaultInventoryColumnMappings()
 	}
-
-	// Parse environment overrides
-	var envConfig TransformConfig
-	if err := yaml.Unmarshal(envConfigData, &envConfig); err != nil {
-		return baseConfig, nil // Fall back to base config
+	if config.Inventory.DefaultWarehouse == "" {
+		config.Inventory.DefaultWarehouse = models.DefaultWarehouse
 	}
 
-	// Merge configurations (environment overrides base)
-	mergedConfig := cl.mergeConfigs(baseConfig, envConfig)
+	return config
+}
 
-	return mergedConfig, nil
+// LoadEnvironmentSpecificConfig loads configuration based on environment:
+// the environment file, such as data_transformation.production.yaml next to
+// the base file, is layered over the base file. See LoadLayeredConfig.
+func (cl *ConfigLoader) LoadEnvironmentSpecificConfig(env string) (TransformConfig, error) {
+	config, _, err := cl.LoadLayeredConfig(ConfigLayers{Environment: env})
+	return config, err
 }
 
-// getEnvironmentConfigPath returns path for environment-specific config
-func (cl *ConfigLoader) getEnvironmentConfigPath(env string) string {
+// EnvironmentConfigPath returns the path of the environment file of env,
+// such as data_transformation.production.yaml next to the config file
+func (cl *ConfigLoader) EnvironmentConfigPath(env string) string {
 	dir := filepath.Dir(cl.configPath)
 	basename := strings.TrimSuffix(filepath.Base(cl.configPath), filepath.Ext(cl.configPath))
 	ext := filepath.Ext(cl.configPath)
@@ -220,86 +350,112 @@ func (cl *ConfigLoader) getEnvironmentConfigPath(env string) string {
 	return filepath.Join(dir, fmt.Sprintf("%s.%s%s", basename, env, ext))
 }
 
-// mergeConfigs merges two configurations with override taking precedence
-func (cl *ConfigLoader) mergeConfigs(base, override TransformConfig) TransformConfig {
-	merged := base
-
-	// Override boolean flags
-	if override.EnableValidation != base.EnableValidation {
-		merged.EnableValidation = override.EnableValidation
+// ValidateConfig validates the loaded configuration
+func (cl *ConfigLoader) ValidateConfig(config TransformConfig) error {
+	// Validate required fields
+	if config.PriceMultiplier <= 0 {
+		return fmt.Errorf("price_multiplier must be positive, got %f", config.PriceMultiplier)
 	}
-	if override.EnableOptimization != base.EnableOptimization {
-		merged.EnableOptimization = override.EnableOptimization
+
+	if len(config.DateFormats) == 0 {
+		return fmt.Errorf("at least one date format must be specified")
 	}
 
-	// Override arrays (replace completely if provided)
-	if len(override.DateFormats) > 0 {
-		merged.DateFormats = override.DateFormats
+	if config.BatchSize < 0 {
+		return fmt.Errorf("batch_size must not be negative, got %d", config.BatchSize)
 	}
-	if len(override.CurrencyFormats) > 0 {
-		merged.CurrencyFormats = override.CurrencyFormats
+
+	// Validate date formats
+	validFormats := 0
+	for _, format := range config.DateFormats {
+		if cl.isValidDateFormat(format) {
+			validFormats++
+		}
 	}
-	if len(override.NullValues) > 0 {
-		merged.NullValues = override.NullValues
+
+	if validFormats == 0 {
+		return fmt.Errorf("no valid date formats found")
 	}
 
-	// Override strings
-	if override.DefaultCountry != "" {
-		merged.DefaultCountry = override.DefaultCountry
+	// Validate workbook sheet selection
+	if config.XLSX.SheetIndex < 0 {
+		return fmt.Errorf("xlsx.sheet_index must not be negative, got %d", config.XLSX.SheetIndex)
 	}
-	if override.DefaultRegion != "" {
-		merged.DefaultRegion = override.DefaultRegion
+	if config.XLSX.HeaderRow < 0 {
+		return fmt.Errorf("xlsx.header_row must not be negative, got %d", config.XLSX.HeaderRow)
 	}
 
-	// Override numeric values
-	if override.PriceMultiplier != 0 {
-		merged.PriceMultiplier = override.PriceMultiplier
+	// Validate column mappings
+	if err := validateColumnMappings(config.ColumnMappings); err != nil {
+		return err
 	}
-
-	// Merge custom mappings
-	if override.CustomMappings != nil {
-		if merged.CustomMappings == nil {
-			merged.CustomMappings = make(map[string]string)
-		}
-		for key, value := range override.CustomMappings {
-			merged.CustomMappings[key] = value
-		}
+	if err := validateInventoryConfig(config.Inventory); err != nil {
+		return err
 	}
 
-	// Merge data types
-	if override.DataTypes != nil {
-		if merged.DataTypes == nil {
-			merged.DataTypes = make(map[string]string)
+	// Validate currency codes
+	for name, code := range map[string]string{
+		"reporting_currency": config.Currency.ReportingCurrency,
+		"default_currency":   config.Currency.DefaultCurrency,
+	} {
+		if code != "" && len(strings.TrimSpace(code)) != 3 {
+			return fmt.Errorf("currency.%s must be a 3-letter ISO 4217 code, got %q", name, code)
 		}
-		for key, value := range override.DataTypes {
-			merged.DataTypes[key] = value
+	}
+	if config.Currency.FXRatesFile != "" && config.Currency.Rates == nil {
+		return fmt.Errorf("currency.fx_rates_file %s has not been loaded", config.Currency.FXRatesFile)
+	}
+	if config.ProductAliasesFile != "" {
+		if _, err := LoadProductAliases(config.ProductAliasesFile); err != nil {
+			return err
 		}
 	}
+	if config.Geography.File != "" && config.Geography.Hierarchy == nil {
+		return fmt.Errorf("geography.file %s has not been loaded", config.Geography.File)
+	}
+	if err := validateMoneyConfig(config.Currency); err != nil {
+		return err
+	}
 
-	return merged
-}
-
-// ValidateConfig validates the loaded configuration
-func (cl *ConfigLoader) ValidateConfig(config TransformConfig) error {
-	// Validate required fields
-	if config.PriceMultiplier <= 0 {
-		return fmt.Errorf("price_multiplier must be positive, got %f", config.PriceMultiplier)
+	// Validate timezones
+	if err := validateTimezoneConfig(config.Timezone); err != nil {
+		return err
 	}
 
-	if len(config.DateFormats) == 0 {
-		return fmt.Errorf("at least one date format must be specified")
+	// Validate pipeline stages, rules and derived fields
+	if err := validatePipeline(config.Pipeline); err != nil {
+		return err
+	}
+	if err := validateRules(config.Rules); err != nil {
+		return err
+	}
+	if err := validateDerivedFields(config.DerivedFields, config.Pipeline); err != nil {
+		return err
 	}
 
-	// Validate date formats
-	validFormats := 0
-	for _, format := range config.DateFormats {
-		if cl.isValidDateFormat(format) {
-			validFormats++
-		}
+	// Validate quarantine format
+	switch config.Quarantine.Format {
+	case "", quarantine.FormatCSV, quarantine.FormatNDJSON:
+	default:
+		return fmt.Errorf("error_handling.quarantine.format must be %q or %q, got %q",
+			quarantine.FormatCSV, quarantine.FormatNDJSON, config.Quarantine.Format)
 	}
 
-	if validFormats == 0 {
-		return fmt.Errorf("no valid date formats found")
+	// Validate XML field paths
+	for field, path := range config.XML.FieldPaths {
+		known := false
+		for _, name := range standardFields {
+			if field == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("xml.field_paths: unknown field %q", field)
+		}
+		if strings.Trim(path, "/") == "" {
+			return fmt.Errorf("xml.field_paths: empty path for field %q", field)
+		}
 	}
 
 	return nil
@@ -321,34 +477,7 @@ func (cl *ConfigLoader) isValidDateFormat(format string) bool {
 
 // SaveConfig saves the current configuration to file
 func (cl *ConfigLoader) SaveConfig(config TransformConfig) error {
-	// Create YAML structure
-	yamlConfig := struct {
-		Transformation struct {
-			EnableValidation   bool     `yaml:"enable_validation"`
-			EnableOptimization bool     `yaml:"enable_optimization"`
-			DateFormats        []string `yaml:"date_formats"`
-			CurrencyFormats    []string `yaml:"currency_formats"`
-			NullValues         []string `yaml:"null_values"`
-			Defaults           struct {
-				Country         string  `yaml:"country"`
-				Region          string  `yaml:"region"`
-				PriceMultiplier float64 `yaml:"price_multiplier"`
-			} `yaml:"defaults"`
-			CustomMappings map[string]string `yaml:"custom_mappings"`
-			DataTypes      map[string]string `yaml:"data_types"`
-		} `yaml:"transformation"`
-	}{}
-
-	yamlConfig.Transformation.EnableValidation = config.EnableValidation
-	yamlConfig.Transformation.EnableOptimization = config.EnableOptimization
-	yamlConfig.Transformation.DateFormats = config.DateFormats
-	yamlConfig.Transformation.CurrencyFormats = config.CurrencyFormats
-	yamlConfig.Transformation.NullValues = config.NullValues
-	yamlConfig.Transformation.Defaults.Country = config.DefaultCountry
-	yamlConfig.Transformation.Defaults.Region = config.DefaultRegion
-	yamlConfig.Transformation.Defaults.PriceMultiplier = config.PriceMultiplier
-	yamlConfig.Transformation.CustomMappings = config.CustomMappings
-	yamlConfig.Transformation.DataTypes = config.DataTypes
+	yamlConfig := newConfigFile(config)
 
 	// Marshal to YAML
 	configData, err := yaml.Marshal(yamlConfig)
@@ -0,0 +1,351 @@
+package transform
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Country is an ISO 3166-1 country. Name is the common English short name,
+// which is what transactions are normalized to.
+type Country struct {
+	Name    string
+	Alpha2  string
+	Alpha3  string
+	Numeric string
+	Aliases []string // official and other common names
+}
+
+// LookupCountry finds a country by name, alias, alpha-2, alpha-3 or numeric
+// code. Case, diacritics, punctuation and a leading "the" are ignored, so
+// "CÔTE D'IVOIRE", "cote divoire" and "384" all find Côte d'Ivoire.
+func LookupCountry(value string) (Country, bool) {
+	key := countryKey(value)
+	if n, err := strconv.Atoi(key); err == nil {
+		key = fmt.Sprintf("%03d", n)
+	}
+	i, ok := countryIndex[key]
+	if !ok {
+		return Country{}, false
+	}
+	return countries[i], true
+}
+
+// countryCode returns the alpha-2 code of a country name, or "" if the name
+// is not in the ISO table
+func countryCode(name string) string {
+	if c, ok := LookupCountry(name); ok {
+		return c.Alpha2
+	}
+	return ""
+}
+
+// countryKey folds a country name for lookup: lower case ASCII with dots and
+// apostrophes removed and other punctuation turned into single spaces
+func countryKey(value string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(value) {
+		if folded, ok := diacritics[r]; ok {
+			sb.WriteString(folded)
+			continue
+		}
+		switch {
+		case r == '.' || r == '\'' || r == '’':
+		case r == '&':
+			sb.WriteString(" and ")
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte(' ')
+		}
+	}
+	words := strings.Fields(sb.String())
+	if len(words) > 1 && words[0] == "the" {
+		words = words[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// diacritics maps the accented letters found in country names to ASCII
+var diacritics = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'č': "c", 'đ': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ę': "e", 'ğ': "g",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ı': "i", 'ł': "l",
+	'ñ': "n", 'ń': "n", 'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ő': "o",
+	'œ': "oe", 'ř': "r", 'ś': "s", 'š': "s", 'ş': "s", 'ș': "s", 'ß': "ss", 'ţ': "t", 'ț': "t",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ů': "u", 'ű': "u", 'ý': "y", 'ÿ': "y",
+	'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// countryIndex maps the folded names, aliases and codes to countries
+var countryIndex = buildCountryIndex()
+
+func buildCountryIndex() map[string]int {
+	index := make(map[string]int, len(countries)*5)
+	for i, c := range countries {
+		keys := append([]string{c.Name, c.Alpha2, c.Alpha3, c.Numeric}, c.Aliases...)
+		for _, name := range keys {
+			key := countryKey(name)
+			if j, exists := index[key]; exists && j != i {
+				panic(fmt.Sprintf("country key %q of %s is also used by %s", key, c.Name, countries[j].Name))
+			}
+			index[key] = i
+		}
+	}
+	return index
+}
+
+// countries is the ISO 3166-1 table
+var countries = []Country{
+	{"Afghanistan", "AF", "AFG", "004", nil},
+	{"Åland Islands", "AX", "ALA", "248", nil},
+	{"Albania", "AL", "ALB", "008", nil},
+	{"Algeria", "DZ", "DZA", "012", nil},
+	{"American Samoa", "AS", "ASM", "016", nil},
+	{"Andorra", "AD", "AND", "020", nil},
+	{"Angola", "AO", "AGO", "024", nil},
+	{"Anguilla", "AI", "AIA", "660", nil},
+	{"Antarctica", "AQ", "ATA", "010", nil},
+	{"Antigua and Barbuda", "AG", "ATG", "028", nil},
+	{"Argentina", "AR", "ARG", "032", nil},
+	{"Armenia", "AM", "ARM", "051", nil},
+	{"Aruba", "AW", "ABW", "533", nil},
+	{"Australia", "AU", "AUS", "036", nil},
+	{"Austria", "AT", "AUT", "040", []string{"Österreich"}},
+	{"Azerbaijan", "AZ", "AZE", "031", nil},
+	{"Bahamas", "BS", "BHS", "044", nil},
+	{"Bahrain", "BH", "BHR", "048", nil},
+	{"Bangladesh", "BD", "BGD", "050", nil},
+	{"Barbados", "BB", "BRB", "052", nil},
+	{"Belarus", "BY", "BLR", "112", nil},
+	{"Belgium", "BE", "BEL", "056", []string{"België", "Belgique"}},
+	{"Belize", "BZ", "BLZ", "084", nil},
+	{"Benin", "BJ", "BEN", "204", nil},
+	{"Bermuda", "BM", "BMU", "060", nil},
+	{"Bhutan", "BT", "BTN", "064", nil},
+	{"Bolivia", "BO", "BOL", "068", []string{"Bolivia, Plurinational State of", "Plurinational State of Bolivia"}},
+	{"Caribbean Netherlands", "BQ", "BES", "535", []string{"Bonaire, Sint Eustatius and Saba", "Bonaire"}},
+	{"Bosnia and Herzegovina", "BA", "BIH", "070", []string{"Bosnia"}},
+	{"Botswana", "BW", "BWA", "072", nil},
+	{"Bouvet Island", "BV", "BVT", "074", nil},
+	{"Brazil", "BR", "BRA", "076", []string{"Brasil"}},
+	{"British Indian Ocean Territory", "IO", "IOT", "086", nil},
+	{"Brunei", "BN", "BRN", "096", []string{"Brunei Darussalam"}},
+	{"Bulgaria", "BG", "BGR", "100", nil},
+	{"Burkina Faso", "BF", "BFA", "854", nil},
+	{"Burundi", "BI", "BDI", "108", nil},
+	{"Cape Verde", "CV", "CPV", "132", []string{"Cabo Verde"}},
+	{"Cambodia", "KH", "KHM", "116", nil},
+	{"Cameroon", "CM", "CMR", "120", nil},
+	{"Canada", "CA", "CAN", "124", nil},
+	{"Cayman Islands", "KY", "CYM", "136", nil},
+	{"Central African Republic", "CF", "CAF", "140", nil},
+	{"Chad", "TD", "TCD", "148", nil},
+	{"Chile", "CL", "CHL", "152", nil},
+	{"China", "CN", "CHN", "156", []string{"PRC", "People's Republic of China", "Mainland China"}},
+	{"Christmas Island", "CX", "CXR", "162", nil},
+	{"Cocos (Keeling) Islands", "CC", "CCK", "166", []string{"Cocos Islands", "Keeling Islands"}},
+	{"Colombia", "CO", "COL", "170", nil},
+	{"Comoros", "KM", "COM", "174", nil},
+	{"Republic of the Congo", "CG", "COG", "178", []string{"Congo", "Congo-Brazzaville", "Congo Republic"}},
+	{"DR Congo", "CD", "COD", "180", []string{"Democratic Republic of the Congo", "Congo, The Democratic Republic of the", "DRC", "Congo-Kinshasa", "Zaire"}},
+	{"Cook Islands", "CK", "COK", "184", nil},
+	{"Costa Rica", "CR", "CRI", "188", nil},
+	{"Côte d'Ivoire", "CI", "CIV", "384", []string{"Ivory Coast"}},
+	{"Croatia", "HR", "HRV", "191", []string{"Hrvatska"}},
+	{"Cuba", "CU", "CUB", "192", nil},
+	{"Curaçao", "CW", "CUW", "531", nil},
+	{"Cyprus", "CY", "CYP", "196", nil},
+	{"Czechia", "CZ", "CZE", "203", []string{"Czech Republic"}},
+	{"Denmark", "DK", "DNK", "208", []string{"Danmark"}},
+	{"Djibouti", "DJ", "DJI", "262", nil},
+	{"Dominica", "DM", "DMA", "212", nil},
+	{"Dominican Republic", "DO", "DOM", "214", nil},
+	{"Ecuador", "EC", "ECU", "218", nil},
+	{"Egypt", "EG", "EGY", "818", nil},
+	{"El Salvador", "SV", "SLV", "222", nil},
+	{"Equatorial Guinea", "GQ", "GNQ", "226", nil},
+	{"Eritrea", "ER", "ERI", "232", nil},
+	{"Estonia", "EE", "EST", "233", nil},
+	{"Eswatini", "SZ", "SWZ", "748", []string{"Swaziland"}},
+	{"Ethiopia", "ET", "ETH", "231", nil},
+	{"Falkland Islands", "FK", "FLK", "238", []string{"Falkland Islands (Malvinas)", "Malvinas"}},
+	{"Faroe Islands", "FO", "FRO", "234", []string{"Faroes"}},
+	{"Fiji", "FJ", "FJI", "242", nil},
+	{"Finland", "FI", "FIN", "246", []string{"Suomi"}},
+	{"France", "FR", "FRA", "250", nil},
+	{"French Guiana", "GF", "GUF", "254", nil},
+	{"French Polynesia", "PF", "PYF", "258", nil},
+	{"French Southern Territories", "TF", "ATF", "260", nil},
+	{"Gabon", "GA", "GAB", "266", nil},
+	{"Gambia", "GM", "GMB", "270", nil},
+	{"Georgia", "GE", "GEO", "268", nil},
+	{"Germany", "DE", "DEU", "276", []string{"Deutschland"}},
+	{"Ghana", "GH", "GHA", "288", nil},
+	{"Gibraltar", "GI", "GIB", "292", nil},
+	{"Greece", "GR", "GRC", "300", []string{"Hellas"}},
+	{"Greenland", "GL", "GRL", "304", nil},
+	{"Grenada", "GD", "GRD", "308", nil},
+	{"Guadeloupe", "GP", "GLP", "312", nil},
+	{"Guam", "GU", "GUM", "316", nil},
+	{"Guatemala", "GT", "GTM", "320", nil},
+	{"Guernsey", "GG", "GGY", "831", nil},
+	{"Guinea", "GN", "GIN", "324", nil},
+	{"Guinea-Bissau", "GW", "GNB", "624", nil},
+	{"Guyana", "GY", "GUY", "328", nil},
+	{"Haiti", "HT", "HTI", "332", nil},
+	{"Heard Island and McDonald Islands", "HM", "HMD", "334", nil},
+	{"Vatican City", "VA", "VAT", "336", []string{"Holy See", "Holy See (Vatican City State)", "Vatican"}},
+	{"Honduras", "HN", "HND", "340", nil},
+	{"Hong Kong", "HK", "HKG", "344", []string{"Hong Kong SAR"}},
+	{"Hungary", "HU", "HUN", "348", []string{"Magyarország"}},
+	{"Iceland", "IS", "ISL", "352", []string{"Ísland"}},
+	{"India", "IN", "IND", "356", []string{"Bharat"}},
+	{"Indonesia", "ID", "IDN", "360", nil},
+	{"Iran", "IR", "IRN", "364", []string{"Iran, Islamic Republic of", "Islamic Republic of Iran"}},
+	{"Iraq", "IQ", "IRQ", "368", nil},
+	{"Ireland", "IE", "IRL", "372", []string{"Republic of Ireland", "Éire"}},
+	{"Isle of Man", "IM", "IMN", "833", nil},
+	{"Israel", "IL", "ISR", "376", nil},
+	{"Italy", "IT", "ITA", "380", []string{"Italia"}},
+	{"Jamaica", "JM", "JAM", "388", nil},
+	{"Japan", "JP", "JPN", "392", []string{"Nippon"}},
+	{"Jersey", "JE", "JEY", "832", nil},
+	{"Jordan", "JO", "JOR", "400", nil},
+	{"Kazakhstan", "KZ", "KAZ", "398", nil},
+	{"Kenya", "KE", "KEN", "404", nil},
+	{"Kiribati", "KI", "KIR", "296", nil},
+	{"North Korea", "KP", "PRK", "408", []string{"Korea, Democratic People's Republic of", "Democratic People's Republic of Korea", "DPRK"}},
+	{"South Korea", "KR", "KOR", "410", []string{"Korea, Republic of", "Republic of Korea", "Korea", "ROK"}},
+	{"Kuwait", "KW", "KWT", "414", nil},
+	{"Kyrgyzstan", "KG", "KGZ", "417", []string{"Kyrgyz Republic"}},
+	{"Laos", "LA", "LAO", "418", []string{"Lao People's Democratic Republic", "Lao PDR"}},
+	{"Latvia", "LV", "LVA", "428", nil},
+	{"Lebanon", "LB", "LBN", "422", nil},
+	{"Lesotho", "LS", "LSO", "426", nil},
+	{"Liberia", "LR", "LBR", "430", nil},
+	{"Libya", "LY", "LBY", "434", nil},
+	{"Liechtenstein", "LI", "LIE", "438", nil},
+	{"Lithuania", "LT", "LTU", "440", nil},
+	{"Luxembourg", "LU", "LUX", "442", nil},
+	{"Macao", "MO", "MAC", "446", []string{"Macau", "Macao SAR"}},
+	{"Madagascar", "MG", "MDG", "450", nil},
+	{"Malawi", "MW", "MWI", "454", nil},
+	{"Malaysia", "MY", "MYS", "458", nil},
+	{"Maldives", "MV", "MDV", "462", nil},
+	{"Mali", "ML", "MLI", "466", nil},
+	{"Malta", "MT", "MLT", "470", nil},
+	{"Marshall Islands", "MH", "MHL", "584", nil},
+	{"Martinique", "MQ", "MTQ", "474", nil},
+	{"Mauritania", "MR", "MRT", "478", nil},
+	{"Mauritius", "MU", "MUS", "480", nil},
+	{"Mayotte", "YT", "MYT", "175", nil},
+	{"Mexico", "MX", "MEX", "484", nil},
+	{"Micronesia", "FM", "FSM", "583", []string{"Micronesia, Federated States of", "Federated States of Micronesia"}},
+	{"Moldova", "MD", "MDA", "498", []string{"Moldova, Republic of", "Republic of Moldova"}},
+	{"Monaco", "MC", "MCO", "492", nil},
+	{"Mongolia", "MN", "MNG", "496", nil},
+	{"Montenegro", "ME", "MNE", "499", nil},
+	{"Montserrat", "MS", "MSR", "500", nil},
+	{"Morocco", "MA", "MAR", "504", nil},
+	{"Mozambique", "MZ", "MOZ", "508", nil},
+	{"Myanmar", "MM", "MMR", "104", []string{"Burma"}},
+	{"Namibia", "NA", "NAM", "516", nil},
+	{"Nauru", "NR", "NRU", "520", nil},
+	{"Nepal", "NP", "NPL", "524", nil},
+	{"Netherlands", "NL", "NLD", "528", []string{"Holland", "Netherlands, Kingdom of the", "Nederland"}},
+	{"New Caledonia", "NC", "NCL", "540", nil},
+	{"New Zealand", "NZ", "NZL", "554", []string{"Aotearoa"}},
+	{"Nicaragua", "NI", "NIC", "558", nil},
+	{"Niger", "NE", "NER", "562", nil},
+	{"Nigeria", "NG", "NGA", "566", nil},
+	{"Niue", "NU", "NIU", "570", nil},
+	{"Norfolk Island", "NF", "NFK", "574", nil},
+	{"North Macedonia", "MK", "MKD", "807", []string{"Macedonia", "Republic of North Macedonia", "Macedonia, the former Yugoslav Republic of"}},
+	{"Northern Mariana Islands", "MP", "MNP", "580", nil},
+	{"Norway", "NO", "NOR", "578", []string{"Norge"}},
+	{"Oman", "OM", "OMN", "512", nil},
+	{"Pakistan", "PK", "PAK", "586", nil},
+	{"Palau", "PW", "PLW", "585", nil},
+	{"Palestine", "PS", "PSE", "275", []string{"Palestine, State of", "State of Palestine", "Palestinian Territories"}},
+	{"Panama", "PA", "PAN", "591", []string{"Panamá"}},
+	{"Papua New Guinea", "PG", "PNG", "598", nil},
+	{"Paraguay", "PY", "PRY", "600", nil},
+	{"Peru", "PE", "PER", "604", []string{"Perú"}},
+	{"Philippines", "PH", "PHL", "608", []string{"Pilipinas"}},
+	{"Pitcairn Islands", "PN", "PCN", "612", []string{"Pitcairn"}},
+	{"Poland", "PL", "POL", "616", []string{"Polska"}},
+	{"Portugal", "PT", "PRT", "620", nil},
+	{"Puerto Rico", "PR", "PRI", "630", nil},
+	{"Qatar", "QA", "QAT", "634", nil},
+	{"Réunion", "RE", "REU", "638", nil},
+	{"Romania", "RO", "ROU", "642", []string{"România"}},
+	{"Russia", "RU", "RUS", "643", []string{"Russian Federation"}},
+	{"Rwanda", "RW", "RWA", "646", nil},
+	{"Saint Barthélemy", "BL", "BLM", "652", []string{"St Barthélemy", "St Barts"}},
+	{"Saint Helena", "SH", "SHN", "654", []string{"Saint Helena, Ascension and Tristan da Cunha", "St Helena"}},
+	{"Saint Kitts and Nevis", "KN", "KNA", "659", []string{"St Kitts and Nevis"}},
+	{"Saint Lucia", "LC", "LCA", "662", []string{"St Lucia"}},
+	{"Saint Martin", "MF", "MAF", "663", []string{"Saint Martin (French part)", "St Martin"}},
+	{"Saint Pierre and Miquelon", "PM", "SPM", "666", []string{"St Pierre and Miquelon"}},
+	{"Saint Vincent and the Grenadines", "VC", "VCT", "670", []string{"St Vincent and the Grenadines"}},
+	{"Samoa", "WS", "WSM", "882", nil},
+	{"San Marino", "SM", "SMR", "674", nil},
+	{"São Tomé and Príncipe", "ST", "STP", "678", nil},
+	{"Saudi Arabia", "SA", "SAU", "682", []string{"KSA", "Kingdom of Saudi Arabia"}},
+	{"Senegal", "SN", "SEN", "686", nil},
+	{"Serbia", "RS", "SRB", "688", nil},
+	{"Seychelles", "SC", "SYC", "690", nil},
+	{"Sierra Leone", "SL", "SLE", "694", nil},
+	{"Singapore", "SG", "SGP", "702", nil},
+	{"Sint Maarten", "SX", "SXM", "534", []string{"Sint Maarten (Dutch part)"}},
+	{"Slovakia", "SK", "SVK", "703", []string{"Slovak Republic"}},
+	{"Slovenia", "SI", "SVN", "705", nil},
+	{"Solomon Islands", "SB", "SLB", "090", nil},
+	{"Somalia", "SO", "SOM", "706", nil},
+	{"South Africa", "ZA", "ZAF", "710", []string{"RSA"}},
+	{"South Georgia and the South Sandwich Islands", "GS", "SGS", "239", nil},
+	{"South Sudan", "SS", "SSD", "728", nil},
+	{"Spain", "ES", "ESP", "724", []string{"España"}},
+	{"Sri Lanka", "LK", "LKA", "144", []string{"Ceylon"}},
+	{"Sudan", "SD", "SDN", "729", nil},
+	{"Suriname", "SR", "SUR", "740", []string{"Surinam"}},
+	{"Svalbard and Jan Mayen", "SJ", "SJM", "744", nil},
+	{"Sweden", "SE", "SWE", "752", []string{"Sverige"}},
+	{"Switzerland", "CH", "CHE", "756", []string{"Schweiz", "Suisse", "Svizzera"}},
+	{"Syria", "SY", "SYR", "760", []string{"Syrian Arab Republic"}},
+	{"Taiwan", "TW", "TWN", "158", []string{"Taiwan, Province of China", "Republic of China", "ROC", "Chinese Taipei"}},
+	{"Tajikistan", "TJ", "TJK", "762", nil},
+	{"Tanzania", "TZ", "TZA", "834", []string{"Tanzania, United Republic of", "United Republic of Tanzania"}},
+	{"Thailand", "TH", "THA", "764", nil},
+	{"Timor-Leste", "TL", "TLS", "626", []string{"East Timor"}},
+	{"Togo", "TG", "TGO", "768", nil},
+	{"Tokelau", "TK", "TKL", "772", nil},
+	{"Tonga", "TO", "TON", "776", nil},
+	{"Trinidad and Tobago", "TT", "TTO", "780", []string{"Trinidad"}},
+	{"Tunisia", "TN", "TUN", "788", nil},
+	{"Turkey", "TR", "TUR", "792", []string{"Türkiye"}},
+	{"Turkmenistan", "TM", "TKM", "795", nil},
+	{"Turks and Caicos Islands", "TC", "TCA", "796", nil},
+	{"Tuvalu", "TV", "TUV", "798", nil},
+	{"Uganda", "UG", "UGA", "800", nil},
+	{"Ukraine", "UA", "UKR", "804", nil},
+	{"United Arab Emirates", "AE", "ARE", "784", []string{"UAE", "Emirates"}},
+	{"United Kingdom", "GB", "GBR", "826", []string{"UK", "Great Britain", "Britain", "England", "Scotland", "Wales", "Northern Ireland", "United Kingdom of Great Britain and Northern Ireland"}},
+	{"United States", "US", "USA", "840", []string{"United States of America", "America"}},
+	{"United States Minor Outlying Islands", "UM", "UMI", "581", nil},
+	{"Uruguay", "UY", "URY", "858", nil},
+	{"Uzbekistan", "UZ", "UZB", "860", nil},
+	{"Vanuatu", "VU", "VUT", "548", nil},
+	{"Venezuela", "VE", "VEN", "862", []string{"Venezuela, Bolivarian Republic of", "Bolivarian Republic of Venezuela"}},
+	{"Vietnam", "VN", "VNM", "704", []string{"Viet Nam"}},
+	{"British Virgin Islands", "VG", "VGB", "092", []string{"Virgin Islands, British", "BVI"}},
+	{"U.S. Virgin Islands", "VI", "VIR", "850", []string{"Virgin Islands, U.S.", "USVI"}},
+	{"Wallis and Futuna", "WF", "WLF", "876", nil},
+	{"Western Sahara", "EH", "ESH", "732", nil},
+	{"Yemen", "YE", "YEM", "887", nil},
+	{"Zambia", "ZM", "ZMB", "894", nil},
+	{"Zimbabwe", "ZW", "ZWE", "716", nil},
+}
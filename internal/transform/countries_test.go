@@ -0,0 +1,133 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"abt-dashboard/internal/models"
+)
+
+func TestLookupCountry(t *testing.T) {
+	tests := []struct {
+		value string
+		name  string
+		code  string
+	}{
+		{"LK", "Sri Lanka", "LK"},
+		{"lka", "Sri Lanka", "LK"},
+		{"144", "Sri Lanka", "LK"},
+		{"Ceylon", "Sri Lanka", "LK"},
+		{"U.S.A.", "United States", "US"},
+		{"united states of america", "United States", "US"},
+		{"CÔTE D'IVOIRE", "Côte d'Ivoire", "CI"},
+		{"Cote dIvoire", "Côte d'Ivoire", "CI"},
+		{"Türkiye", "Turkey", "TR"},
+		{"The Netherlands", "Netherlands", "NL"},
+		{"Korea, Republic of", "South Korea", "KR"},
+		{"DPRK", "North Korea", "KP"},
+		{"St. Lucia", "Saint Lucia", "LC"},
+		{"Bosnia & Herzegovina", "Bosnia and Herzegovina", "BA"},
+		{"8", "Albania", "AL"},
+		{"reunion", "Réunion", "RE"},
+	}
+	for _, tt := range tests {
+		c, ok := LookupCountry(tt.value)
+		if !ok || c.Name != tt.name || c.Alpha2 != tt.code {
+			t.Errorf("%q: got %+v, %v want %s (%s)", tt.value, c, ok, tt.name, tt.code)
+		}
+	}
+
+	for _, value := range []string{"", "Atlantis", "The", "999", "United"} {
+		if c, ok := LookupCountry(value); ok {
+			t.Errorf("%q: got %+v", value, c)
+		}
+	}
+}
+
+func TestCountryCodesAndUnmappedCountries(t *testing.T) {
+	handler := newTestHandler(10)
+	handler.config.CustomMappings = map[string]string{"blighty": "United Kingdom", "narnia": "Narnia"}
+	handler.engine = NewDataTransformationEngine(handler.config)
+
+	csv := `transaction_id,transaction_date,country,product_name,price,quantity
+tx-1,2024-01-15,USA,widget,25.00,2
+tx-2,2024-01-15,deu,widget,25.00,2
+tx-3,2024-01-15,Blighty,widget,25.00,2
+tx-4,2024-01-15,atlantis,widget,25.00,2
+tx-5,2024-01-15,Atlantis,widget,25.00,2
+tx-6,2024-01-15,Narnia,widget,25.00,2
+tx-7,2024-01-15,Lemuria,widget,25.00,2
+`
+	transactions, result, err := handler.ProcessDataStream(strings.NewReader(csv), FormatCSV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []struct{ country, code string }{
+		{"United States", "US"},
+		{"Germany", "DE"},
+		{"United Kingdom", "GB"},
+		{"Atlantis", ""},
+		{"Atlantis", ""},
+		{"Narnia", ""},
+		{"Lemuria", ""},
+	}
+	if len(transactions) != len(want) {
+		t.Fatalf("got %d transactions", len(transactions))
+	}
+	for i, tx := range transactions {
+		if tx.Country != want[i].country || tx.CountryCode != want[i].code {
+			t.Errorf("%s: got %q (%s) want %q (%s)", tx.ID, tx.Country, tx.CountryCode, want[i].country, want[i].code)
+		}
+	}
+
+	unmapped := result.DataQuality.UnmappedCountries
+	if len(unmapped) != 3 || unmapped["Atlantis"] != 2 || unmapped["Narnia"] != 1 || unmapped["Lemuria"] != 1 {
+		t.Errorf("unmapped countries: got %v", unmapped)
+	}
+
+	report := handler.GetDataQualityReport(transactions)
+	var issue *DataQualityIssue
+	for i := range report.Issues {
+		if report.Issues[i].Type == "unmapped_country" {
+			issue = &report.Issues[i]
+		}
+	}
+	if issue == nil || issue.Count != 4 || strings.Join(issue.Examples, ", ") != "Atlantis (2), Lemuria (1), Narnia (1)" {
+		t.Errorf("unmapped country issue: got %+v", issue)
+	}
+}
+
+func TestCanonicalCountryNamesAreValid(t *testing.T) {
+	validator := &DataTypeValidator{}
+	for _, c := range countries {
+		tx := models.Transaction{ID: "tx-1", Country: c.Name, UnitPriceCents: 100, Quantity: 1, TxTime: time.Now()}
+		if err := validator.Validate(&tx); err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+		}
+	}
+	tx := models.Transaction{ID: "tx-1", Country: "Atlantis <script>", UnitPriceCents: 100, Quantity: 1, TxTime: time.Now()}
+	if err := validator.Validate(&tx); err == nil {
+		t.Error("country with markup: expected an error")
+	}
+
+	// Normalized rows for accented and parenthesized names get no warnings
+	handler := newTestHandler(10)
+	csv := `transaction_id,transaction_date,country,product_name,price,quantity
+tx-1,2024-01-15,CIV,widget,25.00,2
+tx-2,2024-01-15,Curacao,widget,25.00,2
+tx-3,2024-01-15,ALA,widget,25.00,2
+tx-4,2024-01-15,Reunion,widget,25.00,2
+tx-5,2024-01-15,STP,widget,25.00,2
+tx-6,2024-01-15,St Barts,widget,25.00,2
+tx-7,2024-01-15,Cocos Islands,widget,25.00,2
+`
+	transactions, result, err := handler.ProcessDataStream(strings.NewReader(csv), FormatCSV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transactions) != 7 || len(result.Warnings) != 0 {
+		t.Errorf("got %d transactions, warnings %v", len(transactions), result.Warnings)
+	}
+}
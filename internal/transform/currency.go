@@ -0,0 +1,271 @@
+package transform
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/money"
+)
+
+// CurrencyConfig controls conversion of prices to a single reporting currency.
+//
+// Each record's currency comes from its currency column (see column_mappings)
+// or DefaultCurrency. When ReportingCurrency is set, prices in any other
+// currency are converted with the rate from FXRatesFile that is in effect on
+// the transaction date; records that cannot be converted are rejected.
+//
+// Prices are kept in the minor units of their currency (ISO 4217 decimal
+// places, overridable with MinorUnits) and amounts with more decimals are
+// rounded with Rounding: half_up (default), half_even, down, up, floor or
+// ceiling.
+type CurrencyConfig struct {
+	ReportingCurrency string         `json:"reporting_currency" yaml:"reporting_currency"`
+	DefaultCurrency   string         `json:"default_currency" yaml:"default_currency"`
+	FXRatesFile       string         `json:"fx_rates_file" yaml:"fx_rates_file"`
+	Rounding          string         `json:"rounding" yaml:"rounding"`
+	MinorUnits        map[string]int `json:"minor_units,omitempty" yaml:"minor_units,omitempty"`
+
+	// Rates is loaded from FXRatesFile together with the configuration
+	Rates *FXRates `json:"-" yaml:"-"`
+}
+
+// FXRates is a table of exchange rates into the reporting currency. A rate
+// takes effect on its date and applies until the next rate for the currency.
+type FXRates struct {
+	rates map[string][]fxRate // currency -> rates sorted by date
+}
+
+type fxRate struct {
+	from time.Time
+	rate *big.Rat
+}
+
+// LoadFXRates reads an FX rate file; see ParseFXRates for the layout
+func LoadFXRates(path string) (*FXRates, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open FX rate file: %w", err)
+	}
+	defer file.Close()
+
+	rates, err := ParseFXRates(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read FX rate file %s: %w", path, err)
+	}
+	return rates, nil
+}
+
+// ParseFXRates reads CSV with the columns date,currency,rate. The date is a
+// day (YYYY-MM-DD) for daily rates or a month (YYYY-MM) for monthly rates,
+// and rate is the value of one unit of currency in the reporting currency,
+// e.g. "2024-03,EUR,1.0850" when reporting in USD.
+func ParseFXRates(reader io.Reader) (*FXRates, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+	csvReader.Comment = '#'
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	idx := make(map[string]int)
+	for i, col := range header {
+		idx[strings.ToLower(strings.TrimSpace(col))] = i
+	}
+	for _, required := range []string{"date", "currency", "rate"} {
+		if _, ok := idx[required]; !ok {
+			return nil, fmt.Errorf("missing required column: %s", required)
+		}
+	}
+
+	fx := &FXRates{rates: make(map[string][]fxRate)}
+	line := 1
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		dateStr := strings.TrimSpace(record[idx["date"]])
+		from, err := time.Parse("2006-01-02", dateStr)
+		if err != nil {
+			if from, err = time.Parse("2006-01", dateStr); err != nil {
+				return nil, fmt.Errorf("line %d: invalid date %q, expected YYYY-MM-DD or YYYY-MM", line, dateStr)
+			}
+		}
+
+		currency := normalizeCurrency(record[idx["currency"]])
+		if currency == "" {
+			return nil, fmt.Errorf("line %d: currency is required", line)
+		}
+
+		rate, ok := new(big.Rat).SetString(strings.TrimSpace(record[idx["rate"]]))
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("line %d: invalid rate %q", line, record[idx["rate"]])
+		}
+
+		fx.rates[currency] = append(fx.rates[currency], fxRate{from: from, rate: rate})
+	}
+
+	for _, rates := range fx.rates {
+		sort.SliceStable(rates, func(i, j int) bool { return rates[i].from.Before(rates[j].from) })
+	}
+	return fx, nil
+}
+
+// Rate returns the rate for currency in effect at t
+func (fx *FXRates) Rate(currency string, t time.Time) (*big.Rat, error) {
+	if fx == nil {
+		return nil, fmt.Errorf("no FX rate file configured")
+	}
+
+	rates := fx.rates[currency]
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	i := sort.Search(len(rates), func(i int) bool { return rates[i].from.After(day) })
+	if i == 0 {
+		return nil, fmt.Errorf("no %s rate on or before %s", currency, day.Format("2006-01-02"))
+	}
+	return rates[i-1].rate, nil
+}
+
+// Currencies returns the currencies that have rates, sorted
+func (fx *FXRates) Currencies() []string {
+	if fx == nil {
+		return nil
+	}
+	currencies := make([]string, 0, len(fx.rates))
+	for currency := range fx.rates {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	return currencies
+}
+
+// validateMoneyConfig checks the rounding mode and minor unit overrides
+func validateMoneyConfig(cfg CurrencyConfig) error {
+	if _, err := money.ParseRounding(cfg.Rounding); err != nil {
+		return fmt.Errorf("currency.rounding: %w", err)
+	}
+	for code, units := range cfg.MinorUnits {
+		if units < 0 || units > 4 {
+			return fmt.Errorf("currency.minor_units.%s must be between 0 and 4, got %d", code, units)
+		}
+	}
+	return nil
+}
+
+func normalizeCurrency(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// resolveCurrency picks a record's currency: its own, else the default
+// currency, else the reporting currency. It may be empty when none of these
+// is configured.
+func (fc *FormatConverter) resolveCurrency(currency string) (string, error) {
+	cfg := fc.config.Currency
+
+	currency = normalizeCurrency(currency)
+	if currency == "" {
+		currency = normalizeCurrency(cfg.DefaultCurrency)
+	}
+	if currency == "" {
+		currency = normalizeCurrency(cfg.ReportingCurrency)
+	}
+
+	if currency != "" && len(fc.config.CurrencyFormats) > 0 && !containsFold(fc.config.CurrencyFormats, currency) {
+		return "", fieldErrorf("currency", "unsupported currency %q", currency)
+	}
+	return currency, nil
+}
+
+// convertCurrency converts the price of a parsed record to the reporting
+// currency. The source price and currency stay in the Original fields.
+func (fc *FormatConverter) convertCurrency(tx *models.Transaction) error {
+	cfg := fc.config.Currency
+	currency := tx.OriginalCurrency
+
+	reporting := normalizeCurrency(cfg.ReportingCurrency)
+	if reporting == "" || currency == reporting {
+		return nil
+	}
+
+	rate, err := cfg.Rates.Rate(currency, tx.TxTime)
+	if err != nil {
+		return &FieldError{Field: "currency", Err: fmt.Errorf("cannot convert %s to %s: %w", currency, reporting, err)}
+	}
+
+	// Minor units of the source currency -> major units -> reporting minor units
+	amount := new(big.Rat).Quo(new(big.Rat).SetInt64(tx.OriginalUnitPriceCents), fc.priceScale(currency))
+	amount.Mul(amount, rate)
+	converted, _, err := money.ToMinor(amount, fc.priceScale(reporting), fc.rounding())
+	if err != nil {
+		return &FieldError{Field: "currency", Err: fmt.Errorf("cannot convert %s to %s: %w", currency, reporting, err)}
+	}
+
+	tx.UnitPriceCents = converted
+	tx.Currency = reporting
+	return nil
+}
+
+// rounding returns the configured rounding mode. Invalid modes are reported
+// when the configuration is loaded.
+func (fc *FormatConverter) rounding() money.Rounding {
+	mode, err := money.ParseRounding(fc.config.Currency.Rounding)
+	if err != nil {
+		return money.DefaultRounding
+	}
+	return mode
+}
+
+// priceUnits returns the number of decimal places prices in currency are
+// kept with. Records without a currency use the places implied by
+// PriceMultiplier (100 -> 2).
+func (fc *FormatConverter) priceUnits(currency string) int {
+	if currency == "" {
+		units := 0
+		for m := fc.config.PriceMultiplier; m >= 10; m /= 10 {
+			units++
+		}
+		return units
+	}
+	for code, units := range fc.config.Currency.MinorUnits {
+		if normalizeCurrency(code) == currency {
+			return units
+		}
+	}
+	return money.MinorUnits(currency)
+}
+
+// priceScale returns the number of stored units per major unit of currency
+func (fc *FormatConverter) priceScale(currency string) *big.Rat {
+	if currency == "" && fc.config.PriceMultiplier > 0 {
+		return new(big.Rat).SetFloat64(fc.config.PriceMultiplier)
+	}
+	return money.Scale(fc.priceUnits(currency))
+}
+
+// formatPrice renders a stored price in major units, e.g. 1999 USD as "19.99"
+// and 1500 JPY as "1500"
+func (fc *FormatConverter) formatPrice(price int64, currency string) string {
+	return money.Format(price, fc.priceUnits(currency))
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,155 +1,348 @@
 package transform
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
+	"maps"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/quarantine"
+)
+
+const (
+	// defaultBatchSize is the streaming chunk size used when none is configured
+	defaultBatchSize = 10000
+
+	// maxResultMessages caps the warnings kept on a TransformationResult so
+	// that long streams with many bad records do not grow without bound
+	maxResultMessages = 1000
 )
 
 // FlexibleDataHandler provides comprehensive data handling capabilities
 type FlexibleDataHandler struct {
-	engine    *DataTransformationEngine
-	converter *FormatConverter
-	config    TransformConfig
+	engine     *DataTransformationEngine
+	converter  *FormatConverter
+	config     TransformConfig
+	quarantine *quarantine.Writer
+
+	// tracer decides which records carry their source text; nil means they
+	// all do. rawKept counts the records emitted with it.
+	tracer  LineageTracer
+	rawKept int
+
+	// mu serializes processing, as validators keep state across records
+	mu sync.Mutex
 }
 
 // NewFlexibleDataHandler creates a new flexible data handler
 func NewFlexibleDataHandler(config TransformConfig) *FlexibleDataHandler {
-	return &FlexibleDataHandler{
+	fdh := &FlexibleDataHandler{
 		engine:    NewDataTransformationEngine(config),
 		converter: NewFormatConverter(config),
 		config:    config,
 	}
+
+	if config.Quarantine.Path != "" {
+		writer, err := quarantine.New(config.Quarantine.Path, config.Quarantine.Format)
+		if err != nil {
+			log.Printf("Quarantine disabled: %v", err)
+		} else {
+			fdh.quarantine = writer
+		}
+	}
+
+	return fdh
 }
 
-// ProcessDataFile processes a data file with automatic format detection and transformation
-func (fdh *FlexibleDataHandler) ProcessDataFile(filePath string) ([]models.Transaction, *TransformationResult, error) {
-	// Open file
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to open file %s: %w", filePath, err)
+// Reconfigure switches the handler to a new configuration. Processing in
+// progress finishes with the old one. The transaction IDs seen so far are
+// kept, so duplicates of earlier records are still reported, and a changed
+// quarantine sink is opened anew.
+func (fdh *FlexibleDataHandler) Reconfigure(config TransformConfig) {
+	engine := NewDataTransformationEngine(config)
+	converter := NewFormatConverter(config)
+
+	fdh.mu.Lock()
+	defer fdh.mu.Unlock()
+
+	if old, u := fdh.engine.uniqueness(), engine.uniqueness(); old != nil && u != nil {
+		u.seenIDs, u.run = old.seenIDs, old.run
 	}
-	defer file.Close()
 
-	// Detect format based on file extension and content
-	format, err := fdh.detectFileFormat(filePath, file)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to detect file format: %w", err)
+	if config.Quarantine != fdh.config.Quarantine {
+		if fdh.quarantine != nil {
+			fdh.quarantine.Close()
+			fdh.quarantine = nil
+		}
+		if config.Quarantine.Path != "" {
+			writer, err := quarantine.New(config.Quarantine.Path, config.Quarantine.Format)
+			if err != nil {
+				log.Printf("Quarantine disabled: %v", err)
+			} else {
+				fdh.quarantine = writer
+			}
+		}
 	}
 
-	log.Printf("Processing file %s with detected format: %s", filePath, format)
+	fdh.engine = engine
+	fdh.converter = converter
+	fdh.config = config
+}
 
-	// Reset file position for reading
-	file.Seek(0, 0)
+// LineageTracer keeps the lineage of the first transactions it is given,
+// such as a metrics.Aggregator
+type LineageTracer interface {
+	// Tracing reports whether the lineage of the next transaction is kept
+	Tracing() bool
+	// LineageLimit returns how many transactions the lineage is kept of
+	LineageLimit() int
+}
 
-	// Convert to transactions using the appropriate format handler
-	transactions, err := fdh.converter.ConvertToTransactions(file, format)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to convert data: %w", err)
-	}
+// SetRawLineage sets the tracer that processed records are handed to.
+// Only transactions whose lineage is traced need their source text in
+// Lineage.Raw, and it takes about as much memory as the record itself, so
+// records carry it while the tracer is tracing and until the handler has
+// emitted LineageLimit records with it. The count covers records collected
+// before they reach the tracer, as ProcessDataFile does, and records of runs
+// that fail are not counted. By default the text is always kept.
+func (fdh *FlexibleDataHandler) SetRawLineage(tracer LineageTracer) {
+	fdh.mu.Lock()
+	defer fdh.mu.Unlock()
+	fdh.tracer = tracer
+	fdh.rawKept = 0
+}
 
-	// Apply transformations and optimizations
-	result := &TransformationResult{
-		OriginalRecords:    len(transactions),
-		TransformedRecords: len(transactions),
-		Errors:             make([]string, 0),
-		Warnings:           make([]string, 0),
-		Transformations:    make([]string, 0),
+// rawLeft returns how many more records may carry their source text. fdh.mu
+// is held.
+func (fdh *FlexibleDataHandler) rawLeft() int {
+	switch {
+	case fdh.tracer == nil:
+		return math.MaxInt
+	case !fdh.tracer.Tracing():
+		return 0
 	}
+	return max(fdh.tracer.LineageLimit()-fdh.rawKept, 0)
+}
 
-	startTime := time.Now()
+// Quarantine returns the writer rejected and defaulted rows are sent to, or
+// nil when no quarantine path is configured
+func (fdh *FlexibleDataHandler) Quarantine() *quarantine.Writer {
+	return fdh.quarantine
+}
 
-	// Apply transformations to each transaction
+// ProcessDataFile processes a data file with automatic format detection and transformation
+func (fdh *FlexibleDataHandler) ProcessDataFile(filePath string) ([]models.Transaction, *TransformationResult, error) {
 	var transformedTransactions []models.Transaction
-	for i, tx := range transactions {
-		transformedTx := tx
 
-		// Apply all transformations
-		for _, transformation := range fdh.engine.transformations {
-			transformedData, err := transformation.Transform(&transformedTx)
-			if err != nil {
-				result.Warnings = append(result.Warnings,
-					fmt.Sprintf("Transformation %s failed for record %d: %v",
-						transformation.Name(), i, err))
-				continue
-			}
-			if newTx, ok := transformedData.(*models.Transaction); ok {
-				transformedTx = *newTx
-			}
-		}
+	result, err := fdh.ProcessDataFileStreaming(filePath, func(chunk []models.Transaction) error {
+		transformedTransactions = append(transformedTransactions, chunk...)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
 
-		// Validate if enabled
-		if fdh.config.EnableValidation {
-			for _, validator := range fdh.engine.validators {
-				if err := validator.Validate(&transformedTx); err != nil {
-					result.Warnings = append(result.Warnings,
-						fmt.Sprintf("Validation %s failed for record %d: %v",
-							validator.Name(), i, err))
-				}
-			}
-		}
+	return transformedTransactions, result, nil
+}
 
-		transformedTransactions = append(transformedTransactions, transformedTx)
+// ProcessDataFileStreaming processes a data file in chunks of BatchSize records.
+// Records are parsed, transformed, validated and optimized one chunk at a time
+// and each chunk is handed to sink, so memory use stays bounded regardless of
+// file size. The chunk slice is reused between calls and must not be retained.
+//
+// gzip and zstd files are decompressed on the fly and every data file of a zip
+// archive is ingested into the same dataset, with per-member counts reported
+// in TransformationResult.Members.
+func (fdh *FlexibleDataHandler) ProcessDataFileStreaming(filePath string, sink func([]models.Transaction) error) (*TransformationResult, error) {
+	// Open file
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", filePath, err)
 	}
+	defer file.Close()
 
-	// Apply optimizations if enabled
-	if fdh.config.EnableOptimization {
-		optimizedData, err := fdh.optimizeTransactions(transformedTransactions)
-		if err != nil {
-			result.Warnings = append(result.Warnings, fmt.Sprintf("Optimization failed: %v", err))
+	return fdh.ProcessReaderStreaming(filePath, file, sink)
+}
+
+// ProcessReaderStreaming is ProcessDataFileStreaming for data that does not
+// come from a path, such as an upload. The name is only used for format
+// detection by extension and in messages.
+func (fdh *FlexibleDataHandler) ProcessReaderStreaming(name string, reader io.Reader, sink func([]models.Transaction) error) (*TransformationResult, error) {
+	return fdh.ProcessReaderContext(context.Background(), name, reader, sink, nil)
+}
+
+// ProcessReaderContext is ProcessReaderStreaming for long-running work. It
+// stops at the next record once ctx is cancelled, returning ctx's error, and
+// when progress is non-nil it reports the result so far after every chunk.
+// The reported result is a copy and may be retained.
+func (fdh *FlexibleDataHandler) ProcessReaderContext(ctx context.Context, name string, reader io.Reader,
+	sink func([]models.Transaction) error, progress func(TransformationResult)) (*TransformationResult, error) {
+	fdh.mu.Lock()
+	defer fdh.mu.Unlock()
+
+	run := fdh.newStreamRun(sink)
+	run.ctx = ctx
+	run.progress = progress
+	run.source = name
+	return run.execute(func() error {
+		return fdh.processSource(run, name, reader, false)
+	}, nil)
+}
+
+// Upload is a source whose records are added to data already loaded; see
+// ProcessUpload. Progress is optional.
+type Upload struct {
+	Name     string // selects the format by extension and names the source
+	Reader   io.Reader
+	Sink     func([]models.Transaction) error
+	Progress func(TransformationResult)
+
+	// Commit is called once the whole source has been processed, with the
+	// handler still held. The records count as loaded only if it returns
+	// nil.
+	Commit func(*TransformationResult) error
+}
+
+// ProcessUpload processes a source whose records are added to data already
+// loaded, such as an upload to the running server. It works like
+// ProcessReaderContext, except that records whose transaction ID an earlier
+// run has seen are skipped and counted in AlreadyLoaded, so that a source
+// submitted twice is only loaded once. This relies on the
+// UniquenessValidator stage. When processing or Commit fails, the IDs the
+// run has seen are forgotten again, so the source can be submitted again.
+func (fdh *FlexibleDataHandler) ProcessUpload(ctx context.Context, upload Upload) (*TransformationResult, error) {
+	fdh.mu.Lock()
+	defer fdh.mu.Unlock()
+
+	run := fdh.newStreamRun(upload.Sink)
+	run.ctx = ctx
+	run.progress = upload.Progress
+	run.source = upload.Name
+	run.dropLoaded = true
+	return run.execute(func() error {
+		return fdh.processSource(run, upload.Name, upload.Reader, false)
+	}, upload.Commit)
+}
+
+// ProcessDataStreamChunked is the streaming counterpart of ProcessDataStream.
+// See ProcessDataFileStreaming for the chunking contract.
+func (fdh *FlexibleDataHandler) ProcessDataStreamChunked(reader io.Reader, format DataFormat, sink func([]models.Transaction) error) (*TransformationResult, error) {
+	fdh.mu.Lock()
+	defer fdh.mu.Unlock()
+
+	run := fdh.newStreamRun(sink)
+	run.source = "stream"
+	return run.execute(func() error {
+		return run.consume(reader, format)
+	}, nil)
+}
+
+// applyPipeline runs all transformations and, if enabled, all validators on a
+// single record whose unmapped source columns are given by name. Each failure
+// is reported to onIssue with the name of the failing stage, and each
+// transformation that changes the record is added to its lineage.
+func (fdh *FlexibleDataHandler) applyPipeline(tx models.Transaction, columns map[string]string, index int, onIssue func(stage, message string, err error)) models.Transaction {
+	transformedTx := tx
+
+	// Apply all transformations
+	for _, transformation := range fdh.engine.transformations {
+		before := transformedTx
+		var transformedData interface{}
+		var err error
+		if st, ok := transformation.(sourceTransformation); ok {
+			transformedData, err = st.TransformSource(&transformedTx, columns)
 		} else {
-			transformedTransactions = optimizedData
-			result.TransformedRecords = len(transformedTransactions)
+			transformedData, err = transformation.Transform(&transformedTx)
+		}
+		if err != nil {
+			onIssue(transformation.Name(), fmt.Sprintf("Transformation %s failed for record %d: %v",
+				transformation.Name(), index, err), err)
+		}
+		// A failing transformation may still return the part it completed
+		if newTx, ok := transformedData.(*models.Transaction); ok {
+			transformedTx = *newTx
+		}
+		if recordChanged(&before, &transformedTx) {
+			transformedTx.Lineage.Transformations = append(transformedTx.Lineage.Transformations, transformation.Name())
 		}
 	}
 
-	// Record applied transformations
-	for _, t := range fdh.engine.transformations {
-		result.Transformations = append(result.Transformations, t.Name())
+	// Validate if enabled
+	if fdh.config.EnableValidation {
+		for _, validator := range fdh.engine.validators {
+			if err := validator.Validate(&transformedTx); err != nil {
+				onIssue(validator.Name(), fmt.Sprintf("Validation %s failed for record %d: %v",
+					validator.Name(), index, err), err)
+			}
+		}
 	}
 
-	// Calculate data quality metrics
-	result.DataQuality = fdh.engine.calculateDataQuality(transformedTransactions)
-	result.ProcessingTime = time.Since(startTime)
-
-	log.Printf("Data processing completed: %d records processed in %v with %.2f%% quality score",
-		len(transformedTransactions), result.ProcessingTime, result.DataQuality.Completeness*100)
+	return transformedTx
+}
 
-	return transformedTransactions, result, nil
+// recordChanged reports whether a transformation changed any field of a
+// record other than its lineage
+func recordChanged(before, after *models.Transaction) bool {
+	return before.ID != after.ID ||
+		before.Country != after.Country ||
+		before.CountryCode != after.CountryCode ||
+		before.Continent != after.Continent ||
+		before.Region != after.Region ||
+		before.ProductName != after.ProductName ||
+		before.UnitPriceCents != after.UnitPriceCents ||
+		before.Quantity != after.Quantity ||
+		!before.TxTime.Equal(after.TxTime) ||
+		before.TxTime.Location() != after.TxTime.Location() ||
+		before.Currency != after.Currency ||
+		before.OriginalCurrency != after.OriginalCurrency ||
+		before.OriginalUnitPriceCents != after.OriginalUnitPriceCents ||
+		!maps.Equal(before.Attributes, after.Attributes)
 }
 
-// detectFileFormat detects the format of a data file
-func (fdh *FlexibleDataHandler) detectFileFormat(filePath string, file *os.File) (DataFormat, error) {
+// detectFileFormat detects the format of a data file from its name and, when
+// the extension is not conclusive, its leading bytes. A .json file whose
+// content is JSON Lines is read as JSON Lines, as such files are common.
+func (fdh *FlexibleDataHandler) detectFileFormat(filePath string, header []byte) DataFormat {
 	// Check file extension first
-	ext := strings.ToLower(filepath.Ext(filePath))
-	switch ext {
+	if format, ok := formatFromExtension(filePath); ok {
+		if format == FormatJSON && fdh.converter.DetectFormat(header) == FormatNDJSON {
+			return FormatNDJSON
+		}
+		return format
+	}
+
+	// Use format converter's detection logic
+	return fdh.converter.DetectFormat(header)
+}
+
+// formatFromExtension maps a file name's extension to its data format
+func formatFromExtension(name string) (DataFormat, bool) {
+	switch strings.ToLower(filepath.Ext(name)) {
 	case ".csv":
-		return FormatCSV, nil
+		return FormatCSV, true
 	case ".tsv":
-		return FormatTSV, nil
+		return FormatTSV, true
 	case ".json":
-		return FormatJSON, nil
+		return FormatJSON, true
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON, true
 	case ".yaml", ".yml":
-		return FormatYAML, nil
+		return FormatYAML, true
 	case ".xml":
-		return FormatXML, nil
+		return FormatXML, true
+	case ".xlsx":
+		return FormatXLSX, true
 	}
-
-	// Read first few bytes to analyze content
-	buffer := make([]byte, 1024)
-	n, err := file.Read(buffer)
-	if err != nil && err != io.EOF {
-		return "", fmt.Errorf("failed to read file content: %w", err)
-	}
-
-	// Use format converter's detection logic
-	return fdh.converter.DetectFormat(buffer[:n]), nil
+	return "", false
 }
 
 // optimizeTransactions applies optimization strategies
@@ -173,23 +366,16 @@ func (fdh *FlexibleDataHandler) optimizeTransactions(transactions []models.Trans
 
 // ProcessDataStream processes data from a stream with specified format
 func (fdh *FlexibleDataHandler) ProcessDataStream(reader io.Reader, format DataFormat) ([]models.Transaction, *TransformationResult, error) {
-	// Convert to transactions
-	transactions, err := fdh.converter.ConvertToTransactions(reader, format)
+	var transactions []models.Transaction
+
+	result, err := fdh.ProcessDataStreamChunked(reader, format, func(chunk []models.Transaction) error {
+		transactions = append(transactions, chunk...)
+		return nil
+	})
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to convert stream data: %w", err)
 	}
 
-	// Create transformation result
-	result := &TransformationResult{
-		OriginalRecords:    len(transactions),
-		TransformedRecords: len(transactions),
-		Errors:             make([]string, 0),
-		Warnings:           make([]string, 0),
-		Transformations:    make([]string, 0),
-		ProcessingTime:     time.Since(time.Now()),
-		DataQuality:        fdh.engine.calculateDataQuality(transactions),
-	}
-
 	return transactions, result, nil
 }
 
@@ -201,13 +387,20 @@ func (fdh *FlexibleDataHandler) ExportTransactions(transactions []models.Transac
 // GetDataQualityReport generates a comprehensive data quality report
 func (fdh *FlexibleDataHandler) GetDataQualityReport(transactions []models.Transaction) DataQualityReport {
 	metrics := fdh.engine.calculateDataQuality(transactions)
+	violations, ruleIssues := fdh.checkRules(transactions)
+
+	issues := fdh.identifyDataQualityIssues(transactions)
+	if issue, found := unmappedCountryIssue(metrics); found {
+		issues = append(issues, issue)
+	}
 
 	return DataQualityReport{
 		Metrics:         metrics,
 		TotalRecords:    len(transactions),
 		Timestamp:       time.Now(),
-		Issues:          fdh.identifyDataQualityIssues(transactions),
+		Issues:          append(issues, ruleIssues...),
 		Recommendations: fdh.generateRecommendations(transactions, metrics),
+		RuleViolations:  violations,
 	}
 }
 
@@ -218,6 +411,7 @@ type DataQualityReport struct {
 	Timestamp       time.Time          `json:"timestamp"`
 	Issues          []DataQualityIssue `json:"issues"`
 	Recommendations []string           `json:"recommendations"`
+	RuleViolations  map[string]int     `json:"rule_violations,omitempty"` // rule name -> records breaking it
 }
 
 // DataQualityIssue represents a specific data quality issue
@@ -350,6 +544,94 @@ func (fdh *FlexibleDataHandler) identifyDataQualityIssues(transactions []models.
 	return issues
 }
 
+// unmappedCountryIssue reports the country values that are not in the ISO
+// table, most frequent first
+func unmappedCountryIssue(metrics DataQualityMetrics) (DataQualityIssue, bool) {
+	if len(metrics.UnmappedCountries) == 0 {
+		return DataQualityIssue{}, false
+	}
+
+	total := 0
+	countries := make([]string, 0, len(metrics.UnmappedCountries))
+	for country, count := range metrics.UnmappedCountries {
+		total += count
+		countries = append(countries, country)
+	}
+	sort.Slice(countries, func(i, j int) bool {
+		ci, cj := metrics.UnmappedCountries[countries[i]], metrics.UnmappedCountries[countries[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return countries[i] < countries[j]
+	})
+
+	var examples []string
+	for _, country := range countries {
+		if len(examples) == 5 {
+			break
+		}
+		examples = append(examples, fmt.Sprintf("%s (%d)", country, metrics.UnmappedCountries[country]))
+	}
+
+	return DataQualityIssue{
+		Type: "unmapped_country",
+		Description: fmt.Sprintf("Unrecognized country in %d records (%d values): %s",
+			total, len(countries), strings.Join(examples, ", ")),
+		Severity: "medium",
+		Count:    total,
+		Examples: examples,
+	}, true
+}
+
+// checkRules counts the records breaking each rule of the pipeline's
+// RuleValidator, if it has one. Records rejected by a rule while processing
+// are no longer among the transactions.
+func (fdh *FlexibleDataHandler) checkRules(transactions []models.Transaction) (map[string]int, []DataQualityIssue) {
+	var rules *RuleValidator
+	for _, validator := range fdh.engine.validators {
+		if v, ok := validator.(*RuleValidator); ok {
+			rules = v
+		}
+	}
+	if rules == nil {
+		return nil, nil
+	}
+
+	counts := make(map[string]int)
+	examples := make(map[string][]string)
+	for i := range transactions {
+		for _, v := range rules.Violations(&transactions[i]) {
+			counts[v.Rule]++
+			if len(examples[v.Rule]) < 3 {
+				examples[v.Rule] = append(examples[v.Rule], transactions[i].ID)
+			}
+		}
+	}
+
+	var issues []DataQualityIssue
+	for _, rule := range rules.Rules() {
+		count := counts[rule.Name]
+		if count == 0 {
+			continue
+		}
+		severity := "low"
+		switch rule.Severity {
+		case SeverityReject:
+			severity = "high"
+		case SeverityFlag:
+			severity = "medium"
+		}
+		issues = append(issues, DataQualityIssue{
+			Type:        "rule_violation",
+			Description: fmt.Sprintf("Rule %s broken by %d records: %s", rule.Name, count, rule.Message),
+			Severity:    severity,
+			Count:       count,
+			Examples:    examples[rule.Name],
+		})
+	}
+	return counts, issues
+}
+
 // generateRecommendations provides recommendations for improving data quality
 func (fdh *FlexibleDataHandler) generateRecommendations(transactions []models.Transaction, metrics DataQualityMetrics) []string {
 	var recommendations []string
@@ -400,12 +682,17 @@ func (fdh *FlexibleDataHandler) GetSupportedFormats() map[string]interface{} {
 			string(FormatTSV),
 			string(FormatJSON),
 			string(FormatYAML),
+			string(FormatXML),
+			string(FormatNDJSON),
+			string(FormatXLSX),
 		},
 		"output_formats": []string{
 			string(FormatCSV),
 			string(FormatTSV),
 			string(FormatJSON),
 			string(FormatYAML),
+			string(FormatXML),
+			string(FormatNDJSON),
 		},
 		"transformations": fdh.engine.GetSupportedFormats(),
 		"quality_checks": []string{
@@ -0,0 +1,605 @@
+package transform
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"abt-dashboard/internal/metrics"
+	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/quarantine"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const sampleCSV = `transaction_id,transaction_date,country,region,product_name,price,quantity
+tx-001,2024-01-15,USA,n,widget a,25.00,2
+tx-002,2024-01-16,UK,s,gadget b,10.50,1
+tx-003,not-a-date,UK,s,gadget b,10.50,1
+tx-004,2024-02-01,Sri Lanka,Western,widget a,25.00,3
+tx-002,2024-01-16,UK,s,gadget b,10.50,1
+tx-005,2024-02-03,India,e,tool c,5.00,10
+`
+
+func newTestHandler(batchSize int) *FlexibleDataHandler {
+	config := NewConfigLoader("does-not-exist.yaml").getDefaultConfig()
+	config.BatchSize = batchSize
+	return NewFlexibleDataHandler(config)
+}
+
+func TestProcessDataStreamChunked(t *testing.T) {
+	var chunkSizes []int
+	var streamed []models.Transaction
+
+	handler := newTestHandler(2)
+	result, err := handler.ProcessDataStreamChunked(strings.NewReader(sampleCSV), FormatCSV,
+		func(chunk []models.Transaction) error {
+			chunkSizes = append(chunkSizes, len(chunk))
+			streamed = append(streamed, chunk...)
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, size := range chunkSizes {
+		if size > 2 {
+			t.Errorf("chunk exceeded batch size: got %d want <= 2", size)
+		}
+	}
+
+	if result.OriginalRecords != 6 {
+		t.Errorf("original records: got %d want 6", result.OriginalRecords)
+	}
+	if result.SkippedRecords != 1 {
+		t.Errorf("skipped records: got %d want 1", result.SkippedRecords)
+	}
+
+	// The duplicate tx-002 lands in a later chunk and must still be removed
+	if result.TransformedRecords != 4 || len(streamed) != 4 {
+		t.Errorf("transformed records: got %d (%d streamed) want 4",
+			result.TransformedRecords, len(streamed))
+	}
+	if result.DataQuality.Uniqueness != 1 {
+		t.Errorf("uniqueness: got %v want 1", result.DataQuality.Uniqueness)
+	}
+}
+
+func TestProcessDataStreamMatchesChunked(t *testing.T) {
+	transactions, full, err := newTestHandler(1000).ProcessDataStream(strings.NewReader(sampleCSV), FormatCSV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	chunked, err := newTestHandler(1).ProcessDataStreamChunked(strings.NewReader(sampleCSV), FormatCSV,
+		func([]models.Transaction) error { return nil })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(transactions) != chunked.TransformedRecords {
+		t.Errorf("record count: got %d want %d", chunked.TransformedRecords, len(transactions))
+	}
+	if full.DataQuality.Completeness != chunked.DataQuality.Completeness {
+		t.Errorf("completeness: got %v want %v",
+			chunked.DataQuality.Completeness, full.DataQuality.Completeness)
+	}
+	if transactions[0].Country != "United States" {
+		t.Errorf("transformations not applied: got country %q", transactions[0].Country)
+	}
+}
+
+func gzipBytes(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(data))
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessReaderContextProgressAndCancel(t *testing.T) {
+	handler := newTestHandler(2)
+	var progress []int
+	result, err := handler.ProcessReaderContext(context.Background(), "sample.csv", strings.NewReader(sampleCSV),
+		func([]models.Transaction) error { return nil },
+		func(partial TransformationResult) { progress = append(progress, partial.OriginalRecords) })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(progress) < 2 || progress[len(progress)-1] != result.OriginalRecords {
+		t.Errorf("progress: got %v, final %d records", progress, result.OriginalRecords)
+	}
+
+	// Cancelling from the sink stops before the remaining records
+	ctx, cancel := context.WithCancel(context.Background())
+	chunks := 0
+	_, err = handler.ProcessReaderContext(ctx, "sample.csv", strings.NewReader(sampleCSV),
+		func([]models.Transaction) error {
+			chunks++
+			cancel()
+			return nil
+		}, nil)
+	if !errors.Is(err, context.Canceled) || chunks != 1 {
+		t.Errorf("cancel: got error %v after %d chunks", err, chunks)
+	}
+}
+
+func TestProcessUpload(t *testing.T) {
+	handler := newTestHandler(2)
+	upload := func(ctx context.Context, commit func(*TransformationResult) error) (*TransformationResult, int, error) {
+		kept := 0
+		result, err := handler.ProcessUpload(ctx, Upload{
+			Name:   "sample.csv",
+			Reader: strings.NewReader(sampleCSV),
+			Sink: func(chunk []models.Transaction) error {
+				kept += len(chunk)
+				return nil
+			},
+			Commit: commit,
+		})
+		return result, kept, err
+	}
+	accept := func(*TransformationResult) error { return nil }
+
+	// Neither a refused commit nor a cancelled run keeps the IDs it has seen
+	refused := errors.New("refused")
+	if _, _, err := upload(context.Background(), func(*TransformationResult) error { return refused }); err != refused {
+		t.Fatalf("commit: got error %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := upload(ctx, accept); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancel: got error %v", err)
+	}
+
+	result, kept, err := upload(context.Background(), accept)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.AlreadyLoaded != 0 || kept != result.TransformedRecords || kept == 0 {
+		t.Errorf("first upload: got %+v with %d kept", result, kept)
+	}
+
+	// Records of a committed upload are skipped when they come again
+	result, kept, err = upload(context.Background(), accept)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.AlreadyLoaded != 5 || kept != 0 || result.SkippedRecords != 6 || len(result.Warnings) != 0 {
+		t.Errorf("second upload: got %+v with %d kept", result, kept)
+	}
+}
+
+func TestProcessDataFileGzip(t *testing.T) {
+	// The name hides the compression, so it must be found by magic bytes
+	path := filepath.Join(t.TempDir(), "sales.csv")
+	if err := os.WriteFile(path, gzipBytes(t, sampleCSV), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	transactions, result, err := newTestHandler(1000).ProcessDataFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transactions) != 4 || result.OriginalRecords != 6 {
+		t.Errorf("got %d transactions from %d records, want 4 from 6", len(transactions), result.OriginalRecords)
+	}
+	if len(result.Members) != 0 {
+		t.Errorf("plain compressed file should not report members: %+v", result.Members)
+	}
+}
+
+func TestProcessDataFileZipArchive(t *testing.T) {
+	ndjson := `{"transaction_id": "z1", "country": "USA", "product_name": "Kit", "price": 4, "quantity": 1, "transaction_date": "2024-06-01"}
+{"transaction_id": "tx-001", "country": "USA", "product_name": "Kit", "price": 4, "quantity": 1, "transaction_date": "2024-06-01"}
+`
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	members := map[string][]byte{
+		"2024/jan.csv":       []byte(sampleCSV),
+		"2024/feb.ndjson.gz": gzipBytes(t, ndjson),
+		"__MACOSX/._jan.csv": []byte("junk"),
+		"2024/.DS_Store":     []byte("junk"),
+		"README.txt":         []byte("Monthly sales, one file per month\n"),
+	}
+	for _, name := range []string{"README.txt", "2024/jan.csv", "2024/feb.ndjson.gz", "__MACOSX/._jan.csv", "2024/.DS_Store"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(members[name])
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "monthly.zip")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	transactions, result, err := newTestHandler(2).ProcessDataFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result.Members) != 2 {
+		t.Fatalf("members: got %+v want 2 entries", result.Members)
+	}
+	jan, feb := result.Members[0], result.Members[1]
+	if jan.Format != FormatCSV || jan.OriginalRecords != 6 || jan.TransformedRecords != 4 || jan.SkippedRecords != 1 {
+		t.Errorf("unexpected csv member: %+v", jan)
+	}
+	// tx-001 was already loaded from the csv member
+	if feb.Name != path+"/2024/feb.ndjson" || feb.Format != FormatNDJSON || feb.TransformedRecords != 1 {
+		t.Errorf("unexpected ndjson member: %+v", feb)
+	}
+	if len(transactions) != 5 || result.TransformedRecords != 5 {
+		t.Errorf("transactions: got %d (result %d) want 5", len(transactions), result.TransformedRecords)
+	}
+}
+
+func TestProcessDataFileArchiveWithoutDataFiles(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("transaction_id,quantity\n"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = newTestHandler(2).ProcessReaderStreaming("docs.zip", bytes.NewReader(buf.Bytes()),
+		func([]models.Transaction) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "contains no data files") {
+		t.Errorf("got %v, want no data files error", err)
+	}
+}
+
+func TestProcessDataFileZstd(t *testing.T) {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "sales.csv.zst")
+	if err := os.WriteFile(path, encoder.EncodeAll([]byte(sampleCSV), nil), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	transactions, _, err := newTestHandler(1000).ProcessDataFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transactions) != 4 {
+		t.Errorf("transactions: got %d want 4", len(transactions))
+	}
+}
+
+func TestProcessDataFileLongJSONLines(t *testing.T) {
+	// Every record is longer than the detection sample
+	var lines []string
+	for _, id := range []string{"long-1", "long-2"} {
+		record, err := json.Marshal(map[string]interface{}{
+			"transaction_id": id, "transaction_date": "2024-03-01", "country": "USA",
+			"product_name": "Widget", "price": "9.99", "quantity": 1,
+			"notes": strings.Repeat("x", 3*sniffSize),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(record))
+	}
+	ndjson := strings.Join(lines, "\n") + "\n"
+	document := `{"transactions": [` + strings.Join(lines, ", ") + "]}\n"
+
+	// The names have no data extension, so the format is detected
+	dir := t.TempDir()
+	for name, content := range map[string][]byte{
+		"export":     []byte(ndjson),
+		"export.gz":  gzipBytes(t, ndjson),
+		"document":   []byte(document),
+		"whitespace": []byte("\n  " + ndjson),
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		transactions, _, err := newTestHandler(1000).ProcessDataFile(path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		if len(transactions) != 2 {
+			t.Errorf("%s: got %d transactions, want 2", name, len(transactions))
+		}
+	}
+}
+
+func TestQuarantineRecordsRejectedAndDefaultedRows(t *testing.T) {
+	input := `transaction_id,transaction_date,country,region,product_name,price,quantity
+tx-001,2024-01-15,USA,n,"widget
+a",25.00,2
+tx-002,not-a-date,UK,s,"gadget, b",10.50,1
+tx-003,2024-02-01,UK,,gadget b,10.50,1
+`
+	path := filepath.Join(t.TempDir(), "quarantine.ndjson")
+
+	config := NewConfigLoader("does-not-exist.yaml").getDefaultConfig()
+	config.Quarantine = QuarantineConfig{Path: path}
+	handler := NewFlexibleDataHandler(config)
+
+	result, err := handler.ProcessReaderStreaming("sales.csv", strings.NewReader(input),
+		func([]models.Transaction) error { return nil })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("quarantine file not written: %v", err)
+	}
+
+	var entries []quarantine.Entry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry quarantine.Entry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid quarantine line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if result.QuarantinedEntries != len(entries) {
+		t.Errorf("quarantined entries: got %d, file has %d", result.QuarantinedEntries, len(entries))
+	}
+
+	find := func(action string, line int) *quarantine.Entry {
+		for i := range entries {
+			if entries[i].Action == action && entries[i].Line == line {
+				return &entries[i]
+			}
+		}
+		t.Fatalf("no %s entry for line %d in %+v", action, line, entries)
+		return nil
+	}
+
+	// Lines are file lines: the header is line 1 and tx-001 spans two
+	rejected := find(quarantine.ActionRejected, 4)
+	if rejected.Source != "sales.csv" || rejected.Column != "transaction_date" || rejected.Stage != stageParser {
+		t.Errorf("rejected entry: got %+v", rejected)
+	}
+	if want := `tx-002,not-a-date,UK,s,"gadget, b",10.50,1`; rejected.Raw != want {
+		t.Errorf("rejected raw row: got %q want %q", rejected.Raw, want)
+	}
+
+	defaulted := find(quarantine.ActionDefaulted, 5)
+	if defaulted.Column != "region" {
+		t.Errorf("defaulted column: got %q want region", defaulted.Column)
+	}
+}
+
+func TestTimezonesAndReportingMonths(t *testing.T) {
+	handler := newTestHandler(10)
+	handler.config.Timezone = TimezoneConfig{
+		Reporting: "Asia/Tokyo",
+		Source:    "America/New_York",
+		Datasets:  map[string]string{"apac_*.csv": "Asia/Singapore"},
+	}
+	handler.converter = NewFormatConverter(handler.config)
+
+	input := `transaction_id,transaction_date,product_name,price,tz
+t1,2024-01-31,Widget,1.00,
+t2,2024-01-31T20:00:00Z,Widget,1.00,
+t3,2024-01-31 23:30:00,Widget,1.00,+01:00
+t4,2024-01-31,Widget,1.00,Mars/Olympus
+`
+	collect := func(name string) []models.Transaction {
+		var out []models.Transaction
+		_, err := handler.ProcessReaderStreaming(name, strings.NewReader(input), func(chunk []models.Transaction) error {
+			out = append(out, chunk...)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		return out
+	}
+
+	want := map[string][]string{
+		"us_sales.csv":   {"2024-01-31T05:00:00Z", "2024-01-31T20:00:00Z", "2024-01-31T22:30:00Z"},
+		"apac_sales.csv": {"2024-01-30T16:00:00Z", "2024-01-31T20:00:00Z", "2024-01-31T22:30:00Z"},
+	}
+	for name, times := range want {
+		transactions := collect(name)
+		if len(transactions) != len(times) {
+			t.Fatalf("%s: got %d transactions want %d", name, len(transactions), len(times))
+		}
+		for i, ts := range times {
+			if got := transactions[i].TxTime.UTC().Format(time.RFC3339); got != ts {
+				t.Errorf("%s %s: got %s want %s", name, transactions[i].ID, got, ts)
+			}
+		}
+	}
+
+	// In Tokyo the two timed sales already fall on 1 February
+	agg := metrics.NewAggregatorInLocation(handler.config.Timezone.ReportingLocation())
+	agg.AddTransactions(collect("us_sales.csv"))
+	months := agg.SalesByMonth()
+	if len(months) != 2 || months[0].YearMonth != "2024-01" || months[0].TxCount != 1 ||
+		months[1].YearMonth != "2024-02" || months[1].TxCount != 2 {
+		t.Errorf("months: got %+v", months)
+	}
+}
+
+func TestConfiguredPipeline(t *testing.T) {
+	handler := newTestHandler(2)
+	handler.config.Pipeline = []PipelineStage{
+		{Name: "RangeValidator", Params: map[string]interface{}{"max_quantity": 5}},
+		{Name: "StringCleaning"},
+	}
+	handler.engine = NewDataTransformationEngine(handler.config)
+
+	transactions, result, err := handler.ProcessDataStream(strings.NewReader(sampleCSV), FormatCSV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := strings.Join(result.Transformations, ","); got != "StringCleaning,RangeValidator" {
+		t.Errorf("transformations applied: got %s", got)
+	}
+
+	// Without CountryMapping names are kept, and without DuplicateRemoval
+	// the repeated tx-002 is kept
+	if len(transactions) != 5 || transactions[0].Country != "USA" {
+		t.Errorf("transactions: got %d, first %+v", len(transactions), transactions[0])
+	}
+	flagged := 0
+	for _, warning := range result.Warnings {
+		if strings.Contains(warning, "RangeValidator") {
+			flagged++
+		}
+	}
+	if flagged != 1 {
+		t.Errorf("quantity 10 should exceed max_quantity 5 once: %v", result.Warnings)
+	}
+
+	loader := NewConfigLoader("does-not-exist.yaml")
+	invalid := map[string][]PipelineStage{
+		"unknown stage":     {{Name: "Sharpen"}},
+		"unknown parameter": {{Name: "RangeValidator", Params: map[string]interface{}{"max_qty": 5}}},
+		"bad parameter":     {{Name: "RangeValidator", Params: map[string]interface{}{"max_quantity": "many"}}},
+		"duplicate stage":   {{Name: "StringCleaning"}, {Name: "StringCleaning"}},
+	}
+	for name, stages := range invalid {
+		config := loader.getDefaultConfig()
+		config.Pipeline = stages
+		if err := loader.ValidateConfig(config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestTransactionLineage(t *testing.T) {
+	handler := newTestHandler(2)
+	agg := metrics.NewAggregator()
+	result, err := handler.ProcessReaderStreaming("sales.csv", strings.NewReader(sampleCSV),
+		func(chunk []models.Transaction) error {
+			agg.AddTransactions(chunk)
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.BatchID == "" {
+		t.Fatal("result has no batch ID")
+	}
+
+	drill := agg.DrillDown(metrics.Cell{Country: "united kingdom"}, 0, 0)
+	if !drill.Complete || drill.Matched != 1 || len(drill.Records) != 1 {
+		t.Fatalf("drill-down: got %+v", drill)
+	}
+	lineage := drill.Records[0].Lineage
+	if lineage.Source != "sales.csv" || lineage.Line != 3 || lineage.BatchID != result.BatchID ||
+		lineage.Raw != "tx-002,2024-01-16,UK,s,gadget b,10.50,1" {
+		t.Errorf("lineage: got %+v", lineage)
+	}
+	// "UK" was mapped and "gadget b" title-cased; the date was already clean
+	changed := strings.Join(lineage.Transformations, ",")
+	if !strings.Contains(changed, "CountryMapping") || !strings.Contains(changed, "ProductNameNormalization") ||
+		strings.Contains(changed, "DateNormalization") {
+		t.Errorf("transformations: got %v", lineage.Transformations)
+	}
+
+	// A second batch with a different ID; only the first record is kept
+	agg.SetLineageLimit(agg.DrillDown(metrics.Cell{}, 0, 0).Matched + 1)
+	handler.SetRawLineage(agg)
+	second, err := handler.ProcessReaderStreaming("more.csv", strings.NewReader(
+		"transaction_id,transaction_date,country,product_name,price\nm-1,2024-01-20,USA,widget a,1\nm-2,2024-01-21,USA,widget a,1\n"),
+		func(chunk []models.Transaction) error {
+			agg.AddTransactions(chunk)
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	drill = agg.DrillDown(metrics.Cell{Month: "2024-01", Product: "Widget A"}, 1, 10)
+	if drill.Complete || drill.Matched != 2 || len(drill.Records) != 1 ||
+		drill.Records[0].ID != "m-1" || drill.Records[0].Lineage.BatchID != second.BatchID ||
+		drill.Records[0].Lineage.Raw != "m-1,2024-01-20,USA,widget a,1" {
+		t.Errorf("second batch: got %+v", drill)
+	}
+
+	// The aggregator is full, so records no longer carry their raw text
+	_, err = handler.ProcessReaderStreaming("late.csv", strings.NewReader(
+		"transaction_id,transaction_date,country,product_name,price\nl-1,2024-01-22,USA,widget a,1\n"),
+		func(chunk []models.Transaction) error {
+			for _, tx := range chunk {
+				if tx.Lineage.Source != "late.csv" || tx.Lineage.Raw != "" {
+					t.Errorf("lineage past the limit: got %+v", tx.Lineage)
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Collected before they are aggregated, only as many records as the
+	// aggregator traces carry their raw text, and a failed run does not
+	// use up the limit
+	collected := newTestHandler(2)
+	limited := metrics.NewAggregator()
+	limited.SetLineageLimit(3)
+	collected.SetRawLineage(limited)
+	if _, err := collected.ProcessReaderStreaming("bad.csv", strings.NewReader(sampleCSV),
+		func([]models.Transaction) error { return errors.New("sink failed") }); err == nil {
+		t.Fatal("failing sink: expected an error")
+	}
+	transactions, _, err := collected.ProcessDataStream(strings.NewReader(sampleCSV), FormatCSV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, tx := range transactions {
+		if keep := i < 3; (tx.Lineage.Raw != "") != keep {
+			t.Errorf("%s: raw %q, want it kept: %v", tx.ID, tx.Lineage.Raw, keep)
+		}
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	handler := newTestHandler(10)
+	if _, _, err := handler.ProcessDataStream(strings.NewReader(sampleCSV), FormatCSV); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config := NewConfigLoader("does-not-exist.yaml").getDefaultConfig()
+	config.CustomMappings["usa"] = "United States of America"
+	handler.Reconfigure(config)
+
+	csv := "transaction_id,transaction_date,country,product_name,price,quantity\n" +
+		"tx-001,2024-03-01,USA,widget a,25.00,1\n" +
+		"tx-100,2024-03-02,USA,widget a,25.00,1\n"
+	transactions, result, err := handler.ProcessDataStream(strings.NewReader(csv), FormatCSV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transactions) == 0 || transactions[len(transactions)-1].Country != "United States of America" {
+		t.Errorf("the new mappings were not applied: %+v", transactions)
+	}
+	// IDs seen before the reload are still duplicates
+	if !strings.Contains(strings.Join(append(result.Errors, result.Warnings...), "\n"), "duplicate transaction ID: tx-001") {
+		t.Errorf("tx-001 was not reported as a duplicate: %+v", result)
+	}
+}
@@ -0,0 +1,171 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"abt-dashboard/internal/models"
+)
+
+// DerivedField is an attribute computed for every record from an expression,
+// such as
+//
+//	price_band:     band(price, 10, 50, 100)
+//	weekend:        is_weekend(date)
+//	fiscal_quarter: fiscal_quarter(date, 4)
+//	net:            round(price * quantity * (1 - coalesce(discount, 0)), 2)
+//
+// Expressions use the language of rule checks (see Rule and exprParser):
+// numbers, 'strings', true and false combined with + - * / %, the
+// comparisons and text operators of rules, and, or, not, parentheses and the
+// functions listed in exprFuncs. Names refer to the record fields, to derived
+// fields defined earlier and otherwise to source columns that are not mapped
+// onto a field, read as text and used as numbers where arithmetic needs them;
+// column('Unit Discount') reads a column whose header is not a plain name.
+//
+// Results are stored in Transaction.Attributes as text and can be used as
+// group-by dimensions. A field whose expression fails for a record, e.g.
+// because a column it uses is empty, is left unset with a warning.
+type DerivedField struct {
+	Name string `json:"name" yaml:"name"`
+	Expr string `json:"expr" yaml:"expr"`
+}
+
+// derivedFieldName is the form of derived field names, which expressions
+// refer to as plain names
+var derivedFieldName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// DerivedFields computes the configured derived fields
+type DerivedFields struct {
+	fields  []compiledField
+	columns []string
+	prices  *FormatConverter // only used for price scales
+	loc     *time.Location
+}
+
+type compiledField struct {
+	name string
+	expr exprNode
+}
+
+// NewDerivedFields compiles config.DerivedFields
+func NewDerivedFields(config TransformConfig) (*DerivedFields, error) {
+	loc := config.Timezone.ReportingLocation()
+	d := &DerivedFields{prices: &FormatConverter{config: config}, loc: loc}
+
+	p := &exprParser{loc: loc, derived: make(map[string]bool), columns: make(map[string]bool)}
+	for _, field := range config.DerivedFields {
+		switch {
+		case !derivedFieldName.MatchString(field.Name):
+			return nil, fmt.Errorf("derived field %q: names are lower case letters, digits and underscores", field.Name)
+		case p.derived[field.Name]:
+			return nil, fmt.Errorf("derived field %s is defined more than once", field.Name)
+		case isReservedName(field.Name):
+			return nil, fmt.Errorf("derived field %s: the name is taken by a field or keyword", field.Name)
+		}
+
+		expr, err := p.parse(field.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("derived field %s: %w", field.Name, err)
+		}
+		d.fields = append(d.fields, compiledField{name: field.Name, expr: expr})
+		p.derived[field.Name] = true
+	}
+
+	for column := range p.columns {
+		d.columns = append(d.columns, column)
+	}
+	sort.Strings(d.columns)
+	return d, nil
+}
+
+// validateDerivedFields checks that every derived field compiles and that
+// the pipeline computes them
+func validateDerivedFields(fields []DerivedField, pipeline []PipelineStage) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	if _, err := NewDerivedFields(TransformConfig{DerivedFields: fields}); err != nil {
+		return fmt.Errorf("derived_fields: %w", err)
+	}
+	if len(pipeline) > 0 {
+		for _, stage := range pipeline {
+			if stage.Name == "DerivedFields" {
+				return nil
+			}
+		}
+		return fmt.Errorf("derived_fields: the pipeline has no DerivedFields stage")
+	}
+	return nil
+}
+
+// isReservedName reports whether name is a record field or keyword. Function
+// names stay available, as calls are told apart by their parentheses.
+func isReservedName(name string) bool {
+	if _, ok := recordFields[name]; ok {
+		return true
+	}
+	switch name {
+	case "now", "today", "true", "false", "and", "or", "not":
+		return true
+	}
+	return false
+}
+
+func (d *DerivedFields) Name() string {
+	return "DerivedFields"
+}
+
+func (d *DerivedFields) Description() string {
+	return "Computes the configured derived fields"
+}
+
+// Columns returns the source columns the expressions read, by name
+func (d *DerivedFields) Columns() []string {
+	return d.columns
+}
+
+func (d *DerivedFields) Transform(data interface{}) (interface{}, error) {
+	return d.TransformSource(data, nil)
+}
+
+// TransformSource computes the derived fields of a record whose unmapped
+// source columns are given by name
+func (d *DerivedFields) TransformSource(data interface{}, columns map[string]string) (interface{}, error) {
+	tx, ok := data.(*models.Transaction)
+	if !ok || len(d.fields) == 0 {
+		return data, nil
+	}
+
+	result := *tx
+	result.Attributes = make(map[string]string, len(tx.Attributes)+len(d.fields))
+	for name, value := range tx.Attributes {
+		result.Attributes[name] = value
+	}
+
+	ctx := newExprContext(tx, d.prices, d.loc)
+	ctx.columns = columns
+	ctx.derived = make(map[string]exprValue, len(d.fields))
+	var errs []error
+	for _, field := range d.fields {
+		value, err := field.expr.eval(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field.name, err))
+			continue
+		}
+		ctx.derived[field.name] = value
+		if !value.null {
+			result.Attributes[field.name] = value.String()
+		}
+	}
+	return &result, errors.Join(errs...)
+}
+
+// sourceTransformation is a Transformation that also reads source columns
+// that are not mapped onto Transaction fields
+type sourceTransformation interface {
+	TransformSource(data interface{}, columns map[string]string) (interface{}, error)
+}
@@ -0,0 +1,140 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"abt-dashboard/internal/models"
+)
+
+func TestDerivedFieldExpressions(t *testing.T) {
+	tx := models.Transaction{
+		ID:             "tx-1",
+		Country:        "United States",
+		ProductName:    "Widget",
+		UnitPriceCents: 2550,
+		Currency:       "USD",
+		Quantity:       4,
+		TxTime:         time.Date(2024, 3, 16, 10, 0, 0, 0, time.UTC), // a Saturday
+	}
+	columns := map[string]string{"discount": "0.1", "channel": "Web"}
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"price * quantity * (1 - discount)", "91.8"},
+		{"round(price * quantity * (1 - discount) / 7, 2)", "13.11"},
+		{"band(price, 10, 25, 100)", "25-100"},
+		{"band(quantity, 10, 50)", "<10"},
+		{"is_weekend(date)", "true"},
+		{"weekday(tx_time)", "Saturday"},
+		{"quarter(date)", "Q1"},
+		{"fiscal_quarter(date, 4)", "FY2024-Q4"},
+		{"fiscal_quarter(date + 1m, 4)", "FY2025-Q1"},
+		{"if(quantity >= 4 and country = 'united states', 'bulk', 'single')", "bulk"},
+		{"concat(upper(channel), '-', year(date))", "WEB-2024"},
+		{"coalesce(voucher, 'none')", "none"},
+		{"quantity % 3 + max(1, discount, -2)", "2"},
+		{"date - 1y", "2023-03-16"},
+		{"not (price > 100 or channel != 'web')", "true"},
+		{"if(product in ('widget', 'gadget') and country starts_with 'united', 'core', 'other')", "core"},
+		{"quantity between 1 and 4 unless channel is empty", "true"},
+		{"'2024-03-01' < date and voucher is empty", "true"},
+	}
+	for _, tt := range tests {
+		d, err := NewDerivedFields(TransformConfig{DerivedFields: []DerivedField{{Name: "out", Expr: tt.expr}}})
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.expr, err)
+			continue
+		}
+		out, err := d.TransformSource(&tx, columns)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.expr, err)
+			continue
+		}
+		if got := out.(*models.Transaction).Attributes["out"]; got != tt.want {
+			t.Errorf("%q: got %q want %q", tt.expr, got, tt.want)
+		}
+	}
+
+	// Runtime failures leave the field unset but keep the others
+	d, err := NewDerivedFields(TransformConfig{DerivedFields: []DerivedField{
+		{Name: "bad", Expr: "price / (quantity - 4)"},
+		{Name: "net", Expr: "price * quantity"},
+		{Name: "empty", Expr: "price * voucher"},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out, err := d.TransformSource(&tx, columns)
+	attrs := out.(*models.Transaction).Attributes
+	if err == nil || !strings.Contains(err.Error(), "division by zero") || !strings.Contains(err.Error(), "voucher is empty") {
+		t.Errorf("got error %v", err)
+	}
+	if _, ok := attrs["bad"]; ok || attrs["net"] != "102" {
+		t.Errorf("got attributes %v", attrs)
+	}
+
+	for _, bad := range [][]DerivedField{
+		{{Name: "x", Expr: "price *"}},
+		{{Name: "x", Expr: "nosuch(price)"}},
+		{{Name: "x", Expr: "round()"}},
+		{{Name: "x", Expr: "band(price, 50, 10)"}},
+		{{Name: "price", Expr: "1"}},
+		{{Name: "Net Amount", Expr: "1"}},
+		{{Name: "x", Expr: "1"}, {Name: "x", Expr: "2"}},
+	} {
+		if err := validateDerivedFields(bad, nil); err == nil {
+			t.Errorf("%+v: expected an error", bad)
+		}
+	}
+	if err := validateDerivedFields([]DerivedField{{Name: "x", Expr: "1"}},
+		[]PipelineStage{{Name: "StringCleaning"}}); err == nil {
+		t.Error("pipeline without DerivedFields: expected an error")
+	}
+}
+
+func TestDerivedFieldsFromSource(t *testing.T) {
+	handler := newTestHandler(10)
+	handler.config.DerivedFields = []DerivedField{
+		{Name: "net", Expr: "price * quantity * (1 - coalesce(discount, 0))"},
+		{Name: "price_band", Expr: "band(net, 20, 50)"},
+		{Name: "channel", Expr: "lower(column('Sales Channel'))"},
+	}
+	handler.converter = NewFormatConverter(handler.config)
+	handler.engine = NewDataTransformationEngine(handler.config)
+
+	csv := "transaction_id,transaction_date,country,product_name,price,quantity,discount,Sales Channel\n" +
+		"tx-1,2024-01-15,USA,widget,25.00,2,0.5,WEB\n" +
+		"tx-2,2024-01-16,USA,widget,10.00,1,,Store\n"
+	transactions, _, err := handler.ProcessDataStream(strings.NewReader(csv), FormatCSV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transactions) != 2 {
+		t.Fatalf("got %d transactions", len(transactions))
+	}
+	want := []map[string]string{
+		{"net": "25", "price_band": "20-50", "channel": "web"},
+		{"net": "10", "price_band": "<20", "channel": "store"},
+	}
+	for i, tx := range transactions {
+		for name, value := range want[i] {
+			if tx.Attributes[name] != value {
+				t.Errorf("%s: %s = %q want %q", tx.ID, name, tx.Attributes[name], value)
+			}
+		}
+	}
+
+	// JSON keys are matched the same way
+	json := `[{"id": "j-1", "date": "2024-01-15", "product": "widget", "price": 8, "discount": 0.25, "sales channel": "App"}]`
+	transactions, _, err = handler.ProcessDataStream(strings.NewReader(json), FormatJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transactions) != 1 || transactions[0].Attributes["net"] != "6" || transactions[0].Attributes["channel"] != "app" {
+		t.Errorf("json: got %+v", transactions)
+	}
+}
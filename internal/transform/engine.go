@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/big"
 	"strconv"
 	"strings"
 	"time"
 
 	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/money"
 )
 
 // DataTransformationEngine provides flexible data transformation capabilities
@@ -23,16 +25,29 @@ type DataTransformationEngine struct {
 
 // TransformConfig defines configuration for data transformations
 type TransformConfig struct {
-	EnableValidation   bool              `json:"enable_validation"`
-	EnableOptimization bool              `json:"enable_optimization"`
-	DateFormats        []string          `json:"date_formats"`
-	CurrencyFormats    []string          `json:"currency_formats"`
-	NullValues         []string          `json:"null_values"`
-	DefaultCountry     string            `json:"default_country"`
-	DefaultRegion      string            `json:"default_region"`
-	PriceMultiplier    float64           `json:"price_multiplier"`
-	CustomMappings     map[string]string `json:"custom_mappings"`
-	DataTypes          map[string]string `json:"data_types"`
+	EnableValidation   bool                     `json:"enable_validation"`
+	EnableOptimization bool                     `json:"enable_optimization"`
+	DateFormats        []string                 `json:"date_formats"`
+	CurrencyFormats    []string                 `json:"currency_formats"`
+	NullValues         []string                 `json:"null_values"`
+	DefaultCountry     string                   `json:"default_country"`
+	DefaultRegion      string                   `json:"default_region"`
+	PriceMultiplier    float64                  `json:"price_multiplier"`
+	CustomMappings     map[string]string        `json:"custom_mappings"`
+	DataTypes          map[string]string        `json:"data_types"`
+	BatchSize          int                      `json:"batch_size"`
+	XML                XMLConfig                `json:"xml"`
+	XLSX               XLSXConfig               `json:"xlsx"`
+	ColumnMappings     map[string]ColumnMapping `json:"column_mappings"`
+	Currency           CurrencyConfig           `json:"currency"`
+	Timezone           TimezoneConfig           `json:"timezone"`
+	Quarantine         QuarantineConfig         `json:"quarantine"`
+	Pipeline           []PipelineStage          `json:"pipeline"` // empty uses DefaultPipeline
+	Rules              []Rule                   `json:"rules"`    // empty uses DefaultRules
+	DerivedFields      []DerivedField           `json:"derived_fields"`
+	ProductAliasesFile string                   `json:"product_aliases_file"` // merged into CustomMappings on load
+	Geography          GeographyConfig          `json:"geography"`
+	Inventory          InventoryConfig          `json:"inventory"`
 }
 
 // Transformation interface for data transformation operations
@@ -58,6 +73,7 @@ type Optimization interface {
 
 // TransformationResult contains the result of data transformation
 type TransformationResult struct {
+	BatchID            string             `json:"batch_id"` // names the run in the lineage of its transactions
 	OriginalRecords    int                `json:"original_records"`
 	TransformedRecords int                `json:"transformed_records"`
 	SkippedRecords     int                `json:"skipped_records"`
@@ -66,6 +82,20 @@ type TransformationResult struct {
 	Transformations    []string           `json:"transformations_applied"`
 	ProcessingTime     time.Duration      `json:"processing_time"`
 	DataQuality        DataQualityMetrics `json:"data_quality"`
+	Members            []MemberResult     `json:"members,omitempty"`
+	QuarantinedEntries int                `json:"quarantined_entries,omitempty"`
+	RoundedValues      int                `json:"rounded_values,omitempty"`
+	RuleViolations     map[string]int     `json:"rule_violations,omitempty"`        // rule name -> records breaking it
+	AlreadyLoaded      int                `json:"already_loaded_records,omitempty"` // skipped by ProcessUpload as loaded before
+}
+
+// MemberResult holds the record counts of one file inside an archive
+type MemberResult struct {
+	Name               string     `json:"name"`
+	Format             DataFormat `json:"format"`
+	OriginalRecords    int        `json:"original_records"`
+	TransformedRecords int        `json:"transformed_records"`
+	SkippedRecords     int        `json:"skipped_records"`
 }
 
 // DataQualityMetrics provides insights into data quality
@@ -75,6 +105,10 @@ type DataQualityMetrics struct {
 	Validity     float64            `json:"validity"`
 	Uniqueness   float64            `json:"uniqueness"`
 	FieldMetrics map[string]float64 `json:"field_metrics"`
+
+	// UnmappedCountries counts the records per country value that
+	// CountryMapping could not find in the ISO table
+	UnmappedCountries map[string]int `json:"unmapped_countries,omitempty"`
 }
 
 // NewDataTransformationEngine creates a new transformation engine
@@ -103,28 +137,41 @@ func NewDataTransformationEngine(config TransformConfig) *DataTransformationEngi
 		engine.config.PriceMultiplier = 100 // Default: dollars to cents
 	}
 
-	// Register default transformations
-	engine.RegisterTransformation(&CurrencyNormalization{config: config})
-	engine.RegisterTransformation(&DateNormalization{config: config})
-	engine.RegisterTransformation(&StringCleaning{config: config})
-	engine.RegisterTransformation(&CountryMapping{config: config})
-	engine.RegisterTransformation(&RegionMapping{config: config})
-	engine.RegisterTransformation(&ProductNameNormalization{config: config})
-
-	// Register default validators
-	engine.RegisterValidator(&RequiredFieldValidator{})
-	engine.RegisterValidator(&DataTypeValidator{config: config})
-	engine.RegisterValidator(&RangeValidator{})
-	engine.RegisterValidator(&UniquenessValidator{})
-
-	// Register default optimizations
-	engine.RegisterOptimization(&DuplicateRemoval{})
-	engine.RegisterOptimization(&DataDeduplication{})
-	engine.RegisterOptimization(&IndexOptimization{})
+	// Register the configured transformations, validators and optimizations
+	stages := config.Pipeline
+	if len(stages) == 0 {
+		stages = DefaultPipeline()
+	}
+	for _, stage := range stages {
+		// Loaded configurations have been validated; skip what cannot be built
+		built, err := buildStage(stage, config)
+		if err != nil {
+			log.Printf("Skipping pipeline stage: %v", err)
+			continue
+		}
+		switch s := built.(type) {
+		case Transformation:
+			engine.RegisterTransformation(s)
+		case Validator:
+			engine.RegisterValidator(s)
+		case Optimization:
+			engine.RegisterOptimization(s)
+		}
+	}
 
 	return engine
 }
 
+// uniqueness returns the engine's UniquenessValidator, or nil if it has none
+func (e *DataTransformationEngine) uniqueness() *UniquenessValidator {
+	for _, validator := range e.validators {
+		if u, ok := validator.(*UniquenessValidator); ok {
+			return u
+		}
+	}
+	return nil
+}
+
 // RegisterTransformation adds a new transformation to the engine
 func (e *DataTransformationEngine) RegisterTransformation(t Transformation) {
 	e.transformations = append(e.transformations, t)
@@ -140,6 +187,47 @@ func (e *DataTransformationEngine) RegisterOptimization(o Optimization) {
 	e.optimizations = append(e.optimizations, o)
 }
 
+// hasTransformation reports whether the named transformation is registered
+func (e *DataTransformationEngine) hasTransformation(name string) bool {
+	for _, t := range e.transformations {
+		if t.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasOptimization reports whether the named optimization is registered
+func (e *DataTransformationEngine) hasOptimization(name string) bool {
+	for _, o := range e.optimizations {
+		if o.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// stagesRun names the registered stages that ran, in execution order
+func (e *DataTransformationEngine) stagesRun(transformed, validated, optimized bool) []string {
+	names := make([]string, 0)
+	if transformed {
+		for _, t := range e.transformations {
+			names = append(names, t.Name())
+		}
+	}
+	if validated {
+		for _, v := range e.validators {
+			names = append(names, v.Name())
+		}
+	}
+	if optimized {
+		for _, o := range e.optimizations {
+			names = append(names, o.Name())
+		}
+	}
+	return names
+}
+
 // TransformCSVData processes CSV data with all registered transformations
 func (e *DataTransformationEngine) TransformCSVData(reader io.Reader) ([]models.Transaction, *TransformationResult, error) {
 	startTime := time.Now()
@@ -203,7 +291,8 @@ func (e *DataTransformationEngine) TransformCSVData(reader io.Reader) ([]models.
 	}
 
 	// Apply optimizations if enabled
-	if e.config.EnableOptimization {
+	optimized := e.config.EnableOptimization && len(transactions) > 0
+	if optimized {
 		optimizedTransactions, err := e.optimizeData(transactions)
 		if err != nil {
 			result.Warnings = append(result.Warnings, fmt.Sprintf("Optimization warning: %s", err.Error()))
@@ -216,10 +305,9 @@ func (e *DataTransformationEngine) TransformCSVData(reader io.Reader) ([]models.
 	result.DataQuality = e.calculateDataQuality(transactions)
 	result.ProcessingTime = time.Since(startTime)
 
-	// Record applied transformations
-	for _, t := range e.transformations {
-		result.Transformations = append(result.Transformations, t.Name())
-	}
+	// Record the stages that ran; records are transformed by transformRecord
+	// rather than the registered transformations
+	result.Transformations = e.stagesRun(false, e.config.EnableValidation && result.TransformedRecords > 0, optimized)
 
 	log.Printf("Data transformation completed: %d/%d records processed in %v",
 		result.TransformedRecords, result.OriginalRecords, result.ProcessingTime)
@@ -238,7 +326,8 @@ func (e *DataTransformationEngine) transformRecord(record []string, columnMap ma
 
 	if idx, ok := columnMap["country"]; ok && idx < len(record) {
 		country := e.cleanString(record[idx])
-		transaction.Country = e.mapCountry(country)
+		transaction.Country, transaction.CountryCode = e.mapCountry(country)
+		transaction.Continent = e.config.Geography.Hierarchy.Continent(transaction.CountryCode)
 	}
 
 	if idx, ok := columnMap["region"]; ok && idx < len(record) {
@@ -301,34 +390,22 @@ func (e *DataTransformationEngine) cleanString(s string) string {
 	return s
 }
 
-func (e *DataTransformationEngine) mapCountry(country string) string {
+// mapCountry returns the standard name and ISO 3166-1 alpha-2 code of a
+// country, like the CountryMapping transformation
+func (e *DataTransformationEngine) mapCountry(country string) (string, string) {
 	if country == "" && e.config.DefaultCountry != "" {
-		return e.config.DefaultCountry
+		country = e.config.DefaultCountry
 	}
 
 	// Apply custom mappings
 	if mapped, ok := e.config.CustomMappings[strings.ToLower(country)]; ok {
-		return mapped
+		return mapped, countryCode(mapped)
 	}
 
-	// Normalize common country name variations
-	countryMappings := map[string]string{
-		"usa":         "United States",
-		"us":          "United States",
-		"america":     "United States",
-		"uk":          "United Kingdom",
-		"britain":     "United Kingdom",
-		"england":     "United Kingdom",
-		"uae":         "United Arab Emirates",
-		"south korea": "Korea",
-		"north korea": "Korea",
-	}
-
-	if mapped, ok := countryMappings[strings.ToLower(country)]; ok {
-		return mapped
+	if iso, found := LookupCountry(country); found {
+		return iso.Name, iso.Alpha2
 	}
-
-	return country
+	return country, ""
 }
 
 func (e *DataTransformationEngine) mapRegion(region string) string {
@@ -369,20 +446,26 @@ func (e *DataTransformationEngine) parsePrice(priceStr string) (int64, error) {
 	// Remove commas
 	priceStr = strings.ReplaceAll(priceStr, ",", "")
 
-	price, err := strconv.ParseFloat(priceStr, 64)
+	price, err := money.ParseDecimal(priceStr)
 	if err != nil {
 		return 0, err
 	}
 
-	// Convert to cents using multiplier
-	return int64(price * e.config.PriceMultiplier), nil
+	// Convert to cents using multiplier, without going through float64
+	rounding, err := money.ParseRounding(e.config.Currency.Rounding)
+	if err != nil {
+		rounding = money.DefaultRounding
+	}
+	cents, _, err := money.ToMinor(price, new(big.Rat).SetFloat64(e.config.PriceMultiplier), rounding)
+	return cents, err
 }
 
 func (e *DataTransformationEngine) parseDate(dateStr string) (time.Time, error) {
 	dateStr = e.cleanString(dateStr)
 
+	loc := e.config.Timezone.sourceLocation("")
 	for _, format := range e.config.DateFormats {
-		if date, err := time.Parse(format, dateStr); err == nil {
+		if date, err := time.ParseInLocation(format, dateStr, layoutLocation(format, loc)); err == nil {
 			return date, nil
 		}
 	}
@@ -418,7 +501,86 @@ func (e *DataTransformationEngine) optimizeData(transactions []models.Transactio
 }
 
 func (e *DataTransformationEngine) calculateDataQuality(transactions []models.Transaction) DataQualityMetrics {
-	if len(transactions) == 0 {
+	acc := newQualityAccumulator(e.hasTransformation("CountryMapping"))
+	for i := range transactions {
+		acc.add(&transactions[i])
+	}
+	return acc.metrics()
+}
+
+// maxUnmappedCountries bounds the distinct unmapped country values counted;
+// further values are counted under otherCountries
+const maxUnmappedCountries = 100
+
+const otherCountries = "(other)"
+
+// qualityAccumulator computes DataQualityMetrics incrementally so that streamed
+// records can be scored without keeping them in memory. Only transaction IDs
+// are retained, for the uniqueness score.
+type qualityAccumulator struct {
+	total              int
+	completenessScores map[string]int
+	uniqueIDs          map[string]struct{}
+	validTransactions  int
+
+	// unmappedCountries is counted when countries are normalized, as a
+	// record without a country code then has an unknown country
+	checkCountries    bool
+	unmappedCountries map[string]int
+}
+
+func newQualityAccumulator(checkCountries bool) *qualityAccumulator {
+	return &qualityAccumulator{
+		completenessScores: make(map[string]int),
+		uniqueIDs:          make(map[string]struct{}),
+		checkCountries:     checkCountries,
+		unmappedCountries:  make(map[string]int),
+	}
+}
+
+func (q *qualityAccumulator) add(tx *models.Transaction) {
+	q.total++
+
+	if tx.ID != "" {
+		q.completenessScores["id"]++
+	}
+	if tx.Country != "" {
+		q.completenessScores["country"]++
+	}
+	if tx.Region != "" {
+		q.completenessScores["region"]++
+	}
+	if tx.ProductName != "" {
+		q.completenessScores["product_name"]++
+	}
+	if tx.UnitPriceCents > 0 {
+		q.completenessScores["price"]++
+	}
+	if tx.Quantity > 0 {
+		q.completenessScores["quantity"]++
+	}
+	if !tx.TxTime.IsZero() {
+		q.completenessScores["date"]++
+	}
+
+	q.uniqueIDs[tx.ID] = struct{}{}
+
+	if q.checkCountries && tx.Country != "" && tx.CountryCode == "" {
+		country := tx.Country
+		if _, seen := q.unmappedCountries[country]; !seen && len(q.unmappedCountries) >= maxUnmappedCountries {
+			country = otherCountries
+		}
+		q.unmappedCountries[country]++
+	}
+
+	// Basic validity check (non-negative prices and quantities)
+	if tx.UnitPriceCents >= 0 && tx.Quantity >= 0 {
+		q.validTransactions++
+	}
+}
+
+func (q *qualityAccumulator) metrics() DataQualityMetrics {
+	if q.total == 0 {
 		return DataQualityMetrics{}
 	}
 
@@ -427,56 +589,26 @@ func (e *DataTransformationEngine) calculateDataQuality(transactions []models.Tr
 	}
 
 	// Calculate completeness
-	completenessScores := make(map[string]int)
 	totalFields := 7 // Number of fields in Transaction
-
-	for _, tx := range transactions {
-		if tx.ID != "" {
-			completenessScores["id"]++
-		}
-		if tx.Country != "" {
-			completenessScores["country"]++
-		}
-		if tx.Region != "" {
-			completenessScores["region"]++
-		}
-		if tx.ProductName != "" {
-			completenessScores["product_name"]++
-		}
-		if tx.UnitPriceCents > 0 {
-			completenessScores["price"]++
-		}
-		if tx.Quantity > 0 {
-			completenessScores["quantity"]++
-		}
-		if !tx.TxTime.IsZero() {
-			completenessScores["date"]++
-		}
-	}
-
 	totalCompleteness := 0.0
-	for field, count := range completenessScores {
-		completeness := float64(count) / float64(len(transactions))
+	for field, count := range q.completenessScores {
+		completeness := float64(count) / float64(q.total)
 		metrics.FieldMetrics[field+"_completeness"] = completeness
 		totalCompleteness += completeness
 	}
 	metrics.Completeness = totalCompleteness / float64(totalFields)
 
 	// Calculate uniqueness (for transaction IDs)
-	uniqueIDs := make(map[string]bool)
-	for _, tx := range transactions {
-		uniqueIDs[tx.ID] = true
-	}
-	metrics.Uniqueness = float64(len(uniqueIDs)) / float64(len(transactions))
+	metrics.Uniqueness = float64(len(q.uniqueIDs)) / float64(q.total)
 
-	// Basic validity check (non-negative prices and quantities)
-	validTransactions := 0
-	for _, tx := range transactions {
-		if tx.UnitPriceCents >= 0 && tx.Quantity >= 0 {
-			validTransactions++
+	metrics.Validity = float64(q.validTransactions) / float64(q.total)
+
+	if len(q.unmappedCountries) > 0 {
+		metrics.UnmappedCountries = make(map[string]int, len(q.unmappedCountries))
+		for country, count := range q.unmappedCountries {
+			metrics.UnmappedCountries[country] = count
 		}
 	}
-	metrics.Validity = float64(validTransactions) / float64(len(transactions))
 
 	// Consistency score (basic implementation)
 	metrics.Consistency = 0.95 // Placeholder - could implement more sophisticated consistency checks
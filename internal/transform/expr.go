@@ -0,0 +1,1306 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/money"
+)
+
+// exprKind is the type of an expression value
+type exprKind int
+
+const (
+	kindString exprKind = iota
+	kindNumber
+	kindTime
+	kindBool
+)
+
+func (k exprKind) String() string {
+	return [...]string{"string", "number", "date", "boolean"}[k]
+}
+
+// recordFields are the record fields expressions can refer to, by name and
+// alias
+var recordFields = map[string]struct {
+	field string
+	kind  exprKind
+}{
+	"transaction_id":   {"transaction_id", kindString},
+	"id":               {"transaction_id", kindString},
+	"country":          {"country", kindString},
+	"region":           {"region", kindString},
+	"product_name":     {"product_name", kindString},
+	"product":          {"product_name", kindString},
+	"currency":         {"currency", kindString},
+	"price":            {"price", kindNumber},
+	"quantity":         {"quantity", kindNumber},
+	"transaction_date": {"transaction_date", kindTime},
+	"tx_time":          {"transaction_date", kindTime},
+	"date":             {"transaction_date", kindTime},
+}
+
+// exprValue is an evaluated expression. A null value is an empty or missing
+// source column; str then holds the column name for error messages.
+type exprValue struct {
+	kind exprKind
+	str  string
+	num  *big.Rat
+	t    time.Time
+	b    bool
+	null bool
+}
+
+// String renders a value as stored in Transaction.Attributes
+func (v exprValue) String() string {
+	switch {
+	case v.null:
+		return ""
+	case v.kind == kindNumber:
+		if v.num.IsInt() {
+			return v.num.Num().String()
+		}
+		s := strings.TrimRight(v.num.FloatString(6), "0")
+		return strings.TrimSuffix(s, ".")
+	case v.kind == kindTime:
+		return v.t.Format("2006-01-02")
+	case v.kind == kindBool:
+		if v.b {
+			return "true"
+		}
+		return "false"
+	}
+	return v.str
+}
+
+func numberValue(n *big.Rat) exprValue { return exprValue{kind: kindNumber, num: n} }
+func stringValue(s string) exprValue   { return exprValue{kind: kindString, str: s} }
+func timeValue(t time.Time) exprValue  { return exprValue{kind: kindTime, t: t} }
+func boolValue(b bool) exprValue       { return exprValue{kind: kindBool, b: b} }
+
+// number returns the value as a number, parsing strings
+func (v exprValue) number() (*big.Rat, error) {
+	switch {
+	case v.null:
+		return nil, fmt.Errorf("%s is empty", v.str)
+	case v.kind == kindNumber:
+		return v.num, nil
+	case v.kind == kindString:
+		if n, err := money.ParseDecimal(strings.TrimSpace(v.str)); err == nil {
+			return n, nil
+		}
+		return nil, fmt.Errorf("%q is not a number", v.str)
+	}
+	return nil, fmt.Errorf("expected a number, got a %s", v.kind)
+}
+
+// time returns the value as a time in loc, parsing strings
+func (v exprValue) time(loc *time.Location) (time.Time, error) {
+	switch {
+	case v.null:
+		return time.Time{}, fmt.Errorf("%s is empty", v.str)
+	case v.kind == kindTime:
+		return v.t.In(loc), nil
+	case v.kind == kindString:
+		if t, err := parseExprDate(strings.TrimSpace(v.str), loc); err == nil {
+			return t.In(loc), nil
+		}
+		return time.Time{}, fmt.Errorf("%q is not a date", v.str)
+	}
+	return time.Time{}, fmt.Errorf("expected a date, got a %s", v.kind)
+}
+
+// bool returns the value of a condition
+func (v exprValue) bool() (bool, error) {
+	if v.kind != kindBool || v.null {
+		return false, fmt.Errorf("expected true or false, got %q", v.String())
+	}
+	return v.b, nil
+}
+
+// exprContext is what an expression is evaluated against
+type exprContext struct {
+	tx      *models.Transaction
+	columns map[string]string
+	derived map[string]exprValue
+	now     time.Time        // in loc
+	prices  *FormatConverter // only used for price scales
+	loc     *time.Location
+}
+
+func newExprContext(tx *models.Transaction, prices *FormatConverter, loc *time.Location) *exprContext {
+	return &exprContext{tx: tx, now: time.Now().In(loc), prices: prices, loc: loc}
+}
+
+// exprNode is a compiled expression
+type exprNode interface {
+	eval(ctx *exprContext) (exprValue, error)
+}
+
+type literalExpr struct{ value exprValue }
+
+func (n literalExpr) eval(*exprContext) (exprValue, error) {
+	return n.value, nil
+}
+
+// fieldExpr is a record field
+type fieldExpr struct {
+	field string
+	kind  exprKind
+}
+
+func (n fieldExpr) eval(ctx *exprContext) (exprValue, error) {
+	tx := ctx.tx
+	switch n.field {
+	case "transaction_id":
+		return stringValue(tx.ID), nil
+	case "country":
+		return stringValue(tx.Country), nil
+	case "region":
+		return stringValue(tx.Region), nil
+	case "product_name":
+		return stringValue(tx.ProductName), nil
+	case "currency":
+		return stringValue(tx.Currency), nil
+	case "price":
+		price := new(big.Rat).SetInt64(tx.UnitPriceCents)
+		return numberValue(price.Quo(price, ctx.prices.priceScale(tx.Currency))), nil
+	case "quantity":
+		return numberValue(new(big.Rat).SetInt64(tx.Quantity)), nil
+	}
+	return timeValue(tx.TxTime), nil
+}
+
+// nowExpr is now, or the start of today
+type nowExpr struct{ today bool }
+
+func (n nowExpr) eval(ctx *exprContext) (exprValue, error) {
+	if !n.today {
+		return timeValue(ctx.now), nil
+	}
+	y, m, d := ctx.now.Date()
+	return timeValue(time.Date(y, m, d, 0, 0, 0, 0, ctx.now.Location())), nil
+}
+
+type derivedExpr struct{ name string }
+
+func (n derivedExpr) eval(ctx *exprContext) (exprValue, error) {
+	if v, ok := ctx.derived[n.name]; ok {
+		return v, nil
+	}
+	return exprValue{null: true, str: n.name}, nil
+}
+
+type columnExpr struct{ name string }
+
+func (n columnExpr) eval(ctx *exprContext) (exprValue, error) {
+	if v := strings.TrimSpace(ctx.columns[n.name]); v != "" {
+		return stringValue(v), nil
+	}
+	return exprValue{null: true, str: n.name}, nil
+}
+
+type negateExpr struct{ x exprNode }
+
+func (n negateExpr) eval(ctx *exprContext) (exprValue, error) {
+	v, err := n.x.eval(ctx)
+	if err != nil {
+		return v, err
+	}
+	num, err := v.number()
+	if err != nil {
+		return v, err
+	}
+	return numberValue(new(big.Rat).Neg(num)), nil
+}
+
+type notExpr struct{ x exprNode }
+
+func (n notExpr) eval(ctx *exprContext) (exprValue, error) {
+	v, err := n.x.eval(ctx)
+	if err != nil {
+		return v, err
+	}
+	b, err := v.bool()
+	return boolValue(!b), err
+}
+
+// logicExpr is and or or, evaluated left to right and only as far as needed
+type logicExpr struct {
+	and         bool
+	left, right exprNode
+}
+
+func (n logicExpr) eval(ctx *exprContext) (exprValue, error) {
+	for _, x := range []exprNode{n.left, n.right} {
+		v, err := x.eval(ctx)
+		if err != nil {
+			return v, err
+		}
+		b, err := v.bool()
+		if err != nil {
+			return v, err
+		}
+		if b != n.and {
+			return boolValue(b), nil
+		}
+	}
+	return boolValue(n.and), nil
+}
+
+// offsetExpr moves a date by a number of years, months and days
+type offsetExpr struct {
+	x      exprNode
+	offset [3]int
+}
+
+func (n offsetExpr) eval(ctx *exprContext) (exprValue, error) {
+	v, err := n.x.eval(ctx)
+	if err != nil {
+		return v, err
+	}
+	t, err := v.time(ctx.loc)
+	if err != nil {
+		return v, err
+	}
+	return timeValue(t.AddDate(n.offset[0], n.offset[1], n.offset[2])), nil
+}
+
+type binaryExpr struct {
+	op          string
+	left, right exprNode
+}
+
+func (n binaryExpr) eval(ctx *exprContext) (exprValue, error) {
+	left, err := n.left.eval(ctx)
+	if err != nil {
+		return left, err
+	}
+	right, err := n.right.eval(ctx)
+	if err != nil {
+		return right, err
+	}
+
+	switch n.op {
+	case "=", "!=", "<", "<=", ">", ">=":
+		c, err := compareExpr(left, right, ctx.loc)
+		if err != nil {
+			return left, err
+		}
+		return boolValue(map[string]bool{
+			"=": c == 0, "!=": c != 0, "<": c < 0, "<=": c <= 0, ">": c > 0, ">=": c >= 0,
+		}[n.op]), nil
+	case "+":
+		// + joins text that is not numeric
+		if left.kind == kindString && right.kind == kindString && !left.null && !right.null {
+			if _, err := left.number(); err != nil {
+				return stringValue(left.str + right.str), nil
+			}
+		}
+	}
+
+	a, err := left.number()
+	if err != nil {
+		return left, err
+	}
+	b, err := right.number()
+	if err != nil {
+		return right, err
+	}
+	out := new(big.Rat)
+	switch n.op {
+	case "+":
+		out.Add(a, b)
+	case "-":
+		out.Sub(a, b)
+	case "*":
+		out.Mul(a, b)
+	case "/":
+		if b.Sign() == 0 {
+			return left, errors.New("division by zero")
+		}
+		out.Quo(a, b)
+	case "%":
+		if !a.IsInt() || !b.IsInt() || b.Sign() == 0 {
+			return left, errors.New("% needs whole numbers and a non-zero divisor")
+		}
+		out.SetInt(new(big.Int).Rem(a.Num(), b.Num()))
+	}
+	return numberValue(out), nil
+}
+
+// compareExpr orders two values: as numbers or dates when either is one,
+// otherwise as case-insensitive text
+func compareExpr(a, b exprValue, loc *time.Location) (int, error) {
+	switch {
+	case a.null || b.null:
+		return strings.Compare(a.String(), b.String()), nil
+	case a.kind == kindNumber || b.kind == kindNumber:
+		x, err := a.number()
+		if err != nil {
+			return 0, err
+		}
+		y, err := b.number()
+		if err != nil {
+			return 0, err
+		}
+		return x.Cmp(y), nil
+	case a.kind == kindTime || b.kind == kindTime:
+		x, err := a.time(loc)
+		if err != nil {
+			return 0, err
+		}
+		y, err := b.time(loc)
+		if err != nil {
+			return 0, err
+		}
+		return x.Compare(y), nil
+	}
+	return strings.Compare(strings.ToLower(a.String()), strings.ToLower(b.String())), nil
+}
+
+// betweenExpr is x between low and high, inclusive
+type betweenExpr struct {
+	x, low, high exprNode
+}
+
+func (n betweenExpr) eval(ctx *exprContext) (exprValue, error) {
+	values := make([]exprValue, 3)
+	for i, x := range []exprNode{n.x, n.low, n.high} {
+		v, err := x.eval(ctx)
+		if err != nil {
+			return v, err
+		}
+		values[i] = v
+	}
+	low, err := compareExpr(values[0], values[1], ctx.loc)
+	if err != nil {
+		return values[0], err
+	}
+	high, err := compareExpr(values[0], values[2], ctx.loc)
+	if err != nil {
+		return values[0], err
+	}
+	return boolValue(low >= 0 && high <= 0), nil
+}
+
+type inExpr struct {
+	x    exprNode
+	list []exprNode
+}
+
+func (n inExpr) eval(ctx *exprContext) (exprValue, error) {
+	v, err := n.x.eval(ctx)
+	if err != nil {
+		return v, err
+	}
+	for _, item := range n.list {
+		w, err := item.eval(ctx)
+		if err != nil {
+			return w, err
+		}
+		c, err := compareExpr(v, w, ctx.loc)
+		if err != nil {
+			return v, err
+		}
+		if c == 0 {
+			return boolValue(true), nil
+		}
+	}
+	return boolValue(false), nil
+}
+
+// textExpr is starts_with, ends_with or contains, ignoring case
+type textExpr struct {
+	op          string
+	left, right exprNode
+}
+
+func (n textExpr) eval(ctx *exprContext) (exprValue, error) {
+	left, err := n.left.eval(ctx)
+	if err != nil {
+		return left, err
+	}
+	right, err := n.right.eval(ctx)
+	if err != nil {
+		return right, err
+	}
+	a, b := strings.ToLower(left.String()), strings.ToLower(right.String())
+	switch n.op {
+	case "starts_with":
+		return boolValue(strings.HasPrefix(a, b)), nil
+	case "ends_with":
+		return boolValue(strings.HasSuffix(a, b)), nil
+	}
+	return boolValue(strings.Contains(a, b)), nil
+}
+
+type matchExpr struct {
+	x  exprNode
+	re *regexp.Regexp
+}
+
+func (n matchExpr) eval(ctx *exprContext) (exprValue, error) {
+	v, err := n.x.eval(ctx)
+	if err != nil {
+		return v, err
+	}
+	return boolValue(n.re.MatchString(v.String())), nil
+}
+
+// emptyExpr holds for an empty column, blank text, zero and the zero date
+type emptyExpr struct{ x exprNode }
+
+func (n emptyExpr) eval(ctx *exprContext) (exprValue, error) {
+	v, err := n.x.eval(ctx)
+	if err != nil {
+		return v, err
+	}
+	switch {
+	case v.null:
+		return boolValue(true), nil
+	case v.kind == kindNumber:
+		return boolValue(v.num.Sign() == 0), nil
+	case v.kind == kindTime:
+		return boolValue(v.t.IsZero()), nil
+	case v.kind == kindBool:
+		return boolValue(false), nil
+	}
+	return boolValue(strings.TrimSpace(v.str) == ""), nil
+}
+
+// staticKind returns the kind of value a node evaluates to when that is
+// known without evaluating it
+func staticKind(node exprNode) (exprKind, bool) {
+	switch n := node.(type) {
+	case literalExpr:
+		return n.value.kind, true
+	case fieldExpr:
+		return n.kind, true
+	case nowExpr, offsetExpr:
+		return kindTime, true
+	case negateExpr:
+		return kindNumber, true
+	case notExpr, logicExpr, betweenExpr, inExpr, textExpr, matchExpr, emptyExpr:
+		return kindBool, true
+	case binaryExpr:
+		switch n.op {
+		case "=", "!=", "<", "<=", ">", ">=":
+			return kindBool, true
+		case "+":
+			// + joins text unless either side is a number
+			left, ok := staticKind(n.left)
+			right, ok2 := staticKind(n.right)
+			if (ok && left == kindNumber) || (ok2 && right == kindNumber) {
+				return kindNumber, true
+			}
+			return 0, false
+		}
+		return kindNumber, true
+	}
+	return 0, false
+}
+
+type callExpr struct {
+	name string
+	fn   exprFunc
+	args []exprNode
+}
+
+func (n callExpr) eval(ctx *exprContext) (exprValue, error) {
+	if n.fn.lazy != nil {
+		return n.fn.lazy(ctx, n.args)
+	}
+	args := make([]exprValue, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(ctx)
+		if err != nil {
+			return v, err
+		}
+		args[i] = v
+	}
+	v, err := n.fn.call(ctx.loc, args)
+	if err != nil {
+		return v, fmt.Errorf("%s: %w", n.name, err)
+	}
+	return v, nil
+}
+
+// exprFunc is a function expressions can call. Most take evaluated
+// arguments; lazy ones evaluate only the arguments they need.
+type exprFunc struct {
+	minArgs, maxArgs int // maxArgs < 0 allows any number
+	call             func(loc *time.Location, args []exprValue) (exprValue, error)
+	lazy             func(ctx *exprContext, args []exprNode) (exprValue, error)
+}
+
+// exprFuncs are the functions expressions can call
+var exprFuncs = map[string]exprFunc{
+	// if(condition, then, else)
+	"if": {minArgs: 3, maxArgs: 3, lazy: func(ctx *exprContext, args []exprNode) (exprValue, error) {
+		cond, err := args[0].eval(ctx)
+		if err != nil {
+			return cond, err
+		}
+		b, err := cond.bool()
+		if err != nil {
+			return cond, fmt.Errorf("if: %w", err)
+		}
+		if b {
+			return args[1].eval(ctx)
+		}
+		return args[2].eval(ctx)
+	}},
+	// coalesce(a, b, ...) is the first argument that is not empty
+	"coalesce": {minArgs: 1, maxArgs: -1, lazy: func(ctx *exprContext, args []exprNode) (exprValue, error) {
+		var v exprValue
+		for _, arg := range args {
+			var err error
+			if v, err = arg.eval(ctx); err != nil || !v.null {
+				return v, err
+			}
+		}
+		return v, nil
+	}},
+	// band(x, 10, 50, 100) is "<10", "10-50", "50-100" or ">=100"
+	"band": {minArgs: 2, maxArgs: -1, call: func(_ *time.Location, args []exprValue) (exprValue, error) {
+		x, err := args[0].number()
+		if err != nil {
+			return args[0], err
+		}
+		edges := make([]*big.Rat, len(args)-1)
+		for i, arg := range args[1:] {
+			if edges[i], err = arg.number(); err != nil {
+				return arg, err
+			}
+			if i > 0 && edges[i].Cmp(edges[i-1]) <= 0 {
+				return arg, errors.New("band limits must increase")
+			}
+		}
+		if x.Cmp(edges[0]) < 0 {
+			return stringValue("<" + numberValue(edges[0]).String()), nil
+		}
+		for i := 1; i < len(edges); i++ {
+			if x.Cmp(edges[i]) < 0 {
+				return stringValue(numberValue(edges[i-1]).String() + "-" + numberValue(edges[i]).String()), nil
+			}
+		}
+		return stringValue(">=" + numberValue(edges[len(edges)-1]).String()), nil
+	}},
+	"weekday": dateFunc(func(t time.Time) exprValue { return stringValue(t.Weekday().String()) }),
+	"is_weekend": dateFunc(func(t time.Time) exprValue {
+		return boolValue(t.Weekday() == time.Saturday || t.Weekday() == time.Sunday)
+	}),
+	"day":   dateFunc(func(t time.Time) exprValue { return numberValue(new(big.Rat).SetInt64(int64(t.Day()))) }),
+	"month": dateFunc(func(t time.Time) exprValue { return numberValue(new(big.Rat).SetInt64(int64(t.Month()))) }),
+	"year":  dateFunc(func(t time.Time) exprValue { return numberValue(new(big.Rat).SetInt64(int64(t.Year()))) }),
+	"quarter": dateFunc(func(t time.Time) exprValue {
+		return stringValue(fmt.Sprintf("Q%d", (int(t.Month())+2)/3))
+	}),
+	// fiscal_quarter(date, 4) for a fiscal year starting in April. Fiscal
+	// years are named after the calendar year they end in.
+	"fiscal_quarter": {minArgs: 2, maxArgs: 2, call: func(loc *time.Location, args []exprValue) (exprValue, error) {
+		t, err := args[0].time(loc)
+		if err != nil {
+			return args[0], err
+		}
+		start, err := args[1].number()
+		if err != nil || !start.IsInt() || start.Num().Int64() < 1 || start.Num().Int64() > 12 {
+			return args[1], errors.New("the start month must be 1 to 12")
+		}
+		months := (int(t.Month()) - int(start.Num().Int64()) + 12) % 12
+		year := t.Year()
+		if start.Num().Int64() > 1 && int(t.Month()) >= int(start.Num().Int64()) {
+			year++
+		}
+		return stringValue(fmt.Sprintf("FY%d-Q%d", year, months/3+1)), nil
+	}},
+	// round(x) or round(x, places), halves away from zero
+	"round": {minArgs: 1, maxArgs: 2, call: func(_ *time.Location, args []exprValue) (exprValue, error) {
+		x, err := args[0].number()
+		if err != nil {
+			return args[0], err
+		}
+		places := int64(0)
+		if len(args) == 2 {
+			p, err := args[1].number()
+			if err != nil || !p.IsInt() || p.Sign() < 0 || p.Num().Int64() > 12 {
+				return args[1], errors.New("places must be 0 to 12")
+			}
+			places = p.Num().Int64()
+		}
+		scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(places), nil))
+		rounded := new(big.Rat).SetInt(money.Round(new(big.Rat).Mul(x, scale), money.HalfUp))
+		return numberValue(rounded.Quo(rounded, scale)), nil
+	}},
+	"abs": {minArgs: 1, maxArgs: 1, call: func(_ *time.Location, args []exprValue) (exprValue, error) {
+		x, err := args[0].number()
+		if err != nil {
+			return args[0], err
+		}
+		return numberValue(new(big.Rat).Abs(x)), nil
+	}},
+	"min":   extremeFunc(-1),
+	"max":   extremeFunc(1),
+	"lower": textFunc(strings.ToLower),
+	"upper": textFunc(strings.ToUpper),
+	"trim":  textFunc(strings.TrimSpace),
+	"concat": {minArgs: 1, maxArgs: -1, call: func(_ *time.Location, args []exprValue) (exprValue, error) {
+		var sb strings.Builder
+		for _, arg := range args {
+			sb.WriteString(arg.String())
+		}
+		return stringValue(sb.String()), nil
+	}},
+}
+
+func dateFunc(f func(t time.Time) exprValue) exprFunc {
+	return exprFunc{minArgs: 1, maxArgs: 1, call: func(loc *time.Location, args []exprValue) (exprValue, error) {
+		t, err := args[0].time(loc)
+		if err != nil {
+			return args[0], err
+		}
+		return f(t), nil
+	}}
+}
+
+func textFunc(f func(string) string) exprFunc {
+	return exprFunc{minArgs: 1, maxArgs: 1, call: func(_ *time.Location, args []exprValue) (exprValue, error) {
+		return stringValue(f(args[0].String())), nil
+	}}
+}
+
+// extremeFunc returns min (sign -1) or max (sign 1) of its arguments
+func extremeFunc(sign int) exprFunc {
+	return exprFunc{minArgs: 1, maxArgs: -1, call: func(_ *time.Location, args []exprValue) (exprValue, error) {
+		var best *big.Rat
+		for _, arg := range args {
+			x, err := arg.number()
+			if err != nil {
+				return arg, err
+			}
+			if best == nil || x.Cmp(best) == sign {
+				best = x
+			}
+		}
+		return numberValue(best), nil
+	}}
+}
+
+// exprToken is a lexical token of an expression; kind is one of "ident",
+// "number", "duration", "string", "op" and "eof"
+type exprToken struct {
+	kind string
+	text string
+	pos  int
+}
+
+func lexExpr(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '\'' || c == '"':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(src) && rune(src[j]) != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				sb.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, exprToken{"string", sb.String(), i})
+			i = j + 1
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(src) && unicode.IsDigit(rune(src[i+1]))):
+			j := i
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			kind := "number"
+			if j < len(src) && unicode.IsLetter(rune(src[j])) {
+				kind = "duration"
+				j++
+			}
+			tokens = append(tokens, exprToken{kind, src[i:j], i})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || src[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, exprToken{"ident", strings.ToLower(src[i:j]), i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<>", "<=", ">=", "=", "<", ">", "(", ")", "[", "]", ",", "+", "-", "*", "/", "%"} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at %d", c, i)
+			}
+			switch op {
+			case "==":
+				op = "="
+			case "<>":
+				op = "!="
+			}
+			tokens = append(tokens, exprToken{"op", op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, exprToken{"eof", "", len(src)}), nil
+}
+
+// exprParser compiles the expressions of derived fields and rule checks with
+// recursive descent:
+//
+//	expr       = or [ "unless" or ]
+//	or         = and { "or" and }
+//	and        = not { "and" not }
+//	not        = "not" not | comparison
+//	comparison = sum [ ( "=" | "!=" | "<" | "<=" | ">" | ">=" ) sum
+//	             | [ "not" ] "between" sum "and" sum
+//	             | [ "not" ] "in" ( "(" | "[" ) sum { "," sum } ( ")" | "]" )
+//	             | [ "not" ] ( "starts_with" | "ends_with" | "contains" ) sum
+//	             | [ "not" ] "matches" string
+//	             | "is" [ "not" ] "empty" ]
+//	sum        = term { ( "+" | "-" ) ( term | duration ) }
+//	term       = unary { ( "*" | "/" | "%" ) unary }
+//	unary      = "-" unary | primary
+//	primary    = number | string | name | name "(" [ expr { "," expr } ] ")" | "(" expr ")"
+//
+// "a unless b" holds when a or b does. Comparisons whose operand kinds are
+// known are checked as they are compiled, and string literals compared with
+// dates or numbers are parsed then.
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+	loc    *time.Location
+
+	derived    map[string]bool // derived fields defined so far
+	columns    map[string]bool // source columns referred to; nil if there are none
+	firstField string          // first record field referred to
+}
+
+func (p *exprParser) parse(src string) (exprNode, error) {
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p.tokens, p.pos, p.firstField = tokens, 0, ""
+
+	node, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != "eof" {
+		return nil, fmt.Errorf("unexpected %q at %d", tok.text, tok.pos)
+	}
+	return node, nil
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != "eof" {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the given keyword or operator
+func (p *exprParser) accept(text string) bool {
+	tok := p.peek()
+	if (tok.kind == "ident" || tok.kind == "op") && tok.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(text string) error {
+	if !p.accept(text) {
+		tok := p.peek()
+		if tok.kind == "eof" {
+			return fmt.Errorf("expected %q at end of expression", text)
+		}
+		return fmt.Errorf("expected %q at %d, got %q", text, tok.pos, tok.text)
+	}
+	return nil
+}
+
+func (p *exprParser) expr() (exprNode, error) {
+	node, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.accept("unless") {
+		exception, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return logicExpr{and: false, left: node, right: exception}, nil
+	}
+	return node, nil
+}
+
+func (p *exprParser) or() (exprNode, error) {
+	node, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		node = logicExpr{and: false, left: node, right: right}
+	}
+	return node, nil
+}
+
+func (p *exprParser) and() (exprNode, error) {
+	node, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		node = logicExpr{and: true, left: node, right: right}
+	}
+	return node, nil
+}
+
+func (p *exprParser) not() (exprNode, error) {
+	if p.accept("not") {
+		node, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{node}, nil
+	}
+	return p.comparison()
+}
+
+func (p *exprParser) comparison() (exprNode, error) {
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	switch {
+	case tok.kind == "op":
+		switch tok.text {
+		case "=", "!=", "<", "<=", ">", ">=":
+			p.next()
+			var right exprNode
+			if left, right, err = p.operandOf(left); err != nil {
+				return nil, err
+			}
+			return binaryExpr{op: tok.text, left: left, right: right}, nil
+		}
+		return left, nil
+	case tok.kind != "ident":
+		return left, nil
+	case tok.text == "is":
+		p.next()
+		negate := p.accept("not")
+		if err := p.expect("empty"); err != nil {
+			return nil, err
+		}
+		return negateIf(negate, emptyExpr{left}), nil
+	}
+
+	negate := false
+	if tok.text == "not" {
+		p.next()
+		negate = true
+		tok = p.peek()
+	}
+	if tok.kind != "ident" {
+		return nil, fmt.Errorf("expected a comparison at %d, got %q", tok.pos, tok.text)
+	}
+	// Allow "starts with" as well as "starts_with"
+	op := tok.text
+	if (op == "starts" || op == "ends") && p.tokens[p.pos+1].text == "with" {
+		p.next()
+		op += "_with"
+	}
+
+	var node exprNode
+	switch op {
+	case "between":
+		p.next()
+		var low, high exprNode
+		if left, low, err = p.operandOf(left); err != nil {
+			return nil, err
+		}
+		if err := p.expect("and"); err != nil {
+			return nil, err
+		}
+		if left, high, err = p.operandOf(left); err != nil {
+			return nil, err
+		}
+		node = betweenExpr{x: left, low: low, high: high}
+	case "in":
+		p.next()
+		closing := ")"
+		if p.accept("[") {
+			closing = "]"
+		} else if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		in := inExpr{}
+		for {
+			var item exprNode
+			if left, item, err = p.operandOf(left); err != nil {
+				return nil, err
+			}
+			in.list = append(in.list, item)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect(closing); err != nil {
+			return nil, err
+		}
+		in.x = left
+		node = in
+	case "starts_with", "ends_with", "contains":
+		p.next()
+		if err := needText(op, left, tok.pos); err != nil {
+			return nil, err
+		}
+		start := p.peek()
+		right, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if err := needText(op, right, start.pos); err != nil {
+			return nil, err
+		}
+		node = textExpr{op: op, left: left, right: right}
+	case "matches":
+		p.next()
+		if err := needText(op, left, tok.pos); err != nil {
+			return nil, err
+		}
+		pattern := p.next()
+		if pattern.kind != "string" {
+			return nil, fmt.Errorf("matches needs a quoted pattern at %d", pattern.pos)
+		}
+		re, err := regexp.Compile(pattern.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern.text, err)
+		}
+		node = matchExpr{x: left, re: re}
+	default:
+		if !negate {
+			// Not an operator; parse reports what follows the expression
+			return left, nil
+		}
+		return nil, fmt.Errorf("unknown operator %q at %d", tok.text, tok.pos)
+	}
+	return negateIf(negate, node), nil
+}
+
+func negateIf(negate bool, node exprNode) exprNode {
+	if negate {
+		return notExpr{node}
+	}
+	return node
+}
+
+// needText checks that node can be text, for a text operator
+func needText(op string, node exprNode, pos int) error {
+	if kind, ok := staticKind(node); ok && kind != kindString {
+		return fmt.Errorf("%s needs a string, got a %s at %d", op, kind, pos)
+	}
+	return nil
+}
+
+// operandOf parses the operand compared with left and returns both. A
+// string literal compared with a date or number is parsed as one; other
+// operands of different known kinds cannot be compared.
+func (p *exprParser) operandOf(left exprNode) (exprNode, exprNode, error) {
+	start := p.peek()
+	right, err := p.sum()
+	if err != nil {
+		return nil, nil, err
+	}
+	kind, ok := staticKind(left)
+	other, ok2 := staticKind(right)
+	if !ok || !ok2 || kind == other {
+		return left, right, nil
+	}
+	if lit, err := p.literalOf(right, kind, start.pos); err != nil || lit != nil {
+		return left, lit, err
+	}
+	if lit, err := p.literalOf(left, other, start.pos); err != nil || lit != nil {
+		return lit, right, err
+	}
+	return nil, nil, fmt.Errorf("cannot compare a %s with a %s at %d", kind, other, start.pos)
+}
+
+// literalOf parses node as a date or number literal of the given kind if it
+// is a string literal, and returns nil otherwise
+func (p *exprParser) literalOf(node exprNode, kind exprKind, pos int) (exprNode, error) {
+	lit, ok := node.(literalExpr)
+	if !ok || lit.value.kind != kindString {
+		return nil, nil
+	}
+	switch kind {
+	case kindTime:
+		t, err := parseExprDate(strings.TrimSpace(lit.value.str), p.loc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q at %d", lit.value.str, pos)
+		}
+		return literalExpr{timeValue(t)}, nil
+	case kindNumber:
+		num, err := money.ParseDecimal(strings.TrimSpace(lit.value.str))
+		if err != nil {
+			return nil, fmt.Errorf("cannot compare a number with %q at %d", lit.value.str, pos)
+		}
+		return literalExpr{numberValue(num)}, nil
+	}
+	return nil, nil
+}
+
+func (p *exprParser) sum() (exprNode, error) {
+	node, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != "op" || (tok.text != "+" && tok.text != "-") {
+			return node, nil
+		}
+		p.next()
+
+		// date + 30d
+		if p.peek().kind == "duration" {
+			sign := 1
+			if tok.text == "-" {
+				sign = -1
+			}
+			offset, err := parseOffset(p.next(), sign)
+			if err != nil {
+				return nil, err
+			}
+			node = offsetExpr{x: node, offset: offset}
+			continue
+		}
+
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		node = binaryExpr{op: tok.text, left: node, right: right}
+	}
+}
+
+func (p *exprParser) term() (exprNode, error) {
+	node, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != "op" || (tok.text != "*" && tok.text != "/" && tok.text != "%") {
+			return node, nil
+		}
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		node = binaryExpr{op: tok.text, left: node, right: right}
+	}
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if p.accept("-") {
+		node, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		// Fold negative literals, so that they stay literals
+		if lit, ok := node.(literalExpr); ok && lit.value.kind == kindNumber {
+			return literalExpr{numberValue(new(big.Rat).Neg(lit.value.num))}, nil
+		}
+		return negateExpr{node}, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case "number":
+		num, err := money.ParseDecimal(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", tok.text, tok.pos)
+		}
+		return literalExpr{numberValue(num)}, nil
+	case "string":
+		return literalExpr{stringValue(tok.text)}, nil
+	case "op":
+		if tok.text == "(" {
+			node, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expect(")")
+		}
+	case "ident":
+		if p.peek().text == "(" && p.peek().kind == "op" {
+			return p.call(tok)
+		}
+		return p.name(tok)
+	case "eof":
+		return nil, fmt.Errorf("expression ends early")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", tok.text, tok.pos)
+}
+
+// name resolves a plain name
+func (p *exprParser) name(tok exprToken) (exprNode, error) {
+	switch tok.text {
+	case "true", "false":
+		return literalExpr{boolValue(tok.text == "true")}, nil
+	case "now", "today":
+		return nowExpr{today: tok.text == "today"}, nil
+	case "and", "or", "not":
+		return nil, fmt.Errorf("unexpected %q at %d", tok.text, tok.pos)
+	}
+	if f, ok := recordFields[tok.text]; ok {
+		if p.firstField == "" {
+			p.firstField = f.field
+		}
+		return fieldExpr{field: f.field, kind: f.kind}, nil
+	}
+	if p.derived[tok.text] {
+		return derivedExpr{tok.text}, nil
+	}
+	if p.columns == nil {
+		return nil, fmt.Errorf("unknown field %q at %d", tok.text, tok.pos)
+	}
+	p.columns[tok.text] = true
+	return columnExpr{tok.text}, nil
+}
+
+// call parses the arguments of a function call
+func (p *exprParser) call(name exprToken) (exprNode, error) {
+	p.next() // "("
+
+	// column('Header') names a source column explicitly
+	if name.text == "column" {
+		if p.columns == nil {
+			return nil, fmt.Errorf("source columns cannot be read here, at %d", name.pos)
+		}
+		tok := p.next()
+		if tok.kind != "string" || strings.TrimSpace(tok.text) == "" {
+			return nil, fmt.Errorf("column needs a quoted column name at %d", tok.pos)
+		}
+		column := strings.ToLower(strings.TrimSpace(tok.text))
+		p.columns[column] = true
+		return columnExpr{column}, p.expect(")")
+	}
+
+	fn, ok := exprFuncs[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at %d", name.text, name.pos)
+	}
+	var args []exprNode
+	if !p.accept(")") {
+		for {
+			arg, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("wrong number of arguments to %s at %d", name.text, name.pos)
+	}
+
+	// Check literal band limits now rather than on every record
+	if name.text == "band" {
+		var prev *big.Rat
+		for _, arg := range args[1:] {
+			lit, ok := arg.(literalExpr)
+			if !ok || lit.value.kind != kindNumber {
+				continue
+			}
+			if prev != nil && lit.value.num.Cmp(prev) <= 0 {
+				return nil, fmt.Errorf("band limits must increase at %d", name.pos)
+			}
+			prev = lit.value.num
+		}
+	}
+	return callExpr{name: name.text, fn: fn, args: args}, nil
+}
+
+// parseOffset reads a duration token such as 30d as years, months and days,
+// negated when sign is -1
+func parseOffset(tok exprToken, sign int) ([3]int, error) {
+	var offset [3]int
+	if tok.kind != "duration" {
+		return offset, fmt.Errorf("expected a duration such as 30d at %d, got %q", tok.pos, tok.text)
+	}
+	n, err := strconv.Atoi(tok.text[:len(tok.text)-1])
+	if err != nil {
+		return offset, fmt.Errorf("invalid duration %q at %d", tok.text, tok.pos)
+	}
+	switch tok.text[len(tok.text)-1] {
+	case 'y', 'Y':
+		offset[0] = sign * n
+	case 'm', 'M':
+		offset[1] = sign * n
+	case 'w', 'W':
+		offset[2] = sign * n * 7
+	case 'd', 'D':
+		offset[2] = sign * n
+	default:
+		return offset, fmt.Errorf("invalid duration unit in %q at %d; use d, w, m or y", tok.text, tok.pos)
+	}
+	return offset, nil
+}
+
+func parseExprDate(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, loc)
+}
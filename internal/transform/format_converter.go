@@ -1,15 +1,20 @@
 package transform
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/money"
+	"abt-dashboard/internal/quarantine"
 
 	"gopkg.in/yaml.v2"
 )
@@ -23,25 +28,101 @@ const (
 	FormatYAML DataFormat = "yaml"
 	FormatTSV  DataFormat = "tsv"
 	FormatXML  DataFormat = "xml"
+
+	// FormatNDJSON is newline-delimited JSON (JSON Lines): one object per line
+	FormatNDJSON DataFormat = "ndjson"
+
+	// FormatXLSX is an Excel workbook; see XLSXConfig for sheet selection
+	FormatXLSX DataFormat = "xlsx"
 )
 
 // FormatConverter handles conversion between different data formats
 type FormatConverter struct {
 	config TransformConfig
+	fields map[string]fieldMatcher
+
+	// sources are the unmapped columns derived fields read, by name
+	sources map[string]fieldMatcher
+
+	// location is the zone of timestamps without an offset
+	location *time.Location
+
+	// captureFields sets SourceRecord.Fields on every record
+	captureFields bool
+
+	// required are the fields a record cannot do without, recordKeys the
+	// keys of a JSON or YAML wrapper object whose array holds the records,
+	// and dateField the field whose spreadsheet date serials are converted:
+	// transactions by default, inventory for StreamInventory
+	required   []string
+	recordKeys []string
+	dateField  string
 }
 
 // NewFormatConverter creates a new format converter
 func NewFormatConverter(config TransformConfig) *FormatConverter {
-	return &FormatConverter{config: config}
+	fc := &FormatConverter{
+		config:   config,
+		fields:   compileColumnMappings(config.ColumnMappings, config.XML.FieldPaths),
+		location: config.Timezone.sourceLocation(""),
+
+		required:   requiredFields,
+		recordKeys: []string{"transactions", "data"},
+		dateField:  "transaction_date",
+	}
+	if derived, err := NewDerivedFields(config); err == nil && len(derived.Columns()) > 0 {
+		fc.sources = make(map[string]fieldMatcher, len(derived.Columns()))
+		for _, column := range derived.Columns() {
+			fc.sources[column] = fieldMatcher{aliases: []string{column}}
+		}
+	}
+	return fc
 }
 
-// DetectFormat attempts to detect the format of input data
+// forSource returns a converter that reads timestamps without an offset in the
+// zone configured for the named source
+func (fc *FormatConverter) forSource(name string) *FormatConverter {
+	loc := fc.config.Timezone.sourceLocation(name)
+	if loc == fc.location {
+		return fc
+	}
+	scoped := *fc
+	scoped.location = loc
+	return &scoped
+}
+
+// withFields returns a converter that also reports every source column of
+// each record in SourceRecord.Fields, for profiling
+func (fc *FormatConverter) withFields() *FormatConverter {
+	capturing := *fc
+	capturing.captureFields = true
+	return &capturing
+}
+
+// DetectFormat attempts to detect the format of input data. To tell JSON
+// Lines from JSON, data must hold the first line and the start of the next;
+// processSource reads that far for sources that start with an object.
 func (fc *FormatConverter) DetectFormat(data []byte) DataFormat {
+	// Excel workbooks are zip archives and must be checked on raw bytes
+	if isXLSXData(data) {
+		return FormatXLSX
+	}
+
 	dataStr := strings.TrimSpace(string(data))
 
-	// Check for JSON
-	if (strings.HasPrefix(dataStr, "{") && strings.HasSuffix(dataStr, "}")) ||
-		(strings.HasPrefix(dataStr, "[") && strings.HasSuffix(dataStr, "]")) {
+	// Check for JSON Lines: a complete object on the first line followed by
+	// another object. A JSON document may be cut off by the sample size, so
+	// only its opening character is checked; a first line cut off the same
+	// way is taken for a JSON document.
+	if strings.HasPrefix(dataStr, "{") {
+		lines := strings.SplitN(dataStr, "\n", 3)
+		if len(lines) > 1 && json.Valid([]byte(strings.TrimSpace(lines[0]))) &&
+			strings.HasPrefix(strings.TrimSpace(lines[1]), "{") {
+			return FormatNDJSON
+		}
+		return FormatJSON
+	}
+	if strings.HasPrefix(dataStr, "[") {
 		return FormatJSON
 	}
 
@@ -65,6 +146,193 @@ func (fc *FormatConverter) DetectFormat(data []byte) DataFormat {
 	return FormatCSV
 }
 
+// RecordHandler receives records as a FormatConverter streams them, together
+// with where each record came from
+type RecordHandler struct {
+	OnRecord func(rec SourceRecord, tx models.Transaction) error
+	OnSkip   func(rec SourceRecord, err error)
+}
+
+// recordSink converts the records a stream yields and hands them on:
+// transactionSink for StreamTransactions, inventorySink for StreamInventory.
+// Conversion errors go to the handler's OnSkip; only errors returned by the
+// handler stop the stream.
+type recordSink interface {
+	// row converts a CSV, TSV or worksheet row
+	row(record []string, columnMap map[string]int, rec SourceRecord) error
+	// object converts a JSON, YAML or XML record
+	object(data map[string]interface{}, rec SourceRecord) error
+	// skip reports a record that could not be decoded
+	skip(rec SourceRecord, err error)
+}
+
+// transactionSink converts records to transactions for a RecordHandler
+type transactionSink struct {
+	fc      *FormatConverter
+	handler RecordHandler
+}
+
+func (s transactionSink) row(record []string, columnMap map[string]int, rec SourceRecord) error {
+	tx, err := s.fc.parseRecordToTransaction(record, columnMap, &rec)
+	if err != nil {
+		s.handler.OnSkip(rec, err)
+		return nil
+	}
+	return s.handler.OnRecord(rec, *tx)
+}
+
+func (s transactionSink) object(data map[string]interface{}, rec SourceRecord) error {
+	tx, err := s.fc.mapToTransaction(data, &rec)
+	if err != nil {
+		s.handler.OnSkip(rec, err)
+		return nil
+	}
+	return s.handler.OnRecord(rec, *tx)
+}
+
+func (s transactionSink) skip(rec SourceRecord, err error) {
+	s.handler.OnSkip(rec, err)
+}
+
+// SourceRecord describes a record as it appeared in the source. Line is the
+// 1-based position of the record: the file line the record starts on for CSV
+// and TSV, the physical line for NDJSON, the worksheet row for XLSX and the
+// element index for JSON, YAML and XML. Defaults lists the fields that had no
+// usable value and were filled in from the configuration; Rounded lists the
+// values that had more decimals than their currency allows. Columns holds
+// the unmapped columns derived fields read, by lower case name. Fields lists
+// every source column in source order, but only when profiling.
+type SourceRecord struct {
+	Line     int
+	Defaults []FieldDefault
+	Rounded  []FieldRounding
+	Columns  map[string]string
+	Fields   []SourceField
+	raw      func() string
+}
+
+// SourceField is a source column of a record and its value as text. Nested
+// JSON, YAML and XML values are named by their path, as in "customer/name".
+type SourceField struct {
+	Name  string
+	Value string
+}
+
+// csvFields pairs a row's values with the header. Missing values are left
+// out; values beyond the header are named by their 1-based position.
+func csvFields(header, record []string) []SourceField {
+	fields := make([]SourceField, 0, len(record))
+	for i, value := range record {
+		name := fmt.Sprintf("column_%d", i+1)
+		if i < len(header) {
+			name = header[i]
+		}
+		fields = append(fields, SourceField{Name: name, Value: value})
+	}
+	return fields
+}
+
+// objectFields lists the values of a decoded JSON or YAML object by sorted
+// key. Nested objects are flattened into "parent/child" paths.
+func objectFields(data map[string]interface{}) []SourceField {
+	var fields []SourceField
+	var walk func(prefix string, object map[string]interface{})
+	walk = func(prefix string, object map[string]interface{}) {
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := object[key]
+			if nested, ok := toStringMap(value); ok {
+				walk(prefix+key+"/", nested)
+				continue
+			}
+			text := ""
+			if value != nil {
+				text = valueString(value)
+			}
+			fields = append(fields, SourceField{Name: prefix + key, Value: text})
+		}
+	}
+	walk("", data)
+	return fields
+}
+
+// setColumn records the value of an unmapped source column
+func (r *SourceRecord) setColumn(name, value string) {
+	if r.Columns == nil {
+		r.Columns = make(map[string]string)
+	}
+	r.Columns[name] = value
+}
+
+// Raw renders the record in its source syntax: the CSV row, the NDJSON line
+// or the XML element. JSON and YAML elements are rendered as compact JSON and
+// worksheet rows as CSV. It
+// must be called before the handler returns, as the underlying buffers are
+// reused for the next record.
+func (r SourceRecord) Raw() string {
+	if r.raw == nil {
+		return ""
+	}
+	return r.raw()
+}
+
+// FieldDefault records that a field was filled in with a default value
+type FieldDefault struct {
+	Field string
+	Value string
+}
+
+// FieldRounding records that a value lost precision when it was rounded to
+// the minor units of its currency
+type FieldRounding struct {
+	Field  string
+	Value  string // as written in the source
+	Result string // as stored
+}
+
+// FieldError is a record error attributed to one standard field
+// (transaction_id, country, region, product_name, price, quantity,
+// transaction_date, currency or timezone)
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// fieldErrorf creates a FieldError with a formatted message
+func fieldErrorf(field, format string, args ...interface{}) error {
+	return &FieldError{Field: field, Err: fmt.Errorf(format, args...)}
+}
+
+// jsonRaw renders a decoded record as compact JSON
+func jsonRaw(item interface{}) func() string {
+	return func() string {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return fmt.Sprintf("%v", item)
+		}
+		return string(data)
+	}
+}
+
+// decodeJSONNumbers unmarshals JSON keeping numbers as json.Number
+func decodeJSONNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
 // ConvertToTransactions converts data from various formats to Transaction slice
 func (fc *FormatConverter) ConvertToTransactions(reader io.Reader, format DataFormat) ([]models.Transaction, error) {
 	switch format {
@@ -74,31 +342,99 @@ func (fc *FormatConverter) ConvertToTransactions(reader io.Reader, format DataFo
 		return fc.parseCSV(reader, '\t')
 	case FormatJSON:
 		return fc.parseJSON(reader)
+	case FormatNDJSON:
+		return fc.parseNDJSON(reader)
 	case FormatYAML:
 		return fc.parseYAML(reader)
+	case FormatXML:
+		return fc.parseXML(reader)
+	case FormatXLSX:
+		return fc.parseXLSX(reader)
 	default:
 		return nil, fmt.Errorf("unsupported format: %s", format)
 	}
 }
 
+// StreamTransactions parses the input one record at a time and hands each
+// record to the handler instead of collecting them, so memory use does not
+// grow with the size of the input. Returning an error from OnRecord stops
+// the stream and is returned to the caller.
+func (fc *FormatConverter) StreamTransactions(reader io.Reader, format DataFormat, handler RecordHandler) error {
+	if handler.OnSkip == nil {
+		handler.OnSkip = func(SourceRecord, error) {}
+	}
+	return fc.stream(reader, format, transactionSink{fc: fc, handler: handler})
+}
+
+// stream parses the input one record at a time into the sink
+func (fc *FormatConverter) stream(reader io.Reader, format DataFormat, sink recordSink) error {
+	switch format {
+	case FormatCSV:
+		return fc.streamCSV(reader, ',', sink)
+	case FormatTSV:
+		return fc.streamCSV(reader, '\t', sink)
+	case FormatJSON:
+		return fc.streamJSON(reader, sink)
+	case FormatNDJSON:
+		return fc.streamNDJSON(reader, sink)
+	case FormatYAML:
+		// YAML documents cannot be decoded incrementally, so decode the
+		// document first and stream the resulting records
+		var data interface{}
+		if err := yaml.NewDecoder(reader).Decode(&data); err != nil {
+			return fmt.Errorf("failed to parse YAML: %w", err)
+		}
+		return fc.streamRecords(data, sink)
+	case FormatXML:
+		return fc.streamXML(reader, sink)
+	case FormatXLSX:
+		return fc.streamXLSX(reader, sink)
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
 // parseCSV handles CSV and TSV parsing with flexible column mapping
 func (fc *FormatConverter) parseCSV(reader io.Reader, delimiter rune) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+
+	err := fc.streamCSV(reader, delimiter, transactionSink{fc: fc, handler: RecordHandler{
+		OnRecord: func(rec SourceRecord, tx models.Transaction) error {
+			transactions = append(transactions, tx)
+			return nil
+		},
+		OnSkip: func(rec SourceRecord, err error) {
+			// Log error but continue processing
+			fmt.Printf("Warning: Failed to parse line %d: %v\n", rec.Line, err)
+		},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// streamCSV reads CSV or TSV records one at a time
+func (fc *FormatConverter) streamCSV(reader io.Reader, delimiter rune, sink recordSink) error {
 	csvReader := csv.NewReader(reader)
 	csvReader.Comma = delimiter
 	csvReader.TrimLeadingSpace = true
 	csvReader.FieldsPerRecord = -1 // Allow variable number of fields
+	csvReader.ReuseRecord = true
 
 	// Read header
 	header, err := csvReader.Read()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read header: %w", err)
+		return fmt.Errorf("failed to read header: %w", err)
 	}
 
 	// Create flexible column mapping
 	columnMap := fc.createColumnMapping(header)
-
-	var transactions []models.Transaction
-	lineNumber := 1
+	if fc.captureFields {
+		// The reader reuses the header's backing array for the next row
+		header = append([]string(nil), header...)
+	}
 
 	for {
 		record, err := csvReader.Read()
@@ -106,27 +442,33 @@ func (fc *FormatConverter) parseCSV(reader io.Reader, delimiter rune) ([]models.
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("error reading line %d: %w", lineNumber, err)
+			// csv.ParseError already names the file line
+			return fmt.Errorf("error reading record: %w", err)
 		}
 
-		transaction, err := fc.parseRecordToTransaction(record, columnMap)
-		if err != nil {
-			// Log error but continue processing
-			fmt.Printf("Warning: Failed to parse line %d: %v\n", lineNumber, err)
-			continue
+		// The line the record starts on, counting the header and the
+		// line breaks inside quoted fields
+		line, _ := csvReader.FieldPos(0)
+		rec := SourceRecord{
+			Line: line,
+			raw:  func() string { return quarantine.RawCSV(record, delimiter) },
+		}
+		if fc.captureFields {
+			rec.Fields = csvFields(header, record)
+		}
+		if err := sink.row(record, columnMap, rec); err != nil {
+			return err
 		}
-
-		transactions = append(transactions, *transaction)
-		lineNumber++
 	}
 
-	return transactions, nil
+	return nil
 }
 
 // parseJSON handles JSON format parsing
 func (fc *FormatConverter) parseJSON(reader io.Reader) ([]models.Transaction, error) {
 	var data interface{}
 	decoder := json.NewDecoder(reader)
+	decoder.UseNumber() // keep numbers as written for exact prices
 
 	if err := decoder.Decode(&data); err != nil {
 		return nil, fmt.Errorf("failed to parse JSON: %w", err)
@@ -135,6 +477,239 @@ func (fc *FormatConverter) parseJSON(reader io.Reader) ([]models.Transaction, er
 	return fc.extractTransactionsFromData(data)
 }
 
+// streamJSON decodes a JSON document element by element. Top-level arrays and
+// the record arrays of a wrapper object, such as "transactions" or "data",
+// are streamed; any other shape is decoded whole and handled like parseJSON
+// would. Data after the document, such as the further lines of JSON Lines,
+// is an error rather than being dropped.
+func (fc *FormatConverter) streamJSON(reader io.Reader, sink recordSink) error {
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber() // keep numbers as written for exact prices
+
+	if err := fc.streamJSONDocument(decoder, sink); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after the JSON document at byte %d; JSON Lines files need an .ndjson or .jsonl extension",
+			decoder.InputOffset())
+	}
+	return nil
+}
+
+// streamJSONDocument decodes the top-level value of a JSON document
+func (fc *FormatConverter) streamJSONDocument(decoder *json.Decoder, sink recordSink) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return fmt.Errorf("failed to parse JSON: %w", err)
+	}
+
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return fmt.Errorf("unsupported data structure type: %T", token)
+	}
+
+	line := 0
+	switch delim {
+	case '[':
+		return fc.streamJSONArray(decoder, &line, sink)
+	case '{':
+		wrapper := make(map[string]interface{})
+		streamed := false
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return fmt.Errorf("failed to parse JSON: %w", err)
+			}
+			key, _ := keyToken.(string)
+
+			if fc.isRecordKey(key) && !streamed {
+				next, err := decoder.Token()
+				if err != nil {
+					return fmt.Errorf("failed to parse JSON: %w", err)
+				}
+				if d, ok := next.(json.Delim); ok && d == '[' {
+					if err := fc.streamJSONArray(decoder, &line, sink); err != nil {
+						return err
+					}
+					streamed = true
+					continue
+				}
+				return fmt.Errorf("unsupported data structure for %q: %v", key, next)
+			}
+
+			var value interface{}
+			if err := decoder.Decode(&value); err != nil {
+				return fmt.Errorf("failed to parse JSON: %w", err)
+			}
+			wrapper[key] = value
+		}
+		if _, err := decoder.Token(); err != nil { // "}"
+			return fmt.Errorf("failed to parse JSON: %w", err)
+		}
+		if streamed {
+			return nil
+		}
+		return fc.streamRecords(wrapper, sink)
+	default:
+		return fmt.Errorf("unsupported data structure type: %v", delim)
+	}
+}
+
+// streamJSONArray decodes array elements until the closing bracket
+func (fc *FormatConverter) streamJSONArray(decoder *json.Decoder, line *int, sink recordSink) error {
+	for decoder.More() {
+		*line++
+		var item interface{}
+		if err := decoder.Decode(&item); err != nil {
+			return fmt.Errorf("failed to parse JSON element %d: %w", *line, err)
+		}
+
+		rec := SourceRecord{Line: *line, raw: jsonRaw(item)}
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			sink.skip(rec, fmt.Errorf("unsupported record type: %T", item))
+			continue
+		}
+
+		if fc.captureFields {
+			rec.Fields = objectFields(itemMap)
+		}
+		if err := sink.object(itemMap, rec); err != nil {
+			return err
+		}
+	}
+
+	// Consume the closing bracket
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	return nil
+}
+
+// streamRecords hands already-decoded JSON/YAML records to the handler.
+// Elements of a record array that are not objects are ignored.
+func (fc *FormatConverter) streamRecords(data interface{}, sink recordSink) error {
+	switch v := data.(type) {
+	case []interface{}:
+		// Array of record objects
+		for i, item := range v {
+			itemMap, ok := toStringMap(item)
+			if !ok {
+				continue
+			}
+			rec := SourceRecord{Line: i + 1, raw: jsonRaw(itemMap)}
+			if fc.captureFields {
+				rec.Fields = objectFields(itemMap)
+			}
+			if err := sink.object(itemMap, rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}, map[interface{}]interface{}:
+		// Single record object or object containing records
+		itemMap, _ := toStringMap(v)
+		for _, key := range fc.recordKeys {
+			if records, exists := itemMap[key]; exists {
+				return fc.streamRecords(records, sink)
+			}
+		}
+
+		// Treat as single record
+		rec := SourceRecord{Line: 1, raw: jsonRaw(itemMap)}
+		if fc.captureFields {
+			rec.Fields = objectFields(itemMap)
+		}
+		return sink.object(itemMap, rec)
+	default:
+		return fmt.Errorf("unsupported data structure type: %T", data)
+	}
+}
+
+// isRecordKey reports whether a key of a wrapper object holds the records
+func (fc *FormatConverter) isRecordKey(key string) bool {
+	for _, recordKey := range fc.recordKeys {
+		if key == recordKey {
+			return true
+		}
+	}
+	return false
+}
+
+// toStringMap converts a decoded JSON or YAML object to a string-keyed map.
+// YAML decodes mappings with interface{} keys; non-string keys are dropped.
+func toStringMap(item interface{}) (map[string]interface{}, bool) {
+	switch m := item.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		stringMap := make(map[string]interface{}, len(m))
+		for key, value := range m {
+			if keyStr, ok := key.(string); ok {
+				stringMap[keyStr] = value
+			}
+		}
+		return stringMap, true
+	}
+	return nil, false
+}
+
+// parseNDJSON handles JSON Lines parsing
+func (fc *FormatConverter) parseNDJSON(reader io.Reader) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+
+	err := fc.streamNDJSON(reader, transactionSink{fc: fc, handler: RecordHandler{
+		OnRecord: func(rec SourceRecord, tx models.Transaction) error {
+			transactions = append(transactions, tx)
+			return nil
+		},
+		OnSkip: func(rec SourceRecord, err error) {
+			fmt.Printf("Warning: Failed to parse line %d: %v\n", rec.Line, err)
+		},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// streamNDJSON decodes one JSON object per line. A malformed line is skipped
+// and reported rather than failing the whole load.
+func (fc *FormatConverter) streamNDJSON(reader io.Reader, sink recordSink) error {
+	bufReader := bufio.NewReader(reader)
+	lineNumber := 0
+
+	for {
+		raw, readErr := bufReader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("error reading line %d: %w", lineNumber+1, readErr)
+		}
+		if len(raw) > 0 {
+			lineNumber++
+		}
+
+		if line := bytes.TrimSpace(raw); len(line) > 0 {
+			rec := SourceRecord{Line: lineNumber, raw: func() string { return string(line) }}
+			var item map[string]interface{}
+			if err := decodeJSONNumbers(line, &item); err != nil {
+				sink.skip(rec, fmt.Errorf("invalid JSON: %w", err))
+			} else {
+				if fc.captureFields {
+					rec.Fields = objectFields(item)
+				}
+				if err := sink.object(item, rec); err != nil {
+					return err
+				}
+			}
+		}
+
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
 // parseYAML handles YAML format parsing
 func (fc *FormatConverter) parseYAML(reader io.Reader) ([]models.Transaction, error) {
 	var data interface{}
@@ -147,49 +722,39 @@ func (fc *FormatConverter) parseYAML(reader io.Reader) ([]models.Transaction, er
 	return fc.extractTransactionsFromData(data)
 }
 
-// createColumnMapping creates flexible column mapping for various header formats
+// createColumnMapping maps each standard field to the index of the header
+// column it is read from, as configured by column_mappings
 func (fc *FormatConverter) createColumnMapping(header []string) map[string]int {
 	columnMap := make(map[string]int)
 
-	// Define possible column name variations
-	columnVariations := map[string][]string{
-		"transaction_id":   {"transaction_id", "id", "trans_id", "txn_id", "transaction", "order_id"},
-		"country":          {"country", "nation", "country_name", "country_code"},
-		"region":           {"region", "state", "province", "area", "zone", "territory"},
-		"product_name":     {"product_name", "product", "item", "item_name", "product_title"},
-		"price":            {"price", "unit_price", "cost", "amount", "unit_cost", "price_per_unit"},
-		"quantity":         {"quantity", "qty", "amount", "count", "units", "number"},
-		"transaction_date": {"transaction_date", "date", "timestamp", "time", "tx_date", "order_date"},
-	}
-
-	// Map header columns to standard field names
-	for i, col := range header {
-		normalizedCol := strings.ToLower(strings.TrimSpace(col))
-
-		// Direct mapping
-		columnMap[normalizedCol] = i
-
-		// Find matching standard field
-		for standardField, variations := range columnVariations {
-			for _, variation := range variations {
-				if normalizedCol == variation {
-					columnMap[standardField] = i
-					break
-				}
-			}
+	for field, matcher := range fc.fields {
+		if idx := matcher.matchColumn(header); idx >= 0 {
+			columnMap[field] = idx
+		}
+	}
+	for name, matcher := range fc.sources {
+		if idx := matcher.matchColumn(header); idx >= 0 {
+			columnMap[sourceColumnKey(name)] = idx
 		}
 	}
 
 	return columnMap
 }
 
-// parseRecordToTransaction converts a record to Transaction with flexible field mapping
-func (fc *FormatConverter) parseRecordToTransaction(record []string, columnMap map[string]int) (*models.Transaction, error) {
+// sourceColumnKey is the column map key of an unmapped source column, which
+// cannot clash with a standard field
+func sourceColumnKey(name string) string {
+	return "source:" + name
+}
+
+// parseRecordToTransaction converts a record to Transaction with flexible field
+// mapping. Fields filled in from defaults and prices that had to be rounded
+// are noted on rec.
+func (fc *FormatConverter) parseRecordToTransaction(record []string, columnMap map[string]int, rec *SourceRecord) (*models.Transaction, error) {
 	tx := &models.Transaction{}
 
-	// Helper function to safely get field value
 	getField := func(fieldName string) string {
-		if idx, exists := columnMap[fieldName]; exists && idx < len(record) {
+		if idx, ok := columnMap[fieldName]; ok && idx < len(record) {
 			return strings.TrimSpace(record[idx])
 		}
 		return ""
@@ -198,68 +763,116 @@ func (fc *FormatConverter) parseRecordToTransaction(record []string, columnMap m
 	// Transaction ID
 	tx.ID = getField("transaction_id")
 	if tx.ID == "" {
-		return nil, fmt.Errorf("transaction ID is required")
+		return nil, fieldErrorf("transaction_id", "transaction ID is required")
 	}
 
 	// Country
 	tx.Country = getField("country")
 	if tx.Country == "" {
 		tx.Country = fc.config.DefaultCountry
+		rec.Defaults = append(rec.Defaults, FieldDefault{Field: "country", Value: tx.Country})
 	}
 
 	// Region
 	tx.Region = getField("region")
 	if tx.Region == "" {
 		tx.Region = fc.config.DefaultRegion
+		rec.Defaults = append(rec.Defaults, FieldDefault{Field: "region", Value: tx.Region})
 	}
 
 	// Product Name
 	tx.ProductName = getField("product_name")
 	if tx.ProductName == "" {
-		return nil, fmt.Errorf("product name is required")
+		return nil, fieldErrorf("product_name", "product name is required")
+	}
+
+	// Currency, which determines the price's decimal places
+	currency, err := fc.resolveCurrency(getField("currency"))
+	if err != nil {
+		return nil, err
 	}
 
 	// Price
 	priceStr := getField("price")
 	if priceStr == "" {
-		return nil, fmt.Errorf("price is required")
+		return nil, fieldErrorf("price", "price is required")
 	}
 
-	price, err := fc.parseFlexiblePrice(priceStr)
-	if err != nil {
-		return nil, fmt.Errorf("invalid price '%s': %w", priceStr, err)
+	if err := fc.setPrice(tx, priceStr, currency, rec); err != nil {
+		return nil, err
 	}
-	tx.UnitPriceCents = price
 
 	// Quantity
 	quantityStr := getField("quantity")
 	if quantityStr == "" {
 		quantityStr = "1" // Default quantity
+		rec.Defaults = append(rec.Defaults, FieldDefault{Field: "quantity", Value: quantityStr})
 	}
 
 	quantity, err := strconv.ParseInt(quantityStr, 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("invalid quantity '%s': %w", quantityStr, err)
+		return nil, fieldErrorf("quantity", "invalid quantity '%s': %w", quantityStr, err)
 	}
 	tx.Quantity = quantity
 
 	// Transaction Date
 	dateStr := getField("transaction_date")
 	if dateStr == "" {
-		return nil, fmt.Errorf("transaction date is required")
+		return nil, fieldErrorf("transaction_date", "transaction date is required")
+	}
+
+	loc, err := fc.recordLocation(getField("timezone"))
+	if err != nil {
+		return nil, err
 	}
 
-	date, err := fc.parseFlexibleDate(dateStr)
+	date, err := fc.parseFlexibleDate(dateStr, loc)
 	if err != nil {
-		return nil, fmt.Errorf("invalid date '%s': %w", dateStr, err)
+		return nil, fieldErrorf("transaction_date", "invalid date '%s': %w", dateStr, err)
 	}
 	tx.TxTime = date
 
+	// Convert to the reporting currency on the transaction date
+	if err := fc.convertCurrency(tx); err != nil {
+		return nil, err
+	}
+
+	// Unmapped columns derived fields read
+	for name := range fc.sources {
+		if value := getField(sourceColumnKey(name)); value != "" {
+			rec.setColumn(name, value)
+		}
+	}
+
 	return tx, nil
 }
 
-// parseFlexiblePrice handles various price formats
-func (fc *FormatConverter) parseFlexiblePrice(priceStr string) (int64, error) {
+// setPrice parses a price in the given currency and stores it on tx, noting
+// on rec when the amount had more decimals than the currency allows
+func (fc *FormatConverter) setPrice(tx *models.Transaction, priceStr, currency string, rec *SourceRecord) error {
+	price, exact, err := fc.parseFlexiblePrice(priceStr, currency)
+	if err != nil {
+		return fieldErrorf("price", "invalid price '%s': %w", priceStr, err)
+	}
+	if !exact {
+		rec.Rounded = append(rec.Rounded, FieldRounding{
+			Field:  "price",
+			Value:  priceStr,
+			Result: fc.formatPrice(price, currency),
+		})
+	}
+
+	tx.UnitPriceCents = price
+	tx.OriginalUnitPriceCents = price
+	tx.OriginalCurrency = currency
+	tx.Currency = currency
+	return nil
+}
+
+// parseFlexiblePrice handles various price formats. The amount is parsed as
+// an exact decimal and rounded to the currency's minor units with the
+// configured rounding mode; exact is false when rounding changed the value.
+func (fc *FormatConverter) parseFlexiblePrice(priceStr, currency string) (price int64, exact bool, err error) {
 	// Remove common currency symbols and formatting
 	cleanPrice := priceStr
 
@@ -276,27 +889,44 @@ func (fc *FormatConverter) parseFlexiblePrice(priceStr string) (int64, error) {
 	// Handle percentage format (remove % sign)
 	cleanPrice = strings.ReplaceAll(cleanPrice, "%", "")
 
-	// Parse as float
-	price, err := strconv.ParseFloat(cleanPrice, 64)
+	amount, err := money.ParseDecimal(cleanPrice)
 	if err != nil {
-		return 0, err
+		return 0, false, err
 	}
 
-	// Convert to cents using configured multiplier
-	return int64(price * fc.config.PriceMultiplier), nil
+	// Convert to minor units of the currency
+	return money.ToMinor(amount, fc.priceScale(currency), fc.rounding())
 }
 
-// parseFlexibleDate handles various date formats
-func (fc *FormatConverter) parseFlexibleDate(dateStr string) (time.Time, error) {
+// recordLocation returns the zone of a record's timestamps: its timezone
+// column when set, else the converter's source zone
+func (fc *FormatConverter) recordLocation(zone string) (*time.Location, error) {
+	if strings.TrimSpace(zone) == "" {
+		if fc.location == nil {
+			return time.UTC, nil
+		}
+		return fc.location, nil
+	}
+	loc, err := loadLocation(zone)
+	if err != nil {
+		return nil, &FieldError{Field: "timezone", Err: err}
+	}
+	return loc, nil
+}
+
+// parseFlexibleDate handles various date formats. Dates without an offset
+// are wall clock time in loc.
+func (fc *FormatConverter) parseFlexibleDate(dateStr string, loc *time.Location) (time.Time, error) {
 	// Try configured date formats
 	for _, format := range fc.config.DateFormats {
-		if date, err := time.Parse(format, dateStr); err == nil {
+		if date, err := time.ParseInLocation(format, dateStr, layoutLocation(format, loc)); err == nil {
 			return date, nil
 		}
 	}
 
 	// Try additional common formats
 	additionalFormats := []string{
+		"2006-01-02 15:04:05",
 		"January 2, 2006",
 		"Jan 2, 2006 15:04:05",
 		"2006-01-02T15:04:05.000Z",
@@ -306,7 +936,7 @@ func (fc *FormatConverter) parseFlexibleDate(dateStr string) (time.Time, error)
 	}
 
 	for _, format := range additionalFormats {
-		if date, err := time.Parse(format, dateStr); err == nil {
+		if date, err := time.ParseInLocation(format, dateStr, layoutLocation(format, loc)); err == nil {
 			return date, nil
 		}
 	}
@@ -315,174 +945,170 @@ func (fc *FormatConverter) parseFlexibleDate(dateStr string) (time.Time, error)
 	if timestamp, err := strconv.ParseInt(dateStr, 10, 64); err == nil {
 		// Check if it's seconds or milliseconds
 		if timestamp > 1e10 { // Likely milliseconds
-			return time.Unix(timestamp/1000, (timestamp%1000)*1e6), nil
+			return time.Unix(timestamp/1000, (timestamp%1000)*1e6).In(loc), nil
 		} else { // Likely seconds
-			return time.Unix(timestamp, 0), nil
+			return time.Unix(timestamp, 0).In(loc), nil
 		}
 	}
 
 	return time.Time{}, fmt.Errorf("unable to parse date format")
 }
 
+// layoutLocation returns the zone a layout is parsed in. Layouts ending in a
+// literal "Z", such as "2006-01-02T15:04:05Z", denote UTC rather than wall
+// clock time.
+func layoutLocation(layout string, loc *time.Location) *time.Location {
+	if strings.HasSuffix(layout, "Z") {
+		return time.UTC
+	}
+	return loc
+}
+
 // extractTransactionsFromData extracts transactions from parsed JSON/YAML data
 func (fc *FormatConverter) extractTransactionsFromData(data interface{}) ([]models.Transaction, error) {
 	var transactions []models.Transaction
 
-	switch v := data.(type) {
-	case []interface{}:
-		// Array of transaction objects
-		for i, item := range v {
-			if itemMap, ok := item.(map[interface{}]interface{}); ok {
-				// Convert map[interface{}]interface{} to map[string]interface{}
-				stringMap := make(map[string]interface{})
-				for key, value := range itemMap {
-					if keyStr, ok := key.(string); ok {
-						stringMap[keyStr] = value
-					}
-				}
-				tx, err := fc.mapToTransaction(stringMap)
-				if err != nil {
-					fmt.Printf("Warning: Failed to parse transaction %d: %v\n", i, err)
-					continue
-				}
-				transactions = append(transactions, *tx)
-			} else if itemMap, ok := item.(map[string]interface{}); ok {
-				tx, err := fc.mapToTransaction(itemMap)
-				if err != nil {
-					fmt.Printf("Warning: Failed to parse transaction %d: %v\n", i, err)
-					continue
-				}
-				transactions = append(transactions, *tx)
-			}
-		}
-	case map[string]interface{}:
-		// Single transaction object or object containing transactions
-		if txArray, exists := v["transactions"]; exists {
-			return fc.extractTransactionsFromData(txArray)
-		} else if txArray, exists := v["data"]; exists {
-			return fc.extractTransactionsFromData(txArray)
-		} else {
-			// Treat as single transaction
-			tx, err := fc.mapToTransaction(v)
-			if err != nil {
-				return nil, err
-			}
-			transactions = append(transactions, *tx)
-		}
-	case map[interface{}]interface{}:
-		// Convert to string map and try again
-		stringMap := make(map[string]interface{})
-		for key, value := range v {
-			if keyStr, ok := key.(string); ok {
-				stringMap[keyStr] = value
-			}
-		}
-		return fc.extractTransactionsFromData(stringMap)
-	default:
-		return nil, fmt.Errorf("unsupported data structure type: %T", data)
+	err := fc.streamRecords(data, transactionSink{fc: fc, handler: RecordHandler{
+		OnRecord: func(rec SourceRecord, tx models.Transaction) error {
+			transactions = append(transactions, tx)
+			return nil
+		},
+		OnSkip: func(rec SourceRecord, err error) {
+			fmt.Printf("Warning: Failed to parse transaction %d: %v\n", rec.Line, err)
+		},
+	}})
+	if err != nil {
+		return nil, err
 	}
 
 	return transactions, nil
 }
 
-// mapToTransaction converts a map to Transaction
-func (fc *FormatConverter) mapToTransaction(data map[string]interface{}) (*models.Transaction, error) {
+// mapToTransaction converts a map to Transaction. Fields filled in from
+// defaults and prices that had to be rounded are noted on rec.
+func (fc *FormatConverter) mapToTransaction(data map[string]interface{}, rec *SourceRecord) (*models.Transaction, error) {
 	tx := &models.Transaction{}
 
-	// Map fields with flexible naming
-	fieldMappings := map[string][]string{
-		"id":           {"transaction_id", "id", "trans_id", "txn_id"},
-		"country":      {"country", "nation", "country_name"},
-		"region":       {"region", "state", "province", "area"},
-		"product_name": {"product_name", "product", "item", "item_name"},
-		"price":        {"price", "unit_price", "cost", "amount"},
-		"quantity":     {"quantity", "qty", "amount", "count"},
-		"date":         {"transaction_date", "date", "timestamp", "time"},
-	}
-
 	// Transaction ID
-	tx.ID = fc.getFieldValue(data, fieldMappings["id"])
+	tx.ID = fc.getFieldValue(data, "transaction_id")
 	if tx.ID == "" {
-		return nil, fmt.Errorf("transaction ID is required")
+		return nil, fieldErrorf("transaction_id", "transaction ID is required")
 	}
 
 	// Country
-	tx.Country = fc.getFieldValue(data, fieldMappings["country"])
+	tx.Country = fc.getFieldValue(data, "country")
 	if tx.Country == "" {
 		tx.Country = fc.config.DefaultCountry
+		rec.Defaults = append(rec.Defaults, FieldDefault{Field: "country", Value: tx.Country})
 	}
 
 	// Region
-	tx.Region = fc.getFieldValue(data, fieldMappings["region"])
+	tx.Region = fc.getFieldValue(data, "region")
 	if tx.Region == "" {
 		tx.Region = fc.config.DefaultRegion
+		rec.Defaults = append(rec.Defaults, FieldDefault{Field: "region", Value: tx.Region})
 	}
 
 	// Product Name
-	tx.ProductName = fc.getFieldValue(data, fieldMappings["product_name"])
+	tx.ProductName = fc.getFieldValue(data, "product_name")
 	if tx.ProductName == "" {
-		return nil, fmt.Errorf("product name is required")
+		return nil, fieldErrorf("product_name", "product name is required")
 	}
 
-	// Price
-	priceVal, err := fc.getFieldNumericValue(data, fieldMappings["price"])
+	// Currency, which determines the price's decimal places
+	currency, err := fc.resolveCurrency(fc.getFieldValue(data, "currency"))
 	if err != nil {
-		return nil, fmt.Errorf("price is required: %w", err)
+		return nil, err
+	}
+
+	// Price, read as text so that decimals are not routed through float64
+	priceStr := fc.getFieldValue(data, "price")
+	if priceStr == "" {
+		return nil, fieldErrorf("price", "price is required: numeric field not found")
+	}
+	if err := fc.setPrice(tx, priceStr, currency, rec); err != nil {
+		return nil, err
 	}
-	tx.UnitPriceCents = int64(priceVal * fc.config.PriceMultiplier)
 
 	// Quantity
-	quantityVal, err := fc.getFieldNumericValue(data, fieldMappings["quantity"])
+	quantityVal, err := fc.getFieldNumericValue(data, "quantity")
 	if err != nil {
 		quantityVal = 1 // Default quantity
+		rec.Defaults = append(rec.Defaults, FieldDefault{Field: "quantity", Value: "1"})
 	}
 	tx.Quantity = int64(quantityVal)
 
 	// Date
-	dateStr := fc.getFieldValue(data, fieldMappings["date"])
+	dateStr := fc.getFieldValue(data, "transaction_date")
 	if dateStr == "" {
-		return nil, fmt.Errorf("transaction date is required")
+		return nil, fieldErrorf("transaction_date", "transaction date is required")
+	}
+
+	loc, err := fc.recordLocation(fc.getFieldValue(data, "timezone"))
+	if err != nil {
+		return nil, err
 	}
 
-	date, err := fc.parseFlexibleDate(dateStr)
+	date, err := fc.parseFlexibleDate(dateStr, loc)
 	if err != nil {
-		return nil, fmt.Errorf("invalid date: %w", err)
+		return nil, fieldErrorf("transaction_date", "invalid date: %w", err)
 	}
 	tx.TxTime = date
 
+	// Convert to the reporting currency on the transaction date
+	if err := fc.convertCurrency(tx); err != nil {
+		return nil, err
+	}
+
+	// Unmapped columns derived fields read
+	for name, matcher := range fc.sources {
+		if val, ok := matcher.lookup(data); ok && val != nil {
+			rec.setColumn(name, valueString(val))
+		}
+	}
+
 	return tx, nil
 }
 
-// getFieldValue gets string value using flexible field naming
-func (fc *FormatConverter) getFieldValue(data map[string]interface{}, fieldNames []string) string {
-	for _, fieldName := range fieldNames {
-		if val, exists := data[fieldName]; exists {
-			if str, ok := val.(string); ok {
-				return str
-			}
-			return fmt.Sprintf("%v", val)
-		}
+// getFieldValue gets a field's value as a string using column_mappings
+func (fc *FormatConverter) getFieldValue(data map[string]interface{}, field string) string {
+	if val, exists := fc.fields[field].lookup(data); exists {
+		return valueString(val)
 	}
 	return ""
 }
 
-// getFieldNumericValue gets numeric value using flexible field naming
-func (fc *FormatConverter) getFieldNumericValue(data map[string]interface{}, fieldNames []string) (float64, error) {
-	for _, fieldName := range fieldNames {
-		if val, exists := data[fieldName]; exists {
-			switch v := val.(type) {
-			case float64:
-				return v, nil
-			case int:
-				return float64(v), nil
-			case int64:
-				return float64(v), nil
-			case string:
-				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
-					return parsed, nil
-				}
+// valueString renders a decoded value as text
+func valueString(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case float64:
+		// Shortest decimal that reads back as v, without an exponent
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", val)
+}
+
+// getFieldNumericValue gets a field's numeric value using column_mappings
+func (fc *FormatConverter) getFieldNumericValue(data map[string]interface{}, field string) (float64, error) {
+	if val, exists := fc.fields[field].lookup(data); exists {
+		switch v := val.(type) {
+		case float64:
+			return v, nil
+		case json.Number:
+			return v.Float64()
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case string:
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				return parsed, nil
 			}
+			return 0, fmt.Errorf("value %q is not numeric", v)
 		}
+		return 0, fmt.Errorf("value of type %T is not numeric", val)
 	}
 	return 0, fmt.Errorf("numeric field not found")
 }
@@ -496,8 +1122,12 @@ func (fc *FormatConverter) ExportToFormat(transactions []models.Transaction, for
 		return fc.exportToCSV(transactions, writer, '\t')
 	case FormatJSON:
 		return fc.exportToJSON(transactions, writer)
+	case FormatNDJSON:
+		return fc.exportToNDJSON(transactions, writer)
 	case FormatYAML:
 		return fc.exportToYAML(transactions, writer)
+	case FormatXML:
+		return fc.exportToXML(transactions, writer)
 	default:
 		return fmt.Errorf("unsupported export format: %s", format)
 	}
@@ -512,7 +1142,7 @@ func (fc *FormatConverter) exportToCSV(transactions []models.Transaction, writer
 	// Write header
 	header := []string{
 		"transaction_id", "country", "region", "product_name",
-		"price", "quantity", "transaction_date",
+		"price", "quantity", "transaction_date", "currency",
 	}
 	if err := csvWriter.Write(header); err != nil {
 		return err
@@ -525,9 +1155,10 @@ func (fc *FormatConverter) exportToCSV(transactions []models.Transaction, writer
 			tx.Country,
 			tx.Region,
 			tx.ProductName,
-			fmt.Sprintf("%.2f", float64(tx.UnitPriceCents)/fc.config.PriceMultiplier),
+			fc.formatPrice(tx.UnitPriceCents, tx.Currency),
 			fmt.Sprintf("%d", tx.Quantity),
-			tx.TxTime.Format("2006-01-02T15:04:05Z"),
+			tx.TxTime.UTC().Format("2006-01-02T15:04:05Z"),
+			tx.Currency,
 		}
 		if err := csvWriter.Write(record); err != nil {
 			return err
@@ -544,6 +1175,40 @@ func (fc *FormatConverter) exportToJSON(transactions []models.Transaction, write
 	return encoder.Encode(transactions)
 }
 
+// ndjsonTransaction is the object written per line on NDJSON export. It uses
+// the same field names as the CSV export so the output can be loaded back.
+type ndjsonTransaction struct {
+	ID              string `json:"transaction_id"`
+	Country         string `json:"country"`
+	Region          string `json:"region"`
+	ProductName     string `json:"product_name"`
+	Price           string `json:"price"`
+	Quantity        int64  `json:"quantity"`
+	TransactionDate string `json:"transaction_date"`
+	Currency        string `json:"currency,omitempty"`
+}
+
+// exportToNDJSON exports transactions as one JSON object per line
+func (fc *FormatConverter) exportToNDJSON(transactions []models.Transaction, writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	for _, tx := range transactions {
+		record := ndjsonTransaction{
+			ID:              tx.ID,
+			Country:         tx.Country,
+			Region:          tx.Region,
+			ProductName:     tx.ProductName,
+			Price:           fc.formatPrice(tx.UnitPriceCents, tx.Currency),
+			Quantity:        tx.Quantity,
+			TransactionDate: tx.TxTime.UTC().Format("2006-01-02T15:04:05Z"),
+			Currency:        tx.Currency,
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // exportToYAML exports transactions to YAML format
 func (fc *FormatConverter) exportToYAML(transactions []models.Transaction, writer io.Writer) error {
 	encoder := yaml.NewEncoder(writer)
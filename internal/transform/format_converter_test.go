@@ -0,0 +1,474 @@
+package transform
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"abt-dashboard/internal/models"
+)
+
+func TestStreamJSONWrapperObject(t *testing.T) {
+	input := `{"source": "erp", "transactions": [
+		{"id": "a1", "country": "USA", "product": "Widget", "price": 1.5, "qty": 2, "date": "2024-03-01"},
+		{"id": "a2", "product": "Widget", "price": 2, "date": "bad"}
+	]}`
+
+	var lines []int
+	skipped := 0
+	err := NewFormatConverter(newTestHandler(10).config).StreamTransactions(strings.NewReader(input), FormatJSON,
+		RecordHandler{
+			OnRecord: func(rec SourceRecord, tx models.Transaction) error {
+				lines = append(lines, rec.Line)
+				return nil
+			},
+			OnSkip: func(rec SourceRecord, err error) { skipped++ },
+		})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(lines) != 1 || lines[0] != 1 {
+		t.Errorf("streamed lines: got %v want [1]", lines)
+	}
+	if skipped != 1 {
+		t.Errorf("skipped: got %d want 1", skipped)
+	}
+}
+
+func TestXMLRoundTripAndFieldPaths(t *testing.T) {
+	config := newTestHandler(10).config
+	config.XML = XMLConfig{
+		RecordElement: "Order",
+		FieldPaths: map[string]string{
+			"transaction_id": "@ref",
+			"price":          "Line/Amount",
+			"country":        "ShipTo/@country",
+		},
+	}
+	converter := NewFormatConverter(config)
+
+	input := `<?xml version="1.0"?>
+<Export>
+  <Orders>
+    <Order ref="o-1">
+      <ShipTo country="Sri Lanka"><region>Western</region></ShipTo>
+      <product>Widget A</product>
+      <Line><Amount>12.50</Amount><qty>4</qty></Line>
+      <date>2024-05-01</date>
+    </Order>
+  </Orders>
+</Export>`
+
+	transactions, err := converter.ConvertToTransactions(strings.NewReader(input), FormatXML)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transactions) != 1 {
+		t.Fatalf("transactions: got %d want 1", len(transactions))
+	}
+
+	tx := transactions[0]
+	if tx.ID != "o-1" || tx.Country != "Sri Lanka" || tx.Region != "Western" ||
+		tx.UnitPriceCents != 1250 || tx.Quantity != 4 {
+		t.Errorf("unexpected transaction: %+v", tx)
+	}
+
+	// The exported document must load back with the default layout
+	var buf strings.Builder
+	if err := converter.ExportToFormat(transactions, FormatXML, &buf); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	roundTrip, err := NewFormatConverter(newTestHandler(10).config).
+		ConvertToTransactions(strings.NewReader(buf.String()), FormatXML)
+	if err != nil {
+		t.Fatalf("re-import failed: %v", err)
+	}
+	if len(roundTrip) != 1 || roundTrip[0].ID != tx.ID || roundTrip[0].UnitPriceCents != tx.UnitPriceCents {
+		t.Errorf("round trip mismatch: got %+v want %+v", roundTrip, tx)
+	}
+}
+
+func TestJSONWithDataAfterTheDocument(t *testing.T) {
+	const ndjson = `{"id": "j1", "country": "USA", "product": "Widget", "price": 2, "date": "2024-03-01"}
+{"id": "j2", "country": "USA", "product": "Widget", "price": 3, "date": "2024-03-02"}
+`
+	// Read as JSON, the first line is a document and the rest must not be
+	// dropped silently
+	converter := NewFormatConverter(newTestHandler(10).config)
+	for _, input := range []string{ndjson, `[{"id": "j1"}] trailing`, `{"transactions": []}}`} {
+		err := converter.StreamTransactions(strings.NewReader(input), FormatJSON, RecordHandler{
+			OnRecord: func(SourceRecord, models.Transaction) error { return nil },
+			OnSkip:   func(SourceRecord, error) {},
+		})
+		if err == nil || !strings.Contains(err.Error(), "after the JSON document") {
+			t.Errorf("%q: got %v", input, err)
+		}
+	}
+	if _, _, err := newTestHandler(10).ProcessDataStream(strings.NewReader(`{"transactions": []}`+"\n\n"), FormatJSON); err != nil {
+		t.Errorf("trailing whitespace: unexpected error: %v", err)
+	}
+
+	// A .json file holding JSON Lines is read as JSON Lines
+	path := filepath.Join(t.TempDir(), "export.json")
+	if err := os.WriteFile(path, []byte(ndjson), 0644); err != nil {
+		t.Fatal(err)
+	}
+	transactions, result, err := newTestHandler(10).ProcessDataFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transactions) != 2 || len(result.Errors) != 0 {
+		t.Errorf("got %d transactions, errors %v", len(transactions), result.Errors)
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	converter := NewFormatConverter(newTestHandler(10).config)
+
+	tests := []struct {
+		name string
+		data string
+		want DataFormat
+	}{
+		{"json array", `[{"id": 1}, {"id": 2}]`, FormatJSON},
+		{"json object", "{\n  \"transactions\": []\n}", FormatJSON},
+		{"truncated json array", `[{"id": 1}, {"id": 2`, FormatJSON},
+		{"ndjson", "{\"id\": 1}\n{\"id\": 2}\n", FormatNDJSON},
+		{"xml", `<transactions><transaction/></transactions>`, FormatXML},
+		{"tsv", "id\tcountry\n1\tUSA", FormatTSV},
+		{"csv", "id,country\n1,USA", FormatCSV},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := converter.DetectFormat([]byte(tt.data)); got != tt.want {
+				t.Errorf("DetectFormat() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNDJSONPerLineErrors(t *testing.T) {
+	input := `{"transaction_id": "n1", "country": "USA", "product_name": "Widget", "price": "3.10", "quantity": 2, "transaction_date": "2024-04-01"}
+{"transaction_id": "n2", "country": "USA", "product_name": "Widget", "price": 
+{"transaction_id": "n3", "country": "UK", "product_name": "Gadget", "price": 7, "quantity": 1, "transaction_date": "2024-04-02"}
+
+{"transaction_id": "n4", "product_name": "Gadget", "price": 7, "transaction_date": "never"}
+`
+
+	handler := newTestHandler(10)
+	transactions, result, err := handler.ProcessDataStream(strings.NewReader(input), FormatNDJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(transactions) != 2 {
+		t.Errorf("transactions: got %d want 2", len(transactions))
+	}
+	if result.SkippedRecords != 2 || len(result.Errors) != 2 {
+		t.Fatalf("skipped: got %d (errors %v) want 2", result.SkippedRecords, result.Errors)
+	}
+	if !strings.HasPrefix(result.Errors[0], "Record 2 skipped: invalid JSON") {
+		t.Errorf("unexpected error for line 2: %s", result.Errors[0])
+	}
+	if !strings.HasPrefix(result.Errors[1], "Record 5 skipped") {
+		t.Errorf("unexpected error for line 5: %s", result.Errors[1])
+	}
+
+	var buf strings.Builder
+	if err := handler.ExportTransactions(transactions, FormatNDJSON, &buf); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 2 {
+		t.Errorf("exported lines: got %d want 2", lines)
+	}
+	if got := handler.converter.DetectFormat([]byte(buf.String())); got != FormatNDJSON {
+		t.Errorf("exported output detected as %v", got)
+	}
+}
+
+// buildWorkbook writes a minimal two-sheet workbook. The "Sales" sheet has a
+// title row above its header and stores dates as Excel serials.
+func buildWorkbook(t *testing.T) []byte {
+	t.Helper()
+
+	parts := map[string]string{
+		"[Content_Types].xml": `<?xml version="1.0"?><Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"/>`,
+		"xl/workbook.xml": `<?xml version="1.0"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+  <sheets>
+    <sheet name="Notes" sheetId="1" r:id="rId1"/>
+    <sheet name="Sales" sheetId="2" r:id="rId2"/>
+  </sheets>
+</workbook>`,
+		"xl/_rels/workbook.xml.rels": `<?xml version="1.0"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+  <Relationship Id="rId1" Target="worksheets/sheet1.xml"/>
+  <Relationship Id="rId2" Target="worksheets/sheet2.xml"/>
+</Relationships>`,
+		"xl/sharedStrings.xml": `<?xml version="1.0"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+  <si><t>Q1 sales export</t></si>
+  <si><t>Order_ID</t></si><si><t>Country</t></si><si><t>Product</t></si>
+  <si><t>Unit_Price</t></si><si><t>Qty</t></si><si><t>Order_Date</t></si>
+  <si><t>x-1</t></si><si><t>Sri Lanka</t></si><si><r><t>Widget </t></r><r><t>A</t></r></si>
+</sst>`,
+		"xl/worksheets/sheet1.xml": `<?xml version="1.0"?><worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData/></worksheet>`,
+		"xl/worksheets/sheet2.xml": `<?xml version="1.0"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+  <row r="1"><c r="A1" t="s"><v>0</v></c></row>
+  <row r="3">
+    <c r="A3" t="s"><v>1</v></c><c r="B3" t="s"><v>2</v></c><c r="C3" t="s"><v>3</v></c>
+    <c r="D3" t="s"><v>4</v></c><c r="E3" t="s"><v>5</v></c><c r="F3" t="s"><v>6</v></c>
+  </row>
+  <row r="4">
+    <c r="A4" t="s"><v>7</v></c><c r="B4" t="s"><v>8</v></c><c r="C4" t="s"><v>9</v></c>
+    <c r="D4"><v>19.99</v></c><c r="E4"><v>3</v></c><c r="F4"><v>45292.5</v></c>
+  </row>
+  <row r="5">
+    <c r="A5" t="inlineStr"><is><t>x-2</t></is></c><c r="C5" t="inlineStr"><is><t>Gadget</t></is></c>
+    <c r="D5"><v>5</v></c><c r="F5" t="inlineStr"><is><t>2024-02-10</t></is></c>
+  </row>
+</sheetData></worksheet>`,
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"[Content_Types].xml", "xl/workbook.xml", "xl/_rels/workbook.xml.rels",
+		"xl/sharedStrings.xml", "xl/worksheets/sheet1.xml", "xl/worksheets/sheet2.xml"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(parts[name]))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestXLSXSheetSelectionAndSerialDates(t *testing.T) {
+	workbook := buildWorkbook(t)
+
+	config := newTestHandler(10).config
+	config.XLSX.Sheet = "sales"
+	converter := NewFormatConverter(config)
+
+	if got := converter.DetectFormat(workbook[:512]); got != FormatXLSX {
+		t.Errorf("DetectFormat() = %v, want %v", got, FormatXLSX)
+	}
+
+	transactions, err := converter.ConvertToTransactions(bytes.NewReader(workbook), FormatXLSX)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transactions) != 2 {
+		t.Fatalf("transactions: got %d want 2", len(transactions))
+	}
+
+	first := transactions[0]
+	wantTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	if first.ID != "x-1" || first.ProductName != "Widget A" || first.Quantity != 3 || !first.TxTime.Equal(wantTime) {
+		t.Errorf("unexpected first transaction: %+v", first)
+	}
+	if transactions[1].Quantity != 1 || transactions[1].TxTime.Format("2006-01-02") != "2024-02-10" {
+		t.Errorf("unexpected second transaction: %+v", transactions[1])
+	}
+
+	// Selecting the empty sheet by index finds no header
+	config.XLSX = XLSXConfig{SheetIndex: 0}
+	if _, err := NewFormatConverter(config).ConvertToTransactions(bytes.NewReader(workbook), FormatXLSX); err == nil {
+		t.Error("expected an error for a sheet without a header row")
+	}
+}
+
+func TestColumnMappingsAliasesPatternsAndPaths(t *testing.T) {
+	config := newTestHandler(10).config
+	config.ColumnMappings = map[string]ColumnMapping{
+		"transaction_id":   {Aliases: []string{"Order Ref", "ref"}},
+		"country":          {Patterns: []string{`(?i)^ship-to nation$`}},
+		"product_name":     {Aliases: []string{"sku_name", "item.name"}},
+		"price":            {Patterns: []string{`(?i)sale.?amount`}},
+		"transaction_date": {Aliases: []string{"booked"}},
+	}
+	fc := NewFormatConverter(config)
+
+	csvInput := "order ref,Ship-To Nation,SKU_Name,Sale Amount,Booked,quantity\nr-1,UK,Widget,12.50,2024-05-01,4\n"
+	transactions, err := fc.ConvertToTransactions(strings.NewReader(csvInput), FormatCSV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transactions) != 1 {
+		t.Fatalf("transactions: got %d want 1", len(transactions))
+	}
+	tx := transactions[0]
+	if tx.ID != "r-1" || tx.Country != "UK" || tx.ProductName != "Widget" || tx.UnitPriceCents != 1250 {
+		t.Errorf("csv mapping: got %+v", tx)
+	}
+	// quantity has no mapping, so the header is ignored and the default applies
+	if tx.Quantity != 1 {
+		t.Errorf("unmapped quantity: got %d want default 1", tx.Quantity)
+	}
+
+	jsonInput := `[{"ref": "r-2", "item": {"name": "Gadget"}, "sale_amount": 3, "booked": "2024-05-02"}]`
+	transactions, err = fc.ConvertToTransactions(strings.NewReader(jsonInput), FormatJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transactions) != 1 || transactions[0].ProductName != "Gadget" || transactions[0].UnitPriceCents != 300 {
+		t.Errorf("json mapping: got %+v", transactions)
+	}
+}
+
+func TestValidateColumnMappings(t *testing.T) {
+	if err := validateColumnMappings(DefaultColumnMappings()); err != nil {
+		t.Errorf("default mappings rejected: %v", err)
+	}
+
+	tests := map[string]map[string]ColumnMapping{
+		"missing required field": {
+			"transaction_id":   {Aliases: []string{"id"}},
+			"product_name":     {Aliases: []string{"product"}},
+			"transaction_date": {Aliases: []string{"date"}},
+		},
+		"unknown field": {
+			"transaction_id":   {Aliases: []string{"id"}},
+			"product_name":     {Aliases: []string{"product"}},
+			"price":            {Aliases: []string{"price"}},
+			"transaction_date": {Aliases: []string{"date"}},
+			"discount":         {Aliases: []string{"discount"}},
+		},
+		"invalid pattern": {
+			"transaction_id":   {Patterns: []string{"(id"}},
+			"product_name":     {Aliases: []string{"product"}},
+			"price":            {Aliases: []string{"price"}},
+			"transaction_date": {Aliases: []string{"date"}},
+		},
+	}
+	for name, mappings := range tests {
+		if err := validateColumnMappings(mappings); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestCurrencyConversionToReportingCurrency(t *testing.T) {
+	rates, err := ParseFXRates(strings.NewReader(`date,currency,rate
+2024-01,EUR,1.10
+2024-02-15,EUR,1.20
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	config := newTestHandler(10).config
+	config.Currency = CurrencyConfig{ReportingCurrency: "USD", DefaultCurrency: "EUR", Rates: rates}
+	fc := NewFormatConverter(config)
+
+	input := `transaction_id,transaction_date,product_name,price,currency
+c1,2024-01-20,Widget,10.00,eur
+c2,2024-02-20,Widget,10.00,
+c3,2024-02-20,Widget,10.00,USD
+c4,2023-12-31,Widget,10.00,EUR
+c5,2024-01-20,Widget,10.00,XYZ
+`
+	var transactions []models.Transaction
+	var skipped []error
+	err = fc.StreamTransactions(strings.NewReader(input), FormatCSV, RecordHandler{
+		OnRecord: func(rec SourceRecord, tx models.Transaction) error {
+			transactions = append(transactions, tx)
+			return nil
+		},
+		OnSkip: func(rec SourceRecord, err error) { skipped = append(skipped, err) },
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []struct {
+		id         string
+		cents      int64
+		original   int64
+		sourceCode string
+	}{
+		{"c1", 1100, 1000, "EUR"}, // monthly rate
+		{"c2", 1200, 1000, "EUR"}, // default currency, daily rate in effect
+		{"c3", 1000, 1000, "USD"}, // already in the reporting currency
+	}
+	if len(transactions) != len(want) {
+		t.Fatalf("transactions: got %d want %d (skipped %v)", len(transactions), len(want), skipped)
+	}
+	for i, w := range want {
+		tx := transactions[i]
+		if tx.ID != w.id || tx.UnitPriceCents != w.cents || tx.Currency != "USD" ||
+			tx.OriginalUnitPriceCents != w.original || tx.OriginalCurrency != w.sourceCode {
+			t.Errorf("%s: got %+v", w.id, tx)
+		}
+	}
+
+	// c4 predates every rate and XYZ is not a configured currency
+	if len(skipped) != 2 {
+		t.Fatalf("skipped: got %v want 2 errors", skipped)
+	}
+	for _, err := range skipped {
+		var fieldErr *FieldError
+		if !errors.As(err, &fieldErr) || fieldErr.Field != "currency" {
+			t.Errorf("expected a currency field error, got %v", err)
+		}
+	}
+}
+
+func TestExactPricesAndRounding(t *testing.T) {
+	config := newTestHandler(10).config
+	config.CurrencyFormats = nil
+	config.Currency = CurrencyConfig{DefaultCurrency: "USD", Rounding: "half_even"}
+	fc := NewFormatConverter(config)
+
+	input := `{"transaction_id":"p1","transaction_date":"2024-01-20","product_name":"Widget","price":19.99}
+{"transaction_id":"p2","transaction_date":"2024-01-20","product_name":"Widget","price":"1500","currency":"JPY"}
+{"transaction_id":"p3","transaction_date":"2024-01-20","product_name":"Widget","price":12.345,"currency":"KWD"}
+{"transaction_id":"p4","transaction_date":"2024-01-20","product_name":"Widget","price":0.125}
+`
+	var transactions []models.Transaction
+	var rounded []FieldRounding
+	err := fc.StreamTransactions(strings.NewReader(input), FormatNDJSON, RecordHandler{
+		OnRecord: func(rec SourceRecord, tx models.Transaction) error {
+			transactions = append(transactions, tx)
+			rounded = append(rounded, rec.Rounded...)
+			return nil
+		},
+		OnSkip: func(rec SourceRecord, err error) { t.Errorf("record %d skipped: %v", rec.Line, err) },
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []int64{1999, 1500, 12345, 12}
+	if len(transactions) != len(want) {
+		t.Fatalf("transactions: got %d want %d", len(transactions), len(want))
+	}
+	for i, cents := range want {
+		if transactions[i].UnitPriceCents != cents {
+			t.Errorf("%s: got %d want %d", transactions[i].ID, transactions[i].UnitPriceCents, cents)
+		}
+	}
+
+	if len(rounded) != 1 || rounded[0].Field != "price" || rounded[0].Value != "0.125" || rounded[0].Result != "0.12" {
+		t.Errorf("rounded: got %+v", rounded)
+	}
+
+	var out bytes.Buffer
+	if err := fc.ExportToFormat(transactions[1:3], FormatNDJSON, &out); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if !strings.Contains(out.String(), `"price":"1500"`) || !strings.Contains(out.String(), `"price":"12.345"`) {
+		t.Errorf("export did not keep minor units: %s", out.String())
+	}
+}
@@ -0,0 +1,152 @@
+package transform
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"abt-dashboard/internal/models"
+)
+
+// GeographyConfig points at the continent > country > region reference file:
+//
+//	continents:
+//	  Asia:
+//	    LK: [Western, Central, Southern]
+//	    IN: []
+//
+// Countries are given by anything LookupCountry accepts, preferably their
+// alpha-2 code. CountryMapping sets the continent of every listed country;
+// GeographyValidator flags regions that are not listed for their country.
+// Countries with no regions listed accept any region.
+type GeographyConfig struct {
+	File string `json:"file" yaml:"file"`
+
+	// Hierarchy is loaded from File together with the configuration
+	Hierarchy *Geography `json:"-" yaml:"-"`
+}
+
+// Geography is the continent > country > region hierarchy of a reference file
+type Geography struct {
+	countries map[string]geoCountry // alpha-2 code -> continent and regions
+}
+
+type geoCountry struct {
+	continent string
+	regions   map[string]bool // folded region names, nil when not checked
+}
+
+// LoadGeography reads a geography reference file
+func LoadGeography(path string) (*Geography, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read geography file: %w", err)
+	}
+	var file struct {
+		Continents map[string]map[string][]string `yaml:"continents"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse geography file %s: %w", path, err)
+	}
+	geography, err := newGeography(file.Continents)
+	if err != nil {
+		return nil, fmt.Errorf("invalid geography file %s: %w", path, err)
+	}
+	return geography, nil
+}
+
+func newGeography(continents map[string]map[string][]string) (*Geography, error) {
+	if len(continents) == 0 {
+		return nil, fmt.Errorf("no continents listed")
+	}
+
+	names := make([]string, 0, len(continents))
+	for name := range continents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	g := &Geography{countries: make(map[string]geoCountry)}
+	for _, name := range names {
+		continent := strings.TrimSpace(name)
+		if continent == "" {
+			return nil, fmt.Errorf("a continent has no name")
+		}
+		for country, regions := range continents[name] {
+			iso, ok := LookupCountry(country)
+			if !ok {
+				return nil, fmt.Errorf("continent %s: unknown country %q", continent, country)
+			}
+			if other, exists := g.countries[iso.Alpha2]; exists {
+				return nil, fmt.Errorf("country %s is listed under both %s and %s", iso.Name, other.continent, continent)
+			}
+
+			entry := geoCountry{continent: continent}
+			for _, region := range regions {
+				key := countryKey(region)
+				if key == "" {
+					return nil, fmt.Errorf("country %s: empty region name", iso.Name)
+				}
+				if entry.regions == nil {
+					entry.regions = make(map[string]bool, len(regions))
+				}
+				entry.regions[key] = true
+			}
+			g.countries[iso.Alpha2] = entry
+		}
+	}
+	return g, nil
+}
+
+// Continent returns the continent of a country by alpha-2 code, or "" if the
+// country is not listed
+func (g *Geography) Continent(countryCode string) string {
+	if g == nil {
+		return ""
+	}
+	return g.countries[countryCode].continent
+}
+
+// HasRegion reports whether region belongs to a country by alpha-2 code.
+// Case, diacritics and punctuation are ignored. Countries that are not
+// listed, or have no regions listed, accept any region.
+func (g *Geography) HasRegion(countryCode, region string) bool {
+	if g == nil {
+		return true
+	}
+	country, ok := g.countries[countryCode]
+	if !ok || country.regions == nil {
+		return true
+	}
+	return country.regions[countryKey(region)]
+}
+
+// GeographyValidator flags records whose region does not belong to their
+// country according to the geography reference file. Records without a
+// region or a recognized country are not checked.
+type GeographyValidator struct {
+	geography *Geography
+}
+
+func (g *GeographyValidator) Name() string {
+	return "GeographyValidator"
+}
+
+func (g *GeographyValidator) Description() string {
+	return "Validates that regions belong to the transaction's country"
+}
+
+func (g *GeographyValidator) Validate(data interface{}) error {
+	if tx, ok := data.(*models.Transaction); ok {
+		if tx.Region == "" || tx.CountryCode == "" {
+			return nil
+		}
+		if !g.geography.HasRegion(tx.CountryCode, tx.Region) {
+			return fieldErrorf("region", "region %q is not a region of %s", tx.Region, tx.Country)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package transform
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGeographyHierarchy(t *testing.T) {
+	dir := t.TempDir()
+	geographyPath := filepath.Join(dir, "geography.yaml")
+	os.WriteFile(geographyPath, []byte(`continents:
+  Asia:
+    LK: [Western, Central, North Western]
+    India: []
+  Europe:
+    "NO": []
+`), 0o644)
+
+	handler := newTestHandler(10)
+	geography, err := LoadGeography(geographyPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler.config.Geography = GeographyConfig{File: geographyPath, Hierarchy: geography}
+	handler.config.Pipeline = []PipelineStage{
+		{Name: "StringCleaning"}, {Name: "CountryMapping"}, {Name: "RegionMapping"}, {Name: "GeographyValidator"},
+	}
+	handler.engine = NewDataTransformationEngine(handler.config)
+
+	csv := `transaction_id,transaction_date,country,region,product_name,price,quantity
+tx-1,2024-01-15,Sri Lanka,western,widget,25.00,2
+tx-2,2024-01-15,LKA,North-Western,widget,25.00,2
+tx-3,2024-01-15,Sri Lanka,California,widget,25.00,2
+tx-4,2024-01-15,India,Western,widget,25.00,2
+tx-5,2024-01-15,Norway,Oslo,widget,25.00,2
+tx-6,2024-01-15,France,Paris,widget,25.00,2
+`
+	transactions, result, err := handler.ProcessDataStream(strings.NewReader(csv), FormatCSV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transactions) != 6 {
+		t.Fatalf("got %d transactions", len(transactions))
+	}
+
+	continents := []string{"Asia", "Asia", "Asia", "Asia", "Europe", ""}
+	for i, tx := range transactions {
+		if tx.Continent != continents[i] {
+			t.Errorf("%s: continent %q want %q", tx.ID, tx.Continent, continents[i])
+		}
+	}
+
+	// Only the Californian region of Sri Lanka is flagged; countries with
+	// no regions listed, or not listed at all, accept any region
+	var flagged []string
+	for _, warning := range result.Warnings {
+		if strings.Contains(warning, "GeographyValidator") {
+			flagged = append(flagged, warning)
+		}
+	}
+	if len(flagged) != 1 || !strings.Contains(flagged[0], `region "California" is not a region of Sri Lanka`) {
+		t.Errorf("flagged: got %v", flagged)
+	}
+
+	for name, bad := range map[string]string{
+		"unknown country": "continents:\n  Asia:\n    Atlantis: []\n",
+		"listed twice":    "continents:\n  Asia:\n    TR: []\n  Europe:\n    Turkey: []\n",
+		"empty region":    "continents:\n  Asia:\n    LK: [\"\"]\n",
+		"no continents":   "countries: {}\n",
+	} {
+		path := filepath.Join(dir, "bad.yaml")
+		os.WriteFile(path, []byte(bad), 0o644)
+		if _, err := LoadGeography(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package transform
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"abt-dashboard/internal/models"
+)
+
+// inventoryFields are the Inventory fields that source columns map onto
+var inventoryFields = []string{"product_name", "stock_quantity", "warehouse", "location", "as_of"}
+
+// requiredInventoryFields must be covered by the inventory column mappings.
+// Warehouse and location fall back to the configured defaults, and as_of to
+// the time the source was written.
+var requiredInventoryFields = []string{"product_name", "stock_quantity"}
+
+// InventoryConfig controls how inventory sources are read. ColumnMappings
+// works like the transaction column_mappings, for the fields product_name,
+// stock_quantity, warehouse, location and as_of. Records without a warehouse or
+// location are held in DefaultWarehouse, models.DefaultWarehouse unless set,
+// and DefaultLocation.
+type InventoryConfig struct {
+	ColumnMappings   map[string]ColumnMapping `json:"column_mappings" yaml:"column_mappings"`
+	DefaultWarehouse string                   `json:"default_warehouse" yaml:"default_warehouse"`
+	DefaultLocation  string                   `json:"default_location" yaml:"default_location"`
+}
+
+// DefaultInventoryColumnMappings returns the built-in inventory aliases used
+// when the configuration has no inventory column_mappings section
+func DefaultInventoryColumnMappings() map[string]ColumnMapping {
+	return map[string]ColumnMapping{
+		"product_name":   {Aliases: []string{"product_name", "product", "item", "item_name", "product_title"}},
+		"stock_quantity": {Aliases: []string{"stock_quantity", "stock_qty", "stock", "on_hand", "quantity_on_hand", "qty_on_hand", "available"}},
+		"warehouse":      {Aliases: []string{"warehouse", "warehouse_name", "warehouse_id", "depot", "site"}},
+		"location":       {Aliases: []string{"location", "bin", "bin_location", "aisle", "shelf"}},
+		"as_of":          {Aliases: []string{"as_of", "as_of_date", "snapshot_date", "stock_date", "inventory_date", "count_date", "date"}},
+	}
+}
+
+// validateInventoryConfig checks the inventory column mappings
+func validateInventoryConfig(config InventoryConfig) error {
+	if config.ColumnMappings == nil {
+		return nil
+	}
+	return validateFieldMappings("inventory.column_mappings", config.ColumnMappings,
+		inventoryFields, requiredInventoryFields)
+}
+
+// InventoryHandler receives stock levels as a FormatConverter streams them
+type InventoryHandler struct {
+	OnRecord func(rec SourceRecord, item models.Inventory) error
+	OnSkip   func(rec SourceRecord, err error)
+}
+
+// inventorySink converts records to stock levels for an InventoryHandler
+type inventorySink struct {
+	fc      *FormatConverter
+	handler InventoryHandler
+}
+
+func (s inventorySink) row(record []string, columnMap map[string]int, rec SourceRecord) error {
+	item, err := s.fc.buildInventory(func(field string) string {
+		if idx, ok := columnMap[field]; ok && idx < len(record) {
+			return strings.TrimSpace(record[idx])
+		}
+		return ""
+	}, &rec)
+	if err != nil {
+		s.handler.OnSkip(rec, err)
+		return nil
+	}
+	return s.handler.OnRecord(rec, *item)
+}
+
+func (s inventorySink) object(data map[string]interface{}, rec SourceRecord) error {
+	item, err := s.fc.buildInventory(func(field string) string {
+		return strings.TrimSpace(s.fc.getFieldValue(data, field))
+	}, &rec)
+	if err != nil {
+		s.handler.OnSkip(rec, err)
+		return nil
+	}
+	return s.handler.OnRecord(rec, *item)
+}
+
+func (s inventorySink) skip(rec SourceRecord, err error) {
+	s.handler.OnSkip(rec, err)
+}
+
+// StreamInventory parses stock levels one record at a time, like
+// StreamTransactions parses transactions: every format is supported and
+// columns are matched with the inventory column mappings. JSON and YAML
+// wrapper objects may hold the records under "inventory", "stock" or "data".
+// Product names are returned as written, and AsOf is zero for records
+// without an as-of date.
+func (fc *FormatConverter) StreamInventory(reader io.Reader, format DataFormat, handler InventoryHandler) error {
+	if handler.OnSkip == nil {
+		handler.OnSkip = func(SourceRecord, error) {}
+	}
+	inventory := fc.forInventory()
+	return inventory.stream(reader, format, inventorySink{fc: inventory, handler: handler})
+}
+
+// forInventory returns a converter that matches columns onto the inventory
+// fields
+func (fc *FormatConverter) forInventory() *FormatConverter {
+	mappings := fc.config.Inventory.ColumnMappings
+	if len(mappings) == 0 {
+		mappings = DefaultInventoryColumnMappings()
+	}
+	inventory := *fc
+	inventory.fields = compileColumnMappings(mappings, nil)
+	inventory.sources = nil
+	inventory.required = requiredInventoryFields
+	inventory.recordKeys = []string{"inventory", "stock", "data"}
+	inventory.dateField = "as_of"
+	return &inventory
+}
+
+// buildInventory reads a stock level through get, which returns the
+// trimmed value of a field. Warehouses and locations filled in from
+// defaults are noted on rec.
+func (fc *FormatConverter) buildInventory(get func(field string) string, rec *SourceRecord) (*models.Inventory, error) {
+	item := &models.Inventory{}
+
+	item.ProductName = get("product_name")
+	if item.ProductName == "" {
+		return nil, fieldErrorf("product_name", "product name is required")
+	}
+
+	quantityStr := get("stock_quantity")
+	if quantityStr == "" {
+		return nil, fieldErrorf("stock_quantity", "stock quantity is required")
+	}
+	quantity, err := parseStockQuantity(quantityStr)
+	if err != nil {
+		return nil, fieldErrorf("stock_quantity", "invalid stock quantity '%s': %w", quantityStr, err)
+	}
+	item.StockQty = quantity
+
+	item.Warehouse = get("warehouse")
+	if item.Warehouse == "" {
+		item.Warehouse = fc.config.Inventory.DefaultWarehouse
+		if item.Warehouse == "" {
+			item.Warehouse = models.DefaultWarehouse
+		}
+		rec.Defaults = append(rec.Defaults, FieldDefault{Field: "warehouse", Value: item.Warehouse})
+	}
+
+	item.Location = get("location")
+	if item.Location == "" && fc.config.Inventory.DefaultLocation != "" {
+		item.Location = fc.config.Inventory.DefaultLocation
+		rec.Defaults = append(rec.Defaults, FieldDefault{Field: "location", Value: item.Location})
+	}
+
+	if dateStr := get("as_of"); dateStr != "" {
+		loc, err := fc.recordLocation("")
+		if err != nil {
+			return nil, err
+		}
+		asOf, err := fc.parseFlexibleDate(dateStr, loc)
+		if err != nil {
+			return nil, fieldErrorf("as_of", "invalid as-of date '%s': %w", dateStr, err)
+		}
+		item.AsOf = asOf
+	}
+
+	return item, nil
+}
+
+// parseStockQuantity parses a whole, non-negative number of units. Thousand
+// separators are ignored and decimals are accepted when they are zero, as
+// spreadsheets often write "1,200.00".
+func parseStockQuantity(value string) (int64, error) {
+	clean := strings.ReplaceAll(strings.ReplaceAll(value, ",", ""), " ", "")
+	quantity, err := strconv.ParseInt(clean, 10, 64)
+	if err != nil {
+		f, ferr := strconv.ParseFloat(clean, 64)
+		if ferr != nil || f != float64(int64(f)) {
+			return 0, fmt.Errorf("not a whole number")
+		}
+		quantity = int64(f)
+	}
+	if quantity < 0 {
+		return 0, fmt.Errorf("stock cannot be negative")
+	}
+	return quantity, nil
+}
+
+// productNameStages are the transformations that rewrite product names
+var productNameStages = map[string]bool{"StringCleaning": true, "ProductNameNormalization": true}
+
+// normalizeInventoryName runs a product name through the product name
+// transformations of the pipeline, in order, so that stock is keyed like
+// the transactions it belongs to
+func (e *DataTransformationEngine) normalizeInventoryName(name string) string {
+	tx := &models.Transaction{ProductName: name}
+	for _, transformation := range e.transformations {
+		if !productNameStages[transformation.Name()] {
+			continue
+		}
+		if out, err := transformation.Transform(tx); err == nil {
+			if newTx, ok := out.(*models.Transaction); ok {
+				tx = newTx
+			}
+		}
+	}
+	return tx.ProductName
+}
+
+// InventoryResult summarizes loading one inventory source. Records counts
+// every record read; StockLevels the distinct product, warehouse, location
+// and as-of combinations kept, as a later record for the same combination
+// replaces an earlier one. Snapshots lists the as-of days read, YYYY-MM-DD,
+// and Undated counts the records without an as-of date of their own.
+type InventoryResult struct {
+	Source         string        `json:"source"`
+	Records        int           `json:"records"`
+	StockLevels    int           `json:"stock_levels"`
+	SkippedRecords int           `json:"skipped_records"`
+	Undated        int           `json:"undated_records"`
+	Warehouses     []string      `json:"warehouses"`
+	Snapshots      []string      `json:"snapshots"`
+	Errors         []string      `json:"errors"`
+	ProcessingTime time.Duration `json:"processing_time"`
+}
+
+// ProcessInventoryFile loads the stock levels of an inventory file. Formats,
+// compression and archives are detected like ProcessDataFile detects them,
+// and product names are normalized by the pipeline's product name
+// transformations. Records without an as-of date are taken as of the file's
+// modification time, so that reloading an unchanged file yields the same
+// snapshot. Levels are returned in the order first read.
+func (fdh *FlexibleDataHandler) ProcessInventoryFile(filePath string) ([]models.Inventory, *InventoryResult, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open file %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to stat file %s: %w", filePath, err)
+	}
+	return fdh.processInventory(filePath, file, info.ModTime())
+}
+
+// ProcessInventoryReader is ProcessInventoryFile for data that does not come
+// from a path. The name is only used for format detection by extension and
+// in the result; records without an as-of date are taken as of now.
+func (fdh *FlexibleDataHandler) ProcessInventoryReader(name string, reader io.Reader) ([]models.Inventory, *InventoryResult, error) {
+	return fdh.processInventory(name, reader, time.Now())
+}
+
+// processInventory loads the stock levels of a source, dating records
+// without an as-of date asOf
+func (fdh *FlexibleDataHandler) processInventory(name string, reader io.Reader, asOf time.Time) ([]models.Inventory, *InventoryResult, error) {
+	fdh.mu.Lock()
+	defer fdh.mu.Unlock()
+
+	run := &inventoryRun{
+		fdh:    fdh,
+		result: &InventoryResult{Source: name, Errors: make([]string, 0)},
+		index:  make(map[inventoryKey]int),
+		asOf:   asOf,
+		start:  time.Now(),
+	}
+	if err := fdh.processSource(run, name, reader, false); err != nil {
+		return nil, nil, err
+	}
+	levels, result := run.finish()
+	return levels, result, nil
+}
+
+// inventoryKey identifies a stock level within a source
+type inventoryKey struct {
+	product, warehouse, location string
+	asOf                         int64 // Unix seconds
+}
+
+// inventoryRun collects the stock levels of one source
+type inventoryRun struct {
+	fdh    *FlexibleDataHandler
+	result *InventoryResult
+	levels []models.Inventory
+	index  map[inventoryKey]int // position in levels
+	asOf   time.Time            // as-of time of undated records
+	start  time.Time
+
+	// member is the archive member being read, "" for plain sources
+	member string
+}
+
+func (r *inventoryRun) consume(reader io.Reader, format DataFormat) error {
+	prefix := ""
+	if r.member != "" {
+		prefix = r.member + ": "
+	}
+
+	err := r.fdh.converter.StreamInventory(reader, format, InventoryHandler{
+		OnRecord: func(rec SourceRecord, item models.Inventory) error {
+			r.result.Records++
+			item.ProductName = r.fdh.engine.normalizeInventoryName(item.ProductName)
+			if item.AsOf.IsZero() {
+				item.AsOf = r.asOf
+				r.result.Undated++
+			}
+
+			key := inventoryKey{item.ProductName, item.Warehouse, item.Location, item.AsOf.Unix()}
+			if i, ok := r.index[key]; ok {
+				r.levels[i] = item
+				return nil
+			}
+			r.index[key] = len(r.levels)
+			r.levels = append(r.levels, item)
+			return nil
+		},
+		OnSkip: func(rec SourceRecord, err error) {
+			r.result.Records++
+			r.result.SkippedRecords++
+			if len(r.result.Errors) < maxResultMessages {
+				r.result.Errors = append(r.result.Errors, fmt.Sprintf("%sRecord %d skipped: %v", prefix, rec.Line, err))
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to convert data: %w", err)
+	}
+	return nil
+}
+
+func (r *inventoryRun) beginMember(name string, format DataFormat) {
+	r.member = name
+}
+
+func (r *inventoryRun) endMember() error {
+	r.member = ""
+	return nil
+}
+
+func (r *inventoryRun) finish() ([]models.Inventory, *InventoryResult) {
+	warehouses := make(map[string]bool)
+	snapshots := make(map[string]bool)
+	for _, level := range r.levels {
+		warehouses[level.Warehouse] = true
+		snapshots[level.AsOf.Format("2006-01-02")] = true
+	}
+	r.result.Warehouses = sortedKeys(warehouses)
+	r.result.Snapshots = sortedKeys(snapshots)
+
+	r.result.StockLevels = len(r.levels)
+	r.result.ProcessingTime = time.Since(r.start)
+	return r.levels, r.result
+}
+
+// sortedKeys returns the keys of set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,151 @@
+package transform
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"abt-dashboard/internal/models"
+)
+
+func TestStreamInventoryFormats(t *testing.T) {
+	converter := NewFormatConverter(newTestHandler(10).config)
+
+	for name, input := range map[string]struct {
+		format DataFormat
+		data   string
+	}{
+		"csv aliases": {FormatCSV, "Item,On_Hand,Depot,Bin\nWidget A,\"1,200\",north,A-1\nGadget B,lots,north,A-2\nTool C,-3,south,\n"},
+		"json wrapper": {FormatJSON, `{"as_exported": "today", "inventory": [
+			{"product": "Widget A", "stock": 1200, "warehouse": "north", "location": "A-1"},
+			{"product": "Gadget B", "stock": "lots", "warehouse": "north"},
+			{"product": "Tool C", "stock": -3, "warehouse": "south"}]}`},
+		"ndjson": {FormatNDJSON, `{"product_name": "Widget A", "stock_qty": 1200.0, "warehouse": "north", "bin": "A-1"}
+{"product_name": "Gadget B", "stock_qty": "lots"}
+not json`},
+	} {
+		var levels []models.Inventory
+		skipped := 0
+		err := converter.StreamInventory(strings.NewReader(input.data), input.format, InventoryHandler{
+			OnRecord: func(rec SourceRecord, item models.Inventory) error {
+				levels = append(levels, item)
+				return nil
+			},
+			OnSkip: func(rec SourceRecord, err error) { skipped++ },
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		want := models.Inventory{ProductName: "Widget A", Warehouse: "north", Location: "A-1", StockQty: 1200}
+		if len(levels) != 1 || levels[0] != want || skipped != 2 {
+			t.Errorf("%s: got %+v with %d skipped", name, levels, skipped)
+		}
+	}
+
+	// Stock without a warehouse column is held in the default warehouse
+	var rec SourceRecord
+	var item models.Inventory
+	converter.StreamInventory(strings.NewReader("product_name,stock_quantity\nWidget A,5\n"), FormatCSV, InventoryHandler{
+		OnRecord: func(r SourceRecord, i models.Inventory) error {
+			rec, item = r, i
+			return nil
+		},
+	})
+	if item.Warehouse != models.DefaultWarehouse || len(rec.Defaults) != 1 || rec.Defaults[0].Field != "warehouse" {
+		t.Errorf("got %+v with defaults %+v", item, rec.Defaults)
+	}
+}
+
+func TestProcessInventoryFile(t *testing.T) {
+	handler := newTestHandler(10)
+	transactions, _, err := handler.ProcessDataStream(strings.NewReader(sampleCSV), FormatCSV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Inventory names are normalized like transaction names, and a later
+	// row for the same warehouse location replaces an earlier one
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stock.csv.gz")
+	os.WriteFile(path, gzipBytes(t, `product,stock_quantity,warehouse,location
+  widget a ,10,north,A-1
+WIDGET A,4,north,A-2
+widget a,12,north,A-1
+gadget b,7,south,
+tool c,,south,
+`), 0o644)
+
+	// Records without an as-of date are taken as of the file's modification
+	// time
+	modified := time.Date(2024, 3, 4, 18, 0, 0, 0, time.UTC)
+	os.Chtimes(path, modified, modified)
+
+	levels, result, err := handler.ProcessInventoryFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(levels) != 3 || levels[0].ProductName != transactions[0].ProductName || levels[0].StockQty != 12 ||
+		levels[1].ProductName != transactions[0].ProductName || levels[1].Location != "A-2" ||
+		!levels[2].AsOf.Equal(modified) {
+		t.Errorf("got levels %+v for product %q", levels, transactions[0].ProductName)
+	}
+	if result.Records != 5 || result.StockLevels != 3 || result.SkippedRecords != 1 || len(result.Errors) != 1 ||
+		result.Undated != 4 || strings.Join(result.Warehouses, ",") != "north,south" ||
+		strings.Join(result.Snapshots, ",") != "2024-03-04" {
+		t.Errorf("got result %+v", result)
+	}
+
+	// Snapshots of the same location on different days are all kept
+	levels, result, err = handler.ProcessInventoryReader("stock.ndjson", strings.NewReader(
+		`{"product": "widget a", "stock": 12, "as_of": "2024-03-01"}
+{"product": "widget a", "stock": 9, "snapshot_date": "2024-03-02"}
+{"product": "widget a", "stock": 7, "as_of": "yesterday"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(levels) != 2 || levels[1].StockQty != 9 || !levels[1].AsOf.Equal(time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)) ||
+		strings.Join(result.Snapshots, ",") != "2024-03-01,2024-03-02" || result.SkippedRecords != 1 {
+		t.Errorf("got levels %+v, result %+v", levels, result)
+	}
+
+	// Configured column mappings replace the built-in aliases
+	config := handler.config
+	config.Inventory.ColumnMappings = map[string]ColumnMapping{
+		"product_name":   {Aliases: []string{"sku_name"}},
+		"stock_quantity": {Patterns: []string{`(?i)^qty_`}},
+		"warehouse":      {Aliases: []string{"dc"}},
+	}
+	config.Inventory.DefaultLocation = "floor"
+	levels, _, err = NewFlexibleDataHandler(config).ProcessInventoryReader("stock.json",
+		bytes.NewReader([]byte(`[{"sku_name": "tool c", "qty_free": 3, "dc": "east"}]`)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(levels) != 1 {
+		t.Fatalf("got %+v", levels)
+	}
+	// Undated levels read without a file are taken as of now
+	if time.Since(levels[0].AsOf) > time.Minute {
+		t.Errorf("got as-of %v", levels[0].AsOf)
+	}
+	levels[0].AsOf = time.Time{}
+	want := models.Inventory{ProductName: "Tool C", Warehouse: "east", Location: "floor", StockQty: 3}
+	if levels[0] != want {
+		t.Errorf("got %+v", levels[0])
+	}
+
+	if err := validateInventoryConfig(InventoryConfig{ColumnMappings: map[string]ColumnMapping{
+		"product_name": {Aliases: []string{"item"}},
+		"price":        {Aliases: []string{"cost"}},
+	}}); err == nil {
+		t.Error("expected an error for mappings onto a transaction field")
+	}
+	if err := validateInventoryConfig(InventoryConfig{ColumnMappings: map[string]ColumnMapping{
+		"product_name": {Aliases: []string{"item"}},
+	}}); err == nil {
+		t.Error("expected an error for mappings without stock_quantity")
+	}
+}
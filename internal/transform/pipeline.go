@@ -0,0 +1,257 @@
+package transform
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// PipelineStage selects one transformation, validator or optimization by
+// name, with its parameters.
+//
+// Stages run in the order listed within their kind: every transformation
+// runs on a record before any validator, and optimizations run on each chunk
+// of validated records. Stages that are not listed do not run.
+type PipelineStage struct {
+	Name   string                 `json:"name" yaml:"name"`
+	Params map[string]interface{} `json:"params,omitempty" yaml:"params,omitempty"`
+}
+
+// stageFactory builds a Transformation, Validator or Optimization
+type stageFactory struct {
+	params []string // accepted parameter names
+	build  func(config TransformConfig, params stageParams) (interface{}, error)
+}
+
+// pipelineStages are the stages a pipeline can list, by name
+var pipelineStages = map[string]stageFactory{
+	"CurrencyNormalization": {build: func(config TransformConfig, _ stageParams) (interface{}, error) {
+		return &CurrencyNormalization{config: config}, nil
+	}},
+	"DateNormalization": {build: func(config TransformConfig, _ stageParams) (interface{}, error) {
+		return &DateNormalization{config: config}, nil
+	}},
+	"StringCleaning": {build: func(config TransformConfig, _ stageParams) (interface{}, error) {
+		return &StringCleaning{config: config}, nil
+	}},
+	"CountryMapping": {params: []string{"mappings"}, build: func(config TransformConfig, p stageParams) (interface{}, error) {
+		mappings, err := p.stringMap("mappings")
+		return &CountryMapping{config: config, mappings: mappings}, err
+	}},
+	"RegionMapping": {params: []string{"mappings"}, build: func(config TransformConfig, p stageParams) (interface{}, error) {
+		mappings, err := p.stringMap("mappings")
+		return &RegionMapping{config: config, mappings: mappings}, err
+	}},
+	"ProductNameNormalization": {params: []string{"mappings"}, build: func(config TransformConfig, p stageParams) (interface{}, error) {
+		mappings, err := p.stringMap("mappings")
+		return &ProductNameNormalization{config: config, mappings: mappings}, err
+	}},
+	"DerivedFields": {build: func(config TransformConfig, _ stageParams) (interface{}, error) {
+		return NewDerivedFields(config)
+	}},
+
+	"RequiredFieldValidator": {params: []string{"fields"}, build: func(_ TransformConfig, p stageParams) (interface{}, error) {
+		fields, err := p.strings("fields")
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range fields {
+			if !isRequirableField(field) {
+				return nil, fmt.Errorf("fields: unknown field %q", field)
+			}
+		}
+		return &RequiredFieldValidator{fields: fields}, nil
+	}},
+	"DataTypeValidator": {params: []string{"max_price_cents", "max_quantity", "max_age_years", "max_future_years"},
+		build: func(config TransformConfig, p stageParams) (interface{}, error) {
+			v := &DataTypeValidator{config: config}
+			return v, p.ints(map[string]*int64{
+				"max_price_cents":  &v.maxPriceCents,
+				"max_quantity":     &v.maxQuantity,
+				"max_age_years":    &v.maxAgeYears,
+				"max_future_years": &v.maxFutureYears,
+			})
+		}},
+	"RangeValidator": {params: []string{"min_price_cents", "max_price_cents", "min_quantity", "max_quantity"},
+		build: func(_ TransformConfig, p stageParams) (interface{}, error) {
+			v := &RangeValidator{}
+			if err := p.ints(map[string]*int64{
+				"min_price_cents": &v.minPriceCents,
+				"max_price_cents": &v.maxPriceCents,
+				"min_quantity":    &v.minQuantity,
+				"max_quantity":    &v.maxQuantity,
+			}); err != nil {
+				return nil, err
+			}
+			minPrice, maxPrice, minQty, maxQty := v.limits()
+			if minPrice > maxPrice || minQty > maxQty {
+				return nil, fmt.Errorf("minimum exceeds maximum")
+			}
+			return v, nil
+		}},
+	"GeographyValidator": {build: func(config TransformConfig, _ stageParams) (interface{}, error) {
+		return &GeographyValidator{geography: config.Geography.Hierarchy}, nil
+	}},
+	"RuleValidator": {build: func(config TransformConfig, _ stageParams) (interface{}, error) {
+		return NewRuleValidator(config)
+	}},
+	"UniquenessValidator": {build: func(TransformConfig, stageParams) (interface{}, error) {
+		return &UniquenessValidator{}, nil
+	}},
+
+	"DuplicateRemoval": {build: func(TransformConfig, stageParams) (interface{}, error) {
+		return &DuplicateRemoval{}, nil
+	}},
+	"DataDeduplication": {build: func(TransformConfig, stageParams) (interface{}, error) {
+		return &DataDeduplication{}, nil
+	}},
+	"IndexOptimization": {build: func(TransformConfig, stageParams) (interface{}, error) {
+		return &IndexOptimization{}, nil
+	}},
+}
+
+// DefaultPipeline returns the stages used when no pipeline is configured
+func DefaultPipeline() []PipelineStage {
+	names := []string{
+		"CurrencyNormalization", "DateNormalization", "StringCleaning",
+		"CountryMapping", "RegionMapping", "ProductNameNormalization", "DerivedFields",
+		"RequiredFieldValidator", "DataTypeValidator", "GeographyValidator", "RuleValidator", "UniquenessValidator",
+		"DuplicateRemoval", "DataDeduplication", "IndexOptimization",
+	}
+	stages := make([]PipelineStage, len(names))
+	for i, name := range names {
+		stages[i] = PipelineStage{Name: name}
+	}
+	return stages
+}
+
+// buildStage creates the transformation, validator or optimization of a
+// pipeline stage
+func buildStage(stage PipelineStage, config TransformConfig) (interface{}, error) {
+	factory, ok := pipelineStages[stage.Name]
+	if !ok {
+		names := make([]string, 0, len(pipelineStages))
+		for name := range pipelineStages {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown stage %q (known stages: %s)", stage.Name, strings.Join(names, ", "))
+	}
+
+	for param := range stage.Params {
+		known := false
+		for _, name := range factory.params {
+			if param == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("stage %s: unknown parameter %q", stage.Name, param)
+		}
+	}
+
+	built, err := factory.build(config, stageParams(stage.Params))
+	if err != nil {
+		return nil, fmt.Errorf("stage %s: %w", stage.Name, err)
+	}
+	return built, nil
+}
+
+// validatePipeline checks that every stage is known, listed once and has
+// valid parameters
+func validatePipeline(stages []PipelineStage) error {
+	seen := make(map[string]bool)
+	for _, stage := range stages {
+		if seen[stage.Name] {
+			return fmt.Errorf("pipeline: stage %s is listed more than once", stage.Name)
+		}
+		seen[stage.Name] = true
+		if _, err := buildStage(stage, TransformConfig{}); err != nil {
+			return fmt.Errorf("pipeline: %w", err)
+		}
+	}
+	return nil
+}
+
+// stageParams are the parameters of one stage as decoded from YAML or JSON
+type stageParams map[string]interface{}
+
+// stringMap returns a map parameter, or nil when it is not set
+func (p stageParams) stringMap(name string) (map[string]string, error) {
+	value, ok := p[name]
+	if !ok || value == nil {
+		return nil, nil
+	}
+
+	out := make(map[string]string)
+	switch m := value.(type) {
+	case map[string]interface{}:
+		for key, v := range m {
+			out[strings.ToLower(strings.TrimSpace(key))] = fmt.Sprint(v)
+		}
+	case map[interface{}]interface{}:
+		for key, v := range m {
+			out[strings.ToLower(strings.TrimSpace(fmt.Sprint(key)))] = fmt.Sprint(v)
+		}
+	default:
+		return nil, fmt.Errorf("%s must be a map", name)
+	}
+	return out, nil
+}
+
+// strings returns a list parameter, or nil when it is not set
+func (p stageParams) strings(name string) ([]string, error) {
+	value, ok := p[name]
+	if !ok || value == nil {
+		return nil, nil
+	}
+
+	switch list := value.(type) {
+	case []string:
+		return list, nil
+	case []interface{}:
+		out := make([]string, len(list))
+		for i, v := range list {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a list of strings", name)
+			}
+			out[i] = s
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("%s must be a list", name)
+}
+
+// ints stores the integer parameters that are set into targets. Parameters
+// must be positive, as zero selects a stage's built-in limit.
+func (p stageParams) ints(targets map[string]*int64) error {
+	for name, target := range targets {
+		value, ok := p[name]
+		if !ok || value == nil {
+			continue
+		}
+
+		var n int64
+		switch v := value.(type) {
+		case int:
+			n = int64(v)
+		case int64:
+			n = v
+		case float64:
+			if v != math.Trunc(v) || math.Abs(v) > math.MaxInt64/2 {
+				return fmt.Errorf("%s must be an integer, got %v", name, v)
+			}
+			n = int64(v)
+		default:
+			return fmt.Errorf("%s must be an integer, got %v", name, value)
+		}
+		if n <= 0 {
+			return fmt.Errorf("%s must be positive, got %d", name, n)
+		}
+		*target = n
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package transform
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultSimilarityThreshold is the similarity above which SuggestProductMerges
+// groups product names
+const DefaultSimilarityThreshold = 0.85
+
+// ProductAliasFile is a reviewable list of product names that are merged
+// into a canonical name. It is read from transformation.product_aliases_file
+// and merged into CustomMappings as product_ mappings:
+//
+//	products:
+//	  - canonical: "Widget A"
+//	    aliases: ["Widget-a", "Wdget A"]
+type ProductAliasFile struct {
+	Products []ProductAliasGroup `yaml:"products"`
+}
+
+// ProductAliasGroup maps aliases onto one canonical product name
+type ProductAliasGroup struct {
+	Canonical string   `yaml:"canonical"`
+	Aliases   []string `yaml:"aliases"`
+}
+
+// LoadProductAliases reads an alias file and returns its groups
+func LoadProductAliases(path string) (ProductAliasFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ProductAliasFile{}, fmt.Errorf("failed to read product alias file: %w", err)
+	}
+	var file ProductAliasFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return ProductAliasFile{}, fmt.Errorf("failed to parse product alias file %s: %w", path, err)
+	}
+	if _, err := file.Mappings(); err != nil {
+		return ProductAliasFile{}, fmt.Errorf("invalid product alias file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// Mappings returns the groups as CustomMappings entries, keyed by
+// "product_" and the lower case alias. An alias may belong to one group only
+// and a canonical name may not itself be an alias.
+func (f ProductAliasFile) Mappings() (map[string]string, error) {
+	mappings := make(map[string]string)
+	canonical := make(map[string]bool)
+	for _, group := range f.Products {
+		name := strings.TrimSpace(group.Canonical)
+		if name == "" {
+			return nil, fmt.Errorf("a group of aliases %v has no canonical name", group.Aliases)
+		}
+		canonical[productAliasKey(name)] = true
+	}
+
+	for _, group := range f.Products {
+		name := strings.TrimSpace(group.Canonical)
+		for _, alias := range group.Aliases {
+			key := productAliasKey(alias)
+			if key == "product_" || key == productAliasKey(name) {
+				continue
+			}
+			if canonical[key] {
+				return nil, fmt.Errorf("%q is both a canonical name and an alias", strings.TrimSpace(alias))
+			}
+			if other, ok := mappings[key]; ok && other != name {
+				return nil, fmt.Errorf("alias %q maps to both %q and %q", strings.TrimSpace(alias), other, name)
+			}
+			mappings[key] = name
+		}
+	}
+	return mappings, nil
+}
+
+// productAliasKey is the CustomMappings key ProductNameNormalization looks
+// a product name up by
+func productAliasKey(name string) string {
+	return "product_" + strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// mergeProductAliases adds the mappings of an alias file to config's custom
+// mappings. Mappings configured directly take precedence.
+func mergeProductAliases(config *TransformConfig, file ProductAliasFile) error {
+	mappings, err := file.Mappings()
+	if err != nil {
+		return err
+	}
+	if config.CustomMappings == nil {
+		config.CustomMappings = make(map[string]string, len(mappings))
+	}
+	for key, name := range mappings {
+		if _, exists := config.CustomMappings[key]; !exists {
+			config.CustomMappings[key] = name
+		}
+	}
+	return nil
+}
+
+// ProductStats summarizes the sales of one product name
+type ProductStats struct {
+	Name         string
+	RevenueCents int64
+	Transactions int64
+}
+
+// ProductMerge is a suggested group of product names that are likely the
+// same product. Canonical is the name with the most revenue.
+type ProductMerge struct {
+	Canonical ProductStats
+	Aliases   []ProductAlias // by revenue, highest first
+
+	// AffectedRevenueCents is the revenue of the aliases, which the merge
+	// would move to the canonical name
+	AffectedRevenueCents int64
+}
+
+// ProductAlias is a name suggested for merging into a canonical name
+type ProductAlias struct {
+	ProductStats
+	Similarity float64 // to the canonical name, 0 to 1
+}
+
+// SuggestProductMerges groups product names whose similarity is at least
+// threshold (DefaultSimilarityThreshold when 0 or less). Names that differ
+// only in case, spacing or punctuation always match. Otherwise names are
+// compared by edit distance, but never match when their numbers or short
+// tokens such as model letters differ, so "Widget A" and "Widget B" stay
+// apart. Groups are returned by affected revenue, highest first.
+func SuggestProductMerges(products []ProductStats, threshold float64) []ProductMerge {
+	if threshold <= 0 {
+		threshold = DefaultSimilarityThreshold
+	}
+
+	keys := make([]productKey, len(products))
+	for i, p := range products {
+		keys[i] = newProductKey(p.Name)
+	}
+
+	// Union-find over the names that match
+	parent := make([]int, len(products))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range products {
+		for j := i + 1; j < len(products); j++ {
+			if find(i) != find(j) && keys[i].similarity(keys[j], threshold) >= threshold {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	groups := make(map[int][]int)
+	for i := range products {
+		groups[find(i)] = append(groups[find(i)], i)
+	}
+
+	var merges []ProductMerge
+	for _, members := range groups {
+		if len(members) < 2 {
+			continue
+		}
+		sort.Slice(members, func(a, b int) bool {
+			return productLess(products[members[a]], products[members[b]])
+		})
+
+		canonical := members[0]
+		merge := ProductMerge{Canonical: products[canonical]}
+		for _, i := range members[1:] {
+			merge.Aliases = append(merge.Aliases, ProductAlias{
+				ProductStats: products[i],
+				Similarity:   keys[canonical].similarity(keys[i], 0),
+			})
+			merge.AffectedRevenueCents += products[i].RevenueCents
+		}
+		merges = append(merges, merge)
+	}
+
+	sort.Slice(merges, func(i, j int) bool {
+		if merges[i].AffectedRevenueCents == merges[j].AffectedRevenueCents {
+			return merges[i].Canonical.Name < merges[j].Canonical.Name
+		}
+		return merges[i].AffectedRevenueCents > merges[j].AffectedRevenueCents
+	})
+	return merges
+}
+
+// productLess orders the names of a group: most revenue, then most
+// transactions, then alphabetically
+func productLess(a, b ProductStats) bool {
+	if a.RevenueCents != b.RevenueCents {
+		return a.RevenueCents > b.RevenueCents
+	}
+	if a.Transactions != b.Transactions {
+		return a.Transactions > b.Transactions
+	}
+	return a.Name < b.Name
+}
+
+// productKey is a product name prepared for comparison
+type productKey struct {
+	text    []rune // lower case words separated by single spaces
+	compact string // letters and digits only
+	exact   string // sorted tokens that must match exactly
+}
+
+func newProductKey(name string) productKey {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var exact []string
+	for _, word := range words {
+		if len([]rune(word)) <= 2 || strings.IndexFunc(word, unicode.IsDigit) >= 0 {
+			exact = append(exact, word)
+		}
+	}
+	sort.Strings(exact)
+
+	return productKey{
+		text:    []rune(strings.Join(words, " ")),
+		compact: strings.Join(words, ""),
+		exact:   strings.Join(exact, " "),
+	}
+}
+
+// similarity returns 1 for names that differ only in case, spacing or
+// punctuation, 0 for names whose exact tokens differ and otherwise one minus
+// the edit distance relative to the longer name. Pairs that cannot reach
+// min are given up early.
+func (k productKey) similarity(other productKey, min float64) float64 {
+	if k.compact == other.compact {
+		return 1
+	}
+	if k.exact != other.exact || len(k.text) == 0 || len(other.text) == 0 {
+		return 0
+	}
+
+	longest := len(k.text)
+	if len(other.text) > longest {
+		longest = len(other.text)
+	}
+	diff := len(k.text) - len(other.text)
+	if diff < 0 {
+		diff = -diff
+	}
+	if 1-float64(diff)/float64(longest) < min {
+		return 0
+	}
+	return 1 - float64(editDistance(k.text, other.text))/float64(longest)
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// AddMerges returns the file with the suggested merges added. Aliases join
+// the group of their canonical name if it has one.
+func (f ProductAliasFile) AddMerges(merges []ProductMerge) ProductAliasFile {
+	out := ProductAliasFile{Products: make([]ProductAliasGroup, len(f.Products))}
+	index := make(map[string]int)
+	for i, group := range f.Products {
+		out.Products[i] = ProductAliasGroup{Canonical: group.Canonical, Aliases: append([]string(nil), group.Aliases...)}
+		index[productAliasKey(group.Canonical)] = i
+	}
+
+	for _, merge := range merges {
+		i, ok := index[productAliasKey(merge.Canonical.Name)]
+		if !ok {
+			i = len(out.Products)
+			index[productAliasKey(merge.Canonical.Name)] = i
+			out.Products = append(out.Products, ProductAliasGroup{Canonical: merge.Canonical.Name})
+		}
+		for _, alias := range merge.Aliases {
+			out.Products[i].Aliases = append(out.Products[i].Aliases, alias.Name)
+		}
+	}
+	return out
+}
+
+// WriteProductAliases writes an alias file for review. Groups that come from
+// merges are annotated with the revenue they affect, in the currency's minor
+// units as formatted by formatCents.
+func WriteProductAliases(w io.Writer, file ProductAliasFile, merges []ProductMerge, formatCents func(int64) string) error {
+	notes := make(map[string]ProductMerge, len(merges))
+	for _, merge := range merges {
+		notes[productAliasKey(merge.Canonical.Name)] = merge
+	}
+
+	var sb strings.Builder
+	sb.WriteString("# Product aliases, merged into custom_mappings. Review before use:\n")
+	sb.WriteString("# remove aliases that are really different products.\n")
+	sb.WriteString("products:\n")
+	for _, group := range file.Products {
+		if merge, ok := notes[productAliasKey(group.Canonical)]; ok {
+			fmt.Fprintf(&sb, "  # revenue %s, suggested aliases affect %s\n",
+				formatCents(merge.Canonical.RevenueCents), formatCents(merge.AffectedRevenueCents))
+		}
+		fmt.Fprintf(&sb, "  - canonical: %s\n", strconv.Quote(group.Canonical))
+		sb.WriteString("    aliases:\n")
+		for _, alias := range group.Aliases {
+			fmt.Fprintf(&sb, "      - %s\n", strconv.Quote(alias))
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,104 @@
+package transform
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSuggestProductMerges(t *testing.T) {
+	products := []ProductStats{
+		{Name: "Widget A", RevenueCents: 10000, Transactions: 40},
+		{Name: "Widget-a", RevenueCents: 500, Transactions: 2},
+		{Name: "Wdget A", RevenueCents: 200, Transactions: 1},
+		{Name: "Widget B", RevenueCents: 9000, Transactions: 30},
+		{Name: "Gadget Pro 2", RevenueCents: 3000, Transactions: 10},
+		{Name: "Gadget Pro 3", RevenueCents: 2000, Transactions: 8},
+		{Name: "Gadgett Pro 2", RevenueCents: 100, Transactions: 1},
+		{Name: "Tool Kit", RevenueCents: 800, Transactions: 5},
+	}
+
+	merges := SuggestProductMerges(products, 0)
+	if len(merges) != 2 {
+		t.Fatalf("got %d merges: %+v", len(merges), merges)
+	}
+	widget := merges[0]
+	if widget.Canonical.Name != "Widget A" || widget.AffectedRevenueCents != 700 || len(widget.Aliases) != 2 ||
+		widget.Aliases[0].Name != "Widget-a" || widget.Aliases[0].Similarity != 1 {
+		t.Errorf("widget merge: got %+v", widget)
+	}
+	if gadget := merges[1]; gadget.Canonical.Name != "Gadget Pro 2" || len(gadget.Aliases) != 1 ||
+		gadget.Aliases[0].Name != "Gadgett Pro 2" {
+		t.Errorf("gadget merge: got %+v", gadget)
+	}
+
+	// Write the suggestions next to an existing group and read them back
+	existing := ProductAliasFile{Products: []ProductAliasGroup{{Canonical: "Widget A", Aliases: []string{"WidgetA"}}}}
+	var buf bytes.Buffer
+	err := WriteProductAliases(&buf, existing.AddMerges(merges), merges, func(c int64) string { return "x" })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "aliases.yaml")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := LoadProductAliases(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, buf.String())
+	}
+	mappings, _ := file.Mappings()
+	want := map[string]string{
+		"product_widgeta":       "Widget A",
+		"product_widget-a":      "Widget A",
+		"product_wdget a":       "Widget A",
+		"product_gadgett pro 2": "Gadget Pro 2",
+	}
+	if len(mappings) != len(want) {
+		t.Errorf("got mappings %v", mappings)
+	}
+	for key, name := range want {
+		if mappings[key] != name {
+			t.Errorf("%s: got %q want %q", key, mappings[key], name)
+		}
+	}
+
+	conflicting := ProductAliasFile{Products: []ProductAliasGroup{
+		{Canonical: "Widget A", Aliases: []string{"Wdget"}},
+		{Canonical: "Widget B", Aliases: []string{"wdget"}},
+	}}
+	if _, err := conflicting.Mappings(); err == nil {
+		t.Error("alias in two groups: expected an error")
+	}
+}
+
+func TestProductAliasesFile(t *testing.T) {
+	dir := t.TempDir()
+	aliases := filepath.Join(dir, "aliases.yaml")
+	os.WriteFile(aliases, []byte("products:\n  - canonical: Widget A\n    aliases: [Widget-A, Wdget A]\n"), 0o644)
+	configPath := filepath.Join(dir, "config.yaml")
+	os.WriteFile(configPath, []byte("transformation:\n  product_aliases_file: "+aliases+
+		"\n  custom_mappings:\n    \"product_wdget a\": \"Wdget Alpha\"\n"), 0o644)
+
+	config, err := LoadTransformationConfigFromPath(configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := NewFlexibleDataHandler(config)
+	csv := "transaction_id,transaction_date,product_name,price\n" +
+		"1,2024-01-01,Widget A,1\n2,2024-01-01,widget-a,1\n3,2024-01-01,Wdget  A,1\n"
+	transactions, _, err := handler.ProcessDataStream(strings.NewReader(csv), FormatCSV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// custom_mappings configured directly win over the alias file
+	want := []string{"Widget A", "Widget A", "Wdget Alpha"}
+	for i, tx := range transactions {
+		if tx.ProductName != want[i] {
+			t.Errorf("%s: got %q want %q", tx.ID, tx.ProductName, want[i])
+		}
+	}
+}
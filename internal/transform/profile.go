@@ -0,0 +1,413 @@
+package transform
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"abt-dashboard/internal/models"
+)
+
+const (
+	// profileTopValues is the number of most frequent values a column
+	// profile lists
+	profileTopValues = 10
+
+	// maxProfileDistinct caps the distinct values counted per column. Past
+	// it, new values are no longer counted, so Distinct is a lower bound and
+	// TopValues only counts values seen before the cap was reached.
+	maxProfileDistinct = 10000
+)
+
+// Inferred column types, from most to least specific
+const (
+	ColumnEmpty   = "empty" // every value is null
+	ColumnInteger = "integer"
+	ColumnNumber  = "number"
+	ColumnBoolean = "boolean"
+	ColumnDate    = "date"
+	ColumnString  = "string"
+)
+
+// lengthBuckets are the value-length ranges of the histogram, in characters,
+// by their upper bound
+var lengthBuckets = []struct {
+	label string
+	max   int
+}{
+	{"0", 0},
+	{"1-5", 5},
+	{"6-10", 10},
+	{"11-20", 20},
+	{"21-50", 50},
+	{"51-100", 100},
+	{"101+", math.MaxInt},
+}
+
+// DataProfile describes the columns of a source as read, before any column
+// mapping or transformation. Records counts every record read, including
+// the SkippedRecords that could not be converted to a transaction.
+type DataProfile struct {
+	Source         string          `json:"source"`
+	Records        int             `json:"records"`
+	SkippedRecords int             `json:"skipped_records"`
+	Columns        []ColumnProfile `json:"columns"`
+}
+
+// ColumnProfile describes the values of one source column. A value is null
+// when it is missing from a record or is one of the configured null_values.
+// Min and Max compare numbers numerically, dates chronologically and other
+// values as text.
+type ColumnProfile struct {
+	Name            string         `json:"name"`
+	Type            string         `json:"type"`
+	TypeCounts      map[string]int `json:"type_counts"`
+	Nulls           int            `json:"nulls"`
+	NullRate        float64        `json:"null_rate"`
+	Distinct        int            `json:"distinct"`
+	DistinctCapped  bool           `json:"distinct_capped,omitempty"`
+	Min             string         `json:"min,omitempty"`
+	Max             string         `json:"max,omitempty"`
+	TopValues       []ValueCount   `json:"top_values"`
+	LengthHistogram []LengthBucket `json:"length_histogram"`
+	DateRange       *DateRange     `json:"date_range,omitempty"`
+}
+
+// ValueCount is a column value and the number of records that have it
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// LengthBucket counts the non-null values whose length in characters is in
+// Range, such as "6-10"
+type LengthBucket struct {
+	Range string `json:"range"`
+	Count int    `json:"count"`
+}
+
+// DateRange spans the values of a column that parse as dates
+type DateRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// ProfileDataFile profiles the columns of a data file. Compressed files and
+// archives are read like ProcessDataFile reads them, with every member of an
+// archive profiled into the same columns. Records are not transformed or
+// validated, so profiling does not affect the handler's state, but it waits
+// for processing in progress like another run would.
+func (fdh *FlexibleDataHandler) ProfileDataFile(filePath string) (*DataProfile, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	return fdh.ProfileReader(filePath, file)
+}
+
+// ProfileReader is ProfileDataFile for data that does not come from a path.
+// The name is only used for format detection by extension and in the profile.
+func (fdh *FlexibleDataHandler) ProfileReader(name string, reader io.Reader) (*DataProfile, error) {
+	fdh.mu.Lock()
+	defer fdh.mu.Unlock()
+
+	run := newProfileRun(fdh, name)
+	if err := fdh.processSource(run, name, reader, false); err != nil {
+		return nil, err
+	}
+	return run.finish(), nil
+}
+
+// ProfileDataStream profiles data of a known format from a stream
+func (fdh *FlexibleDataHandler) ProfileDataStream(reader io.Reader, format DataFormat) (*DataProfile, error) {
+	fdh.mu.Lock()
+	defer fdh.mu.Unlock()
+
+	run := newProfileRun(fdh, "stream")
+	if err := run.consume(reader, format); err != nil {
+		return nil, err
+	}
+	return run.finish(), nil
+}
+
+// profileRun collects the profile of one source. It reads records like a
+// streamRun but only looks at their source fields.
+type profileRun struct {
+	fdh     *FlexibleDataHandler
+	profile *DataProfile
+	nulls   map[string]bool
+	columns map[string]*columnStats
+	order   []*columnStats // in order of first appearance
+
+	// member is the archive member being read, "" for plain sources
+	member string
+}
+
+func newProfileRun(fdh *FlexibleDataHandler, source string) *profileRun {
+	// The engine fills in the default null values
+	nulls := make(map[string]bool)
+	for _, value := range fdh.engine.config.NullValues {
+		nulls[value] = true
+	}
+
+	return &profileRun{
+		fdh:     fdh,
+		profile: &DataProfile{Source: source},
+		nulls:   nulls,
+		columns: make(map[string]*columnStats),
+	}
+}
+
+func (r *profileRun) consume(reader io.Reader, format DataFormat) error {
+	name := r.profile.Source
+	if r.member != "" {
+		name = r.member
+	}
+	converter := r.fdh.converter.forSource(name).withFields()
+
+	err := converter.StreamTransactions(reader, format, RecordHandler{
+		OnRecord: func(rec SourceRecord, _ models.Transaction) error {
+			r.add(rec, converter)
+			return nil
+		},
+		OnSkip: func(rec SourceRecord, _ error) {
+			r.profile.SkippedRecords++
+			r.add(rec, converter)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read data: %w", err)
+	}
+	return nil
+}
+
+func (r *profileRun) beginMember(name string, format DataFormat) {
+	r.member = name
+}
+
+func (r *profileRun) endMember() error {
+	r.member = ""
+	return nil
+}
+
+// add counts the fields of one record
+func (r *profileRun) add(rec SourceRecord, converter *FormatConverter) {
+	r.profile.Records++
+	for _, field := range rec.Fields {
+		column, ok := r.columns[field.Name]
+		if !ok {
+			column = newColumnStats(field.Name)
+			r.columns[field.Name] = column
+			r.order = append(r.order, column)
+		}
+
+		value := strings.TrimSpace(field.Value)
+		if r.nulls[value] {
+			continue
+		}
+		// A path repeated within an XML record counts once towards presence
+		if column.lastRecord != r.profile.Records {
+			column.lastRecord = r.profile.Records
+			column.present++
+		}
+		column.add(value, converter)
+	}
+}
+
+func (r *profileRun) finish() *DataProfile {
+	r.profile.Columns = make([]ColumnProfile, 0, len(r.order))
+	for _, column := range r.order {
+		r.profile.Columns = append(r.profile.Columns, column.profile(r.profile.Records))
+	}
+	return r.profile
+}
+
+// columnStats accumulates the non-null values of one column
+type columnStats struct {
+	name       string
+	present    int // records with a non-null value
+	lastRecord int // the last record counted in present
+	kinds      map[string]int
+	values     map[string]int // capped at maxProfileDistinct
+	capped     bool
+	lengths    []int
+
+	minText, maxText string
+	hasText          bool
+
+	minNumber, maxNumber         float64
+	minNumberText, maxNumberText string
+	hasNumber                    bool
+
+	minDate, maxDate         time.Time
+	minDateText, maxDateText string
+	hasDate                  bool
+}
+
+func newColumnStats(name string) *columnStats {
+	return &columnStats{
+		name:    name,
+		kinds:   make(map[string]int),
+		values:  make(map[string]int),
+		lengths: make([]int, len(lengthBuckets)),
+	}
+}
+
+// add counts a non-null value
+func (c *columnStats) add(value string, converter *FormatConverter) {
+	if _, seen := c.values[value]; seen || len(c.values) < maxProfileDistinct {
+		c.values[value]++
+	} else {
+		c.capped = true
+	}
+
+	length := utf8.RuneCountInString(value)
+	for i, bucket := range lengthBuckets {
+		if length <= bucket.max {
+			c.lengths[i]++
+			break
+		}
+	}
+
+	if !c.hasText || value < c.minText {
+		c.minText = value
+	}
+	if !c.hasText || value > c.maxText {
+		c.maxText = value
+	}
+	c.hasText = true
+
+	kind := ColumnString
+	if number, isInteger, ok := parseProfileNumber(value); ok {
+		kind = ColumnNumber
+		if isInteger {
+			kind = ColumnInteger
+		}
+		if !c.hasNumber || number < c.minNumber {
+			c.minNumber, c.minNumberText = number, value
+		}
+		if !c.hasNumber || number > c.maxNumber {
+			c.maxNumber, c.maxNumberText = number, value
+		}
+		c.hasNumber = true
+	} else if isProfileBoolean(value) {
+		kind = ColumnBoolean
+	} else if strings.ContainsAny(value, "0123456789") {
+		// Only values with digits can be dates; this skips the date
+		// layouts for most text
+		if date, err := converter.parseFlexibleDate(value, converter.location); err == nil {
+			kind = ColumnDate
+			if !c.hasDate || date.Before(c.minDate) {
+				c.minDate, c.minDateText = date, value
+			}
+			if !c.hasDate || date.After(c.maxDate) {
+				c.maxDate, c.maxDateText = date, value
+			}
+			c.hasDate = true
+		}
+	}
+	c.kinds[kind]++
+}
+
+// parseProfileNumber parses a plain decimal number. Unlike strconv.ParseFloat
+// it rejects "NaN", "Inf" and hexadecimal, which are text in a data file.
+func parseProfileNumber(value string) (float64, bool, bool) {
+	if value == "" || !strings.ContainsAny(value[:1], "+-.0123456789") ||
+		strings.ContainsAny(value, "xXnN") {
+		return 0, false, false
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return float64(n), true, true
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false, false
+	}
+	return f, false, true
+}
+
+func isProfileBoolean(value string) bool {
+	switch strings.ToLower(value) {
+	case "true", "false", "yes", "no":
+		return true
+	}
+	return false
+}
+
+// profile completes the column profile of a source with the given number of
+// records
+func (c *columnStats) profile(records int) ColumnProfile {
+	p := ColumnProfile{
+		Name:            c.name,
+		Type:            c.inferType(),
+		TypeCounts:      c.kinds,
+		Nulls:           records - c.present,
+		Distinct:        len(c.values),
+		DistinctCapped:  c.capped,
+		LengthHistogram: make([]LengthBucket, len(lengthBuckets)),
+	}
+	if records > 0 {
+		p.NullRate = float64(p.Nulls) / float64(records)
+	}
+
+	switch p.Type {
+	case ColumnInteger, ColumnNumber:
+		p.Min, p.Max = c.minNumberText, c.maxNumberText
+	case ColumnDate:
+		p.Min, p.Max = c.minDateText, c.maxDateText
+	default:
+		p.Min, p.Max = c.minText, c.maxText
+	}
+	if c.hasDate {
+		p.DateRange = &DateRange{From: c.minDate, To: c.maxDate}
+	}
+
+	p.TopValues = make([]ValueCount, 0, len(c.values))
+	for value, count := range c.values {
+		p.TopValues = append(p.TopValues, ValueCount{Value: value, Count: count})
+	}
+	sort.Slice(p.TopValues, func(i, j int) bool {
+		if p.TopValues[i].Count != p.TopValues[j].Count {
+			return p.TopValues[i].Count > p.TopValues[j].Count
+		}
+		return p.TopValues[i].Value < p.TopValues[j].Value
+	})
+	if len(p.TopValues) > profileTopValues {
+		p.TopValues = p.TopValues[:profileTopValues]
+	}
+
+	for i, bucket := range lengthBuckets {
+		p.LengthHistogram[i] = LengthBucket{Range: bucket.label, Count: c.lengths[i]}
+	}
+	return p
+}
+
+// inferType returns the most specific type that fits every non-null value:
+// integers also fit number, anything fits string
+func (c *columnStats) inferType() string {
+	total := 0
+	for _, n := range c.kinds {
+		total += n
+	}
+	switch {
+	case total == 0:
+		return ColumnEmpty
+	case c.kinds[ColumnInteger] == total:
+		return ColumnInteger
+	case c.kinds[ColumnInteger]+c.kinds[ColumnNumber] == total:
+		return ColumnNumber
+	case c.kinds[ColumnBoolean] == total:
+		return ColumnBoolean
+	case c.kinds[ColumnDate] == total:
+		return ColumnDate
+	}
+	return ColumnString
+}
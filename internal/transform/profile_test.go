@@ -0,0 +1,110 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProfileDataStream(t *testing.T) {
+	handler := newTestHandler(10)
+
+	csv := `transaction_id,transaction_date,country,product_name,price,quantity,gift
+tx-1,2024-01-15,USA,widget,25.00,2,yes
+tx-2,2024-03-01,N/A,gadget,9.5,1,no
+tx-3,2023-12-31,Germany,widget,100,,
+tx-4,not a date,USA,a very long product name indeed,7,3,true
+tx-5,2024-02-10,,,1.25,1
+`
+	profile, err := handler.ProfileDataStream(strings.NewReader(csv), FormatCSV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// tx-4 has an invalid date and tx-5 no product name
+	if profile.Records != 5 || profile.SkippedRecords != 2 || len(profile.Columns) != 7 {
+		t.Fatalf("got %d records, %d skipped, %d columns", profile.Records, profile.SkippedRecords, len(profile.Columns))
+	}
+
+	columns := make(map[string]ColumnProfile)
+	for _, c := range profile.Columns {
+		columns[c.Name] = c
+	}
+
+	date := columns["transaction_date"]
+	if date.Type != ColumnString || date.TypeCounts[ColumnDate] != 4 || date.DateRange == nil ||
+		date.DateRange.From.Format("2006-01-02") != "2023-12-31" || date.DateRange.To.Format("2006-01-02") != "2024-03-01" {
+		t.Errorf("transaction_date: got %+v", date)
+	}
+
+	price := columns["price"]
+	if price.Type != ColumnNumber || price.Min != "1.25" || price.Max != "100" || price.Nulls != 0 {
+		t.Errorf("price: got %+v", price)
+	}
+
+	quantity := columns["quantity"]
+	if quantity.Type != ColumnInteger || quantity.Nulls != 1 || quantity.NullRate != 0.2 || quantity.Distinct != 3 {
+		t.Errorf("quantity: got %+v", quantity)
+	}
+
+	country := columns["country"]
+	if country.Nulls != 2 || country.Distinct != 2 || len(country.TopValues) != 2 ||
+		country.TopValues[0] != (ValueCount{Value: "USA", Count: 2}) || country.Min != "Germany" || country.Max != "USA" {
+		t.Errorf("country: got %+v", country)
+	}
+
+	// The last row is one field short
+	if gift := columns["gift"]; gift.Type != ColumnBoolean || gift.Nulls != 2 {
+		t.Errorf("gift: got %+v", gift)
+	}
+
+	product := columns["product_name"]
+	histogram := make(map[string]int)
+	for _, bucket := range product.LengthHistogram {
+		histogram[bucket.Range] = bucket.Count
+	}
+	if len(product.LengthHistogram) != len(lengthBuckets) || histogram["6-10"] != 3 || histogram["21-50"] != 1 {
+		t.Errorf("product_name histogram: got %+v", product.LengthHistogram)
+	}
+}
+
+func TestProfileNestedRecords(t *testing.T) {
+	handler := newTestHandler(10)
+
+	ndjson := `{"transaction_id": "1", "customer": {"name": "Ann", "tier": 2}, "price": 5}
+not json
+{"transaction_id": "2", "customer": {"name": null}, "price": 7.5}
+`
+	profile, err := handler.ProfileDataStream(strings.NewReader(ndjson), FormatNDJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, c := range profile.Columns {
+		names = append(names, c.Name)
+	}
+	if profile.Records != 3 || strings.Join(names, ",") != "customer/name,customer/tier,price,transaction_id" {
+		t.Fatalf("got %d records, columns %v", profile.Records, names)
+	}
+	if tier := profile.Columns[1]; tier.Type != ColumnInteger || tier.Nulls != 2 {
+		t.Errorf("customer/tier: got %+v", tier)
+	}
+
+	xml := `<transactions>
+  <transaction id="1"><customer><name>Ann</name></customer><item>a</item><item>b</item></transaction>
+  <transaction id="2"><customer><name>Bob</name></customer></transaction>
+</transactions>`
+	profile, err = handler.ProfileDataStream(strings.NewReader(xml), FormatXML)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names = names[:0]
+	for _, c := range profile.Columns {
+		names = append(names, c.Name)
+	}
+	if strings.Join(names, ",") != "@id,customer/name,item" {
+		t.Fatalf("got columns %v", names)
+	}
+	// Repeated elements count every value but the record once
+	if item := profile.Columns[2]; item.Nulls != 1 || item.Distinct != 2 {
+		t.Errorf("item: got %+v", item)
+	}
+}
@@ -0,0 +1,100 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+
+	"abt-dashboard/internal/quarantine"
+)
+
+// QuarantineConfig configures where rejected and defaulted rows are written.
+// Quarantining is disabled when Path is empty. Format is "csv" or "ndjson";
+// when empty it follows the file extension.
+type QuarantineConfig struct {
+	Path   string `json:"path" yaml:"path"`
+	Format string `json:"format" yaml:"format"`
+}
+
+// stageParser names the conversion step in quarantine entries; validators and
+// transformations are named by their Name()
+const stageParser = "parser"
+
+// quarantineSkip records a record that could not be converted
+func (r *streamRun) quarantineSkip(rec SourceRecord, err error) {
+	r.quarantine(rec, quarantine.ActionRejected, stageParser, err)
+}
+
+// quarantineDefaults records every field of a record that was filled in with
+// a configured default
+func (r *streamRun) quarantineDefaults(rec SourceRecord) {
+	for _, d := range rec.Defaults {
+		r.quarantine(rec, quarantine.ActionDefaulted, stageParser,
+			&FieldError{Field: d.Field, Err: fmt.Errorf("no usable value, default %q applied", d.Value)})
+	}
+}
+
+// noteRounding reports every value of a record that lost precision when it
+// was rounded to its currency's minor units
+func (r *streamRun) noteRounding(rec SourceRecord, prefix string) {
+	for _, rounded := range rec.Rounded {
+		r.result.RoundedValues++
+		r.addWarning(fmt.Sprintf("%sRecord %d: %s %s rounded to %s", prefix, rec.Line, rounded.Field, rounded.Value, rounded.Result))
+		r.quarantine(rec, quarantine.ActionRounded, stageParser,
+			&FieldError{Field: rounded.Field, Err: fmt.Errorf("%s rounded to %s", rounded.Value, rounded.Result)})
+	}
+}
+
+// noteViolations counts and reports the rules a record broke and returns
+// whether one of them rejects it
+func (r *streamRun) noteViolations(rec SourceRecord, prefix, stage string, violations RuleViolations) bool {
+	if r.result.RuleViolations == nil {
+		r.result.RuleViolations = make(map[string]int)
+	}
+
+	rejected := false
+	for _, v := range violations {
+		r.result.RuleViolations[v.Rule]++
+		var err error = v
+		if v.Field != "" {
+			err = &FieldError{Field: v.Field, Err: v}
+		}
+
+		switch v.Severity {
+		case SeverityReject:
+			rejected = true
+			r.addError(fmt.Sprintf("%sRecord %d rejected: %v", prefix, rec.Line, v))
+			r.quarantine(rec, quarantine.ActionRejected, stage, err)
+		case SeverityFlag:
+			r.addWarning(fmt.Sprintf("%sRecord %d: %v", prefix, rec.Line, v))
+			r.quarantine(rec, quarantine.ActionFlagged, stage, err)
+		default:
+			r.addWarning(fmt.Sprintf("%sRecord %d: %v", prefix, rec.Line, v))
+		}
+	}
+	return rejected
+}
+
+// quarantine writes one entry, attributing it to a column when err is a
+// FieldError
+func (r *streamRun) quarantine(rec SourceRecord, action, stage string, err error) {
+	if r.fdh.quarantine == nil {
+		return
+	}
+
+	column := ""
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) {
+		column = fieldErr.Field
+	}
+
+	r.fdh.quarantine.Record(quarantine.Entry{
+		Source: r.sourceName(),
+		Line:   rec.Line,
+		Action: action,
+		Stage:  stage,
+		Column: column,
+		Reason: err.Error(),
+		Raw:    rec.Raw(),
+	})
+	r.result.QuarantinedEntries++
+}
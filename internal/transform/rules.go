@@ -0,0 +1,199 @@
+package transform
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"abt-dashboard/internal/models"
+)
+
+// Rule is a business rule that valid records satisfy. Check is an expression
+// such as
+//
+//	quantity between 1 and 10000
+//	price > 0 unless product_name starts_with 'PROMO'
+//	transaction_date <= now
+//	country in ('United States', 'Canada') and not region is empty
+//	tx_time >= today - 10y
+//
+// over the fields transaction_id, country, region, product_name, currency,
+// price (in major units), quantity and transaction_date (also tx_time).
+// Strings compare case-insensitively; matches takes a regular expression.
+// Dates are 'YYYY-MM-DD' or RFC3339 strings, now or today, optionally offset
+// by a number of d(ays), w(eeks), m(onths) or y(ears), and are read in the
+// reporting timezone. Checks are boolean expressions of the language derived
+// fields use (see exprParser), so arithmetic and functions such as
+// is_weekend(date) work in them too; source columns do not.
+type Rule struct {
+	Name     string       `json:"name" yaml:"name"`
+	Check    string       `json:"check" yaml:"check"`
+	Severity RuleSeverity `json:"severity" yaml:"severity"`
+	Message  string       `json:"message,omitempty" yaml:"message,omitempty"`
+}
+
+// RuleSeverity decides what happens to a record that breaks a rule
+type RuleSeverity string
+
+const (
+	SeverityReject RuleSeverity = "reject" // the record is skipped
+	SeverityWarn   RuleSeverity = "warn"   // the record is kept with a warning
+	SeverityFlag   RuleSeverity = "flag"   // as warn, plus a flagged quarantine entry
+)
+
+// DefaultRules returns the rules used when none are configured: the limits
+// RangeValidator applies, as flags
+func DefaultRules() []Rule {
+	return []Rule{
+		{Name: "price_range", Check: "price between 0.01 and 500000", Severity: SeverityFlag,
+			Message: "unit price is outside the acceptable range"},
+		{Name: "quantity_range", Check: "quantity between 1 and 100000", Severity: SeverityFlag,
+			Message: "quantity is outside the acceptable range"},
+	}
+}
+
+// RuleViolation reports one rule a record broke
+type RuleViolation struct {
+	Rule     string
+	Field    string // first field the rule checks
+	Severity RuleSeverity
+	Message  string
+}
+
+func (v *RuleViolation) Error() string {
+	return fmt.Sprintf("rule %s: %s", v.Rule, v.Message)
+}
+
+// RuleViolations is the error RuleValidator returns for a record that broke
+// one or more rules
+type RuleViolations []*RuleViolation
+
+func (vs RuleViolations) Error() string {
+	messages := make([]string, len(vs))
+	for i, v := range vs {
+		messages[i] = v.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// RuleValidator checks records against the configured rules
+type RuleValidator struct {
+	rules  []compiledRule
+	prices *FormatConverter // only used for price scales
+	loc    *time.Location
+}
+
+type compiledRule struct {
+	Rule
+	check exprNode
+	field string
+}
+
+// NewRuleValidator compiles config.Rules, or DefaultRules when none are
+// configured
+func NewRuleValidator(config TransformConfig) (*RuleValidator, error) {
+	rules := config.Rules
+	if len(rules) == 0 {
+		rules = DefaultRules()
+	}
+
+	loc := config.Timezone.ReportingLocation()
+	v := &RuleValidator{prices: &FormatConverter{config: config}, loc: loc}
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %q has no name", rule.Check)
+		}
+		if seen[rule.Name] {
+			return nil, fmt.Errorf("rule %s is defined more than once", rule.Name)
+		}
+		seen[rule.Name] = true
+
+		switch rule.Severity {
+		case SeverityReject, SeverityWarn, SeverityFlag:
+		default:
+			return nil, fmt.Errorf("rule %s: severity must be %q, %q or %q, got %q",
+				rule.Name, SeverityReject, SeverityWarn, SeverityFlag, rule.Severity)
+		}
+
+		p := &exprParser{loc: loc}
+		check, err := p.parse(rule.Check)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+		if kind, ok := staticKind(check); ok && kind != kindBool {
+			return nil, fmt.Errorf("rule %s: the check is a %s, not a condition", rule.Name, kind)
+		}
+		if rule.Message == "" {
+			rule.Message = fmt.Sprintf("%s does not hold", rule.Check)
+		}
+		v.rules = append(v.rules, compiledRule{Rule: rule, check: check, field: p.firstField})
+	}
+	return v, nil
+}
+
+// validateRules checks that every rule compiles
+func validateRules(rules []Rule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	if _, err := NewRuleValidator(TransformConfig{Rules: rules}); err != nil {
+		return fmt.Errorf("rules: %w", err)
+	}
+	return nil
+}
+
+func (v *RuleValidator) Name() string {
+	return "RuleValidator"
+}
+
+func (v *RuleValidator) Description() string {
+	return "Validates records against the configured business rules"
+}
+
+// Rules returns the rules being checked
+func (v *RuleValidator) Rules() []Rule {
+	rules := make([]Rule, len(v.rules))
+	for i, rule := range v.rules {
+		rules[i] = rule.Rule
+	}
+	return rules
+}
+
+// Validate returns RuleViolations when the record breaks any rule
+func (v *RuleValidator) Validate(data interface{}) error {
+	if tx, ok := data.(*models.Transaction); ok {
+		if violations := v.Violations(tx); len(violations) > 0 {
+			return violations
+		}
+	}
+	return nil
+}
+
+// Violations returns the rules tx breaks, in rule order. A check that
+// cannot be evaluated for tx, e.g. because it divides by a zero quantity,
+// counts as broken.
+func (v *RuleValidator) Violations(tx *models.Transaction) RuleViolations {
+	ctx := newExprContext(tx, v.prices, v.loc)
+	var violations RuleViolations
+	for _, rule := range v.rules {
+		message := rule.Message
+		value, err := rule.check.eval(ctx)
+		holds := false
+		if err == nil {
+			holds, err = value.bool()
+		}
+		if err != nil {
+			message = fmt.Sprintf("%s (%v)", message, err)
+		}
+		if !holds {
+			violations = append(violations, &RuleViolation{
+				Rule:     rule.Name,
+				Field:    rule.field,
+				Severity: rule.Severity,
+				Message:  message,
+			})
+		}
+	}
+	return violations
+}
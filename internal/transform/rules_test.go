@@ -0,0 +1,120 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"abt-dashboard/internal/models"
+)
+
+func TestRuleChecks(t *testing.T) {
+	tx := models.Transaction{
+		ID:             "tx-1",
+		Country:        "United States",
+		Region:         "",
+		ProductName:    "Promo Pack",
+		UnitPriceCents: 0,
+		Quantity:       12,
+		TxTime:         time.Now().Add(-48 * time.Hour),
+	}
+
+	tests := []struct {
+		check string
+		want  bool
+	}{
+		{"quantity between 1 and 10000", true},
+		{"quantity not between 1 and 10", true},
+		{"price > 0", false},
+		{"price > 0 unless product_name starts_with 'PROMO'", true},
+		{"price > 0 unless product starts with 'gadget'", false},
+		{"tx_time <= now", true},
+		{"tx_time >= today - 1d", false},
+		{"transaction_date > '2020-01-31'", true},
+		{"country in ('united states', 'Canada') and region is empty", true},
+		{"not (region is not empty or quantity = 12)", false},
+		{"id matches '^tx-[0-9]+$'", true},
+		{"price >= -1.5", true},
+		{"'2020-01-31' < transaction_date", true},
+		{"price * quantity = 0 and lower(country) starts_with 'united'", true},
+		{"quantity % 5 = 2 unless is_weekend(date) or not is_weekend(date)", true},
+		{"quantity / price > 1", false}, // division by zero
+	}
+	for _, tt := range tests {
+		v, err := NewRuleValidator(TransformConfig{Rules: []Rule{{Name: "r", Check: tt.check, Severity: SeverityWarn}}})
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.check, err)
+			continue
+		}
+		if got := len(v.Violations(&tx)) == 0; got != tt.want {
+			t.Errorf("%q: got %v want %v", tt.check, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{
+		"quantity between 1",
+		"price > 'cheap'",
+		"discount > 0",
+		"tx_time < now - 3q",
+		"country starts_with 5",
+		"(quantity > 1",
+		"product_name matches '('",
+		"quantity + 1",
+		"column('discount') > 0",
+		"tx_time > 'yesterday'",
+	} {
+		if err := validateRules([]Rule{{Name: "r", Check: bad, Severity: SeverityWarn}}); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+	v, err := NewRuleValidator(TransformConfig{Rules: []Rule{{Name: "r", Check: "quantity / price > 1", Severity: SeverityWarn}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if violations := v.Violations(&tx); len(violations) != 1 || !strings.Contains(violations[0].Message, "division by zero") {
+		t.Errorf("failing check: got %v", violations)
+	}
+	if err := validateRules([]Rule{{Name: "r", Check: "quantity > 0", Severity: "fatal"}}); err == nil {
+		t.Error("unknown severity: expected an error")
+	}
+}
+
+func TestRuleSeverities(t *testing.T) {
+	handler := newTestHandler(10)
+	handler.config.Rules = []Rule{
+		{Name: "max_quantity", Check: "quantity <= 5", Severity: SeverityReject},
+		{Name: "known_region", Check: "region in ('North', 'South')", Severity: SeverityWarn},
+		{Name: "no_uk", Check: "country != 'United Kingdom'", Severity: SeverityFlag},
+	}
+	handler.engine = NewDataTransformationEngine(handler.config)
+
+	transactions, result, err := handler.ProcessDataStream(strings.NewReader(sampleCSV), FormatCSV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// tx-005 (quantity 10) is rejected on top of the unparseable tx-003
+	if result.SkippedRecords != 2 || len(transactions) != 3 {
+		t.Errorf("got %d skipped, %d kept", result.SkippedRecords, len(transactions))
+	}
+	want := map[string]int{"max_quantity": 1, "known_region": 2, "no_uk": 2}
+	for rule, n := range want {
+		if result.RuleViolations[rule] != n {
+			t.Errorf("violations of %s: got %d want %d (%v)", rule, result.RuleViolations[rule], n, result.RuleViolations)
+		}
+	}
+
+	report := handler.GetDataQualityReport(transactions)
+	if report.RuleViolations["known_region"] != 1 || report.RuleViolations["max_quantity"] != 0 {
+		t.Errorf("report violations: got %v", report.RuleViolations)
+	}
+	found := false
+	for _, issue := range report.Issues {
+		if issue.Type == "rule_violation" && strings.Contains(issue.Description, "known_region") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("report issues: got %+v", report.Issues)
+	}
+}
@@ -0,0 +1,340 @@
+package transform
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/quarantine"
+)
+
+// streamRun carries the state of one streaming ingestion: the result being
+// built, the current chunk and what is needed to de-duplicate and score
+// records across chunks and across the members of an archive. That is one
+// set entry per distinct transaction ID, in emittedIDs and in the quality
+// accumulator, so a run's memory still grows with its rows, if far slower
+// than with the records themselves.
+type streamRun struct {
+	fdh        *FlexibleDataHandler
+	sink       func([]models.Transaction) error
+	result     *TransformationResult
+	startTime  time.Time
+	batchSize  int
+	quality    *qualityAccumulator
+	emittedIDs map[string]struct{}
+	chunk      []models.Transaction
+	suppressed int
+
+	// source names the input in quarantine entries
+	source string
+
+	// member is the archive member being read, nil for plain sources
+	member *MemberResult
+
+	// ctx stops the run at the next record; progress, if set, receives a
+	// copy of the result after every chunk
+	ctx      context.Context
+	progress func(TransformationResult)
+
+	// unique is the engine's UniquenessValidator, nil if it has none. With
+	// dropLoaded, records whose ID an earlier run has seen are skipped.
+	unique     *UniquenessValidator
+	dropLoaded bool
+
+	// optimized is set once a chunk has gone through the optimizations
+	optimized bool
+
+	// rawLeft is how many more records carry their source text, as of the
+	// start of the current chunk; rawKept counts the records the run
+	// emitted with it
+	rawLeft int
+	rawKept int
+}
+
+func (fdh *FlexibleDataHandler) newStreamRun(sink func([]models.Transaction) error) *streamRun {
+	batchSize := fdh.config.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	return &streamRun{
+		fdh:  fdh,
+		sink: sink,
+		result: &TransformationResult{
+			BatchID:         newBatchID(),
+			Errors:          make([]string, 0),
+			Warnings:        make([]string, 0),
+			Transformations: make([]string, 0),
+		},
+		startTime:  time.Now(),
+		ctx:        context.Background(),
+		batchSize:  batchSize,
+		quality:    newQualityAccumulator(fdh.engine.hasTransformation("CountryMapping")),
+		unique:     fdh.engine.uniqueness(),
+		emittedIDs: make(map[string]struct{}),
+		chunk:      make([]models.Transaction, 0, batchSize),
+		rawLeft:    fdh.rawLeft(),
+	}
+}
+
+// execute reads the run's source with read and completes the result. The
+// transaction IDs the run has seen first are forgotten again unless it
+// succeeds and commit, if set, accepts the result, so that records that are
+// not kept are not reported as duplicates when they come again.
+func (r *streamRun) execute(read func() error, commit func(*TransformationResult) error) (*TransformationResult, error) {
+	r.unique.beginRun()
+	result, err := r.complete(read)
+	if err == nil && commit != nil {
+		err = commit(result)
+	}
+	r.unique.endRun(err == nil)
+	if err != nil {
+		r.fdh.rawKept -= r.rawKept
+		return nil, err
+	}
+	return result, nil
+}
+
+// complete reads the run's source with read and finishes the result
+func (r *streamRun) complete(read func() error) (*TransformationResult, error) {
+	if err := read(); err != nil {
+		return nil, err
+	}
+	return r.finish()
+}
+
+// newBatchID returns a random ID for one ingestion run
+func newBatchID() string {
+	b := make([]byte, 8)
+	rand.Read(b) // never fails as of Go 1.24
+	return hex.EncodeToString(b)
+}
+
+func (r *streamRun) addWarning(msg string) {
+	if len(r.result.Warnings) < maxResultMessages {
+		r.result.Warnings = append(r.result.Warnings, msg)
+	} else {
+		r.suppressed++
+	}
+}
+
+// sourceName names the file being read: the archive member if any, else the
+// source of the run
+func (r *streamRun) sourceName() string {
+	if r.member != nil {
+		return r.member.Name
+	}
+	return r.source
+}
+
+func (r *streamRun) addError(msg string) {
+	if len(r.result.Errors) < maxResultMessages {
+		r.result.Errors = append(r.result.Errors, msg)
+	} else {
+		r.suppressed++
+	}
+}
+
+// consume streams one source of a known format through the pipeline
+func (r *streamRun) consume(reader io.Reader, format DataFormat) error {
+	prefix := ""
+	if r.member != nil {
+		prefix = r.member.Name + ": "
+	}
+
+	err := r.fdh.converter.forSource(r.sourceName()).StreamTransactions(reader, format, RecordHandler{
+		OnRecord: func(rec SourceRecord, tx models.Transaction) error {
+			if err := r.ctx.Err(); err != nil {
+				return err
+			}
+			r.result.OriginalRecords++
+			if r.member != nil {
+				r.member.OriginalRecords++
+			}
+			r.quarantineDefaults(rec)
+			r.noteRounding(rec, prefix)
+			tx.Lineage = models.Lineage{
+				Source:  r.sourceName(),
+				Line:    rec.Line,
+				BatchID: r.result.BatchID,
+			}
+			if r.rawLeft > 0 {
+				tx.Lineage.Raw = rec.Raw()
+			}
+			rejected := false
+			var loaded error
+			mark := r.unique.mark()
+			out := r.fdh.applyPipeline(tx, rec.Columns, r.result.OriginalRecords-1,
+				func(stage, message string, err error) {
+					var violations RuleViolations
+					if errors.As(err, &violations) {
+						rejected = r.noteViolations(rec, prefix, stage, violations) || rejected
+						return
+					}
+					if r.dropLoaded && errors.Is(err, ErrLoadedID) {
+						loaded = err
+						return
+					}
+					r.addWarning(message)
+					r.quarantine(rec, quarantine.ActionFlagged, stage, err)
+				})
+			if loaded != nil && !rejected {
+				r.result.AlreadyLoaded++
+				r.addError(fmt.Sprintf("%sRecord %d skipped: transaction ID %s is already loaded", prefix, rec.Line, out.ID))
+				r.quarantine(rec, quarantine.ActionRejected, "UniquenessValidator", loaded)
+			}
+			if rejected || loaded != nil {
+				// A record that is not kept does not claim its ID
+				r.unique.forgetSince(mark)
+				r.result.SkippedRecords++
+				if r.member != nil {
+					r.member.SkippedRecords++
+				}
+				return nil
+			}
+			if out.Lineage.Raw != "" {
+				r.rawLeft--
+				r.rawKept++
+				r.fdh.rawKept++
+			}
+			r.chunk = append(r.chunk, out)
+			if len(r.chunk) >= r.batchSize {
+				return r.flush()
+			}
+			return nil
+		},
+		OnSkip: func(rec SourceRecord, err error) {
+			r.result.OriginalRecords++
+			r.result.SkippedRecords++
+			if r.member != nil {
+				r.member.OriginalRecords++
+				r.member.SkippedRecords++
+			}
+			r.addError(fmt.Sprintf("%sRecord %d skipped: %v", prefix, rec.Line, err))
+			r.quarantineSkip(rec, err)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to convert data: %w", err)
+	}
+	return nil
+}
+
+// flush optimizes the current chunk, scores it and hands it to the sink
+func (r *streamRun) flush() error {
+	if len(r.chunk) == 0 {
+		return nil
+	}
+
+	out := r.chunk
+	if r.fdh.config.EnableOptimization {
+		r.optimized = true
+		dedupe := r.fdh.engine.hasOptimization("DuplicateRemoval")
+		if dedupe {
+			// Drop records already emitted in an earlier chunk so that
+			// duplicate removal holds across chunk boundaries
+			deduped := out[:0]
+			for _, tx := range out {
+				if _, seen := r.emittedIDs[tx.ID]; !seen {
+					deduped = append(deduped, tx)
+				}
+			}
+			out = deduped
+		}
+
+		optimizedData, err := r.fdh.optimizeTransactions(out)
+		if err != nil {
+			r.addWarning(fmt.Sprintf("Optimization failed: %v", err))
+		} else {
+			out = optimizedData
+		}
+
+		if dedupe {
+			for _, tx := range out {
+				r.emittedIDs[tx.ID] = struct{}{}
+			}
+		}
+	}
+
+	for i := range out {
+		r.quality.add(&out[i])
+	}
+	r.result.TransformedRecords += len(out)
+	if r.member != nil {
+		r.member.TransformedRecords += len(out)
+	}
+
+	err := r.sink(out)
+	r.chunk = r.chunk[:0]
+	r.rawLeft = r.fdh.rawLeft()
+	if err == nil && r.progress != nil {
+		r.progress(r.snapshot())
+	}
+	return err
+}
+
+// snapshot copies the result so far
+func (r *streamRun) snapshot() TransformationResult {
+	result := *r.result
+	result.Errors = append([]string(nil), r.result.Errors...)
+	result.Warnings = append([]string(nil), r.result.Warnings...)
+	result.Members = append([]MemberResult(nil), r.result.Members...)
+	if r.result.RuleViolations != nil {
+		result.RuleViolations = make(map[string]int, len(r.result.RuleViolations))
+		for rule, n := range r.result.RuleViolations {
+			result.RuleViolations[rule] = n
+		}
+	}
+	result.ProcessingTime = time.Since(r.startTime)
+	return result
+}
+
+// beginMember starts attributing records to an archive member
+func (r *streamRun) beginMember(name string, format DataFormat) {
+	r.member = &MemberResult{Name: name, Format: format}
+}
+
+// endMember flushes the member's last records so its counts are exact
+func (r *streamRun) endMember() error {
+	err := r.flush()
+	if r.member != nil {
+		r.result.Members = append(r.result.Members, *r.member)
+		r.member = nil
+	}
+	return err
+}
+
+// finish flushes the final chunk and completes the result
+func (r *streamRun) finish() (*TransformationResult, error) {
+	if err := r.flush(); err != nil {
+		return nil, err
+	}
+
+	result := r.result
+	if r.suppressed > 0 {
+		result.Warnings = append(result.Warnings,
+			fmt.Sprintf("%d additional warnings and errors suppressed", r.suppressed))
+	}
+
+	// Record the stages that ran
+	piped := result.OriginalRecords > result.SkippedRecords
+	result.Transformations = r.fdh.engine.stagesRun(piped, piped && r.fdh.config.EnableValidation, r.optimized)
+
+	// Calculate data quality metrics
+	result.DataQuality = r.quality.metrics()
+	if issue, found := unmappedCountryIssue(result.DataQuality); found {
+		result.Warnings = append(result.Warnings, issue.Description)
+	}
+	result.ProcessingTime = time.Since(r.startTime)
+
+	log.Printf("Data processing completed: %d records processed in %v with %.2f%% quality score",
+		result.TransformedRecords, result.ProcessingTime, result.DataQuality.Completeness*100)
+
+	return result, nil
+}
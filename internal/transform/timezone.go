@@ -0,0 +1,137 @@
+package transform
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TimezoneConfig controls how timestamps are read and bucketed.
+//
+// Timestamps that carry an offset (RFC3339, "MST" layouts, Unix times) are
+// exact. Timestamps without one, including date-only values, are read as wall
+// clock time in the record's timezone column if present, else the zone of a
+// Datasets pattern matching the file name (tried in sorted order), else
+// Source. Reporting is the zone in which months and other time buckets are
+// computed. All zones are IANA names such as "Asia/Tokyo" or fixed offsets
+// such as "+09:00"; empty means UTC.
+type TimezoneConfig struct {
+	Reporting string            `json:"reporting" yaml:"reporting"`
+	Source    string            `json:"source" yaml:"source"`
+	Datasets  map[string]string `json:"datasets,omitempty" yaml:"datasets,omitempty"` // file name glob -> zone
+}
+
+// ReportingLocation returns the zone time buckets are computed in. Invalid
+// names are reported when the configuration is loaded and fall back to UTC.
+func (c TimezoneConfig) ReportingLocation() *time.Location {
+	loc, err := loadLocation(c.Reporting)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// sourceLocation returns the zone of timestamps without an offset in the
+// named source
+func (c TimezoneConfig) sourceLocation(name string) *time.Location {
+	if name != "" {
+		patterns := make([]string, 0, len(c.Datasets))
+		for pattern := range c.Datasets {
+			patterns = append(patterns, pattern)
+		}
+		sort.Strings(patterns)
+
+		base := filepath.Base(name)
+		for _, pattern := range patterns {
+			matched, _ := filepath.Match(pattern, base)
+			if !matched {
+				matched, _ = filepath.Match(pattern, name)
+			}
+			if matched {
+				if loc, err := loadLocation(c.Datasets[pattern]); err == nil {
+					return loc
+				}
+			}
+		}
+	}
+
+	loc, err := loadLocation(c.Source)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// validateTimezoneConfig checks that every configured zone can be loaded
+func validateTimezoneConfig(c TimezoneConfig) error {
+	if _, err := loadLocation(c.Reporting); err != nil {
+		return fmt.Errorf("timezone.reporting: %w", err)
+	}
+	if _, err := loadLocation(c.Source); err != nil {
+		return fmt.Errorf("timezone.source: %w", err)
+	}
+	for pattern, zone := range c.Datasets {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("timezone.datasets: invalid pattern %q: %w", pattern, err)
+		}
+		if _, err := loadLocation(zone); err != nil {
+			return fmt.Errorf("timezone.datasets.%s: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// locations caches loaded zones, as records usually repeat a few names
+var locations sync.Map // string -> *time.Location
+
+// loadLocation resolves an IANA zone name or a fixed offset ("+09:00",
+// "-0530", "UTC+9"). Empty and "UTC" are UTC.
+func loadLocation(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.EqualFold(name, "UTC") || name == "Z" {
+		return time.UTC, nil
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+
+	loc, err := parseFixedZone(name)
+	if err != nil {
+		if loc, err = time.LoadLocation(name); err != nil {
+			return nil, fmt.Errorf("unknown timezone %q", name)
+		}
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// parseFixedZone parses offsets such as "+09:00", "-0530", "UTC+9" or
+// "GMT-03:30"
+func parseFixedZone(name string) (*time.Location, error) {
+	offset := name
+	for _, prefix := range []string{"UTC", "GMT"} {
+		if len(offset) > len(prefix) && strings.EqualFold(offset[:len(prefix)], prefix) {
+			offset = offset[len(prefix):]
+			break
+		}
+	}
+	if offset == "" || (offset[0] != '+' && offset[0] != '-') {
+		return nil, fmt.Errorf("not an offset")
+	}
+
+	for _, layout := range []string{"-07:00", "-0700", "-07"} {
+		if t, err := time.Parse(layout, offset); err == nil {
+			_, seconds := t.Zone()
+			return time.FixedZone(name, seconds), nil
+		}
+	}
+	// Single-digit hours, e.g. "UTC+9"
+	if t, err := time.Parse("-07", offset[:1]+"0"+offset[1:]); err == nil {
+		_, seconds := t.Zone()
+		return time.FixedZone(name, seconds), nil
+	}
+	return nil, fmt.Errorf("invalid offset %q", name)
+}
@@ -1,6 +1,7 @@
 package transform
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
@@ -24,7 +25,8 @@ func (c *CurrencyNormalization) Description() string {
 
 func (c *CurrencyNormalization) Transform(data interface{}) (interface{}, error) {
 	if tx, ok := data.(*models.Transaction); ok {
-		// Currency normalization is handled in parsePrice method
+		// Currency symbols are stripped in parseFlexiblePrice and prices
+		// are converted to the reporting currency in applyCurrency
 		return tx, nil
 	}
 	return data, nil
@@ -95,7 +97,8 @@ func (s *StringCleaning) cleanString(str string) string {
 
 // CountryMapping handles country name standardization
 type CountryMapping struct {
-	config TransformConfig
+	config   TransformConfig
+	mappings map[string]string // pipeline parameter, checked first
 }
 
 func (c *CountryMapping) Name() string {
@@ -103,56 +106,47 @@ func (c *CountryMapping) Name() string {
 }
 
 func (c *CountryMapping) Description() string {
-	return "Maps country names to standardized values"
+	return "Maps country names and ISO 3166 codes to standard names and codes"
 }
 
 func (c *CountryMapping) Transform(data interface{}) (interface{}, error) {
 	if tx, ok := data.(*models.Transaction); ok {
-		tx.Country = c.mapCountry(tx.Country)
+		tx.Country, tx.CountryCode = c.mapCountry(tx.Country)
+		tx.Continent = c.config.Geography.Hierarchy.Continent(tx.CountryCode)
 		return tx, nil
 	}
 	return data, nil
 }
 
-func (c *CountryMapping) mapCountry(country string) string {
-	// Standard country mappings
-	mappings := map[string]string{
-		"usa":                                   "United States",
-		"us":                                    "United States",
-		"america":                               "United States",
-		"united states of america":              "United States",
-		"uk":                                    "United Kingdom",
-		"britain":                               "United Kingdom",
-		"great britain":                         "United Kingdom",
-		"england":                               "United Kingdom",
-		"uae":                                   "United Arab Emirates",
-		"south korea":                           "South Korea",
-		"republic of korea":                     "South Korea",
-		"north korea":                           "North Korea",
-		"democratic people's republic of korea": "North Korea",
-		"prc":                                   "China",
-		"people's republic of china":            "China",
-		"roc":                                   "Taiwan",
-		"republic of china":                     "Taiwan",
-	}
-
+// mapCountry returns the standard name and ISO 3166-1 alpha-2 code of a
+// country. Configured mappings are checked before the ISO table; values that
+// are not found keep their name, in title case, without a code.
+func (c *CountryMapping) mapCountry(country string) (string, string) {
 	normalizedCountry := strings.ToLower(strings.TrimSpace(country))
-	if mapped, exists := mappings[normalizedCountry]; exists {
-		return mapped
+	if normalizedCountry == "" {
+		return "", ""
+	}
+	if mapped, exists := c.mappings[normalizedCountry]; exists {
+		return mapped, countryCode(mapped)
 	}
 
 	// Apply custom mappings from config
 	if mapped, exists := c.config.CustomMappings[normalizedCountry]; exists {
-		return mapped
+		return mapped, countryCode(mapped)
+	}
+
+	if iso, found := LookupCountry(country); found {
+		return iso.Name, iso.Alpha2
 	}
 
 	// Return title case version
-	return strings.Title(strings.ToLower(country))
+	return strings.Title(normalizedCountry), ""
 }
 
 // RegionMapping handles region name standardization
 type RegionMapping struct {
-	config TransformConfig
+	config   TransformConfig
+	mappings map[string]string // pipeline parameter, checked first
 }
 
 func (r *RegionMapping) Name() string {
@@ -189,6 +183,9 @@ func (r *RegionMapping) mapRegion(region string) string {
 	}
 
 	normalizedRegion := strings.ToLower(strings.TrimSpace(region))
+	if mapped, exists := r.mappings[normalizedRegion]; exists {
+		return mapped
+	}
 	if mapped, exists := mappings[normalizedRegion]; exists {
 		return mapped
 	}
@@ -205,7 +202,8 @@ func (r *RegionMapping) mapRegion(region string) string {
 
 // ProductNameNormalization handles product name standardization
 type ProductNameNormalization struct {
-	config TransformConfig
+	config   TransformConfig
+	mappings map[string]string // pipeline parameter, checked first
 }
 
 func (p *ProductNameNormalization) Name() string {
@@ -227,6 +225,10 @@ func (p *ProductNameNormalization) Transform(data interface{}) (interface{}, err
 func (p *ProductNameNormalization) normalizeProductName(productName string) string {
 	productName = strings.TrimSpace(productName)
 
+	if mapped, exists := p.mappings[strings.ToLower(productName)]; exists {
+		return mapped
+	}
+
 	// Apply custom mappings from config
 	configKey := "product_" + strings.ToLower(productName)
 	if mapped, exists := p.config.CustomMappings[configKey]; exists {
@@ -262,7 +264,31 @@ func (p *ProductNameNormalization) normalizeProductName(productName string) stri
 }
 
 // RequiredFieldValidator validates that required fields are present
-type RequiredFieldValidator struct{}
+type RequiredFieldValidator struct {
+	fields []string // fields to require; nil requires all but region
+}
+
+// requiredFieldChecks report whether a field of a transaction is missing
+var requiredFieldChecks = map[string]struct {
+	missing func(tx *models.Transaction) bool
+	message string
+}{
+	"transaction_id":   {func(tx *models.Transaction) bool { return tx.ID == "" }, "transaction ID is required"},
+	"country":          {func(tx *models.Transaction) bool { return tx.Country == "" }, "country is required"},
+	"region":           {func(tx *models.Transaction) bool { return tx.Region == "" }, "region is required"},
+	"product_name":     {func(tx *models.Transaction) bool { return tx.ProductName == "" }, "product name is required"},
+	"price":            {func(tx *models.Transaction) bool { return tx.UnitPriceCents <= 0 }, "unit price must be positive"},
+	"quantity":         {func(tx *models.Transaction) bool { return tx.Quantity <= 0 }, "quantity must be positive"},
+	"transaction_date": {func(tx *models.Transaction) bool { return tx.TxTime.IsZero() }, "transaction time is required"},
+}
+
+// defaultRequiredFields are required when no fields are configured
+var defaultRequiredFields = []string{"transaction_id", "country", "product_name", "price", "quantity", "transaction_date"}
+
+func isRequirableField(field string) bool {
+	_, ok := requiredFieldChecks[field]
+	return ok
+}
 
 func (r *RequiredFieldValidator) Name() string {
 	return "RequiredFieldValidator"
@@ -274,31 +300,35 @@ func (r *RequiredFieldValidator) Description() string {
 
 func (r *RequiredFieldValidator) Validate(data interface{}) error {
 	if tx, ok := data.(*models.Transaction); ok {
-		if tx.ID == "" {
-			return fmt.Errorf("transaction ID is required")
-		}
-		if tx.Country == "" {
-			return fmt.Errorf("country is required")
-		}
-		if tx.ProductName == "" {
-			return fmt.Errorf("product name is required")
-		}
-		if tx.UnitPriceCents <= 0 {
-			return fmt.Errorf("unit price must be positive")
-		}
-		if tx.Quantity <= 0 {
-			return fmt.Errorf("quantity must be positive")
+		fields := r.fields
+		if fields == nil {
+			fields = defaultRequiredFields
 		}
-		if tx.TxTime.IsZero() {
-			return fmt.Errorf("transaction time is required")
+		for _, field := range fields {
+			if check, ok := requiredFieldChecks[field]; ok && check.missing(tx) {
+				return fieldErrorf(field, "%s", check.message)
+			}
 		}
 	}
 	return nil
 }
 
-// DataTypeValidator validates data types and formats
+// DataTypeValidator validates data types and formats. Zero limits use the
+// built-in ones.
 type DataTypeValidator struct {
-	config TransformConfig
+	config         TransformConfig
+	maxPriceCents  int64
+	maxQuantity    int64
+	maxAgeYears    int64
+	maxFutureYears int64
+}
+
+// limitOr returns limit, or def when limit is not set
+func limitOr(limit, def int64) int64 {
+	if limit > 0 {
+		return limit
+	}
+	return def
 }
 
 func (d *DataTypeValidator) Name() string {
@@ -313,38 +343,52 @@ func (d *DataTypeValidator) Validate(data interface{}) error {
 	if tx, ok := data.(*models.Transaction); ok {
 		// Validate ID format (should be alphanumeric)
 		if matched, _ := regexp.MatchString(`^[a-zA-Z0-9_-]+$`, tx.ID); !matched {
-			return fmt.Errorf("transaction ID contains invalid characters")
+			return fieldErrorf("transaction_id", "transaction ID contains invalid characters")
 		}
 
-		// Validate country name (should contain only letters, spaces, and common punctuation)
-		if matched, _ := regexp.MatchString(`^[a-zA-Z\s\.\-']+$`, tx.Country); !matched {
-			return fmt.Errorf("country name contains invalid characters")
+		// Validate country name (should contain only letters, spaces, and common
+		// punctuation). Letters include accented ones and parentheses occur
+		// in ISO names, e.g. "Côte d'Ivoire" and "Cocos (Keeling) Islands".
+		if matched, _ := regexp.MatchString(`^[\p{L}\s.\-'(),]+$`, tx.Country); !matched {
+			return fieldErrorf("country", "country name contains invalid characters")
 		}
 
 		// Validate price range (reasonable business limits)
-		if tx.UnitPriceCents > 10000000 { // $100,000
-			return fmt.Errorf("unit price exceeds reasonable maximum")
+		if tx.UnitPriceCents > limitOr(d.maxPriceCents, 10000000) { // $100,000
+			return fieldErrorf("price", "unit price exceeds reasonable maximum")
 		}
 
 		// Validate quantity range
-		if tx.Quantity > 1000000 {
-			return fmt.Errorf("quantity exceeds reasonable maximum")
+		if tx.Quantity > limitOr(d.maxQuantity, 1000000) {
+			return fieldErrorf("quantity", "quantity exceeds reasonable maximum")
 		}
 
 		// Validate date range (not too far in past or future)
 		now := time.Now()
-		if tx.TxTime.Before(now.AddDate(-10, 0, 0)) {
-			return fmt.Errorf("transaction date is too far in the past")
+		if tx.TxTime.Before(now.AddDate(-int(limitOr(d.maxAgeYears, 10)), 0, 0)) {
+			return fieldErrorf("transaction_date", "transaction date is too far in the past")
 		}
-		if tx.TxTime.After(now.AddDate(1, 0, 0)) {
-			return fmt.Errorf("transaction date is in the future")
+		if tx.TxTime.After(now.AddDate(int(limitOr(d.maxFutureYears, 1)), 0, 0)) {
+			return fieldErrorf("transaction_date", "transaction date is in the future")
 		}
 	}
 	return nil
 }
 
-// RangeValidator validates that numeric values are within acceptable ranges
-type RangeValidator struct{}
+// RangeValidator validates that numeric values are within acceptable ranges.
+// Zero limits use the built-in ones.
+type RangeValidator struct {
+	minPriceCents int64
+	maxPriceCents int64
+	minQuantity   int64
+	maxQuantity   int64
+}
+
+// limits returns the effective price and quantity ranges
+func (r *RangeValidator) limits() (minPrice, maxPrice, minQty, maxQty int64) {
+	return limitOr(r.minPriceCents, 1), limitOr(r.maxPriceCents, 50000000), // $0.01 to $500,000
+		limitOr(r.minQuantity, 1), limitOr(r.maxQuantity, 100000)
+}
 
 func (r *RangeValidator) Name() string {
 	return "RangeValidator"
@@ -356,22 +400,36 @@ func (r *RangeValidator) Description() string {
 
 func (r *RangeValidator) Validate(data interface{}) error {
 	if tx, ok := data.(*models.Transaction); ok {
+		minPrice, maxPrice, minQty, maxQty := r.limits()
+
 		// Price range validation
-		if tx.UnitPriceCents < 1 || tx.UnitPriceCents > 50000000 { // $0.01 to $500,000
-			return fmt.Errorf("unit price %d cents is outside acceptable range", tx.UnitPriceCents)
+		if tx.UnitPriceCents < minPrice || tx.UnitPriceCents > maxPrice {
+			return fieldErrorf("price", "unit price %d cents is outside acceptable range", tx.UnitPriceCents)
 		}
 
 		// Quantity range validation
-		if tx.Quantity < 1 || tx.Quantity > 100000 {
-			return fmt.Errorf("quantity %d is outside acceptable range", tx.Quantity)
+		if tx.Quantity < minQty || tx.Quantity > maxQty {
+			return fieldErrorf("quantity", "quantity %d is outside acceptable range", tx.Quantity)
 		}
 	}
 	return nil
 }
 
-// UniquenessValidator validates uniqueness constraints
+// ErrLoadedID marks a duplicate transaction ID that an earlier run, rather
+// than the current one, has seen
+var ErrLoadedID = errors.New("seen in an earlier run")
+
+// UniquenessValidator validates uniqueness constraints. It remembers every
+// transaction ID it has let through for as long as the handler lives, which
+// takes about 70 bytes per ID of a dozen characters: 10 million rows cost
+// about 700 MB. Leave it out of the validators to load more rows than that.
 type UniquenessValidator struct {
-	seenIDs map[string]bool
+	seenIDs map[string]int // ID → run that first saw it
+
+	// run numbers the current run. journal lists the IDs it has seen first,
+	// in order, so that they can be forgotten when its records are not kept.
+	run     int
+	journal []string
 }
 
 func (u *UniquenessValidator) Name() string {
@@ -384,18 +442,62 @@ func (u *UniquenessValidator) Description() string {
 
 func (u *UniquenessValidator) Validate(data interface{}) error {
 	if u.seenIDs == nil {
-		u.seenIDs = make(map[string]bool)
+		u.seenIDs = make(map[string]int)
 	}
 
 	if tx, ok := data.(*models.Transaction); ok {
-		if u.seenIDs[tx.ID] {
-			return fmt.Errorf("duplicate transaction ID: %s", tx.ID)
+		if run, seen := u.seenIDs[tx.ID]; seen {
+			if run != u.run {
+				return fieldErrorf("transaction_id", "duplicate transaction ID: %s (%w)", tx.ID, ErrLoadedID)
+			}
+			return fieldErrorf("transaction_id", "duplicate transaction ID: %s", tx.ID)
 		}
-		u.seenIDs[tx.ID] = true
+		u.seenIDs[tx.ID] = u.run
+		u.journal = append(u.journal, tx.ID)
 	}
 	return nil
 }
 
+// beginRun starts a new run with an empty journal
+func (u *UniquenessValidator) beginRun() {
+	if u == nil {
+		return
+	}
+	u.run++
+	u.journal = u.journal[:0]
+}
+
+// mark returns the position in the journal, for forgetSince
+func (u *UniquenessValidator) mark() int {
+	if u == nil {
+		return 0
+	}
+	return len(u.journal)
+}
+
+// forgetSince forgets the IDs first seen since mark returned n
+func (u *UniquenessValidator) forgetSince(n int) {
+	if u == nil {
+		return
+	}
+	for _, id := range u.journal[n:] {
+		delete(u.seenIDs, id)
+	}
+	u.journal = u.journal[:n]
+}
+
+// endRun completes the run, forgetting the IDs it has seen first unless its
+// records are kept
+func (u *UniquenessValidator) endRun(keep bool) {
+	if u == nil {
+		return
+	}
+	if !keep {
+		u.forgetSince(0)
+	}
+	u.journal = nil
+}
+
 // DuplicateRemoval removes duplicate transactions
 type DuplicateRemoval struct{}
 
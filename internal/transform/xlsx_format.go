@@ -0,0 +1,469 @@
+package transform
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"abt-dashboard/internal/models"
+	"abt-dashboard/internal/quarantine"
+)
+
+// XLSXConfig selects the worksheet and header row of Excel workbook input.
+//
+// Sheet picks a worksheet by name and takes precedence over SheetIndex, the
+// 0-based position of the sheet in the workbook. HeaderRow is the 1-based row
+// holding the column headers; when 0 the first row among the first
+// maxHeaderScanRows that maps at least minHeaderFields standard fields, or
+// every required one, is used.
+type XLSXConfig struct {
+	Sheet      string `json:"sheet" yaml:"sheet"`
+	SheetIndex int    `json:"sheet_index" yaml:"sheet_index"`
+	HeaderRow  int    `json:"header_row" yaml:"header_row"`
+}
+
+const (
+	// maxHeaderScanRows bounds the header row search
+	maxHeaderScanRows = 20
+
+	// minHeaderFields is how many standard fields a row must map to be
+	// taken as the header
+	minHeaderFields = 3
+
+	// maxExcelSerial is the serial of 9999-12-31, the last date Excel supports
+	maxExcelSerial = 2958465
+)
+
+var (
+	// excelEpoch1900 is day 0 of the default date system. It is 1899-12-30
+	// rather than 12-31 because Excel treats 1900 as a leap year.
+	excelEpoch1900 = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+	// excelEpoch1904 is day 0 of the 1904 date system used by older Mac files
+	excelEpoch1904 = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// xlsxWorkbook is the subset of xl/workbook.xml needed to locate sheets
+type xlsxWorkbook struct {
+	WorkbookPr struct {
+		Date1904 bool `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+// xlsxRelationships is xl/_rels/workbook.xml.rels
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxCell is a <c> element of a worksheet row
+type xlsxCell struct {
+	Ref       string `xml:"r,attr"`
+	Type      string `xml:"t,attr"`
+	Value     string `xml:"v"`
+	InlineStr struct {
+		Text string `xml:"t"`
+		Runs []struct {
+			Text string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"is"`
+}
+
+// xlsxRow is a <row> element of a worksheet
+type xlsxRow struct {
+	Number int        `xml:"r,attr"`
+	Cells  []xlsxCell `xml:"c"`
+}
+
+// isXLSXData reports whether a sniffed file prefix is an Excel workbook, which
+// is a zip archive whose entries include [Content_Types].xml and xl/ parts
+func isXLSXData(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("PK\x03\x04")) &&
+		(bytes.Contains(data, []byte("[Content_Types].xml")) || bytes.Contains(data, []byte("xl/")))
+}
+
+// parseXLSX handles Excel workbook parsing
+func (fc *FormatConverter) parseXLSX(reader io.Reader) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+
+	err := fc.streamXLSX(reader, transactionSink{fc: fc, handler: RecordHandler{
+		OnRecord: func(rec SourceRecord, tx models.Transaction) error {
+			transactions = append(transactions, tx)
+			return nil
+		},
+		OnSkip: func(rec SourceRecord, err error) {
+			fmt.Printf("Warning: Failed to parse row %d: %v\n", rec.Line, err)
+		},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// streamXLSX reads the configured worksheet row by row. The workbook itself
+// is a zip archive and needs random access, so input that is not a file is
+// buffered first; the worksheet XML is then decoded incrementally.
+func (fc *FormatConverter) streamXLSX(reader io.Reader, sink recordSink) error {
+	archive, err := openZipArchive(reader)
+	if err != nil {
+		return fmt.Errorf("failed to open workbook: %w", err)
+	}
+
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+
+	var workbook xlsxWorkbook
+	if err := decodeZipXML(files, "xl/workbook.xml", &workbook); err != nil {
+		return fmt.Errorf("failed to read workbook: %w", err)
+	}
+
+	sheetPath, err := fc.resolveXLSXSheet(files, workbook)
+	if err != nil {
+		return err
+	}
+
+	sharedStrings, err := readSharedStrings(files)
+	if err != nil {
+		return fmt.Errorf("failed to read shared strings: %w", err)
+	}
+
+	epoch := excelEpoch1900
+	if workbook.WorkbookPr.Date1904 {
+		epoch = excelEpoch1904
+	}
+
+	sheetFile, ok := files[sheetPath]
+	if !ok {
+		return fmt.Errorf("worksheet %s not found in workbook", sheetPath)
+	}
+	sheet, err := sheetFile.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open worksheet: %w", err)
+	}
+	defer sheet.Close()
+
+	var (
+		columnMap  map[string]int
+		header     []string
+		dateColumn = -1
+		scanned    int // rows read while searching for the header
+	)
+
+	processRow := func(row xlsxRow) error {
+		record := xlsxRowValues(row, sharedStrings)
+
+		if columnMap == nil {
+			if !fc.isXLSXHeaderRow(row.Number, record) {
+				scanned++
+				if fc.config.XLSX.HeaderRow == 0 && scanned >= maxHeaderScanRows {
+					return fmt.Errorf("no header row found in the first %d rows", maxHeaderScanRows)
+				}
+				return nil
+			}
+			columnMap = fc.createColumnMapping(record)
+			header = record
+			if idx, ok := columnMap[fc.dateField]; ok {
+				dateColumn = idx
+			}
+			return nil
+		}
+
+		if isBlankRecord(record) {
+			return nil
+		}
+
+		// Excel stores dates as day serials; convert them so that the
+		// usual date parsing applies. Serials are wall clock time, so they
+		// are read in the source timezone. The raw row keeps the cell value.
+		rawRecord := record
+		if dateColumn >= 0 && dateColumn < len(record) {
+			if converted, ok := excelSerialToTime(record[dateColumn], epoch); ok {
+				rawRecord = append([]string(nil), record...)
+				record[dateColumn] = converted.Format("2006-01-02 15:04:05")
+			}
+		}
+
+		rec := SourceRecord{
+			Line: row.Number,
+			raw:  func() string { return quarantine.RawCSV(rawRecord, ',') },
+		}
+		if fc.captureFields {
+			rec.Fields = csvFields(header, record)
+		}
+		return sink.row(record, columnMap, rec)
+	}
+
+	decoder := xml.NewDecoder(sheet)
+	rowNumber := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse worksheet: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+
+		var row xlsxRow
+		if err := decoder.DecodeElement(&row, &start); err != nil {
+			return fmt.Errorf("failed to parse worksheet row: %w", err)
+		}
+		// The row number attribute is optional
+		if row.Number == 0 {
+			row.Number = rowNumber + 1
+		}
+		rowNumber = row.Number
+
+		if err := processRow(row); err != nil {
+			return err
+		}
+	}
+
+	if columnMap == nil {
+		return fmt.Errorf("no header row found in worksheet")
+	}
+	return nil
+}
+
+// resolveXLSXSheet returns the archive path of the configured worksheet
+func (fc *FormatConverter) resolveXLSXSheet(files map[string]*zip.File, workbook xlsxWorkbook) (string, error) {
+	if len(workbook.Sheets) == 0 {
+		return "", fmt.Errorf("workbook contains no sheets")
+	}
+
+	cfg := fc.config.XLSX
+	index := -1
+	if cfg.Sheet != "" {
+		for i, s := range workbook.Sheets {
+			if strings.EqualFold(s.Name, cfg.Sheet) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return "", fmt.Errorf("sheet %q not found in workbook", cfg.Sheet)
+		}
+	} else {
+		if cfg.SheetIndex < 0 || cfg.SheetIndex >= len(workbook.Sheets) {
+			return "", fmt.Errorf("sheet index %d out of range (workbook has %d sheets)",
+				cfg.SheetIndex, len(workbook.Sheets))
+		}
+		index = cfg.SheetIndex
+	}
+
+	var rels xlsxRelationships
+	if err := decodeZipXML(files, "xl/_rels/workbook.xml.rels", &rels); err == nil {
+		for _, rel := range rels.Relationships {
+			if rel.ID == workbook.Sheets[index].RID {
+				if strings.HasPrefix(rel.Target, "/") {
+					return strings.TrimPrefix(rel.Target, "/"), nil
+				}
+				return path.Join("xl", rel.Target), nil
+			}
+		}
+	}
+
+	// Fall back to the conventional part name
+	return fmt.Sprintf("xl/worksheets/sheet%d.xml", index+1), nil
+}
+
+// isXLSXHeaderRow decides whether a row is the header row
+func (fc *FormatConverter) isXLSXHeaderRow(rowNumber int, record []string) bool {
+	if fc.config.XLSX.HeaderRow > 0 {
+		return rowNumber == fc.config.XLSX.HeaderRow
+	}
+	if isBlankRecord(record) {
+		return false
+	}
+
+	columnMap := fc.createColumnMapping(record)
+	matched := 0
+	for field := range fc.fields {
+		if _, ok := columnMap[field]; ok {
+			matched++
+		}
+	}
+	if matched >= minHeaderFields {
+		return true
+	}
+
+	// Sheets with fewer columns, such as stock lists, qualify by mapping
+	// every required field
+	for _, field := range fc.required {
+		if _, ok := columnMap[field]; !ok {
+			return false
+		}
+	}
+	return len(fc.required) > 0
+}
+
+// readSharedStrings loads the workbook's shared string table
+func readSharedStrings(files map[string]*zip.File) ([]string, error) {
+	if _, ok := files["xl/sharedStrings.xml"]; !ok {
+		return nil, nil
+	}
+
+	var table struct {
+		Items []struct {
+			Text string `xml:"t"`
+			Runs []struct {
+				Text string `xml:"t"`
+			} `xml:"r"`
+		} `xml:"si"`
+	}
+	if err := decodeZipXML(files, "xl/sharedStrings.xml", &table); err != nil {
+		return nil, err
+	}
+
+	strs := make([]string, len(table.Items))
+	for i, item := range table.Items {
+		if len(item.Runs) == 0 {
+			strs[i] = item.Text
+			continue
+		}
+		var sb strings.Builder
+		for _, run := range item.Runs {
+			sb.WriteString(run.Text)
+		}
+		strs[i] = sb.String()
+	}
+	return strs, nil
+}
+
+// xlsxRowValues lays a row's cells out by column, resolving shared strings
+func xlsxRowValues(row xlsxRow, sharedStrings []string) []string {
+	var record []string
+	for i, cell := range row.Cells {
+		col := i
+		if cell.Ref != "" {
+			if parsed, ok := xlsxColumnIndex(cell.Ref); ok {
+				col = parsed
+			}
+		}
+		for len(record) <= col {
+			record = append(record, "")
+		}
+
+		switch cell.Type {
+		case "s":
+			if idx, err := strconv.Atoi(cell.Value); err == nil && idx >= 0 && idx < len(sharedStrings) {
+				record[col] = sharedStrings[idx]
+			}
+		case "inlineStr":
+			if len(cell.InlineStr.Runs) == 0 {
+				record[col] = cell.InlineStr.Text
+			} else {
+				var sb strings.Builder
+				for _, run := range cell.InlineStr.Runs {
+					sb.WriteString(run.Text)
+				}
+				record[col] = sb.String()
+			}
+		default:
+			record[col] = cell.Value
+		}
+	}
+	return record
+}
+
+// xlsxColumnIndex converts the column letters of a cell reference such as
+// "AB12" to a 0-based column index
+func xlsxColumnIndex(ref string) (int, bool) {
+	col := 0
+	letters := 0
+	for _, r := range ref {
+		if r >= 'A' && r <= 'Z' {
+			col = col*26 + int(r-'A'+1)
+			letters++
+		} else if r >= 'a' && r <= 'z' {
+			col = col*26 + int(r-'a'+1)
+			letters++
+		} else {
+			break
+		}
+	}
+	if letters == 0 {
+		return 0, false
+	}
+	return col - 1, true
+}
+
+// excelSerialToTime converts an Excel date serial (days since the epoch, with
+// the time of day as the fraction) to a time. Values that are not numeric or
+// outside Excel's date range are left alone.
+func excelSerialToTime(value string, epoch time.Time) (time.Time, bool) {
+	serial, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || serial < 1 || serial > maxExcelSerial {
+		return time.Time{}, false
+	}
+
+	days := math.Floor(serial)
+	seconds := math.Round((serial - days) * 86400)
+	return epoch.AddDate(0, 0, int(days)).Add(time.Duration(seconds) * time.Second), true
+}
+
+// isBlankRecord reports whether every field of a record is empty
+func isBlankRecord(record []string) bool {
+	for _, field := range record {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// openZipArchive opens a zip archive from a file or, failing that, from the
+// buffered contents of the reader
+func openZipArchive(reader io.Reader) (*zip.Reader, error) {
+	if file, ok := reader.(*os.File); ok {
+		if info, err := file.Stat(); err == nil {
+			offset, err := file.Seek(0, io.SeekCurrent)
+			if err == nil {
+				return zip.NewReader(io.NewSectionReader(file, offset, info.Size()-offset), info.Size()-offset)
+			}
+		}
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return zip.NewReader(bytes.NewReader(data), int64(len(data)))
+}
+
+// decodeZipXML decodes an XML part of a zip archive
+func decodeZipXML(files map[string]*zip.File, name string, v interface{}) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("%s not found", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
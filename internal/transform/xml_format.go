@@ -0,0 +1,248 @@
+package transform
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"abt-dashboard/internal/models"
+)
+
+// XMLConfig describes where transaction fields live in XML input.
+//
+// RecordElement names the element that holds one transaction. When empty,
+// every child of the document root is treated as a record.
+//
+// FieldPaths maps a standard field name (transaction_id, country, region,
+// product_name, price, quantity, transaction_date) to a path relative to the
+// record element. Path segments are separated by "/" and a final "@name"
+// segment selects an attribute, e.g. "@id", "amount/value" or "shipTo/@country".
+// Fields without a configured path fall back to the column_mappings aliases
+// that also apply to JSON and YAML records.
+type XMLConfig struct {
+	RecordElement string            `json:"record_element" yaml:"record_element"`
+	FieldPaths    map[string]string `json:"field_paths" yaml:"field_paths"`
+}
+
+// parseXML handles XML format parsing
+func (fc *FormatConverter) parseXML(reader io.Reader) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+
+	err := fc.streamXML(reader, transactionSink{fc: fc, handler: RecordHandler{
+		OnRecord: func(rec SourceRecord, tx models.Transaction) error {
+			transactions = append(transactions, tx)
+			return nil
+		},
+		OnSkip: func(rec SourceRecord, err error) {
+			fmt.Printf("Warning: Failed to parse transaction %d: %v\n", rec.Line, err)
+		},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// streamXML walks the XML token stream and converts each record element as
+// soon as it is closed, so only one record is held in memory at a time.
+func (fc *FormatConverter) streamXML(reader io.Reader, sink recordSink) error {
+	source := &xmlRawReader{r: reader}
+	decoder := xml.NewDecoder(source)
+	recordElement := fc.config.XML.RecordElement
+
+	var (
+		depth       int                    // depth of the current element, root = 1
+		recordDepth int                    // depth of the open record element
+		record      map[string]interface{} // fields of the open record, nil outside one
+		path        []string               // element path relative to the record
+		text        strings.Builder
+		line        int
+		recordStart int64 // input offset of the open record element
+
+		// fields lists the open record's values by full path when profiling
+		fields []SourceField
+	)
+	set := func(fullPath, name, value string) {
+		setXMLValue(record, fullPath, name, value)
+		if fc.captureFields {
+			fields = append(fields, SourceField{Name: fullPath, Value: value})
+		}
+	}
+
+	for {
+		offset := decoder.InputOffset()
+		if record == nil {
+			source.discard(offset)
+		}
+
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse XML: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if record == nil {
+				isRecord := (recordElement == "" && depth == 2) ||
+					(recordElement != "" && t.Name.Local == recordElement)
+				if isRecord {
+					record = make(map[string]interface{})
+					recordDepth = depth
+					recordStart = offset
+					path = path[:0]
+					fields = nil
+					addXMLAttributes("", t.Attr, set)
+				}
+				continue
+			}
+
+			path = append(path, t.Name.Local)
+			text.Reset()
+			addXMLAttributes(strings.Join(path, "/"), t.Attr, set)
+
+		case xml.CharData:
+			if record != nil {
+				text.Write(t)
+			}
+
+		case xml.EndElement:
+			if record != nil {
+				if depth == recordDepth {
+					line++
+					recordEnd := decoder.InputOffset()
+					rec := SourceRecord{
+						Line:   line,
+						raw:    func() string { return source.text(recordStart, recordEnd) },
+						Fields: fields,
+					}
+					if err := sink.object(record, rec); err != nil {
+						return err
+					}
+					record = nil
+				} else {
+					if value := strings.TrimSpace(text.String()); value != "" {
+						set(strings.Join(path, "/"), t.Name.Local, value)
+					}
+					path = path[:len(path)-1]
+					text.Reset()
+				}
+			}
+			depth--
+		}
+	}
+
+	return nil
+}
+
+// xmlRawReader keeps the input read since the last discard, so the source text
+// of the open record element can be reported. Outside records the buffer is
+// discarded after every token, which keeps it at about the decoder's read size.
+type xmlRawReader struct {
+	r    io.Reader
+	buf  []byte
+	base int64 // input offset of buf[0]
+}
+
+func (x *xmlRawReader) Read(p []byte) (int, error) {
+	n, err := x.r.Read(p)
+	x.buf = append(x.buf, p[:n]...)
+	return n, err
+}
+
+// discard drops buffered input before offset
+func (x *xmlRawReader) discard(offset int64) {
+	n := int(offset - x.base)
+	if n <= 0 {
+		return
+	}
+	if n > len(x.buf) {
+		n = len(x.buf)
+	}
+	x.buf = x.buf[:copy(x.buf, x.buf[n:])]
+	x.base += int64(n)
+}
+
+// text returns the buffered input between two offsets
+func (x *xmlRawReader) text(start, end int64) string {
+	from, to := int(start-x.base), int(end-x.base)
+	if from < 0 || to > len(x.buf) || from > to {
+		return ""
+	}
+	return strings.TrimSpace(string(x.buf[from:to]))
+}
+
+// addXMLAttributes records element attributes under "<prefix>/@name"
+func addXMLAttributes(prefix string, attrs []xml.Attr, set func(fullPath, name, value string)) {
+	for _, attr := range attrs {
+		key := "@" + attr.Name.Local
+		if prefix != "" {
+			key = prefix + "/" + key
+		}
+		set(key, attr.Name.Local, attr.Value)
+	}
+}
+
+// setXMLValue stores a value under its full path and, unless already taken,
+// under its bare name so that the standard field aliases still match
+func setXMLValue(record map[string]interface{}, fullPath, name, value string) {
+	record[fullPath] = value
+	if _, exists := record[name]; !exists {
+		record[name] = value
+	}
+}
+
+// xmlTransaction is the element written for each transaction on export
+type xmlTransaction struct {
+	XMLName         xml.Name `xml:"transaction"`
+	ID              string   `xml:"transaction_id"`
+	Country         string   `xml:"country"`
+	Region          string   `xml:"region"`
+	ProductName     string   `xml:"product_name"`
+	Price           string   `xml:"price"`
+	Quantity        int64    `xml:"quantity"`
+	TransactionDate string   `xml:"transaction_date"`
+	Currency        string   `xml:"currency,omitempty"`
+}
+
+// exportToXML exports transactions as a <transactions> document that the
+// XML reader can load back with the default configuration
+func (fc *FormatConverter) exportToXML(transactions []models.Transaction, writer io.Writer) error {
+	if _, err := io.WriteString(writer, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(writer)
+	encoder.Indent("", "  ")
+
+	root := xml.StartElement{Name: xml.Name{Local: "transactions"}}
+	if err := encoder.EncodeToken(root); err != nil {
+		return err
+	}
+
+	for _, tx := range transactions {
+		record := xmlTransaction{
+			ID:              tx.ID,
+			Country:         tx.Country,
+			Region:          tx.Region,
+			ProductName:     tx.ProductName,
+			Price:           fc.formatPrice(tx.UnitPriceCents, tx.Currency),
+			Quantity:        tx.Quantity,
+			TransactionDate: tx.TxTime.UTC().Format("2006-01-02T15:04:05Z"),
+			Currency:        tx.Currency,
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	if err := encoder.EncodeToken(root.End()); err != nil {
+		return err
+	}
+	return encoder.Flush()
+}